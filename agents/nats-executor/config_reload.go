@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+)
+
+// EffectiveConfig 为可在运行期生效的配置项
+type EffectiveConfig struct {
+	LogLevel string `json:"log_level"`
+}
+
+type ConfigReloadResponse struct {
+	InstanceId string          `json:"instance_id"`
+	Success    bool            `json:"success"`
+	Config     EffectiveConfig `json:"config"`
+	// 配置文件中已变更、但需要重启进程才能生效的字段
+	RestartRequired []string `json:"restart_required,omitempty"`
+	Code            string   `json:"code,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+type configSubscriber interface {
+	Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error)
+}
+
+// configReloader 重新读取配置文件并应用可热更新的配置，只调整全局设置，不影响进行中的请求
+type configReloader struct {
+	mu      sync.Mutex
+	path    string
+	current *Config
+}
+
+func newConfigReloader(path string, current *Config) *configReloader {
+	return &configReloader{path: path, current: current}
+}
+
+func isValidLogLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "warning", "error":
+		return true
+	default:
+		return false
+	}
+}
+
+// applyLiveConfig 应用可热更新的配置；log_level 为空时保持当前级别（默认来自 LOG_LEVEL 环境变量）
+func applyLiveConfig(cfg *Config) error {
+	level := parseString(cfg.LogLevel)
+	if level == "" {
+		return nil
+	}
+	if !isValidLogLevel(level) {
+		return fmt.Errorf("invalid log_level %q: must be one of debug, info, warn, error", level)
+	}
+	logger.SetLevel(level)
+	return nil
+}
+
+func restartRequiredFields(previous, next *Config) []string {
+	var changed []string
+	for _, field := range []struct {
+		name   string
+		before any
+		after  any
+	}{
+		{"nats_urls", previous.NATSUrls, next.NATSUrls},
+		{"nats_instanceId", previous.NATSInstanceID, next.NATSInstanceID},
+		{"nats_queue_group", previous.NATSQueueGroup, next.NATSQueueGroup},
+		{"nats_conn_timeout", previous.NatsConnTimeout, next.NatsConnTimeout},
+		{"nats_reconnect_wait", previous.NATSReconnectWait, next.NATSReconnectWait},
+		{"nats_max_reconnects", previous.NATSMaxReconnects, next.NATSMaxReconnects},
+		{"nats_reconnect_buf_size", previous.NATSReconnectBufSize, next.NATSReconnectBufSize},
+		{"tls_enabled", previous.TLSEnabled, next.TLSEnabled},
+		{"tls_hostname", previous.TLSHostname, next.TLSHostname},
+		{"tls_ca_file", previous.TLSCAFile, next.TLSCAFile},
+		{"tls_cert_file", previous.TLSCertFile, next.TLSCertFile},
+		{"tls_key_file", previous.TLSKeyFile, next.TLSKeyFile},
+		{"tls_skip_verify", previous.TLSSkipVerify, next.TLSSkipVerify},
+		{"metrics_listen", previous.MetricsListen, next.MetricsListen},
+		{"max_response_bytes", previous.MaxResponseBytes, next.MaxResponseBytes},
+		{"response_size_policy", previous.ResponseSizePolicy, next.ResponseSizePolicy},
+		{"response_offload_bucket", previous.ResponseOffloadBucket, next.ResponseOffloadBucket},
+		{"compress_output_threshold", previous.CompressOutputThreshold, next.CompressOutputThreshold},
+		{"max_concurrent_jobs", previous.MaxConcurrentJobs, next.MaxConcurrentJobs},
+		{"max_concurrent_jobs_ceiling", previous.MaxConcurrentJobsCeiling, next.MaxConcurrentJobsCeiling},
+		{"tail_buffer_lines", previous.TailBufferLines, next.TailBufferLines},
+		{"required_shells", previous.RequiredShells, next.RequiredShells},
+		{"result_bucket", previous.ResultBucket, next.ResultBucket},
+		{"shutdown_timeout", previous.ShutdownTimeout, next.ShutdownTimeout},
+	} {
+		if field.before != field.after {
+			changed = append(changed, field.name)
+		}
+	}
+	return changed
+}
+
+func (r *configReloader) Reload(instanceID string) ConfigReloadResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	response := ConfigReloadResponse{InstanceId: instanceID, Config: EffectiveConfig{LogLevel: logger.GetLevel()}}
+	cfg, err := loadConfigFn(r.path)
+	if err != nil {
+		response.Code = utils.ErrorCodeExecutionFailure
+		response.Error = fmt.Sprintf("failed to reload config: %v", err)
+		return response
+	}
+	if err := applyLiveConfig(cfg); err != nil {
+		response.Code = utils.ErrorCodeInvalidRequest
+		response.Error = err.Error()
+		return response
+	}
+
+	response.Success = true
+	response.Config = EffectiveConfig{LogLevel: logger.GetLevel()}
+	response.RestartRequired = restartRequiredFields(r.current, cfg)
+	// 只记录已生效的部分，需重启的字段保持与运行中的连接一致，下次比较仍会提示
+	r.current.LogLevel = cfg.LogLevel
+	return response
+}
+
+func subscribeConfigReload(sub configSubscriber, instanceID string, reloader *configReloader) error {
+	subject := fmt.Sprintf("config.reload.%s", instanceID)
+	logger.Infof("[Config Reload Subscribe] Instance: %s, Subscribing to subject: %s", instanceID, subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		response := reloader.Reload(instanceID)
+		if response.Success {
+			logger.Infof("[Config Reload] Instance: %s, Config reloaded (log level: %s, restart required: %v)", instanceID, response.Config.LogLevel, response.RestartRequired)
+		} else {
+			logger.Warnf("[Config Reload] Instance: %s, %s", instanceID, response.Error)
+		}
+		responseContent, _ := json.Marshal(response)
+		if err := msg.Respond(responseContent); err != nil {
+			logger.Errorf("[Config Reload Subscribe] Instance: %s, Error responding to reload request: %v", instanceID, err)
+		}
+	})
+	return err
+}
+
+func SubscribeConfigReload(nc *nats.Conn, instanceID string, reloader *configReloader) {
+	if err := subscribeConfigReload(nc, instanceID, reloader); err != nil {
+		logger.Errorf("[Config Reload Subscribe] Instance: %s, Failed to subscribe: %v", instanceID, err)
+	}
+}
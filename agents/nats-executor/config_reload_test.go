@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+)
+
+type stubConfigSubscriber struct {
+	subject string
+	handler nats.MsgHandler
+}
+
+func (s *stubConfigSubscriber) Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	s.subject = subject
+	s.handler = cb
+	return nil, nil
+}
+
+func writeReloadConfig(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func TestConfigReloaderAppliesLogLevelAndReportsRestartFields(t *testing.T) {
+	originalLevel := logger.GetLevel()
+	defer logger.SetLevel(originalLevel)
+	logger.SetLevel("info")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadConfig(t, path, "nats_urls: nats://a:4222", "nats_instanceId: instance-1")
+	current, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	reloader := newConfigReloader(path, current)
+
+	writeReloadConfig(t, path, "nats_urls: nats://b:4222", "nats_instanceId: instance-1", "log_level: debug")
+	response := reloader.Reload("instance-1")
+	if !response.Success || response.Config.LogLevel != "debug" || logger.GetLevel() != "debug" {
+		t.Fatalf("expected log level to be applied, got %+v (level=%s)", response, logger.GetLevel())
+	}
+	if len(response.RestartRequired) != 1 || response.RestartRequired[0] != "nats_urls" {
+		t.Fatalf("expected nats_urls to require restart, got %v", response.RestartRequired)
+	}
+
+	writeReloadConfig(t, path, "nats_urls: nats://b:4222", "nats_instanceId: instance-1", "log_level: loud")
+	response = reloader.Reload("instance-1")
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest || logger.GetLevel() != "debug" {
+		t.Fatalf("expected invalid level to be rejected without changes, got %+v", response)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove config: %v", err)
+	}
+	response = reloader.Reload("instance-1")
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure || !strings.Contains(response.Error, "failed to reload config") {
+		t.Fatalf("expected read failure, got %+v", response)
+	}
+}
+
+func TestSubscribeConfigReloadRegistersSubject(t *testing.T) {
+	sub := &stubConfigSubscriber{}
+	if err := subscribeConfigReload(sub, "instance-1", newConfigReloader("/tmp/missing.yaml", &Config{})); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "config.reload.instance-1" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+
+	payload, _ := json.Marshal(ConfigReloadResponse{InstanceId: "instance-1", Success: true, Config: EffectiveConfig{LogLevel: "info"}})
+	if !strings.Contains(string(payload), `"config":{"log_level":"info"}`) {
+		t.Fatalf("unexpected response encoding: %s", payload)
+	}
+}
+
+func TestRestartRequiredFieldsIncludesShutdownTimeout(t *testing.T) {
+	changed := restartRequiredFields(&Config{ShutdownTimeout: 30}, &Config{ShutdownTimeout: 60})
+	if len(changed) != 1 || changed[0] != "shutdown_timeout" {
+		t.Fatalf("expected shutdown_timeout to require restart, got %v", changed)
+	}
+}
+
+func TestRestartRequiredFieldsIncludesReconnectSettings(t *testing.T) {
+	changed := restartRequiredFields(&Config{}, &Config{NATSReconnectWait: 5, NATSMaxReconnects: 10, NATSReconnectBufSize: 1 << 20})
+	if strings.Join(changed, ",") != "nats_reconnect_wait,nats_max_reconnects,nats_reconnect_buf_size" {
+		t.Fatalf("expected reconnect settings to require restart, got %v", changed)
+	}
+}
@@ -62,7 +62,7 @@
       | log_context     | download missing-sshpass                       |
       | execute_timeout | 3                                              |
     当 在本地执行该命令
-    那么 执行失败且错误码为 "execution_failure"
+    那么 执行失败且错误码为 "command_not_found"
     而且 组合输出包含 "sshpass: command not found"
     而且 错误信息包含 "exit code 127"
 
@@ -0,0 +1,58 @@
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// BucketInfo 为对象存储 bucket 的基本信息
+type BucketInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	SizeBytes   uint64 `json:"size_bytes"`
+	Storage     string `json:"storage"`
+	Replicas    int    `json:"replicas"`
+	Sealed      bool   `json:"sealed"`
+	TTLSeconds  int64  `json:"ttl_seconds,omitempty"`
+}
+
+type objectStoreLister interface {
+	AccountInfo(opts ...nats.JSOpt) (*nats.AccountInfo, error)
+	ObjectStores(opts ...nats.ObjectOpt) <-chan nats.ObjectStoreStatus
+}
+
+var objectStoreListerFromConn = func(nc *nats.Conn) (objectStoreLister, error) { return nc.JetStream() }
+
+// ListObjectStores 列出当前账号可访问的对象存储 bucket，按名称排序。
+// ObjectStores 不返回错误，因此先查询账号信息以区分 JetStream 未启用，并在 ctx 到期时报告超时而不是返回不完整的列表
+func ListObjectStores(ctx context.Context, nc *nats.Conn) ([]BucketInfo, error) {
+	js, err := objectStoreListerFromConn(nc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+	if _, err := js.AccountInfo(nats.Context(ctx)); err != nil {
+		return nil, fmt.Errorf("failed to get JetStream account info: %w", err)
+	}
+
+	buckets := []BucketInfo{}
+	for status := range js.ObjectStores(nats.Context(ctx)) {
+		buckets = append(buckets, BucketInfo{
+			Name:        status.Bucket(),
+			Description: status.Description(),
+			SizeBytes:   status.Size(),
+			Storage:     strings.ToLower(status.Storage().String()),
+			Replicas:    status.Replicas(),
+			Sealed:      status.Sealed(),
+			TTLSeconds:  int64(status.TTL().Seconds()),
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list object stores: %w", err)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
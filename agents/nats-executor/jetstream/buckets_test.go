@@ -0,0 +1,77 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestListObjectStoresReturnsBucketsFromEmbeddedJetStream(t *testing.T) {
+	ns := startJetStreamServer(t, -1, t.TempDir())
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	for _, config := range []nats.ObjectStoreConfig{
+		{Bucket: "packages", Description: "agent packages"},
+		{Bucket: "artifacts", Storage: nats.MemoryStorage},
+	} {
+		if _, err := js.CreateObjectStore(&config); err != nil {
+			t.Fatalf("create object store %s: %v", config.Bucket, err)
+		}
+	}
+	// 普通 stream 不应出现在 bucket 列表中
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "events", Subjects: []string{"events.>"}}); err != nil {
+		t.Fatalf("add stream: %v", err)
+	}
+	store, _ := js.ObjectStore("packages")
+	if _, err := store.PutBytes("agent.bin", []byte("payload")); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	buckets, err := ListObjectStores(ctx, nc)
+	if err != nil {
+		t.Fatalf("list object stores: %v", err)
+	}
+	if len(buckets) != 2 || buckets[0].Name != "artifacts" || buckets[1].Name != "packages" {
+		t.Fatalf("unexpected buckets: %+v", buckets)
+	}
+	if buckets[0].Storage != "memory" || buckets[1].Storage != "file" || buckets[1].Description != "agent packages" || buckets[1].SizeBytes == 0 {
+		t.Fatalf("unexpected bucket info: %+v", buckets)
+	}
+}
+
+func TestListObjectStoresReportsJetStreamDisabled(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	defer nc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ListObjectStores(ctx, nc); !errors.Is(err, nats.ErrJetStreamNotEnabled) {
+		t.Fatalf("expected JetStream disabled error, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package jetstream
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+type clientCacheKey struct {
+	nc     *nats.Conn
+	bucket string
+}
+
+type cachedClient struct {
+	client     *JetStreamClient
+	reconnects uint64
+}
+
+var (
+	clientCacheMu sync.Mutex
+	clientCache   = map[clientCacheKey]cachedClient{}
+
+	newCachedClientFn = NewJetStreamClient
+	connReconnects    = func(nc *nats.Conn) uint64 {
+		if nc == nil {
+			return 0
+		}
+		return nc.Stats().Reconnects
+	}
+	connClosed = func(nc *nats.Conn) bool { return nc != nil && nc.IsClosed() }
+)
+
+// SharedJetStreamClient 按连接与 bucket 复用 JetStreamClient，避免每次传输都重新获取 JetStream 上下文与对象存储句柄；
+// 连接发生重连或已关闭时缓存失效，下次调用重新获取
+func SharedJetStreamClient(nc *nats.Conn, bucketName string) (*JetStreamClient, error) {
+	key := clientCacheKey{nc: nc, bucket: bucketName}
+	reconnects := connReconnects(nc)
+
+	clientCacheMu.Lock()
+	pruneClosedClientsLocked()
+	if cached, ok := clientCache[key]; ok && cached.reconnects == reconnects {
+		clientCacheMu.Unlock()
+		return cached.client, nil
+	}
+	clientCacheMu.Unlock()
+
+	// 创建过程可能按重试策略等待，不持锁；并发创建时保留先写入的客户端
+	client, err := newCachedClientFn(nc, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCacheMu.Lock()
+	defer clientCacheMu.Unlock()
+	if cached, ok := clientCache[key]; ok && cached.reconnects == reconnects {
+		return cached.client, nil
+	}
+	if !connClosed(nc) {
+		clientCache[key] = cachedClient{client: client, reconnects: reconnects}
+	}
+	return client, nil
+}
+
+// InvalidateJetStreamClients 丢弃 nc 上缓存的全部客户端
+func InvalidateJetStreamClients(nc *nats.Conn) {
+	clientCacheMu.Lock()
+	defer clientCacheMu.Unlock()
+	for key := range clientCache {
+		if key.nc == nc {
+			delete(clientCache, key)
+		}
+	}
+}
+
+// pruneClosedClientsLocked 移除已关闭连接上的客户端，避免临时连接的缓存残留
+func pruneClosedClientsLocked() {
+	for key := range clientCache {
+		if connClosed(key.nc) {
+			delete(clientCache, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package jetstream
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+// withClientCacheStubs 以计数的构造函数替换真实的 JetStream 获取，reconnects 与 closed 按连接模拟状态
+func withClientCacheStubs(t *testing.T, reconnects map[*nats.Conn]uint64, closed map[*nats.Conn]bool) *int32 {
+	t.Helper()
+	var created int32
+	var mu sync.Mutex
+	originalNew, originalReconnects, originalClosed := newCachedClientFn, connReconnects, connClosed
+	newCachedClientFn = func(nc *nats.Conn, bucketName string) (*JetStreamClient, error) {
+		atomic.AddInt32(&created, 1)
+		return &JetStreamClient{nc: nc}, nil
+	}
+	connReconnects = func(nc *nats.Conn) uint64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return reconnects[nc]
+	}
+	connClosed = func(nc *nats.Conn) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return closed[nc]
+	}
+	clientCacheMu.Lock()
+	clientCache = map[clientCacheKey]cachedClient{}
+	clientCacheMu.Unlock()
+	t.Cleanup(func() {
+		newCachedClientFn, connReconnects, connClosed = originalNew, originalReconnects, originalClosed
+		clientCacheMu.Lock()
+		clientCache = map[clientCacheKey]cachedClient{}
+		clientCacheMu.Unlock()
+	})
+	return &created
+}
+
+func TestSharedJetStreamClientReusesClientPerBucket(t *testing.T) {
+	nc := &nats.Conn{}
+	created := withClientCacheStubs(t, map[*nats.Conn]uint64{}, map[*nats.Conn]bool{})
+
+	first, _ := SharedJetStreamClient(nc, "packages")
+	second, _ := SharedJetStreamClient(nc, "packages")
+	other, _ := SharedJetStreamClient(nc, "logs")
+	if first != second || first == other || atomic.LoadInt32(created) != 2 {
+		t.Fatalf("expected one client per bucket, created %d", atomic.LoadInt32(created))
+	}
+}
+
+func TestSharedJetStreamClientRebuildsAfterReconnect(t *testing.T) {
+	nc := &nats.Conn{}
+	reconnects := map[*nats.Conn]uint64{}
+	created := withClientCacheStubs(t, reconnects, map[*nats.Conn]bool{})
+
+	before, _ := SharedJetStreamClient(nc, "packages")
+	reconnects[nc] = 1
+	after, _ := SharedJetStreamClient(nc, "packages")
+	if before == after || atomic.LoadInt32(created) != 2 {
+		t.Fatalf("expected reconnect to invalidate cached client, created %d", atomic.LoadInt32(created))
+	}
+
+	InvalidateJetStreamClients(nc)
+	if rebuilt, _ := SharedJetStreamClient(nc, "packages"); rebuilt == after {
+		t.Fatal("expected explicit invalidation to drop cached client")
+	}
+}
+
+func TestSharedJetStreamClientDoesNotKeepClosedConnections(t *testing.T) {
+	live, temporary := &nats.Conn{}, &nats.Conn{}
+	closed := map[*nats.Conn]bool{}
+	withClientCacheStubs(t, map[*nats.Conn]uint64{}, closed)
+
+	SharedJetStreamClient(temporary, "results")
+	closed[temporary] = true
+	SharedJetStreamClient(live, "results")
+
+	clientCacheMu.Lock()
+	defer clientCacheMu.Unlock()
+	if _, ok := clientCache[clientCacheKey{nc: temporary, bucket: "results"}]; ok || len(clientCache) != 1 {
+		t.Fatalf("expected closed connection to be pruned, cache=%v", clientCache)
+	}
+}
+
+func TestSharedJetStreamClientIsSafeForConcurrentUse(t *testing.T) {
+	nc := &nats.Conn{}
+	withClientCacheStubs(t, map[*nats.Conn]uint64{}, map[*nats.Conn]bool{})
+
+	clients := make([]*JetStreamClient, 32)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], _ = SharedJetStreamClient(nc, "packages")
+		}(i)
+	}
+	wg.Wait()
+
+	cached, _ := SharedJetStreamClient(nc, "packages")
+	for i, client := range clients {
+		if client == nil {
+			t.Fatalf("call %d returned no client", i)
+		}
+	}
+	if cached == nil {
+		t.Fatal("expected a cached client")
+	}
+}
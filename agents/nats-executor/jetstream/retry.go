@@ -0,0 +1,68 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nats-executor/logger"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	objectStoreConnectRetriesEnv          = "OBJECTSTORE_CONNECT_RETRIES"
+	objectStoreConnectRetryBackoffEnv     = "OBJECTSTORE_CONNECT_RETRY_BACKOFF_MS"
+	defaultObjectStoreConnectRetries      = 3
+	defaultObjectStoreConnectRetryBackoff = 500 * time.Millisecond
+)
+
+var retrySleepFn = time.Sleep
+
+type connectRetryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// configuredConnectRetryPolicy 读取获取 JetStream 上下文与对象存储时的重试配置，非法值回退默认值
+func configuredConnectRetryPolicy() connectRetryPolicy {
+	policy := connectRetryPolicy{retries: defaultObjectStoreConnectRetries, backoff: defaultObjectStoreConnectRetryBackoff}
+	if value := strings.TrimSpace(os.Getenv(objectStoreConnectRetriesEnv)); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			policy.retries = retries
+		} else {
+			logger.Warnf("[JetStream] invalid %s=%q, using default %d", objectStoreConnectRetriesEnv, value, defaultObjectStoreConnectRetries)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(objectStoreConnectRetryBackoffEnv)); value != "" {
+		if backoffMs, err := strconv.Atoi(value); err == nil && backoffMs >= 0 {
+			policy.backoff = time.Duration(backoffMs) * time.Millisecond
+		} else {
+			logger.Warnf("[JetStream] invalid %s=%q, using default %s", objectStoreConnectRetryBackoffEnv, value, defaultObjectStoreConnectRetryBackoff)
+		}
+	}
+	return policy
+}
+
+// isTransientConnectError 仅识别 NATS 重连期间的瞬时错误；JetStream 未启用、bucket 不存在、连接已关闭等配置或永久错误不重试
+func isTransientConnectError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch {
+	case errors.Is(err, nats.ErrJetStreamNotEnabled),
+		errors.Is(err, nats.ErrJetStreamNotEnabledForAccount),
+		errors.Is(err, nats.ErrBucketNotFound),
+		errors.Is(err, nats.ErrConnectionClosed):
+		return false
+	}
+	return errors.Is(err, nats.ErrConnectionReconnecting) ||
+		errors.Is(err, nats.ErrReconnectBufExceeded) ||
+		errors.Is(err, nats.ErrDisconnected) ||
+		errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
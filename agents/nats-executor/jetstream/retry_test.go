@@ -0,0 +1,131 @@
+package jetstream
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNewJetStreamClientRetriesTransientErrors(t *testing.T) {
+	originalFactory, originalSleep := jetStreamFromConn, retrySleepFn
+	defer func() { jetStreamFromConn, retrySleepFn = originalFactory, originalSleep }()
+	retrySleepFn = func(time.Duration) {}
+
+	calls := 0
+	jetStreamFromConn = func(nc *nats.Conn) (objectStoreManager, error) {
+		calls++
+		if calls < 3 {
+			return &stubObjectStoreManager{objectStoreErr: nats.ErrConnectionReconnecting}, nil
+		}
+		return &stubObjectStoreManager{objectStore: stubObjectStoreImpl{}}, nil
+	}
+
+	client, err := NewJetStreamClient(nil, "downloads")
+	if err != nil || client == nil {
+		t.Fatalf("expected client after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestNewJetStreamClientDoesNotRetryPermanentErrors(t *testing.T) {
+	originalFactory, originalSleep := jetStreamFromConn, retrySleepFn
+	defer func() { jetStreamFromConn, retrySleepFn = originalFactory, originalSleep }()
+	retrySleepFn = func(time.Duration) {}
+
+	for _, permanent := range []error{nats.ErrJetStreamNotEnabled, nats.ErrBucketNotFound, errors.New("invalid bucket name")} {
+		calls := 0
+		jetStreamFromConn = func(nc *nats.Conn) (objectStoreManager, error) {
+			calls++
+			return &stubObjectStoreManager{objectStoreErr: permanent}, nil
+		}
+		if _, err := NewJetStreamClient(nil, "downloads"); err == nil {
+			t.Fatalf("expected %v to fail", permanent)
+		}
+		if calls != 1 {
+			t.Fatalf("expected %v not to be retried, got %d attempts", permanent, calls)
+		}
+	}
+}
+
+func TestNewJetStreamClientGivesUpAfterConfiguredRetries(t *testing.T) {
+	originalFactory, originalSleep := jetStreamFromConn, retrySleepFn
+	defer func() { jetStreamFromConn, retrySleepFn = originalFactory, originalSleep }()
+	var slept []time.Duration
+	retrySleepFn = func(d time.Duration) { slept = append(slept, d) }
+	t.Setenv(objectStoreConnectRetriesEnv, "2")
+	t.Setenv(objectStoreConnectRetryBackoffEnv, "10")
+
+	jetStreamFromConn = func(nc *nats.Conn) (objectStoreManager, error) {
+		return &stubObjectStoreManager{objectStoreErr: nats.ErrTimeout}, nil
+	}
+	_, err := NewJetStreamClient(nil, "downloads")
+	if !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("expected wrapped timeout error, got %v", err)
+	}
+	if len(slept) != 2 || slept[0] != 10*time.Millisecond {
+		t.Fatalf("unexpected backoff: %v", slept)
+	}
+}
+
+func startJetStreamServer(t *testing.T, port int, storeDir string) *server.Server {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, JetStream: true, StoreDir: storeDir, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	return ns
+}
+
+func TestNewJetStreamClientSurvivesServerBounce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts an embedded NATS server")
+	}
+	t.Setenv(objectStoreConnectRetriesEnv, "20")
+	t.Setenv(objectStoreConnectRetryBackoffEnv, "100")
+
+	storeDir := t.TempDir()
+	ns := startJetStreamServer(t, -1, storeDir)
+	addr := ns.ClientURL()
+	serverPort := ns.Addr().(*net.TCPAddr).Port
+
+	// 重连期间不缓冲发布，使请求立即失败而不是等到超时
+	nc, err := nats.Connect(addr, nats.MaxReconnects(-1), nats.ReconnectWait(50*time.Millisecond), nats.ReconnectBufSize(-1))
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "bounce"}); err != nil {
+		t.Fatalf("create object store: %v", err)
+	}
+
+	ns.Shutdown()
+	ns.WaitForShutdown()
+	restarted := make(chan *server.Server, 1)
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		restarted <- startJetStreamServer(t, serverPort, storeDir)
+	}()
+	defer func() { (<-restarted).Shutdown() }()
+
+	client, err := NewJetStreamClient(nc, "bounce")
+	if err != nil {
+		t.Fatalf("expected client creation to survive the server bounce, got %v", err)
+	}
+	if client == nil || client.objectStore == nil {
+		t.Fatalf("expected client with object store, got %#v", client)
+	}
+}
@@ -1,7 +1,10 @@
 package jetstream
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -9,13 +12,21 @@ import (
 	"nats-executor/utils/downloaderr"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/nats-io/nats.go"
 )
 
-type objectStoreGetter interface {
+// MetadataSHA256 为上传时写入对象元数据的 SHA256 摘要（十六进制）键名，下载方据此校验内容
+const MetadataSHA256 = "sha256"
+
+type objectStoreAccessor interface {
 	Get(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error)
+	GetInfo(name string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error)
+	Put(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error)
+	List(opts ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error)
+	Delete(name string) error
 }
 
 type objectStoreManager interface {
@@ -23,6 +34,7 @@ type objectStoreManager interface {
 }
 
 var (
+	openUploadFile         = os.Open
 	createTempDownloadFile = func(dir, pattern string) (*os.File, error) {
 		return os.CreateTemp(dir, pattern)
 	}
@@ -36,13 +48,25 @@ var (
 type JetStreamClient struct {
 	nc          *nats.Conn
 	js          nats.JetStreamContext
-	objectStore objectStoreGetter
+	objectStore objectStoreAccessor
 }
 
+// NewJetStreamClient 获取 JetStream 上下文与对象存储，NATS 重连期间的瞬时错误按 OBJECTSTORE_CONNECT_RETRIES 有限重试
 func NewJetStreamClient(nc *nats.Conn, bucketName string) (*JetStreamClient, error) {
+	policy := configuredConnectRetryPolicy()
+	client, err := newJetStreamClient(nc, bucketName)
+	for attempt := 0; err != nil && attempt < policy.retries && isTransientConnectError(err); attempt++ {
+		logger.Warnf("[JetStream] Object store %s unavailable, retrying (%d/%d) in %s - Error: %v", bucketName, attempt+1, policy.retries, policy.backoff, err)
+		retrySleepFn(policy.backoff)
+		client, err = newJetStreamClient(nc, bucketName)
+	}
+	return client, err
+}
+
+func newJetStreamClient(nc *nats.Conn, bucketName string) (*JetStreamClient, error) {
 	js, err := jetStreamFromConn(nc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get JetStream context: %v", err)
+		return nil, fmt.Errorf("failed to get JetStream context: %w", err)
 	}
 
 	return newJetStreamClientFromContext(nc, js, bucketName)
@@ -63,12 +87,14 @@ func ensureObjectStore(js objectStoreManager, bucketName string) (nats.ObjectSto
 		if err == nats.ErrBucketNotFound {
 			return nil, fmt.Errorf("object store bucket %q not found: %w", bucketName, err)
 		}
-		return nil, fmt.Errorf("failed to access object store: %v", err)
+		return nil, fmt.Errorf("failed to access object store: %w", err)
 	}
 	return store, nil
 }
 
-func (jsc *JetStreamClient) DownloadToFile(ctx context.Context, fileKey, targetPath, fileName string) error {
+// DownloadToFile 将对象下载到 targetPath/fileName；写入后依次校验 NATS 对象摘要、上传时记录的 SHA256
+// 以及调用方给出的 expectedSHA256（可为空），任一不一致时删除临时文件并返回错误
+func (jsc *JetStreamClient) DownloadToFile(ctx context.Context, fileKey, targetPath, fileName, expectedSHA256 string) error {
 	if err := validateTargetFileName(fileName); err != nil {
 		return err
 	}
@@ -103,9 +129,17 @@ func (jsc *JetStreamClient) DownloadToFile(ctx context.Context, fileKey, targetP
 		_ = removeDownloadFile(tempPath)
 	}
 
-	written, err := io.Copy(tempFile, obj)
+	// 超时或取消时关闭对象，使阻塞中的分块读取立即返回
+	stopClose := context.AfterFunc(ctx, func() { _ = obj.Close() })
+	defer stopClose()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tempFile, hasher), contextReader{ctx: ctx, r: obj})
 	if err != nil {
 		cleanupTemp()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
 		kind := downloaderr.KindDependency
 		if errors.Is(err, context.Canceled) {
 			kind = downloaderr.KindCanceled
@@ -115,6 +149,11 @@ func (jsc *JetStreamClient) DownloadToFile(ctx context.Context, fileKey, targetP
 		return downloaderr.New(kind, fmt.Errorf("failed to write file: %w", err))
 	}
 
+	if err := verifyDownloadedObject(obj, hex.EncodeToString(hasher.Sum(nil)), expectedSHA256); err != nil {
+		cleanupTemp()
+		return downloaderr.New(downloaderr.KindDependency, fmt.Errorf("downloaded object %s failed verification: %w", fileKey, err))
+	}
+
 	if err := syncDownloadFile(tempFile); err != nil {
 		cleanupTemp()
 		return downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to sync temporary file %s: %w", tempPath, err))
@@ -136,6 +175,182 @@ func (jsc *JetStreamClient) DownloadToFile(ctx context.Context, fileKey, targetP
 	return nil
 }
 
+// ListObjects 列出 bucket 中未删除的对象及其大小、修改时间、摘要等元信息，按名称排序；bucket 为空时返回空列表
+func (jsc *JetStreamClient) ListObjects(ctx context.Context) ([]*nats.ObjectInfo, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	objects, err := jsc.objectStore.List(nats.Context(ctx))
+	if errors.Is(err, nats.ErrNoObjectsFound) {
+		return []*nats.ObjectInfo{}, nil
+	}
+	if err != nil {
+		kind := downloaderr.KindDependency
+		if errors.Is(err, context.Canceled) {
+			kind = downloaderr.KindCanceled
+		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			kind = downloaderr.KindTimeout
+		}
+		return nil, downloaderr.New(kind, fmt.Errorf("failed to list objects: %w", err))
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// DeleteObject 删除对象；对象不存在或已删除时返回包装 nats.ErrObjectNotFound 的错误
+func (jsc *JetStreamClient) DeleteObject(fileKey string) error {
+	// Delete 对已删除的对象不报错，先确认对象仍然存在
+	if _, err := jsc.objectStore.GetInfo(fileKey); err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			return fmt.Errorf("object %s not found: %w", fileKey, err)
+		}
+		return downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to get object info for key %s: %w", fileKey, err))
+	}
+	if err := jsc.objectStore.Delete(fileKey); err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			return fmt.Errorf("object %s not found: %w", fileKey, err)
+		}
+		return downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to delete object with key %s: %w", fileKey, err))
+	}
+
+	logger.Debugf("[JetStream] Object %s deleted", fileKey)
+	return nil
+}
+
+// contextReader 每次读取分块前检查 ctx，超时或取消后不再继续读取
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// OpenObject 打开对象用于流式读取，返回对象大小供调用方校验写入字节数；调用方负责关闭 reader
+func (jsc *JetStreamClient) OpenObject(ctx context.Context, fileKey string) (io.ReadCloser, int64, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	obj, err := jsc.objectStore.Get(fileKey, nats.Context(ctx))
+	if err != nil {
+		kind := downloaderr.KindDependency
+		if errors.Is(err, context.Canceled) {
+			kind = downloaderr.KindCanceled
+		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			kind = downloaderr.KindTimeout
+		}
+		return nil, 0, downloaderr.New(kind, fmt.Errorf("failed to get object from store with key %s: %w", fileKey, err))
+	}
+
+	info, err := obj.Info()
+	if err != nil {
+		_ = obj.Close()
+		return nil, 0, downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to read object info for key %s: %w", fileKey, err))
+	}
+	return obj, int64(info.Size), nil
+}
+
+// UploadFromFile 将本地文件写入对象存储，fileKey 已存在时覆盖
+func (jsc *JetStreamClient) UploadFromFile(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	file, err := openUploadFile(sourcePath)
+	if err != nil {
+		return nil, downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to open source file %s: %w", sourcePath, err))
+	}
+	defer file.Close()
+
+	// 对象元数据在写入分块前确定，先完整读取一遍计算摘要再回到文件开头上传
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to read source file %s: %w", sourcePath, err))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to rewind source file %s: %w", sourcePath, err))
+	}
+	meta := &nats.ObjectMeta{
+		Name:     fileKey,
+		Metadata: map[string]string{MetadataSHA256: hex.EncodeToString(hasher.Sum(nil))},
+	}
+
+	info, err := jsc.objectStore.Put(meta, file, nats.Context(ctx))
+	if err != nil {
+		kind := downloaderr.KindDependency
+		if errors.Is(err, context.Canceled) {
+			kind = downloaderr.KindCanceled
+		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			kind = downloaderr.KindTimeout
+		}
+		return nil, downloaderr.New(kind, fmt.Errorf("failed to put object with key %s: %w", fileKey, err))
+	}
+
+	logger.Debugf("[JetStream] File %s successfully uploaded with key %s (%d bytes, sha256 %s)", sourcePath, fileKey, info.Size, meta.Metadata[MetadataSHA256])
+	return info, nil
+}
+
+// ObjectSHA256 返回上传时记录的 SHA256 摘要，对象没有记录摘要时返回空字符串
+func (jsc *JetStreamClient) ObjectSHA256(ctx context.Context, fileKey string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	info, err := jsc.objectStore.GetInfo(fileKey, nats.Context(ctx))
+	if err != nil {
+		return "", downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to get object info for key %s: %w", fileKey, err))
+	}
+	return info.Metadata[MetadataSHA256], nil
+}
+
+// UploadBytes 将内存中的数据写入对象存储，fileKey 已存在时覆盖
+func (jsc *JetStreamClient) UploadBytes(ctx context.Context, fileKey string, data []byte) (*nats.ObjectInfo, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	info, err := jsc.objectStore.Put(&nats.ObjectMeta{Name: fileKey}, bytes.NewReader(data), nats.Context(ctx))
+	if err != nil {
+		kind := downloaderr.KindDependency
+		if errors.Is(err, context.Canceled) {
+			kind = downloaderr.KindCanceled
+		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			kind = downloaderr.KindTimeout
+		}
+		return nil, downloaderr.New(kind, fmt.Errorf("failed to put object with key %s: %w", fileKey, err))
+	}
+
+	logger.Debugf("[JetStream] %d bytes uploaded with key %s", info.Size, fileKey)
+	return info, nil
+}
+
+// verifyDownloadedObject 将落盘内容的 SHA256（十六进制）与对象信息中的摘要及 expectedSHA256 比对
+func verifyDownloadedObject(obj nats.ObjectResult, actual, expectedSHA256 string) error {
+	if info, err := obj.Info(); err == nil && info != nil {
+		if info.Digest != "" {
+			digest, decodeErr := nats.DecodeObjectDigest(info.Digest)
+			if decodeErr != nil {
+				return fmt.Errorf("invalid object digest %q: %v", info.Digest, decodeErr)
+			}
+			if expected := hex.EncodeToString(digest); expected != actual {
+				return fmt.Errorf("digest mismatch: expected %s, got %s", expected, actual)
+			}
+		}
+		if expected := info.Metadata[MetadataSHA256]; expected != "" && !strings.EqualFold(actual, expected) {
+			return fmt.Errorf("sha256 mismatch: expected %s, got %s", expected, actual)
+		}
+	}
+	if expectedSHA256 != "" && !strings.EqualFold(actual, expectedSHA256) {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", expectedSHA256, actual)
+	}
+	return nil
+}
+
 func validateTargetFileName(fileName string) error {
 	trimmed := strings.TrimSpace(fileName)
 	if trimmed == "." || trimmed == ".." || filepath.IsAbs(trimmed) || strings.ContainsAny(trimmed, `/\`) {
@@ -1,20 +1,58 @@
 package jetstream
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
 	"nats-executor/utils/downloaderr"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
 type stubObjectStore struct {
-	get func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error)
+	get     func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error)
+	getInfo func(name string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error)
+	put     func(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error)
+	list    func(opts ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error)
+	delete  func(name string) error
+}
+
+func (s stubObjectStore) List(opts ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error) {
+	if s.list == nil {
+		return nil, nats.ErrNoObjectsFound
+	}
+	return s.list(opts...)
+}
+
+func (s stubObjectStore) Delete(name string) error {
+	if s.delete == nil {
+		return nil
+	}
+	return s.delete(name)
+}
+
+func (s stubObjectStore) GetInfo(name string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+	if s.getInfo == nil {
+		return nil, nats.ErrObjectNotFound
+	}
+	return s.getInfo(name, opts...)
+}
+
+func (s stubObjectStore) Put(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+	if s.put == nil {
+		return &nats.ObjectInfo{ObjectMeta: *obj}, nil
+	}
+	return s.put(obj, reader, opts...)
 }
 
 func (s stubObjectStore) Get(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
@@ -25,8 +63,11 @@ func (s stubObjectStore) Get(name string, opts ...nats.GetObjectOpt) (nats.Objec
 }
 
 type stubObjectResult struct {
-	read  func(p []byte) (int, error)
-	close func() error
+	read     func(p []byte) (int, error)
+	close    func() error
+	size     uint64
+	digest   string
+	metadata map[string]string
 }
 
 func (s stubObjectResult) Read(p []byte) (int, error) {
@@ -43,8 +84,11 @@ func (s stubObjectResult) Close() error {
 	return s.close()
 }
 
-func (s stubObjectResult) Info() (*nats.ObjectInfo, error) { return &nats.ObjectInfo{}, nil }
-func (s stubObjectResult) Error() error                    { return nil }
+func (s stubObjectResult) Info() (*nats.ObjectInfo, error) {
+	return &nats.ObjectInfo{Size: s.size, Digest: s.digest, ObjectMeta: nats.ObjectMeta{Metadata: s.metadata}}, nil
+}
+
+func (s stubObjectResult) Error() error { return nil }
 
 func withTempDownloadFileCreator(tb testing.TB, fn func(string, string) (*os.File, error)) {
 	tb.Helper()
@@ -108,7 +152,7 @@ func TestDownloadToFileSucceeds(t *testing.T) {
 	}
 
 	targetDir := t.TempDir()
-	if err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt"); err != nil {
+	if err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt", ""); err != nil {
 		t.Fatalf("expected success, got %v", err)
 	}
 
@@ -138,7 +182,7 @@ func TestDownloadToFilePropagatesObjectStoreError(t *testing.T) {
 		},
 	}
 
-	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt")
+	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected object store error")
 	}
@@ -167,7 +211,7 @@ func TestDownloadToFilePropagatesCreateTempFileError(t *testing.T) {
 		},
 	}
 
-	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt")
+	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected create temp file error")
 	}
@@ -194,7 +238,7 @@ func TestDownloadToFilePropagatesCopyErrorAndRemovesTempFile(t *testing.T) {
 	}
 
 	targetDir := t.TempDir()
-	err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt")
+	err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected write error")
 	}
@@ -239,7 +283,7 @@ func TestDownloadToFileKeepsExistingFileWhenRenameFails(t *testing.T) {
 		t.Fatalf("failed to create existing file: %v", err)
 	}
 
-	err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt")
+	err := client.DownloadToFile(context.Background(), "demo-key", targetDir, "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected rename error")
 	}
@@ -272,7 +316,7 @@ func TestDownloadToFilePassesContextToObjectStore(t *testing.T) {
 		},
 	}
 
-	err := client.DownloadToFile(ctx, "demo-key", t.TempDir(), "demo.txt")
+	err := client.DownloadToFile(ctx, "demo-key", t.TempDir(), "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected context cancellation error")
 	}
@@ -284,6 +328,75 @@ func TestDownloadToFilePassesContextToObjectStore(t *testing.T) {
 	}
 }
 
+func TestDownloadToFileInterruptsBlockedReadOnDeadline(t *testing.T) {
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	client := &JetStreamClient{
+		objectStore: stubObjectStore{
+			get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+				return stubObjectResult{
+					read: func(p []byte) (int, error) {
+						<-closed
+						return 0, io.ErrClosedPipe
+					},
+					close: func() error {
+						closeOnce.Do(func() { close(closed) })
+						return nil
+					},
+				}, nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	targetDir := t.TempDir()
+	started := time.Now()
+	err := client.DownloadToFile(ctx, "demo-key", targetDir, "demo.txt", "")
+	if err == nil {
+		t.Fatal("expected deadline error")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("expected blocked read to be interrupted, took %s", elapsed)
+	}
+	if downloaderr.KindOf(err) != downloaderr.KindTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %s: %v", downloaderr.KindOf(err), err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(targetDir, "demo.txt.tmp-*"))
+	if len(matches) != 0 {
+		t.Fatalf("expected temp files to be removed, found %v", matches)
+	}
+}
+
+func TestDownloadToFileStopsReadingChunksAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reads := 0
+	client := &JetStreamClient{
+		objectStore: stubObjectStore{
+			get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+				return stubObjectResult{
+					read: func(p []byte) (int, error) {
+						reads++
+						if reads == 3 {
+							cancel()
+						}
+						return copy(p, "chunk"), nil
+					},
+				}, nil
+			},
+		},
+	}
+
+	err := client.DownloadToFile(ctx, "demo-key", t.TempDir(), "demo.txt", "")
+	if downloaderr.KindOf(err) != downloaderr.KindCanceled {
+		t.Fatalf("expected canceled error, got %s: %v", downloaderr.KindOf(err), err)
+	}
+	if reads != 3 {
+		t.Fatalf("expected reading to stop right after cancel, got %d reads", reads)
+	}
+}
+
 func TestDownloadToFileRejectsUnsafeFileName(t *testing.T) {
 	client := &JetStreamClient{
 		objectStore: stubObjectStore{
@@ -297,7 +410,7 @@ func TestDownloadToFileRejectsUnsafeFileName(t *testing.T) {
 	tests := []string{"../evil.txt", "/tmp/evil.txt", "nested/evil.txt", `..\evil.txt`}
 	for _, fileName := range tests {
 		t.Run(fileName, func(t *testing.T) {
-			err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), fileName)
+			err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), fileName, "")
 			if err == nil {
 				t.Fatal("expected unsafe file name to be rejected")
 			}
@@ -326,7 +439,7 @@ func TestDownloadToFileRemoveFailureDoesNotMaskPrimaryError(t *testing.T) {
 		},
 	}
 
-	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt")
+	err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt", "")
 	if err == nil {
 		t.Fatal("expected write error")
 	}
@@ -348,7 +461,7 @@ func TestDownloadToFilePropagatesSyncAndCloseErrors(t *testing.T) {
 			},
 		}
 
-		err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt")
+		err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt", "")
 		if err == nil || !strings.Contains(err.Error(), "failed to sync temporary file") {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -366,7 +479,7 @@ func TestDownloadToFilePropagatesSyncAndCloseErrors(t *testing.T) {
 			},
 		}
 
-		err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt")
+		err := client.DownloadToFile(context.Background(), "demo-key", t.TempDir(), "demo.txt", "")
 		if err == nil || !strings.Contains(err.Error(), "failed to close temporary file") {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -385,3 +498,294 @@ func TestNewJetStreamClientReturnsJetStreamFactoryError(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestUploadFromFile(t *testing.T) {
+	t.Run("puts file content with key", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "payload.txt")
+		if err := os.WriteFile(source, []byte("payload"), 0o600); err != nil {
+			t.Fatalf("write source: %v", err)
+		}
+
+		var gotKey, gotContent string
+		client := &JetStreamClient{
+			objectStore: stubObjectStore{
+				put: func(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+					data, err := io.ReadAll(reader)
+					if err != nil {
+						return nil, err
+					}
+					gotKey, gotContent = obj.Name, string(data)
+					return &nats.ObjectInfo{ObjectMeta: *obj, Size: uint64(len(data))}, nil
+				},
+			},
+		}
+
+		info, err := client.UploadFromFile(context.Background(), source, "transfer/payload.txt")
+		if err != nil {
+			t.Fatalf("expected upload to succeed, got %v", err)
+		}
+		if gotKey != "transfer/payload.txt" || gotContent != "payload" || info.Size != 7 {
+			t.Fatalf("unexpected upload: key=%q content=%q info=%+v", gotKey, gotContent, info)
+		}
+	})
+
+	t.Run("missing source is io error", func(t *testing.T) {
+		client := &JetStreamClient{objectStore: stubObjectStore{}}
+		_, err := client.UploadFromFile(context.Background(), filepath.Join(t.TempDir(), "missing"), "key")
+		if downloaderr.KindOf(err) != downloaderr.KindIO {
+			t.Fatalf("expected io error, got %v", err)
+		}
+	})
+
+	t.Run("put timeout is classified", func(t *testing.T) {
+		source := filepath.Join(t.TempDir(), "payload.txt")
+		if err := os.WriteFile(source, []byte("payload"), 0o600); err != nil {
+			t.Fatalf("write source: %v", err)
+		}
+		client := &JetStreamClient{
+			objectStore: stubObjectStore{
+				put: func(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+					return nil, context.DeadlineExceeded
+				},
+			},
+		}
+		_, err := client.UploadFromFile(context.Background(), source, "key")
+		if downloaderr.KindOf(err) != downloaderr.KindTimeout {
+			t.Fatalf("expected timeout error, got %v", err)
+		}
+	})
+}
+
+func TestOpenObjectReturnsReaderAndSize(t *testing.T) {
+	closed := false
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+			if name != "pkg/agent.tar.gz" {
+				t.Fatalf("unexpected object key: %s", name)
+			}
+			return stubObjectResult{
+				read:  strings.NewReader("payload").Read,
+				close: func() error { closed = true; return nil },
+				size:  7,
+			}, nil
+		},
+	}}
+
+	reader, size, err := client.OpenObject(context.Background(), "pkg/agent.tar.gz")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != "payload" || size != 7 {
+		t.Fatalf("unexpected object: data=%q size=%d", data, size)
+	}
+	_ = reader.Close()
+	if !closed {
+		t.Fatal("expected closing the reader to close the object")
+	}
+}
+
+func TestOpenObjectClassifiesGetErrors(t *testing.T) {
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+			return nil, context.DeadlineExceeded
+		},
+	}}
+
+	if _, _, err := client.OpenObject(context.Background(), "missing"); downloaderr.KindOf(err) != downloaderr.KindTimeout {
+		t.Fatalf("expected timeout kind, got %v", err)
+	}
+}
+
+func TestUploadBytes(t *testing.T) {
+	var gotKey, gotContent string
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		put: func(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+			data, _ := io.ReadAll(reader)
+			gotKey, gotContent = obj.Name, string(data)
+			return &nats.ObjectInfo{ObjectMeta: *obj, Size: uint64(len(data))}, nil
+		},
+	}}
+	if _, err := client.UploadBytes(context.Background(), "responses/a", []byte("output")); err != nil {
+		t.Fatalf("expected upload to succeed, got %v", err)
+	}
+	if gotKey != "responses/a" || gotContent != "output" {
+		t.Fatalf("unexpected upload: key=%q content=%q", gotKey, gotContent)
+	}
+
+	client.objectStore = stubObjectStore{put: func(*nats.ObjectMeta, io.Reader, ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+		return nil, nats.ErrTimeout
+	}}
+	if _, err := client.UploadBytes(context.Background(), "responses/a", nil); downloaderr.KindOf(err) != downloaderr.KindTimeout {
+		t.Fatalf("expected timeout kind, got %v", err)
+	}
+}
+
+// memoryObjectStore 在内存中保存对象内容与元数据，用于验证上传/下载往返
+func memoryObjectStore() stubObjectStore {
+	objects := map[string][]byte{}
+	infos := map[string]*nats.ObjectInfo{}
+	return stubObjectStore{
+		put: func(obj *nats.ObjectMeta, reader io.Reader, opts ...nats.ObjectOpt) (*nats.ObjectInfo, error) {
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			objects[obj.Name] = data
+			infos[obj.Name] = &nats.ObjectInfo{ObjectMeta: *obj, Size: uint64(len(data))}
+			return infos[obj.Name], nil
+		},
+		getInfo: func(name string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+			if info, ok := infos[name]; ok {
+				return info, nil
+			}
+			return nil, nats.ErrObjectNotFound
+		},
+		get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+			info, ok := infos[name]
+			if !ok {
+				return nil, nats.ErrObjectNotFound
+			}
+			return stubObjectResult{read: bytes.NewReader(objects[name]).Read, size: info.Size, metadata: info.Metadata}, nil
+		},
+	}
+}
+
+func TestUploadFromFileRecordsSHA256(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "agent.tar.gz")
+	if err := os.WriteFile(source, []byte("payload"), 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	const want = "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5"
+	client := &JetStreamClient{objectStore: memoryObjectStore()}
+
+	info, err := client.UploadFromFile(context.Background(), source, "pkg/agent.tar.gz")
+	if err != nil {
+		t.Fatalf("expected upload to succeed, got %v", err)
+	}
+	if info.Metadata[MetadataSHA256] != want || info.Size != 7 {
+		t.Fatalf("unexpected upload info: %+v", info)
+	}
+	digest, err := client.ObjectSHA256(context.Background(), "pkg/agent.tar.gz")
+	if err != nil || digest != want {
+		t.Fatalf("expected digest to round-trip through GetInfo, got %q err=%v", digest, err)
+	}
+
+	targetDir := t.TempDir()
+	if err := client.DownloadToFile(context.Background(), "pkg/agent.tar.gz", targetDir, "agent.tar.gz", ""); err != nil {
+		t.Fatalf("expected verified download to succeed, got %v", err)
+	}
+	if _, err := client.ObjectSHA256(context.Background(), "missing"); downloaderr.KindOf(err) != downloaderr.KindDependency {
+		t.Fatalf("expected dependency error for missing object, got %v", err)
+	}
+}
+
+func TestDownloadToFileRejectsSHA256Mismatch(t *testing.T) {
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+			return stubObjectResult{read: strings.NewReader("tampered").Read, metadata: map[string]string{MetadataSHA256: strings.Repeat("0", 64)}}, nil
+		},
+	}}
+
+	targetDir := t.TempDir()
+	err := client.DownloadToFile(context.Background(), "pkg/agent.tar.gz", targetDir, "agent.tar.gz", "")
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected sha256 mismatch, got %v", err)
+	}
+	if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+		t.Fatalf("expected no file to be left behind, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadToFileVerifiesObjectDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	digest := "SHA-256=" + base64.URLEncoding.EncodeToString(sum[:])
+	for name, tc := range map[string]struct {
+		content  string
+		expected string
+		wantErr  string
+	}{
+		"matching digest and expected sha256": {content: "payload", expected: strings.ToUpper(hex.EncodeToString(sum[:]))},
+		"truncated content":                   {content: "pay", wantErr: "digest mismatch"},
+		"expected sha256 mismatch":            {content: "payload", expected: strings.Repeat("0", 64), wantErr: "sha256 mismatch"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := &JetStreamClient{objectStore: stubObjectStore{
+				get: func(name string, opts ...nats.GetObjectOpt) (nats.ObjectResult, error) {
+					return stubObjectResult{read: strings.NewReader(tc.content).Read, digest: digest}, nil
+				},
+			}}
+
+			targetDir := t.TempDir()
+			err := client.DownloadToFile(context.Background(), "pkg/agent.tar.gz", targetDir, "agent.tar.gz", tc.expected)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected verified download, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) || downloaderr.KindOf(err) != downloaderr.KindDependency {
+				t.Fatalf("expected %q dependency error, got %v", tc.wantErr, err)
+			}
+			if entries, _ := os.ReadDir(targetDir); len(entries) != 0 {
+				t.Fatalf("expected no file to be left behind, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestListObjectsSortsByNameAndTreatsEmptyBucketAsEmptyList(t *testing.T) {
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		list: func(opts ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error) {
+			if len(opts) == 0 {
+				t.Fatal("expected context option")
+			}
+			return []*nats.ObjectInfo{{ObjectMeta: nats.ObjectMeta{Name: "pkg/b"}}, {ObjectMeta: nats.ObjectMeta{Name: "pkg/a"}}}, nil
+		},
+	}}
+	objects, err := client.ListObjects(context.Background())
+	if err != nil || len(objects) != 2 || objects[0].Name != "pkg/a" || objects[1].Name != "pkg/b" {
+		t.Fatalf("unexpected objects %v (err=%v)", objects, err)
+	}
+
+	empty := &JetStreamClient{objectStore: stubObjectStore{}}
+	if objects, err := empty.ListObjects(context.Background()); err != nil || objects == nil || len(objects) != 0 {
+		t.Fatalf("expected empty list for empty bucket, got %v (err=%v)", objects, err)
+	}
+}
+
+func TestListObjectsMapsContextErrors(t *testing.T) {
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		list: func(opts ...nats.ListObjectsOpt) ([]*nats.ObjectInfo, error) {
+			return nil, context.DeadlineExceeded
+		},
+	}}
+	if _, err := client.ListObjects(context.Background()); downloaderr.KindOf(err) != downloaderr.KindTimeout {
+		t.Fatalf("expected timeout kind, got %s: %v", downloaderr.KindOf(err), err)
+	}
+}
+
+func TestDeleteObjectReportsMissingObject(t *testing.T) {
+	deleted := ""
+	client := &JetStreamClient{objectStore: stubObjectStore{
+		getInfo: func(name string, opts ...nats.GetObjectInfoOpt) (*nats.ObjectInfo, error) {
+			if name == "pkg/old.tar.gz" {
+				return &nats.ObjectInfo{ObjectMeta: nats.ObjectMeta{Name: name}}, nil
+			}
+			return nil, nats.ErrObjectNotFound
+		},
+		delete: func(name string) error {
+			deleted = name
+			return nil
+		},
+	}}
+
+	if err := client.DeleteObject("pkg/old.tar.gz"); err != nil || deleted != "pkg/old.tar.gz" {
+		t.Fatalf("expected object to be deleted, got %v (deleted %q)", err, deleted)
+	}
+	deleted = ""
+	err := client.DeleteObject("pkg/missing.tar.gz")
+	if !errors.Is(err, nats.ErrObjectNotFound) || !strings.Contains(err.Error(), "object pkg/missing.tar.gz not found") || deleted != "" {
+		t.Fatalf("expected not found error without delete, got %v (deleted %q)", err, deleted)
+	}
+}
@@ -0,0 +1,69 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"nats-executor/jetstream"
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+// bucketsListTimeout 为列出对象存储 bucket 的超时
+const bucketsListTimeout = 10 * time.Second
+
+var (
+	subscribeBucketsListFn = subscribeBucketsList
+	listObjectStoreBuckets = jetstream.ListObjectStores
+)
+
+// handleBucketsListMessage 返回执行器可访问的对象存储 bucket，请求体忽略
+func handleBucketsListMessage(instanceId string, nc *nats.Conn) []byte {
+	ctx, cancel := context.WithTimeout(context.Background(), bucketsListTimeout)
+	defer cancel()
+
+	response := BucketsListResponse{InstanceId: instanceId, Buckets: []jetstream.BucketInfo{}}
+	buckets, err := listObjectStoreBuckets(ctx, nc)
+	if err != nil {
+		response.Code = utils.ErrorCodeDependencyFailure
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			response.Code = utils.ErrorCodeTimeout
+		}
+		response.Error = err.Error()
+		logger.WithInstance(instanceId).Warnf("[Buckets List] Failed to list object stores: %v", err)
+	} else {
+		response.Success = true
+		response.Buckets = buckets
+	}
+	responseContent, _ := json.Marshal(response)
+	return responseContent
+}
+
+func respondBucketsListSubscription(msg inboundMsg, instanceId string, nc *nats.Conn) bool {
+	if err := msg.Respond(handleBucketsListMessage(instanceId, nc)); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Buckets List Subscribe] Error responding to buckets list request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeBucketsList(sub subscriber, nc *nats.Conn, instanceId *string) error {
+	subject := fmt.Sprintf("objectstore.buckets.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Buckets List Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondBucketsListSubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func SubscribeBucketsList(nc *nats.Conn, instanceId *string) {
+	if err := subscribeBucketsListFn(nc, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Buckets List Subscribe] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"nats-executor/jetstream"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+func stubBucketsList(t *testing.T, buckets []jetstream.BucketInfo, err error) {
+	t.Helper()
+	original := listObjectStoreBuckets
+	listObjectStoreBuckets = func(ctx context.Context, nc *nats.Conn) ([]jetstream.BucketInfo, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatal("expected listing to be bounded by a deadline")
+		}
+		return buckets, err
+	}
+	t.Cleanup(func() { listObjectStoreBuckets = original })
+}
+
+func decodeBucketsList(t *testing.T, payload []byte) BucketsListResponse {
+	t.Helper()
+	var response BucketsListResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal buckets list: %v", err)
+	}
+	return response
+}
+
+func TestHandleBucketsListMessageReturnsBuckets(t *testing.T) {
+	stubBucketsList(t, []jetstream.BucketInfo{{Name: "packages", Storage: "file"}}, nil)
+
+	response := decodeBucketsList(t, handleBucketsListMessage("instance-1", nil))
+	if !response.Success || response.InstanceId != "instance-1" || len(response.Buckets) != 1 || response.Buckets[0].Name != "packages" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestHandleBucketsListMessageReportsErrors(t *testing.T) {
+	cases := map[error]string{
+		nats.ErrJetStreamNotEnabled: utils.ErrorCodeDependencyFailure,
+		context.DeadlineExceeded:    utils.ErrorCodeTimeout,
+	}
+	for err, code := range cases {
+		stubBucketsList(t, nil, errors.Join(errors.New("failed to list"), err))
+		response := decodeBucketsList(t, handleBucketsListMessage("instance-1", nil))
+		if response.Success || response.Code != code || response.Buckets == nil {
+			t.Fatalf("%v: unexpected response %+v", err, response)
+		}
+	}
+}
+
+func TestSubscribeBucketsListRegistersSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeBucketsList(sub, nil, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "objectstore.buckets.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
@@ -0,0 +1,40 @@
+package local
+
+import (
+	"fmt"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+// localCancels 记录带 task_id 的 local.execute 任务，供 local.cancel 取消
+var localCancels = utils.NewCancelRegistry()
+
+var subscribeLocalCancelFn = subscribeLocalCancel
+
+func respondLocalCancelSubscription(msg inboundMsg, instanceId string) bool {
+	responseContent := localCancels.HandleCancelMessage("Local Cancel", msg.Payload(), instanceId)
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Local Cancel Subscribe] Error responding to cancel request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeLocalCancel(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("local.cancel.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Local Cancel Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondLocalCancelSubscription(natsInboundMsg{msg}, *instanceId)
+	})
+	return err
+}
+
+func SubscribeLocalCancel(nc *nats.Conn, instanceId *string) {
+	if err := subscribeLocalCancelFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Local Cancel Subscribe] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package local
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+)
+
+func sendLocalCancel(t *testing.T, taskID string) utils.CancelResponse {
+	t.Helper()
+	var response utils.CancelResponse
+	msg := stubInboundMsg{
+		payload: []byte(`{"args":[{"task_id":"` + taskID + `"}],"kwargs":{}}`),
+		respond: func(payload []byte) error { return json.Unmarshal(payload, &response) },
+	}
+	if !respondLocalCancelSubscription(msg, "instance-1") {
+		t.Fatal("expected cancel request to be answered")
+	}
+	return response
+}
+
+func TestLocalCancelStopsRunningCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	done := make(chan []byte, 1)
+	started := time.Now()
+	go func() {
+		payload, _ := handleLocalExecuteMessage([]byte(`{"args":[{"args":["sleep","10"],"execute_timeout":20,"task_id":"task-cancel"}],"kwargs":{}}`), "instance-1")
+		done <- payload
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !sendLocalCancel(t, "task-cancel").Success {
+		if time.Now().After(deadline) {
+			t.Fatal("expected running task to be cancelable")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var response ExecuteResponse
+	if err := json.Unmarshal(<-done, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if response.Success || response.Code != utils.ErrorCodeCanceled || response.TaskID != "task-cancel" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("cancel took too long: %s", elapsed)
+	}
+	if cancel := sendLocalCancel(t, "task-cancel"); cancel.Success || cancel.Code != utils.ErrorCodeTaskNotFound {
+		t.Fatalf("expected finished task to be unknown, got %+v", cancel)
+	}
+}
+
+func TestLocalCancelRejectsMissingTaskID(t *testing.T) {
+	if response := sendLocalCancel(t, ""); response.Success || response.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestSubscribeLocalCancelRegistersInstanceSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeLocalCancel(sub, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "local.cancel.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// codePageUTF8 为 Windows 的 UTF-8 代码页，未指定 code_page 时 cmd / PowerShell 默认切换到该代码页
+const codePageUTF8 = 65001
+
+// codePageEncodings 为可解码的常用 Windows 代码页，其余代码页只执行 chcp，输出按默认策略解码
+var codePageEncodings = map[int]encoding.Encoding{
+	437:   charmap.CodePage437,
+	850:   charmap.CodePage850,
+	852:   charmap.CodePage852,
+	866:   charmap.CodePage866,
+	874:   charmap.Windows874,
+	932:   japanese.ShiftJIS,
+	936:   simplifiedchinese.GBK,
+	949:   korean.EUCKR,
+	950:   traditionalchinese.Big5,
+	1250:  charmap.Windows1250,
+	1251:  charmap.Windows1251,
+	1252:  charmap.Windows1252,
+	1253:  charmap.Windows1253,
+	1254:  charmap.Windows1254,
+	1255:  charmap.Windows1255,
+	1256:  charmap.Windows1256,
+	1257:  charmap.Windows1257,
+	1258:  charmap.Windows1258,
+	20866: charmap.KOI8R,
+	54936: simplifiedchinese.GB18030,
+}
+
+// outputEncodingEnv 为进程级的默认回退解码编码，请求未指定 output_encoding 时使用
+const outputEncodingEnv = "LOCAL_OUTPUT_ENCODING"
+
+const defaultOutputEncoding = "gbk"
+
+// outputEncodingNames 为 output_encoding 可用的编码名，另可用 cp<代码页> 引用 codePageEncodings 中的代码页
+var outputEncodingNames = map[string]int{
+	"gbk":       936,
+	"gb18030":   54936,
+	"big5":      950,
+	"shift_jis": 932,
+	"euc-kr":    949,
+}
+
+// lookupOutputEncoding 按名称或 cp<代码页> 查找编码，不支持时返回 false
+func lookupOutputEncoding(name string) (encoding.Encoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	codePage, ok := outputEncodingNames[name]
+	if !ok {
+		number, found := strings.CutPrefix(name, "cp")
+		if !found {
+			return nil, false
+		}
+		parsed, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, false
+		}
+		codePage = parsed
+	}
+	enc, ok := codePageEncodings[codePage]
+	return enc, ok
+}
+
+func validateOutputEncoding(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return ""
+	}
+	if _, ok := lookupOutputEncoding(name); !ok {
+		return fmt.Sprintf("unsupported output_encoding %q", strings.TrimSpace(name))
+	}
+	return ""
+}
+
+// fallbackOutputEncoding 返回非 UTF-8 输出的回退编码名：请求字段优先，其次合法的 LOCAL_OUTPUT_ENCODING，默认 gbk
+func fallbackOutputEncoding(requested string) string {
+	if name := strings.ToLower(strings.TrimSpace(requested)); name != "" {
+		return name
+	}
+	if name := strings.ToLower(strings.TrimSpace(os.Getenv(outputEncodingEnv))); name != "" {
+		if _, ok := lookupOutputEncoding(name); ok {
+			return name
+		}
+	}
+	return defaultOutputEncoding
+}
+
+func validateCodePage(codePage int) string {
+	if codePage < 0 || codePage > 65535 {
+		return "code_page must be between 1 and 65535"
+	}
+	return ""
+}
+
+// effectiveCodePage 返回 cmd / PowerShell 执行前切换到的代码页，未指定时为 UTF-8
+func effectiveCodePage(codePage int) int {
+	if codePage == 0 {
+		return codePageUTF8
+	}
+	return codePage
+}
+
+// decodeCodePageOutput 按显式指定的非 UTF-8 代码页解码输出，未知代码页返回 false
+func decodeCodePageOutput(output []byte, codePage int) (string, bool) {
+	if codePage == 0 || codePage == codePageUTF8 {
+		return "", false
+	}
+	enc, ok := codePageEncodings[codePage]
+	if !ok {
+		return "", false
+	}
+	decoded, err := enc.NewDecoder().Bytes(output)
+	if err != nil {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+func codePageArg(codePage int) string {
+	return strconv.Itoa(effectiveCodePage(codePage))
+}
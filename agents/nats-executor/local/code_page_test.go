@@ -0,0 +1,97 @@
+package local
+
+import (
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func TestWrapCmdCommandAppliesCodePage(t *testing.T) {
+	cases := map[int]string{
+		0:     "chcp 65001 >nul && dir",
+		65001: "chcp 65001 >nul && dir",
+		936:   "chcp 936 >nul && dir",
+		437:   "chcp 437 >nul && dir",
+	}
+	for codePage, want := range cases {
+		if got := wrapCmdCommandForOS("dir", codePage, "windows"); got != want {
+			t.Fatalf("code_page %d: expected %q, got %q", codePage, want, got)
+		}
+	}
+	if got := wrapCmdCommandForOS("dir", 936, "linux"); got != "dir" {
+		t.Fatalf("expected non-windows command to stay unchanged, got %q", got)
+	}
+}
+
+func TestWrapPowerShellCommandAppliesCodePage(t *testing.T) {
+	got := wrapPowerShellCommandForOS("Get-Date", 936, "windows")
+	for _, want := range []string{"[System.Text.Encoding]::GetEncoding(936)", "[Console]::OutputEncoding = $codePageEncoding", "chcp.com 936 > $null"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in %q", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "; Get-Date") {
+		t.Fatalf("expected command after encoding setup, got %q", got)
+	}
+	if got := wrapPowerShellCommandForOS("Get-Date", 65001, "windows"); !strings.Contains(got, "chcp.com 65001") || !strings.Contains(got, "$utf8NoBom") {
+		t.Fatalf("expected UTF-8 setup for code_page 65001, got %q", got)
+	}
+	if got := wrapPowerShellCommandForOS("Get-Date", 936, "linux"); got != "Get-Date" {
+		t.Fatalf("expected non-windows command to stay unchanged, got %q", got)
+	}
+}
+
+func TestDecodeExecuteOutputForCodePage(t *testing.T) {
+	cp437 := []byte{0x82, 0x74, 0x82}
+	if got := decodeExecuteOutputForCodePage(cp437, ShellTypeCmd, 437, "", "windows"); got != "été" {
+		t.Fatalf("expected cp437 output to decode, got %q", got)
+	}
+	gbk := []byte{0xd6, 0xd0, 0xce, 0xc4}
+	if got := decodeExecuteOutputForCodePage(gbk, ShellTypePowerShell, 936, "", "windows"); got != "中文" {
+		t.Fatalf("expected cp936 output to decode, got %q", got)
+	}
+	if got := decodeExecuteOutputForCodePage([]byte("plain"), ShellTypeCmd, 28591, "", "windows"); got != "plain" {
+		t.Fatalf("expected unknown code page to fall back to default decoding, got %q", got)
+	}
+	if got := decodeExecuteOutputForCodePage(cp437, ShellTypeSh, 437, "", "windows"); got == "été" {
+		t.Fatal("expected code_page to be ignored for non-Windows shells")
+	}
+}
+
+func TestExecuteRejectsInvalidCodePage(t *testing.T) {
+	response := Execute(ExecuteRequest{Command: "echo hi", ExecuteTimeout: 5, CodePage: -1}, "instance-1")
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != "code_page must be between 1 and 65535" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestDecodeExecuteOutputUsesConfiguredFallbackEncoding(t *testing.T) {
+	big5 := []byte{0xa4, 0xa4, 0xa4, 0xe5}
+	if got, strategy := decodeExecuteOutputWithFallback(big5, ShellTypeCmd, "big5", "windows"); got != "中文" || strategy != "big5" {
+		t.Fatalf("expected output_encoding big5 to decode, got %q (%s)", got, strategy)
+	}
+	shiftJIS := []byte{0x93, 0xfa, 0x96, 0x7b}
+	if got := decodeExecuteOutputForCodePage(shiftJIS, ShellTypePowerShell, 0, "CP932", "windows"); got != "日本" {
+		t.Fatalf("expected cp932 fallback to decode, got %q", got)
+	}
+
+	t.Setenv(outputEncodingEnv, "big5")
+	if got, strategy := decodeExecuteOutputWithStrategyForOS(big5, ShellTypeCmd, "windows"); got != "中文" || strategy != "big5" {
+		t.Fatalf("expected LOCAL_OUTPUT_ENCODING to set the default fallback, got %q (%s)", got, strategy)
+	}
+	t.Setenv(outputEncodingEnv, "klingon")
+	if got := fallbackOutputEncoding(""); got != defaultOutputEncoding {
+		t.Fatalf("expected invalid LOCAL_OUTPUT_ENCODING to fall back to gbk, got %q", got)
+	}
+	if got, strategy := decodeExecuteOutputWithFallback([]byte("utf-8 文本"), ShellTypeCmd, "big5", "windows"); got != "utf-8 文本" || strategy != "utf8" {
+		t.Fatalf("expected valid UTF-8 to be kept, got %q (%s)", got, strategy)
+	}
+}
+
+func TestExecuteRejectsUnsupportedOutputEncoding(t *testing.T) {
+	response := Execute(ExecuteRequest{Command: "echo hi", OutputEncoding: "klingon"}, "instance-a")
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != `unsupported output_encoding "klingon"` {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
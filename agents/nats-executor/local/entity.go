@@ -1,5 +1,12 @@
 package local
 
+import (
+	"context"
+
+	"nats-executor/jetstream"
+	"nats-executor/utils"
+)
+
 // 支持的脚本类型常量
 const (
 	ShellTypeSh         = "sh"         // Unix Shell（默认）
@@ -12,14 +19,75 @@ const (
 
 type ExecuteRequest struct {
 	Command        string            `json:"command"`
-	ExecuteTimeout int               `json:"execute_timeout"`
-	Shell          string            `json:"shell,omitempty"` // 脚本类型，支持：sh, bash, bat, cmd, powershell, pwsh，默认 "sh"
+	ExecuteTimeout int               `json:"execute_timeout"` // 执行超时（秒），<= 0 视为未设置，按默认 300 秒执行
+	Shell          string            `json:"shell,omitempty"` // 脚本类型，支持：sh, bash, bat, cmd, powershell, pwsh 及 python, python3, node, perl, ruby, php，默认 "sh"
 	Env            map[string]string `json:"env,omitempty"`
 	LogCommand     string            `json:"-"`
 	LogContext     string            `json:"-"`
 	ExecutionID    string            `json:"execution_id,omitempty"`     // 执行 ID（写入流事件）
 	StreamLogs     bool              `json:"stream_logs,omitempty"`      // 是否按行流式 publish stdout/stderr
 	StreamLogTopic string            `json:"stream_log_topic,omitempty"` // 行事件发布主题
+	JobID          string            `json:"job_id,omitempty"`           // 任务 ID，非空时缓存结果供断线后 result.fetch 补取
+	MaxOutputBytes int               `json:"max_output_bytes,omitempty"` // 输出上限（字节），默认 1MB，不超过 16MB
+	// 直接执行的程序及参数（args[0] 为程序），不经过 shell 解释；与 command/shell 互斥
+	Args []string `json:"args,omitempty"`
+	// 存放在对象存储中的脚本，下载后按 shell 执行；与 command/args 互斥
+	ScriptObject *ScriptObject `json:"script_object,omitempty"`
+	// 只返回匹配该正则的输出行；output_grep_invert 为真时返回不匹配的行
+	OutputGrep       string `json:"output_grep,omitempty"`
+	OutputGrepInvert bool   `json:"output_grep_invert,omitempty"`
+	// 设置 output_grep 时在 full_output 中同时返回过滤前的输出
+	IncludeFullOutput bool `json:"include_full_output,omitempty"`
+	// 主命令结束后（无论成功、失败或超时）始终执行的清理命令，结果在 cleanup 中单独返回
+	CleanupCommand string `json:"cleanup_command,omitempty"`
+	CleanupTimeout int    `json:"cleanup_timeout,omitempty"` // 清理命令超时（秒），默认 30
+	// Windows cmd / PowerShell 执行前切换到的代码页（如 936、437），默认 65001（UTF-8），其他 shell 忽略
+	CodePage int `json:"code_page,omitempty"`
+	// Windows cmd / PowerShell 输出不是合法 UTF-8 时的回退解码编码（如 gbk、big5、cp932），默认取 LOCAL_OUTPUT_ENCODING，再默认 gbk
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// 任务 ID，执行期间可通过 local.cancel.<instance_id> 取消，响应中原样返回
+	TaskID string `json:"task_id,omitempty"`
+	// 命令的工作目录，须为已存在的目录，默认沿用执行器的当前目录
+	WorkDir string `json:"work_dir,omitempty"`
+	// 写入命令标准输入的内容，写完即关闭；为空时命令的 stdin 为空设备
+	Stdin string `json:"stdin,omitempty"`
+	// 超时或取消时先向进程组发 SIGTERM，等待该宽限期（秒，默认 5）后仍未退出再 SIGKILL
+	KillGracePeriod int `json:"kill_grace_period,omitempty"`
+	// 以指定用户（用户名或 uid）运行命令，仅 Unix 支持且执行器须以 root 运行
+	RunAsUser string `json:"run_as_user,omitempty"`
+	// powershell / pwsh 追加 -NoProfile -NonInteractive -ExecutionPolicy Bypass，避免执行策略拒绝脚本、配置文件干扰输出
+	PowerShellBypass bool `json:"powershell_bypass,omitempty"`
+	// powershell / pwsh 以 -EncodedCommand 传入 Base64 UTF-16LE 编码的脚本，规避命令行引号转义问题
+	PowerShellEncodedCommand bool `json:"powershell_encoded_command,omitempty"`
+	// ctx 由处理器在截止时间到达时取消以终止命令，不参与序列化；为空时不受处理器截止时间约束
+	ctx context.Context
+}
+
+// withContext 返回绑定 ctx 的请求副本
+func (r ExecuteRequest) withContext(ctx context.Context) ExecuteRequest {
+	r.ctx = ctx
+	return r
+}
+
+func (r ExecuteRequest) requestContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ObjectStoreRunRequest 为 run.objectstore 的请求：下载 bucket_name/file_key 指向的脚本并执行，
+// 其余字段与 local.execute 相同（command、args、script_object 除外）
+type ObjectStoreRunRequest struct {
+	BucketName string `json:"bucket_name"`
+	FileKey    string `json:"file_key"`
+	ExecuteRequest
+}
+
+// ScriptObject 指向对象存储中的脚本，避免超大脚本经 NATS 消息传递
+type ScriptObject struct {
+	BucketName string `json:"bucket_name"`
+	FileKey    string `json:"file_key"`
 }
 
 type ExecuteResponse struct {
@@ -27,12 +95,202 @@ type ExecuteResponse struct {
 	InstanceId string `json:"instance_id"`
 	Success    bool   `json:"success"`
 	Code       string `json:"code,omitempty"`
-	Error      string `json:"error,omitempty"` // 添加错误字段，omitempty表示为空时不序列化
+	Error      string `json:"error,omitempty"`     // 添加错误字段，omitempty表示为空时不序列化
+	Truncated  bool   `json:"truncated,omitempty"` // 输出超过上限被截断
+	// 命令不存在时（code=command_not_found）缺失的可执行文件名
+	MissingCommand string `json:"missing_command,omitempty"`
+	// 文件传输实际写入的字节数
+	BytesTransferred int64 `json:"bytes_transferred,omitempty"`
+	// 响应超过 max_response_bytes 且策略为 offload 时，完整输出所在的对象 key
+	OutputObjectKey string `json:"output_object_key,omitempty"`
+	// include_full_output 时过滤前的完整输出，响应超过 max_response_bytes 时不返回
+	FullOutput string `json:"full_output,omitempty"`
+	// cleanup_command 的执行结果，不影响主命令的 success
+	Cleanup *ExecuteResponse `json:"cleanup,omitempty"`
+	// 输出超过 compress_output_threshold 时为 gzip+base64，result 与 full_output 需按此解码
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// 请求中的 task_id，用于关联取消请求
+	TaskID string `json:"task_id,omitempty"`
+	// unzip.local 使用 continue_on_error 时未能解压的条目，为空表示全部解压成功
+	UnzipFailures []utils.UnzipEntryError `json:"failures,omitempty"`
 }
 
 type HealthCheckResponse struct {
+	Success        bool   `json:"success"`
+	Status         string `json:"status"` // "ok"
+	InstanceId     string `json:"instance_id"`
+	Timestamp      string `json:"timestamp"`
+	Version        string `json:"version"`          // 执行器版本，构建时注入
+	UptimeSeconds  int64  `json:"uptime_seconds"`   // 进程已运行时间
+	Goroutines     int    `json:"goroutines"`       // 当前 goroutine 数
+	MemoryBytes    uint64 `json:"memory_bytes"`     // Go 运行时向系统申请的内存
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"` // 已分配的堆内存
+	ActiveJobs     int    `json:"active_jobs"`      // 正在执行的任务数，与 jobs.list 一致
+}
+
+type ReadinessCheck struct {
+	Name   string `json:"name"` // nats / object_store / concurrency
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ReadinessResponse 为 health.ready 的响应，success 与 status 表示当前是否可承接任务
+type ReadinessResponse struct {
+	Success      bool             `json:"success"`
+	Status       string           `json:"status"` // "ready" / "not_ready"
+	InstanceId   string           `json:"instance_id"`
+	Timestamp    string           `json:"timestamp"`
+	InflightJobs int              `json:"inflight_jobs"`
+	Checks       []ReadinessCheck `json:"checks"`
+}
+
+// ObjectStoreTransferRequest 经对象存储中转的主机间文件复制：本机上传后由目标实例下载
+type ObjectStoreTransferRequest struct {
+	BucketName       string `json:"bucket_name"`
+	FileKey          string `json:"file_key,omitempty"`  // 中转对象 key，默认 transfer/<源实例>/<文件名>
+	SourcePath       string `json:"source_path"`         // 本机源文件路径
+	TargetInstanceID string `json:"target_instance_id"`  // 目标主机执行器实例 ID
+	TargetPath       string `json:"target_path"`         // 目标主机保存目录
+	FileName         string `json:"file_name,omitempty"` // 目标文件名，默认取源文件名
+	ExecuteTimeout   int    `json:"execute_timeout"`     // 上传与下载两段共用的总超时（秒）
+}
+
+type ObjectStoreTransferResponse struct {
+	Output     string           `json:"result"`
+	InstanceId string           `json:"instance_id"`
+	Success    bool             `json:"success"`
+	Code       string           `json:"code,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Upload     ExecuteResponse  `json:"upload"`
+	Download   *ExecuteResponse `json:"download,omitempty"`
+}
+
+// ObjectStoreUploadRequest 将本机文件（如采集的日志）上传到对象存储
+type ObjectStoreUploadRequest struct {
+	BucketName     string `json:"bucket_name"`
+	FileKey        string `json:"file_key,omitempty"` // 对象 key，默认 upload/<实例>/<文件名>
+	SourcePath     string `json:"source_path"`        // 本机源文件路径
+	ExecuteTimeout int    `json:"execute_timeout"`
+}
+
+type ObjectStoreUploadResponse struct {
+	Output     string `json:"result"`
+	InstanceId string `json:"instance_id"`
 	Success    bool   `json:"success"`
-	Status     string `json:"status"` // "ok"
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	BucketName string `json:"bucket_name,omitempty"`
+	FileKey    string `json:"file_key,omitempty"`
+	// 以下为写入成功后的对象元信息
+	Size     uint64 `json:"size"` // 写入的字节数
+	Chunks   uint32 `json:"chunks,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`    // 内容的 SHA256（十六进制），下载方据此校验
+	Digest   string `json:"digest,omitempty"`    // 对象存储记录的摘要（SHA-256=<base64>）
+	ModTime  string `json:"mod_time,omitempty"`  // 写入完成时间（RFC3339）
+	ObjectID string `json:"object_id,omitempty"` // 对象存储分配的 NUID
+}
+
+// ObjectsListRequest 列出 bucket 中的对象，prefix 非空时只返回 key 以其开头的对象
+type ObjectsListRequest struct {
+	BucketName string `json:"bucket_name"`
+	Prefix     string `json:"prefix,omitempty"`
+}
+
+// ObjectEntry 为对象列表中的一项
+type ObjectEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Size        uint64 `json:"size"`
+	Chunks      uint32 `json:"chunks"`
+	ModTime     string `json:"mod_time,omitempty"` // 最后写入时间（RFC3339）
+	Digest      string `json:"digest,omitempty"`   // 对象存储记录的摘要（SHA-256=<base64>）
+	SHA256      string `json:"sha256,omitempty"`   // 经执行器上传时记录的 SHA256（十六进制）
+	ObjectID    string `json:"object_id,omitempty"`
+}
+
+// ObjectsListResponse 为 objectstore.list.<instance_id> 的响应
+type ObjectsListResponse struct {
+	InstanceId string        `json:"instance_id"`
+	Success    bool          `json:"success"`
+	BucketName string        `json:"bucket_name,omitempty"`
+	Objects    []ObjectEntry `json:"objects"`
+	Code       string        `json:"code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// ObjectDeleteRequest 删除 bucket 中的一个对象
+type ObjectDeleteRequest struct {
+	BucketName string `json:"bucket_name"`
+	FileKey    string `json:"file_key"`
+}
+
+// ObjectDeleteResponse 为 objectstore.delete.<instance_id> 的响应
+type ObjectDeleteResponse struct {
 	InstanceId string `json:"instance_id"`
-	Timestamp  string `json:"timestamp"`
+	Success    bool   `json:"success"`
+	BucketName string `json:"bucket_name,omitempty"`
+	FileKey    string `json:"file_key,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// JobsListRequest jobs.list 的过滤条件，status 为空时返回全部任务
+type JobsListRequest struct {
+	Status string `json:"status,omitempty"`
+}
+
+type JobsListResponse struct {
+	InstanceId string          `json:"instance_id"`
+	Success    bool            `json:"success"`
+	Jobs       []utils.JobInfo `json:"jobs"`
+	Code       string          `json:"code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// TailRequest tail.<job_id> 的请求参数，lines 为 0 时返回缓冲区内全部行
+type TailRequest struct {
+	Lines int `json:"lines,omitempty"`
+}
+
+type TailResponse struct {
+	InstanceId string   `json:"instance_id"`
+	Success    bool     `json:"success"`
+	JobID      string   `json:"job_id"`
+	Lines      []string `json:"lines"`
+	Code       string   `json:"code,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// BucketsListResponse 为 objectstore.buckets.<instance_id> 的响应
+type BucketsListResponse struct {
+	InstanceId string                 `json:"instance_id"`
+	Success    bool                   `json:"success"`
+	Buckets    []jetstream.BucketInfo `json:"buckets"`
+	Code       string                 `json:"code,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// UsageResponse 为 usage.<instance_id> 的响应，只统计执行器进程自身，供自动扩缩容判断负载
+type UsageResponse struct {
+	InstanceId         string `json:"instance_id"`
+	Success            bool   `json:"success"`
+	InFlightOperations int64  `json:"inflight_operations"` // 正在处理的请求数
+	// 距上次查询（首次为进程启动）的进程 CPU 使用率，单核满载为 100
+	CPUPercent          float64 `json:"cpu_percent"`
+	CPUSeconds          float64 `json:"cpu_seconds"`           // 进程累计 CPU 时间（秒）
+	MemoryBytes         uint64  `json:"memory_bytes"`          // Go 运行时向系统申请的内存
+	HeapAllocBytes      uint64  `json:"heap_alloc_bytes"`      // 已分配的堆内存
+	Goroutines          int     `json:"goroutines"`            // 当前 goroutine 数
+	CompletedLastMinute int64   `json:"completed_last_minute"` // 最近一分钟处理完成的请求数
+	ThroughputPerSecond float64 `json:"throughput_per_second"` // 最近一分钟的平均每秒处理数
+	UptimeSeconds       int64   `json:"uptime_seconds"`
+}
+
+// LimitsResponse 为 limits / limits.set 的响应，返回调整后的生效限制
+type LimitsResponse struct {
+	InstanceId string               `json:"instance_id"`
+	Success    bool                 `json:"success"`
+	Limits     utils.LimitsSnapshot `json:"limits"`
+	Code       string               `json:"code,omitempty"`
+	Error      string               `json:"error,omitempty"`
 }
@@ -4,15 +4,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"nats-executor/logger"
 	"nats-executor/utils"
-	"nats-executor/utils/downloaderr"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -20,7 +23,6 @@ import (
 	"unicode/utf8"
 
 	"github.com/nats-io/nats.go"
-	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
 type downloadConn interface{}
@@ -35,6 +37,13 @@ type natsInboundMsg struct{ *nats.Msg }
 
 func (m natsInboundMsg) Payload() []byte { return m.Data }
 
+func (m natsInboundMsg) ReplySubject() string { return m.Reply }
+
+// replySubjecter 用于在 Respond 失败时取回原始回复主题，便于重连后补发
+type replySubjecter interface {
+	ReplySubject() string
+}
+
 type subscriber interface {
 	Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error)
 }
@@ -45,14 +54,21 @@ var (
 		natsConn, _ := nc.(*nats.Conn)
 		return utils.DownloadFile(req, natsConn)
 	}
-	unzipLocalArchive          = utils.UnzipToDir
+	unzipLocalArchive          = utils.UnzipToDirWithResult
 	nowUTC                     = func() time.Time { return time.Now().UTC() }
+	handlerDeadline            = utils.HandlerDeadline
+	loadPersistedResult        = utils.LoadPersistedResult
 	subscribeLocalExecutorFn   = subscribeLocalExecutor
 	subscribeDownloadToLocalFn = subscribeDownloadToLocal
 	subscribeUnzipToLocalFn    = subscribeUnzipToLocal
 	subscribeHealthCheckFn     = subscribeHealthCheck
+	subscribeResultFetchFn     = subscribeResultFetch
+	localResultCache           = utils.NewResultCache(utils.DefaultResultCacheTTL, utils.DefaultResultCacheMaxEntries)
 )
 
+// handlerReturned 在截止时间内运行的处理函数返回时调用，测试据此等待后台处理结束后再恢复包级变量
+var handlerReturned = func() {}
+
 // --- 流式行输出（job_mgmt 脚本执行实时日志） ---
 // localStreamPublisher 在 SubscribeLocalExecutor 时被设为本进程的 NATS 连接；
 // Execute 在 req.StreamLogs 为真时用它按行 publish stdout/stderr。设值一次（启动订阅时），
@@ -154,54 +170,223 @@ func invalidRequestResponse(instanceId, message string) ([]byte, bool) {
 	return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, message), true
 }
 
-func handleLocalExecuteMessage(data []byte, instanceId string) ([]byte, bool) {
+// decodeExecuteArgs 将消息的首个参数解析到 target，失败或 instance_id 不匹配时返回错误响应
+func decodeExecuteArgs(data []byte, instanceId, logPrefix string, target any) []byte {
 	incoming, ok := decodeIncomingMessage(data)
 	if !ok {
 		var probe struct {
 			Args []json.RawMessage `json:"args"`
 		}
 		if err := json.Unmarshal(data, &probe); err != nil {
-			return invalidRequestResponse(instanceId, "invalid request payload")
+			return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload")
 		}
-		return invalidRequestResponse(instanceId, "missing request arguments")
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "missing request arguments")
+	}
+	if mismatch := utils.InstanceMismatchResponse(logPrefix, data, instanceId); mismatch != nil {
+		return mismatch
 	}
+	if err := json.Unmarshal(incoming.Args[0], target); err != nil {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload")
+	}
+	return nil
+}
 
+func handleLocalExecuteMessage(data []byte, instanceId string) ([]byte, bool) {
 	var localExecuteRequest ExecuteRequest
-	if err := json.Unmarshal(incoming.Args[0], &localExecuteRequest); err != nil {
-		return invalidRequestResponse(instanceId, "invalid request payload")
+	if errResponse := decodeExecuteArgs(data, instanceId, "Local Execute", &localExecuteRequest); errResponse != nil {
+		return errResponse, true
 	}
+	return runLocalExecuteRequest(localExecuteRequest, instanceId, "local.execute")
+}
 
-	responseData := executeLocalCommand(localExecuteRequest, instanceId)
+// runLocalExecuteRequest 在并发限制与处理器截止时间内执行请求，按 job id 缓存结果；operation 用于任务列表
+func runLocalExecuteRequest(localExecuteRequest ExecuteRequest, instanceId, operation string) ([]byte, bool) {
+	outputFilter, err := utils.NewOutputFilter(localExecuteRequest.OutputGrep, localExecuteRequest.OutputGrepInvert)
+	if err != nil {
+		return invalidRequestResponse(instanceId, err.Error())
+	}
+
+	release, acquired := utils.DefaultLimits.Acquire("local.execute")
+	if !acquired {
+		limit := utils.DefaultLimits.Snapshot().MaxConcurrentJobs
+		logger.WithInstance(instanceId).Warnf("[Local Subscribe] Rejecting request: max_concurrent_jobs=%d reached", limit)
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeTooManyRequests, fmt.Sprintf("max_concurrent_jobs limit %d reached", limit)), true
+	}
+
+	done := utils.DefaultJobs.Track(utils.JobInfo{ID: requestJobID(localExecuteRequest), Operation: operation, Command: commandDisplay(localExecuteRequest)})
+	deadline := handlerDeadline(utils.ExecuteTimeout(localExecuteRequest.ExecuteTimeout) + int(killGracePeriod(localExecuteRequest.KillGracePeriod).Seconds()) + utils.CleanupTimeout(localExecuteRequest.CleanupCommand, localExecuteRequest.CleanupTimeout))
+	responseData, timedOut := utils.RunWithDeadline(deadline, func(ctx context.Context) ExecuteResponse {
+		defer handlerReturned()
+		defer release()
+		defer done()
+		req := localExecuteRequest.withContext(ctx)
+		var response ExecuteResponse
+		if req.ScriptObject != nil {
+			response = executeScriptObject(req, instanceId, localScriptConn)
+		} else {
+			response = executeLocalCommand(req, instanceId)
+		}
+		return withLocalCleanup(req, instanceId, response)
+	}, func() ExecuteResponse {
+		message := fmt.Sprintf("Handler deadline exceeded after %s (timeout: %ds)", deadline, localExecuteRequest.ExecuteTimeout)
+		return ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: utils.ErrorCodeTimeout, Error: message}
+	})
+	if timedOut {
+		// 截止时已取消命令的上下文，进程随之终止；名额与任务条目立即归还，不等后台收尾
+		release()
+		done()
+		logger.WithInstance(instanceId).Warnf("[Local Subscribe] Command did not finish within handler deadline %s, responding with timeout", deadline)
+	}
+	responseData.TaskID = localExecuteRequest.TaskID
+	if outputFilter != nil {
+		if localExecuteRequest.IncludeFullOutput {
+			responseData.FullOutput = responseData.Output
+		}
+		responseData.Output = outputFilter.Apply(responseData.Output)
+	}
 	responseContent, err := json.Marshal(responseData)
 	if err != nil {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to marshal response: %v", err)), true
 	}
+	responseContent = fitLocalExecuteResponse(instanceId, responseData, responseContent)
+
+	// 带 job id 的任务缓存完整结果，respond 阶段遇到 NATS 瞬断时可补发或被 result.fetch 拉取
+	if localExecuteRequest.JobID != "" {
+		localResultCache.Store(localExecuteRequest.JobID, responseContent)
+	}
 
 	return responseContent, true
 }
 
+// fitLocalExecuteResponse 使响应不超过 max_response_bytes，超限时按配置截断或转存输出
+func fitLocalExecuteResponse(instanceId string, response ExecuteResponse, payload []byte) []byte {
+	// 输出超过 compress_output_threshold 时优先压缩返回，压缩后未变小或仍超限则按明文继续处理
+	if utils.ShouldCompressOutput(response.Output) {
+		compressed := response
+		compressed.Output = utils.EncodeOutput(response.Output)
+		compressed.FullOutput = utils.EncodeOutput(response.FullOutput)
+		compressed.OutputEncoding = utils.OutputEncodingGzipBase64
+		if data, err := json.Marshal(compressed); err == nil && len(data) < len(payload) && !utils.ExceedsResponseLimit(len(data)) {
+			return data
+		}
+	}
+	// 超限时先舍弃 full_output，只在仍超限时处理过滤后的输出
+	if response.FullOutput != "" && utils.ExceedsResponseLimit(len(payload)) {
+		response.FullOutput = ""
+		if trimmed, err := json.Marshal(response); err == nil {
+			payload = trimmed
+		}
+	}
+	// 仍超限时舍弃清理命令的输出，保留其执行状态
+	if response.Cleanup != nil && response.Cleanup.Output != "" && utils.ExceedsResponseLimit(len(payload)) {
+		cleanup := *response.Cleanup
+		cleanup.Output = ""
+		cleanup.Truncated = true
+		response.Cleanup = &cleanup
+		if trimmed, err := json.Marshal(response); err == nil {
+			payload = trimmed
+		}
+	}
+	return utils.FitResponse("Local Execute", instanceId, payload, response.Output, func(output, objectKey string, truncated bool) ([]byte, error) {
+		response.Output = output
+		response.OutputObjectKey = objectKey
+		response.Truncated = response.Truncated || truncated
+		return json.Marshal(response)
+	})
+}
+
+// withLocalCleanup 在主命令结束后执行 cleanup_command；请求本身无效（主命令未执行）时不执行
+func withLocalCleanup(req ExecuteRequest, instanceId string, response ExecuteResponse) ExecuteResponse {
+	if strings.TrimSpace(req.CleanupCommand) == "" || response.Code == utils.ErrorCodeInvalidRequest {
+		return response
+	}
+	logger.WithInstance(instanceId).Debugf("[Local Execute] Running cleanup command")
+	cleanup := executeLocalCommand(ExecuteRequest{
+		Command:                  req.CleanupCommand,
+		ExecuteTimeout:           utils.CleanupTimeout(req.CleanupCommand, req.CleanupTimeout),
+		Shell:                    req.Shell,
+		Env:                      req.Env,
+		MaxOutputBytes:           req.MaxOutputBytes,
+		LogContext:               req.LogContext,
+		CodePage:                 req.CodePage,
+		OutputEncoding:           req.OutputEncoding,
+		WorkDir:                  req.WorkDir,
+		KillGracePeriod:          req.KillGracePeriod,
+		RunAsUser:                req.RunAsUser,
+		PowerShellBypass:         req.PowerShellBypass,
+		PowerShellEncodedCommand: req.PowerShellEncodedCommand,
+	}, instanceId)
+	if !cleanup.Success {
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Cleanup command failed: %s", cleanup.Error)
+	}
+	response.Cleanup = &cleanup
+	return response
+}
+
+// requestJobID 返回任务 ID：优先 job_id，其次 execution_id
+func requestJobID(req ExecuteRequest) string {
+	if req.JobID != "" {
+		return req.JobID
+	}
+	return req.ExecutionID
+}
+
+func extractJobID(data []byte) string {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return ""
+	}
+	var probe struct {
+		JobID string `json:"job_id"`
+	}
+	if err := json.Unmarshal(incoming.Args[0], &probe); err != nil {
+		return ""
+	}
+	return probe.JobID
+}
+
+func handleResultFetchMessage(subject, instanceId string) ([]byte, bool) {
+	jobID := strings.TrimPrefix(subject, "result.fetch.")
+	if jobID == "" || jobID == subject {
+		return nil, false
+	}
+	payload, ok := localResultCache.Get(jobID)
+	if !ok {
+		// 连接关闭时转存到对象存储的结果，进程重启或缓存过期后仍可补取
+		if payload, ok = loadPersistedResult(instanceId, jobID); ok {
+			logger.WithInstance(instanceId).Debugf("[Result Fetch] Serving persisted result for job: %s", jobID)
+			return payload, true
+		}
+		// 结果不在本实例（或已过期）时保持静默，由持有结果的实例应答
+		return nil, false
+	}
+	logger.WithInstance(instanceId).Debugf("[Result Fetch] Serving cached result for job: %s", jobID)
+	return payload, true
+}
+
 func handleDownloadToLocalMessage(data []byte, instanceId string, nc downloadConn) ([]byte, bool) {
 	incoming, ok := decodeIncomingMessage(data)
 	if !ok {
 		return invalidRequestResponse(instanceId, "invalid request payload")
 	}
+	if mismatch := utils.InstanceMismatchResponse("Download To Local", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
 
 	var downloadRequest utils.DownloadFileRequest
 	if err := json.Unmarshal(incoming.Args[0], &downloadRequest); err != nil {
 		return invalidRequestResponse(instanceId, "invalid request payload")
 	}
+	if message := utils.CheckDownloadTarget("target_path", downloadRequest.TargetPath); message != "" {
+		logger.WithInstance(instanceId).Warnf("[Download To Local] Rejected download: %s", message)
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodePathForbidden, message), true
+	}
 
 	var resp ExecuteResponse
 	err := downloadToLocalFile(downloadRequest, nc)
 	if err != nil {
 		message := fmt.Sprintf("Failed to download file: %v", err)
-		code := utils.ErrorCodeDependencyFailure
-		switch {
-		case downloaderr.KindOf(err) == downloaderr.KindTimeout || errors.Is(err, context.DeadlineExceeded):
-			code = utils.ErrorCodeTimeout
-		case downloaderr.KindOf(err) == downloaderr.KindIO:
-			code = utils.ErrorCodeExecutionFailure
-		}
+		code := objectStoreErrorCode(err)
 		resp = ExecuteResponse{
 			Success:    false,
 			Output:     message,
@@ -226,13 +411,16 @@ func handleUnzipToLocalMessage(data []byte, instanceId string) ([]byte, bool) {
 	if !ok {
 		return invalidRequestResponse(instanceId, "invalid request payload")
 	}
+	if mismatch := utils.InstanceMismatchResponse("Unzip To Local", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
 
 	var unzipRequest utils.UnzipRequest
 	if err := json.Unmarshal(incoming.Args[0], &unzipRequest); err != nil {
 		return invalidRequestResponse(instanceId, "invalid request payload")
 	}
 
-	parentDir, err := unzipLocalArchive(unzipRequest)
+	result, err := unzipLocalArchive(unzipRequest)
 	if err != nil {
 		message := fmt.Sprintf("Failed to unzip file: %v", err)
 		resp := ExecuteResponse{
@@ -246,50 +434,109 @@ func handleUnzipToLocalMessage(data []byte, instanceId string) ([]byte, bool) {
 		return responseContent, true
 	}
 
+	if len(result.Failures) > 0 {
+		logger.WithInstance(instanceId).Warnf("[Unzip To Local] Extracted %s with %d failed entries", unzipRequest.ZipPath, len(result.Failures))
+	}
 	resp := ExecuteResponse{
-		Output:     parentDir,
-		InstanceId: instanceId,
-		Success:    true,
+		Output:        result.ParentDir,
+		InstanceId:    instanceId,
+		Success:       true,
+		UnzipFailures: result.Failures,
 	}
 	responseContent, _ := json.Marshal(resp)
 	return responseContent, true
 }
 
 func handleHealthCheckMessage(instanceId string) []byte {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
 	response := HealthCheckResponse{
-		Success:    true,
-		Status:     "ok",
-		InstanceId: instanceId,
-		Timestamp:  nowUTC().Format(time.RFC3339),
+		Success:        true,
+		Status:         "ok",
+		InstanceId:     instanceId,
+		Timestamp:      nowUTC().Format(time.RFC3339),
+		Version:        utils.Version,
+		UptimeSeconds:  int64(usageNow().Sub(processStartTime).Seconds()),
+		Goroutines:     runtime.NumGoroutine(),
+		MemoryBytes:    memStats.Sys,
+		HeapAllocBytes: memStats.HeapAlloc,
+		ActiveJobs:     len(utils.DefaultJobs.List(utils.JobStatusRunning)),
 	}
 	responseContent, _ := json.Marshal(response)
 	return responseContent
 }
 
 func respondLocalExecuteMessage(msg responseMsg, data []byte, instanceId string) bool {
-	responseContent, ok := handleLocalExecuteMessage(data, instanceId)
+	return respondExecuteMessage(msg, data, instanceId, handleLocalExecuteMessage)
+}
+
+// respondExecuteMessage 应答执行类请求，应答失败时缓存带 job id 的结果以便补发
+func respondExecuteMessage(msg responseMsg, data []byte, instanceId string, handle func([]byte, string) ([]byte, bool)) bool {
+	responseContent, ok := handle(data, instanceId)
 	if !ok {
-		logger.Errorf("[Local Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[Local Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Local Subscribe] Instance: %s, Error responding to request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Local Subscribe] Error responding to request: %v", err)
+		if jobID := extractJobID(data); jobID != "" {
+			replySubject := ""
+			if replier, ok := msg.(replySubjecter); ok {
+				replySubject = replier.ReplySubject()
+			}
+			localResultCache.MarkPending(jobID, replySubject)
+			logger.WithInstance(instanceId).Warnf("[Local Subscribe] Result for job %s cached for redelivery", jobID)
+		}
+		utils.PersistUndeliveredResult("Local Subscribe", instanceId, utils.ResultIDFromRequest(data), responseContent, err)
 		return false
 	}
 
-	logger.Debugf("[Local Subscribe] Instance: %s, Response sent successfully, size: %d bytes", instanceId, len(responseContent))
+	logger.WithInstance(instanceId).Debugf("[Local Subscribe] Response sent successfully, size: %d bytes", len(responseContent))
+	return true
+}
+
+func respondResultFetchSubscription(msg responseMsg, subject, instanceId string) bool {
+	responseContent, ok := handleResultFetchMessage(subject, instanceId)
+	if !ok {
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Result Fetch] Error responding to result fetch: %v", err)
+		return false
+	}
+	localResultCache.MarkDelivered(strings.TrimPrefix(subject, "result.fetch."))
 	return true
 }
 
+// RedeliverPendingResults 在 NATS 重连后把 respond 失败的结果补发到原始回复主题
+func RedeliverPendingResults(pub eventPublisher) int {
+	if pub == nil {
+		return 0
+	}
+	delivered := 0
+	for _, pending := range localResultCache.Pending() {
+		if err := pub.Publish(pending.ReplySubject, pending.Payload); err != nil {
+			logger.Warnf("[Result Redeliver] Failed to redeliver result for job %s: %v", pending.JobID, err)
+			continue
+		}
+		localResultCache.MarkDelivered(pending.JobID)
+		delivered++
+	}
+	if delivered > 0 {
+		logger.Infof("[Result Redeliver] Redelivered %d pending result(s)", delivered)
+	}
+	return delivered
+}
+
 func respondDownloadToLocalSubscription(msg inboundMsg, instanceId string, nc downloadConn) bool {
 	responseContent, ok := handleDownloadToLocalMessage(msg.Payload(), instanceId, nc)
 	if !ok {
-		logger.Errorf("[Download Local Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[Download Local Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Download Local Subscribe] Instance: %s, Error responding to download request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Download Local Subscribe] Error responding to download request: %v", err)
 		return false
 	}
 	return true
@@ -298,11 +545,11 @@ func respondDownloadToLocalSubscription(msg inboundMsg, instanceId string, nc do
 func respondUnzipToLocalSubscription(msg inboundMsg, instanceId string) bool {
 	responseContent, ok := handleUnzipToLocalMessage(msg.Payload(), instanceId)
 	if !ok {
-		logger.Errorf("[Unzip Local Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[Unzip Local Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Unzip Local Subscribe] Instance: %s, Error responding to unzip request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Unzip Local Subscribe] Error responding to unzip request: %v", err)
 		return false
 	}
 	return true
@@ -312,7 +559,7 @@ func respondHealthCheckSubscription(msg inboundMsg, instanceId, subject string)
 	logger.Debugf("[Health Check] Received health check request from subject: %s", subject)
 	responseContent := handleHealthCheckMessage(instanceId)
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Health Check Subscribe] Instance: %s, Error responding to health check: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Health Check Subscribe] Error responding to health check: %v", err)
 		return false
 	}
 	logger.Debugf("[Health Check] Responded with status: ok")
@@ -332,10 +579,16 @@ func isSupportedShell(shell string) bool {
 	case ShellTypeSh, ShellTypeBash, ShellTypeBat, ShellTypeCmd, ShellTypePowerShell, ShellTypePwsh:
 		return true
 	default:
-		return false
+		_, ok := lookupInterpreter(shell)
+		return ok
 	}
 }
 
+// unsupportedShellMessage 提示未知解释器改用 args 显式传入参数
+func unsupportedShellMessage(shell string) string {
+	return fmt.Sprintf("unsupported shell: %s (use args to run other interpreters)", strings.TrimSpace(shell))
+}
+
 func invalidExecuteResponse(instanceId, message string) ExecuteResponse {
 	return ExecuteResponse{
 		Output:     message,
@@ -346,62 +599,163 @@ func invalidExecuteResponse(instanceId, message string) ExecuteResponse {
 	}
 }
 
+// runAsErrorResponse 构造 run_as_user 解析或切换失败时的响应
+func runAsErrorResponse(instanceId, code, message string) ExecuteResponse {
+	return ExecuteResponse{
+		Output:     message,
+		InstanceId: instanceId,
+		Success:    false,
+		Code:       code,
+		Error:      message,
+	}
+}
+
+// commandDisplay 返回用于日志与任务列表的命令文本，argv 模式下按空格拼接参数
+func commandDisplay(req ExecuteRequest) string {
+	if req.ScriptObject != nil {
+		return fmt.Sprintf("script_object %s/%s", req.ScriptObject.BucketName, req.ScriptObject.FileKey)
+	}
+	if len(req.Args) > 0 {
+		return strings.Join(req.Args, " ")
+	}
+	return req.Command
+}
+
+func validateArgs(req ExecuteRequest) string {
+	switch {
+	case strings.TrimSpace(req.Command) != "":
+		return "command and args are mutually exclusive"
+	case strings.TrimSpace(req.Shell) != "":
+		return "shell cannot be combined with args"
+	case strings.TrimSpace(req.Args[0]) == "":
+		return "args[0] must name the program to run"
+	default:
+		return ""
+	}
+}
+
+// writeStdin 写入 stdin 后立即关闭管道，使读取 stdin 的命令收到 EOF 而不是一直等待输入；
+// 命令未读完 stdin 就退出时写入会失败，此时忽略剩余内容
+func writeStdin(pipe io.WriteCloser, input, instanceId string) {
+	defer pipe.Close()
+	if _, err := io.WriteString(pipe, input); err != nil {
+		logger.WithInstance(instanceId).Debugf("[Local Execute] stdin not fully consumed: %v", err)
+	}
+}
+
+// validateWorkDir 在启动命令前检查 work_dir，避免目录不存在时只得到 shell 含糊的 "no such file" 错误
+func validateWorkDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	if strings.TrimSpace(dir) == "" {
+		return "work_dir must not be blank"
+	}
+	info, err := os.Stat(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Sprintf("work_dir %s does not exist", dir)
+	case err != nil:
+		return fmt.Sprintf("work_dir %s is not accessible: %v", dir, err)
+	case !info.IsDir():
+		return fmt.Sprintf("work_dir %s is not a directory", dir)
+	default:
+		return ""
+	}
+}
+
 func Execute(req ExecuteRequest, instanceId string) ExecuteResponse {
-	if strings.TrimSpace(req.Command) == "" {
+	if len(req.Args) > 0 {
+		if validationErr := validateArgs(req); validationErr != "" {
+			return invalidExecuteResponse(instanceId, validationErr)
+		}
+	} else if strings.TrimSpace(req.Command) == "" {
 		return invalidExecuteResponse(instanceId, "command is required")
 	}
-	if req.ExecuteTimeout <= 0 {
-		return invalidExecuteResponse(instanceId, "execute timeout must be greater than 0")
+	req.ExecuteTimeout = utils.ExecuteTimeout(req.ExecuteTimeout)
+	if validationErr := validateCodePage(req.CodePage); validationErr != "" {
+		return invalidExecuteResponse(instanceId, validationErr)
+	}
+	if validationErr := validateOutputEncoding(req.OutputEncoding); validationErr != "" {
+		return invalidExecuteResponse(instanceId, validationErr)
+	}
+	if validationErr := validateWorkDir(req.WorkDir); validationErr != "" {
+		return invalidExecuteResponse(instanceId, validationErr)
+	}
+	var runAs *runAsUser
+	if strings.TrimSpace(req.RunAsUser) != "" {
+		var code, message string
+		if runAs, code, message = resolveRunAsUser(strings.TrimSpace(req.RunAsUser)); runAs == nil {
+			return runAsErrorResponse(instanceId, code, message)
+		}
 	}
 
 	shell := normalizeShell(req.Shell)
 	if !isSupportedShell(shell) {
-		return invalidExecuteResponse(instanceId, fmt.Sprintf("unsupported shell: %s", strings.TrimSpace(req.Shell)))
+		return invalidExecuteResponse(instanceId, unsupportedShellMessage(req.Shell))
 	}
 
-	commandForLog := req.Command
+	commandForLog := commandDisplay(req)
 	if req.LogCommand != "" {
 		commandForLog = req.LogCommand
 	}
 	logContext := strings.TrimSpace(req.LogContext)
-	isSCPCommand := contains(req.Command, "scp") || contains(req.Command, "sshpass")
+	isSCPCommand := strings.Contains(req.Command, "scp") || strings.Contains(req.Command, "sshpass")
 
-	logger.Debugf("[Local Execute] Instance: %s, Starting command execution", instanceId)
-	logger.Debugf("[Local Execute] Instance: %s, Command: %s", instanceId, commandForLog)
-	logger.Debugf("[Local Execute] Instance: %s, Timeout: %ds", instanceId, req.ExecuteTimeout)
+	logger.WithInstance(instanceId).Debugf("[Local Execute] Starting command execution")
+	logger.WithInstance(instanceId).Debugf("[Local Execute] Command: %s", commandForLog)
+	logger.WithInstance(instanceId).Debugf("[Local Execute] Timeout: %ds", req.ExecuteTimeout)
 	if isSCPCommand {
-		logger.Infof("[SCP] Instance: %s, start | %s | timeout=%ds", instanceId, formatSCPLogContext(logContext), req.ExecuteTimeout)
-		logger.Debugf("[SCP] Instance: %s, command=%s", instanceId, commandForLog)
+		logger.WithInstance(instanceId).Infof("[SCP] start | %s | timeout=%ds", formatSCPLogContext(logContext), req.ExecuteTimeout)
+		logger.WithInstance(instanceId).Debugf("[SCP] command=%s", commandForLog)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.ExecuteTimeout)*time.Second)
+	ctx, cancel := context.WithTimeout(req.requestContext(), time.Duration(req.ExecuteTimeout)*time.Second)
 	defer cancel()
+	defer localCancels.Register(req.TaskID, cancel)()
 
 	var cmd *exec.Cmd
-	switch shell {
-	case "bat", "cmd":
-		cmd = exec.CommandContext(ctx, "cmd", "/c", wrapCmdCommand(req.Command))
-	case "powershell":
-		cmd = exec.CommandContext(ctx, "powershell", "-Command", wrapPowerShellCommand(req.Command))
-	case "pwsh":
-		cmd = exec.CommandContext(ctx, "pwsh", "-Command", wrapPowerShellCommand(req.Command))
-	case "bash":
-		cmd = exec.CommandContext(ctx, "bash", "-c", req.Command)
-	case "sh":
-		cmd = exec.CommandContext(ctx, "sh", "-c", req.Command)
-	default:
-		cmd = exec.CommandContext(ctx, shell, "-c", req.Command)
+	if len(req.Args) > 0 {
+		cmd = exec.CommandContext(ctx, req.Args[0], req.Args[1:]...)
+	} else {
+		switch shell {
+		case "bat", "cmd":
+			cmd = exec.CommandContext(ctx, "cmd", "/c", wrapCmdCommand(req.Command, req.CodePage))
+		case "powershell", "pwsh":
+			cmd = exec.CommandContext(ctx, shell, powerShellArgs(req)...)
+		case "bash":
+			cmd = exec.CommandContext(ctx, "bash", "-c", req.Command)
+		case "sh":
+			cmd = exec.CommandContext(ctx, "sh", "-c", req.Command)
+		default:
+			entry, _ := lookupInterpreter(shell)
+			cmd = exec.CommandContext(ctx, shell, entry.inlineFlag, req.Command)
+		}
 	}
 
+	setProcessGroup(cmd)
+	terminator := newProcessTerminator(cmd, killGracePeriod(req.KillGracePeriod), instanceId)
+	cmd.Cancel = terminator.terminate
+	cmd.Dir = req.WorkDir
+	if runAs != nil {
+		runAs.apply(cmd)
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Running as user %s", req.RunAsUser)
+	}
 	if len(req.Env) > 0 {
-		cmd.Env = os.Environ()
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
 		for k, v := range req.Env {
 			cmd.Env = append(cmd.Env, k+"="+v)
 		}
 	}
 
 	startTime := time.Now()
-	outputCapture := utils.NewSharedOutputCapture(utils.CommandOutputLimitBytes)
+	outputLimit := utils.ResolveOutputLimit(req.MaxOutputBytes)
+	if req.MaxOutputBytes > outputLimit {
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Requested max_output_bytes=%d exceeds hard limit, capped to %dB", req.MaxOutputBytes, outputLimit)
+	}
+	outputCapture := utils.NewSharedOutputCapture(outputLimit)
 	stdoutWriter := outputCapture.StdoutWriter()
 	stderrWriter := outputCapture.StderrWriter()
 	var stdoutStreamWriter *scpStreamLogWriter
@@ -416,21 +770,42 @@ func Execute(req ExecuteRequest, instanceId string) ExecuteResponse {
 	var stdoutNatsWriter *streamLogWriter
 	var stderrNatsWriter *streamLogWriter
 	if req.StreamLogs && req.StreamLogTopic != "" && localStreamPublisher != nil {
-		logger.Infof("[Local Execute] Instance: %s, 流式输出已启用 topic=%s execution_id=%s", instanceId, req.StreamLogTopic, req.ExecutionID)
+		logger.WithInstance(instanceId).Infof("[Local Execute] 流式输出已启用 topic=%s execution_id=%s", req.StreamLogTopic, req.ExecutionID)
 		stdoutNatsWriter = newStreamLogWriter(localStreamPublisher, req.StreamLogTopic, req.ExecutionID, "stdout")
 		stderrNatsWriter = newStreamLogWriter(localStreamPublisher, req.StreamLogTopic, req.ExecutionID, "stderr")
 		stdoutWriter = io.MultiWriter(stdoutWriter, stdoutNatsWriter)
 		stderrWriter = io.MultiWriter(stderrWriter, stderrNatsWriter)
 	}
+	// 带 job_id / execution_id 的任务保留最近输出行，供 tail.<job_id> 查询
+	if tail, closeTail := utils.DefaultTails.Open(requestJobID(req)); tail != nil {
+		defer closeTail()
+		stdoutWriter = io.MultiWriter(stdoutWriter, tail.Writer())
+		stderrWriter = io.MultiWriter(stderrWriter, tail.Writer())
+	}
 	cmd.Stdout = stdoutWriter
 	cmd.Stderr = stderrWriter
+	var stdinPipe io.WriteCloser
+	if req.Stdin != "" {
+		pipe, err := cmd.StdinPipe()
+		if err != nil {
+			message := fmt.Sprintf("failed to open stdin: %v", err)
+			return ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: utils.ErrorCodeExecutionFailure, Error: message}
+		}
+		stdinPipe = pipe
+	}
 
 	if err := cmd.Start(); err != nil {
 		message := fmt.Sprintf("failed to start command: %v", err)
-		logger.Errorf("[Local Execute] Instance: %s, %s", instanceId, message)
+		logger.WithInstance(instanceId).Errorf("[Local Execute] %s", message)
 		if isSCPCommand {
-			logger.Warnf("[SCP] Instance: %s, failure | stage=start | cause=executor_start_failed | next=check_executor_runtime | %s | error=%v", instanceId, formatSCPLogContext(logContext), err)
-			logger.Debugf("[SCP] Instance: %s, command=%s", instanceId, commandForLog)
+			logger.WithInstance(instanceId).Warnf("[SCP] failure | stage=start | cause=executor_start_failed | next=check_executor_runtime | %s | error=%v", formatSCPLogContext(logContext), err)
+			logger.WithInstance(instanceId).Debugf("[SCP] command=%s", commandForLog)
+		}
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+			return commandNotFoundResponse(instanceId, cmd.Args[0], message)
+		}
+		if runAs != nil && errors.Is(err, fs.ErrPermission) {
+			return runAsErrorResponse(instanceId, utils.ErrorCodePermissionDenied, fmt.Sprintf("%s (run_as_user %s)", message, req.RunAsUser))
 		}
 		return ExecuteResponse{
 			Output:     message,
@@ -441,12 +816,21 @@ func Execute(req ExecuteRequest, instanceId string) ExecuteResponse {
 		}
 	}
 
+	if stdinPipe != nil {
+		go writeStdin(stdinPipe, req.Stdin, instanceId)
+	}
+	waitDone := make(chan struct{})
+	defer close(waitDone)
+	go terminator.watch(ctx, waitDone)
+
 	type waitResult struct {
 		err error
 	}
 	waitCh := make(chan waitResult, 1)
 	go func() {
-		waitCh <- waitResult{err: cmd.Wait()}
+		err := cmd.Wait()
+		terminator.stop()
+		waitCh <- waitResult{err: err}
 	}()
 
 	var err error
@@ -462,9 +846,9 @@ func Execute(req ExecuteRequest, instanceId string) ExecuteResponse {
 				elapsed := time.Since(startTime).Round(time.Second)
 				snapshot := outputCapture.Snapshot()
 				bytesSoFar := snapshot.TotalWritten
-				currentOutput := formatCapturedExecuteOutput(snapshot, shell)
+				currentOutput := formatCapturedExecuteOutput(snapshot, shell, req.CodePage, req.OutputEncoding)
 				excerpt := outputExcerpt(currentOutput)
-				logger.Infof("[SCP] Instance: %s, running | %s | elapsed=%s | output=%dB | last=%q", instanceId, formatSCPLogContext(logContext), elapsed, bytesSoFar, excerpt)
+				logger.WithInstance(instanceId).Infof("[SCP] running | %s | elapsed=%s | output=%dB | last=%q", formatSCPLogContext(logContext), elapsed, bytesSoFar, excerpt)
 			case <-ctx.Done():
 				result := <-waitCh
 				err = result.err
@@ -492,7 +876,7 @@ commandFinished:
 
 	duration := time.Since(startTime)
 	snapshot := outputCapture.Snapshot()
-	decodedOutput := formatCapturedExecuteOutput(snapshot, shell)
+	decodedOutput := formatCapturedExecuteOutput(snapshot, shell, req.CodePage, req.OutputEncoding)
 
 	var exitCode int
 	if exitError, ok := err.(*exec.ExitError); ok {
@@ -502,49 +886,102 @@ commandFinished:
 	response := ExecuteResponse{
 		Output:     decodedOutput,
 		InstanceId: instanceId,
-		Success:    err == nil && ctx.Err() != context.DeadlineExceeded,
+		Success:    err == nil && ctx.Err() == nil,
+		Truncated:  snapshot.Truncated,
 	}
 
-	if ctx.Err() == context.DeadlineExceeded {
+	if ctx.Err() == context.Canceled {
+		response.Code = utils.ErrorCodeCanceled
+		response.Error = fmt.Sprintf("Command canceled after %v (task_id: %s)", duration, req.TaskID)
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Command canceled by task_id %s after %v", req.TaskID, duration)
+	} else if ctx.Err() == context.DeadlineExceeded {
 		response.Code = utils.ErrorCodeTimeout
 		response.Error = fmt.Sprintf("Command timed out after %v (timeout: %ds)", duration, req.ExecuteTimeout)
-		logger.Warnf("[Local Execute] Instance: %s, Command timed out after %v", instanceId, duration)
-		logger.Debugf("[Local Execute] Instance: %s, Partial output: %s", instanceId, decodedOutput)
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Command timed out after %v", duration)
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Partial output: %s", decodedOutput)
 		if isSCPCommand {
 			excerpt := outputExcerpt(decodedOutput)
 			cause, next := scpFailureAdvice(decodedOutput, exitCode, true)
-			logger.Warnf("[SCP] Instance: %s, timeout | cause=%s | next=%s | %s | elapsed=%s/%ds | last=%q", instanceId, cause, next, formatSCPLogContext(logContext), duration.Round(time.Second), req.ExecuteTimeout, excerpt)
+			logger.WithInstance(instanceId).Warnf("[SCP] timeout | cause=%s | next=%s | %s | elapsed=%s/%ds | last=%q", cause, next, formatSCPLogContext(logContext), duration.Round(time.Second), req.ExecuteTimeout, excerpt)
 		}
+	} else if missing, ok := detectCommandNotFound(decodedOutput, exitCode, shell); err != nil && ok {
+		response.Code = utils.ErrorCodeCommandNotFound
+		response.MissingCommand = missing
+		response.Error = fmt.Sprintf("Command not found: %s (exit code %d)", missing, exitCode)
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Command not found: %s", missing)
 	} else if err != nil {
 		response.Code = utils.ErrorCodeExecutionFailure
 		response.Error = fmt.Sprintf("Command execution failed with exit code %d: %v", exitCode, err)
-		logger.Warnf("[Local Execute] Instance: %s, Command execution failed after %v, exit code: %d", instanceId, duration, exitCode)
-		logger.Debugf("[Local Execute] Instance: %s, Error: %v", instanceId, err)
-		logger.Debugf("[Local Execute] Instance: %s, Full output: %s", instanceId, decodedOutput)
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Command execution failed after %v, exit code: %d", duration, exitCode)
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Error: %v", err)
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Full output: %s", decodedOutput)
 
 		if isSCPCommand {
 			excerpt := outputExcerpt(decodedOutput)
 			cause, next := scpFailureAdvice(decodedOutput, exitCode, false)
-			logger.Warnf("[SCP] Instance: %s, failure | cause=%s | next=%s | exit=%d | %s | duration=%s | last=%q", instanceId, cause, next, exitCode, formatSCPLogContext(logContext), duration.Round(time.Second), excerpt)
-			logger.Debugf("[SCP] Instance: %s, raw_error=%v", instanceId, err)
+			logger.WithInstance(instanceId).Warnf("[SCP] failure | cause=%s | next=%s | exit=%d | %s | duration=%s | last=%q", cause, next, exitCode, formatSCPLogContext(logContext), duration.Round(time.Second), excerpt)
+			logger.WithInstance(instanceId).Debugf("[SCP] raw_error=%v", err)
 		}
 	} else {
-		logger.Debugf("[Local Execute] Instance: %s, Command executed successfully in %v", instanceId, duration)
-		logger.Debugf("[Local Execute] Instance: %s, Output length: %d bytes", instanceId, len(decodedOutput))
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Command executed successfully in %v", duration)
+		logger.WithInstance(instanceId).Debugf("[Local Execute] Output length: %d bytes", len(decodedOutput))
 		if snapshot.Truncated {
-			logger.Warnf("[Local Execute] Instance: %s, Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", instanceId, snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
+			logger.WithInstance(instanceId).Warnf("[Local Execute] Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
 		}
 		if len(decodedOutput) > 0 {
-			logger.Debugf("[Local Execute] Instance: %s, Output: %s", instanceId, decodedOutput)
+			logger.WithInstance(instanceId).Debugf("[Local Execute] Output: %s", decodedOutput)
 		}
 		if isSCPCommand {
-			logger.Infof("[SCP] Instance: %s, success | %s | duration=%s | output=%dB", instanceId, formatSCPLogContext(logContext), duration.Round(time.Second), len(decodedOutput))
+			logger.WithInstance(instanceId).Infof("[SCP] success | %s | duration=%s | output=%dB", formatSCPLogContext(logContext), duration.Round(time.Second), len(decodedOutput))
 		}
 	}
 
 	return response
 }
 
+func commandNotFoundResponse(instanceId, missing, message string) ExecuteResponse {
+	message = fmt.Sprintf("Command not found: %s (%s)", missing, message)
+	return ExecuteResponse{
+		Output:         message,
+		InstanceId:     instanceId,
+		Success:        false,
+		Code:           utils.ErrorCodeCommandNotFound,
+		Error:          message,
+		MissingCommand: missing,
+	}
+}
+
+var notRecognizedPattern = regexp.MustCompile(`'([^']+)' is not recognized as`)
+
+// detectCommandNotFound 根据 shell 的约定识别"命令不存在"：
+// sh/bash 退出码 127 并输出 "xxx: not found"，cmd 退出码 9009，PowerShell 输出 "is not recognized as"。
+func detectCommandNotFound(output string, exitCode int, shell string) (string, bool) {
+	switch {
+	case exitCode == 127:
+		for _, line := range strings.Split(output, "\n") {
+			line = strings.TrimSpace(line)
+			trimmed, ok := strings.CutSuffix(line, ": command not found")
+			if !ok {
+				trimmed, ok = strings.CutSuffix(line, ": not found")
+			}
+			if !ok {
+				continue
+			}
+			if idx := strings.LastIndex(trimmed, ": "); idx >= 0 {
+				trimmed = trimmed[idx+2:]
+			}
+			if name := strings.TrimSpace(trimmed); name != "" {
+				return name, true
+			}
+		}
+	case exitCode == 9009, shell == ShellTypePowerShell || shell == ShellTypePwsh:
+		if match := notRecognizedPattern.FindStringSubmatch(output); match != nil {
+			return match[1], true
+		}
+	}
+	return "", false
+}
+
 func sampleBytes(output []byte, limit int) []byte {
 	if len(output) <= limit {
 		return output
@@ -617,7 +1054,7 @@ func (w *scpStreamLogWriter) logLine(line string) {
 	if message == "" {
 		return
 	}
-	logger.Debugf("[SCP] Instance: %s, stream=%s | %s | %q", w.instanceId, w.streamName, w.logContext, truncateForLog(message, 400))
+	logger.WithInstance(w.instanceId).Debugf("[SCP] stream=%s | %s | %q", w.streamName, w.logContext, truncateForLog(message, 400))
 }
 
 func outputExcerpt(value string) string {
@@ -631,9 +1068,9 @@ func outputExcerpt(value string) string {
 	return truncateForLog(trimmed, 240)
 }
 
-func formatCapturedExecuteOutput(snapshot utils.OutputSnapshot, shell string) string {
-	stdout := decodeExecuteOutput(snapshot.Stdout, shell)
-	stderr := decodeExecuteOutput(snapshot.Stderr, shell)
+func formatCapturedExecuteOutput(snapshot utils.OutputSnapshot, shell string, codePage int, outputEncoding string) string {
+	stdout := decodeExecuteOutputForCodePage(snapshot.Stdout, shell, codePage, outputEncoding, runtime.GOOS)
+	stderr := decodeExecuteOutputForCodePage(snapshot.Stderr, shell, codePage, outputEncoding, runtime.GOOS)
 	return utils.FormatCapturedOutput(stdout, stderr, snapshot)
 }
 
@@ -650,11 +1087,28 @@ func decodeExecuteOutput(output []byte, shell string) string {
 	return decoded
 }
 
+// decodeExecuteOutputForCodePage 在 Windows cmd / PowerShell 显式指定 code_page 时按该代码页解码，
+// 否则沿用默认策略，非 UTF-8 输出按 outputEncoding 回退解码
+func decodeExecuteOutputForCodePage(output []byte, shell string, codePage int, outputEncoding, goos string) string {
+	if goos == "windows" && isWindowsShell(shell) {
+		if decoded, ok := decodeCodePageOutput(output, codePage); ok {
+			return decoded
+		}
+	}
+	decoded, _ := decodeExecuteOutputWithFallback(output, shell, outputEncoding, goos)
+	return decoded
+}
+
 func decodeExecuteOutputWithStrategy(output []byte, shell string) (string, string) {
 	return decodeExecuteOutputWithStrategyForOS(output, shell, runtime.GOOS)
 }
 
 func decodeExecuteOutputWithStrategyForOS(output []byte, shell string, goos string) (string, string) {
+	return decodeExecuteOutputWithFallback(output, shell, "", goos)
+}
+
+// decodeExecuteOutputWithFallback 依次识别 UTF-16LE、UTF-8，Windows cmd / PowerShell 下再按回退编码解码，返回结果与所用策略
+func decodeExecuteOutputWithFallback(output []byte, shell, outputEncoding, goos string) (string, string) {
 	if decoded, ok := decodeUTF16LEOutput(output); ok {
 		return decoded, "utf16le"
 	}
@@ -663,24 +1117,42 @@ func decodeExecuteOutputWithStrategyForOS(output []byte, shell string, goos stri
 		return string(output), "utf8"
 	}
 
-	if goos == "windows" && (shell == ShellTypeBat || shell == ShellTypeCmd || shell == ShellTypePowerShell || shell == ShellTypePwsh) {
-		if decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(output); err == nil {
-			return string(decoded), "gbk"
+	if goos == "windows" && isWindowsShell(shell) {
+		name := fallbackOutputEncoding(outputEncoding)
+		if enc, ok := lookupOutputEncoding(name); ok {
+			if decoded, err := enc.NewDecoder().Bytes(output); err == nil {
+				return string(decoded), name
+			}
 		}
 	}
 
 	return string(output), "raw"
 }
 
-func wrapPowerShellCommand(command string) string {
-	return wrapPowerShellCommandForOS(command, runtime.GOOS)
+func isWindowsShell(shell string) bool {
+	return shell == ShellTypeBat || shell == ShellTypeCmd || shell == ShellTypePowerShell || shell == ShellTypePwsh
+}
+
+func wrapPowerShellCommand(command string, codePage int) string {
+	return wrapPowerShellCommandForOS(command, codePage, runtime.GOOS)
 }
 
-func wrapPowerShellCommandForOS(command, goos string) string {
+// wrapPowerShellCommandForOS 在 Windows 上设置控制台编码，未指定 code_page 时使用无 BOM 的 UTF-8
+func wrapPowerShellCommandForOS(command string, codePage int, goos string) string {
 	if goos != "windows" {
 		return command
 	}
 
+	if effectiveCodePage(codePage) != codePageUTF8 {
+		cp := codePageArg(codePage)
+		return "$codePageEncoding = [System.Text.Encoding]::GetEncoding(" + cp + "); " +
+			"[Console]::InputEncoding = $codePageEncoding; " +
+			"[Console]::OutputEncoding = $codePageEncoding; " +
+			"$OutputEncoding = $codePageEncoding; " +
+			"if (Get-Command chcp.com -ErrorAction SilentlyContinue) { chcp.com " + cp + " > $null }; " +
+			command
+	}
+
 	return "$utf8NoBom = New-Object System.Text.UTF8Encoding($false); " +
 		"[Console]::InputEncoding = $utf8NoBom; " +
 		"[Console]::OutputEncoding = $utf8NoBom; " +
@@ -689,16 +1161,41 @@ func wrapPowerShellCommandForOS(command, goos string) string {
 		command
 }
 
-func wrapCmdCommand(command string) string {
-	return wrapCmdCommandForOS(command, runtime.GOOS)
+// powerShellArgs 组装 powershell / pwsh 的命令行参数，powershell_bypass 与 powershell_encoded_command 分别控制
+// 执行策略相关参数与脚本的传递方式
+func powerShellArgs(req ExecuteRequest) []string {
+	var args []string
+	if req.PowerShellBypass {
+		args = append(args, "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass")
+	}
+	script := wrapPowerShellCommand(req.Command, req.CodePage)
+	if req.PowerShellEncodedCommand {
+		return append(args, "-EncodedCommand", encodePowerShellCommand(script))
+	}
+	return append(args, "-Command", script)
+}
+
+// encodePowerShellCommand 按 -EncodedCommand 的要求将脚本编码为 Base64 UTF-16LE
+func encodePowerShellCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		buf = binary.LittleEndian.AppendUint16(buf, unit)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
 }
 
-func wrapCmdCommandForOS(command, goos string) string {
+func wrapCmdCommand(command string, codePage int) string {
+	return wrapCmdCommandForOS(command, codePage, runtime.GOOS)
+}
+
+// wrapCmdCommandForOS 在 Windows 上先用 chcp 切换代码页，未指定 code_page 时切换到 UTF-8
+func wrapCmdCommandForOS(command string, codePage int, goos string) string {
 	if goos != "windows" {
 		return command
 	}
 
-	return "chcp 65001 >nul && " + command
+	return "chcp " + codePageArg(codePage) + " >nul && " + command
 }
 
 func decodeUTF16LEOutput(output []byte) (string, bool) {
@@ -743,61 +1240,44 @@ func looksLikeUTF16LE(output []byte) bool {
 	return zeroCount >= len(output)/4
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
-		(len(s) > len(substr) &&
-			(s[:len(substr)] == substr ||
-				s[len(s)-len(substr):] == substr ||
-				containsInMiddle(s, substr))))
-}
-
-func containsInMiddle(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 func analyzeSCPFailure(instanceId, output string, exitCode int) {
-	logger.Debugf("[SCP] Instance: %s, analyze_failure | exit_code=%d | cause=%s | output=%q", instanceId, exitCode, classifySCPFailure(output, exitCode), outputExcerpt(output))
+	logger.WithInstance(instanceId).Debugf("[SCP] analyze_failure | exit_code=%d | cause=%s | output=%q", exitCode, classifySCPFailure(output, exitCode), outputExcerpt(output))
 
 	switch exitCode {
 	case 1:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 1 - General error", instanceId)
-		if contains(output, "Permission denied") {
-			logger.Debugf("[SCP Analysis] Instance: %s, Issue: Permission denied - Check SSH credentials/key", instanceId)
-		} else if contains(output, "Connection refused") {
-			logger.Debugf("[SCP Analysis] Instance: %s, Issue: Connection refused - Check if SSH service is running", instanceId)
-		} else if contains(output, "No such file or directory") {
-			logger.Debugf("[SCP Analysis] Instance: %s, Issue: File/directory not found - Check source/target paths", instanceId)
-		} else if contains(output, "Host key verification failed") {
-			logger.Debugf("[SCP Analysis] Instance: %s, Issue: Host key verification failed - SSH host key problem", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 1 - General error")
+		if strings.Contains(output, "Permission denied") {
+			logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: Permission denied - Check SSH credentials/key")
+		} else if strings.Contains(output, "Connection refused") {
+			logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: Connection refused - Check if SSH service is running")
+		} else if strings.Contains(output, "No such file or directory") {
+			logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: File/directory not found - Check source/target paths")
+		} else if strings.Contains(output, "Host key verification failed") {
+			logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: Host key verification failed - SSH host key problem")
 		}
 	case 2:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 2 - Protocol error", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 2 - Protocol error")
 	case 3:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 3 - Interrupted", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 3 - Interrupted")
 	case 4:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 4 - Unexpected network error", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 4 - Unexpected network error")
 	case 5:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 5 - sshpass authentication failure", instanceId)
-		logger.Debugf("[SCP Analysis] Instance: %s, Issue: Wrong password or sshpass not available", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 5 - sshpass authentication failure")
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: Wrong password or sshpass not available")
 	case 6:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code 6 - sshpass host key unknown", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code 6 - sshpass host key unknown")
 	default:
-		logger.Debugf("[SCP Analysis] Instance: %s, Exit code %d - Unknown error", instanceId, exitCode)
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Exit code %d - Unknown error", exitCode)
 	}
 
-	if contains(output, "sshpass: command not found") {
-		logger.Warnf("[SCP Analysis] Instance: %s, sshpass is not installed on the system", instanceId)
+	if strings.Contains(output, "sshpass: command not found") {
+		logger.WithInstance(instanceId).Warnf("[SCP Analysis] sshpass is not installed on the system")
 	}
-	if contains(output, "ssh: connect to host") && contains(output, "Connection timed out") {
-		logger.Debugf("[SCP Analysis] Instance: %s, Issue: Network connectivity problem or wrong hostname/port", instanceId)
+	if strings.Contains(output, "ssh: connect to host") && strings.Contains(output, "Connection timed out") {
+		logger.WithInstance(instanceId).Debugf("[SCP Analysis] Issue: Network connectivity problem or wrong hostname/port")
 	}
-	if contains(output, "WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED") {
-		logger.Warnf("[SCP Analysis] Instance: %s, Remote host key has changed - security risk", instanceId)
+	if strings.Contains(output, "WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED") {
+		logger.WithInstance(instanceId).Warnf("[SCP Analysis] Remote host key has changed - security risk")
 	}
 }
 
@@ -856,10 +1336,11 @@ func classifySCPFailure(output string, exitCode int) string {
 
 func subscribeLocalExecutor(sub subscriber, instanceId *string) error {
 	subject := fmt.Sprintf("local.execute.%s", *instanceId)
-	logger.Infof("[Local Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Local Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
-		logger.Debugf("[Local Subscribe] Instance: %s, Received message, size: %d bytes", *instanceId, len(msg.Data))
+		defer utils.DefaultMetrics.Begin("local.execute")()
+		logger.WithInstance(*instanceId).Debugf("[Local Subscribe] Received message, size: %d bytes", len(msg.Data))
 		respondLocalExecuteMessage(natsInboundMsg{msg}, msg.Data, *instanceId)
 	})
 	return err
@@ -870,47 +1351,50 @@ func SubscribeLocalExecutor(nc *nats.Conn, instanceId *string) {
 	// 守卫 nil，避免把 nil *nats.Conn 装进非 nil 接口造成误判/空指针。
 	if nc != nil {
 		localStreamPublisher = nc
+		localScriptConn = nc
 	}
-	if err := subscribeLocalExecutorFn(nc, instanceId); err != nil {
-		logger.Errorf("[Local Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeLocalExecutorFn(utils.QueueSubscriber{Conn: nc}, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Local Subscribe] Failed to subscribe: %v", err)
 	}
 }
 
 func subscribeDownloadToLocal(sub subscriber, nc downloadConn, instanceId *string) error {
 	subject := fmt.Sprintf("download.local.%s", *instanceId)
-	logger.Infof("[Download Local Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Download Local Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("download.local")()
 		respondDownloadToLocalSubscription(natsInboundMsg{msg}, *instanceId, nc)
 	})
 	return err
 }
 
 func SubscribeDownloadToLocal(nc *nats.Conn, instanceId *string) {
-	if err := subscribeDownloadToLocalFn(nc, nc, instanceId); err != nil {
-		logger.Errorf("[Download Local Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeDownloadToLocalFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Download Local Subscribe] Failed to subscribe: %v", err)
 	}
 }
 
 func subscribeUnzipToLocal(sub subscriber, instanceId *string) error {
 	subject := fmt.Sprintf("unzip.local.%s", *instanceId)
-	logger.Infof("[Unzip Local Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Unzip Local Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("unzip.local")()
 		respondUnzipToLocalSubscription(natsInboundMsg{msg}, *instanceId)
 	})
 	return err
 }
 
 func SubscribeUnzipToLocal(nc *nats.Conn, instanceId *string) {
-	if err := subscribeUnzipToLocalFn(nc, instanceId); err != nil {
-		logger.Errorf("[Unzip Local Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeUnzipToLocalFn(utils.QueueSubscriber{Conn: nc}, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Unzip Local Subscribe] Failed to subscribe: %v", err)
 	}
 }
 
 func subscribeHealthCheck(sub subscriber, instanceId *string) error {
 	subject := fmt.Sprintf("health.check.%s", *instanceId)
-	logger.Infof("[Health Check Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Health Check Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
 		respondHealthCheckSubscription(natsInboundMsg{msg}, *instanceId, subject)
@@ -920,6 +1404,23 @@ func subscribeHealthCheck(sub subscriber, instanceId *string) error {
 
 func SubscribeHealthCheck(nc *nats.Conn, instanceId *string) {
 	if err := subscribeHealthCheckFn(nc, instanceId); err != nil {
-		logger.Errorf("[Health Check Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+		logger.WithInstance(*instanceId).Errorf("[Health Check Subscribe] Failed to subscribe: %v", err)
+	}
+}
+
+func subscribeResultFetch(sub subscriber, instanceId *string) error {
+	// job id 全局唯一，各实例订阅通配主题，仅持有结果的实例应答
+	subject := "result.fetch.*"
+	logger.WithInstance(*instanceId).Infof("[Result Fetch Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondResultFetchSubscription(natsInboundMsg{msg}, msg.Subject, *instanceId)
+	})
+	return err
+}
+
+func SubscribeResultFetch(nc *nats.Conn, instanceId *string) {
+	if err := subscribeResultFetchFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Result Fetch Subscribe] Failed to subscribe: %v", err)
 	}
 }
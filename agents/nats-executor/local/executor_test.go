@@ -1,6 +1,8 @@
 package local
 
 import (
+	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -154,58 +156,228 @@ func TestExecuteUsesCustomShellBinary(t *testing.T) {
 }
 
 func TestExecuteRejectsEmptyCommand(t *testing.T) {
+	for _, command := range []string{"", "   ", "\t\n  \r\n"} {
+		response := Execute(ExecuteRequest{
+			Command:        command,
+			ExecuteTimeout: 5,
+			Shell:          "sh",
+		}, "test-empty-command")
+
+		if response.Success {
+			t.Fatalf("expected command %q to be rejected", command)
+		}
+		if response.Code != utils.ErrorCodeInvalidRequest {
+			t.Fatalf("unexpected response for %q: %+v", command, response)
+		}
+		if !strings.Contains(response.Error, "command is required") {
+			t.Fatalf("unexpected error for %q: %+v", command, response)
+		}
+	}
+
 	response := Execute(ExecuteRequest{
-		Command:        "   ",
+		Command:        "  echo padded  ",
 		ExecuteTimeout: 5,
 		Shell:          "sh",
 	}, "test-empty-command")
+	if !response.Success || strings.TrimSpace(response.Output) != "padded" {
+		t.Fatalf("expected padded command to run, got %+v", response)
+	}
+}
 
-	if response.Success {
-		t.Fatal("expected empty command to be rejected")
+func TestExecuteTreatsNonPositiveTimeoutAsUnset(t *testing.T) {
+	for _, timeout := range []int{0, -1} {
+		response := Execute(ExecuteRequest{
+			Command:        "echo hi",
+			ExecuteTimeout: timeout,
+			Shell:          "sh",
+		}, "test-default-timeout")
+
+		if !response.Success || response.Code != "" {
+			t.Fatalf("expected timeout %d to run with the default timeout, got %+v", timeout, response)
+		}
+		if strings.TrimSpace(response.Output) != "hi" {
+			t.Fatalf("unexpected output: %q", response.Output)
+		}
 	}
-	if response.Code != utils.ErrorCodeInvalidRequest {
-		t.Fatalf("unexpected response: %+v", response)
+}
+
+func TestExecuteArgsBypassesShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX printf")
 	}
-	if !strings.Contains(response.Error, "command is required") {
-		t.Fatalf("unexpected error: %+v", response)
+
+	args := []string{"hello world", "$(id); echo injected | cat && `uname` > /dev/null", "*", "'quoted' \"double\""}
+	response := Execute(ExecuteRequest{
+		Args:           append([]string{"printf", "%s\n"}, args...),
+		ExecuteTimeout: 5,
+	}, "test-args")
+
+	if !response.Success {
+		t.Fatalf("expected argv execution to succeed, got %+v", response)
+	}
+	if got := strings.Split(strings.TrimRight(response.Output, "\n"), "\n"); strings.Join(got, "|") != strings.Join(args, "|") {
+		t.Fatalf("expected arguments to be passed verbatim, got %q", response.Output)
+	}
+}
+
+func TestExecuteRunsInWorkDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX pwd")
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("resolve temp dir: %v", err)
+	}
+	workDir := filepath.Join(dir, "my app")
+	if err := os.Mkdir(workDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	for _, req := range []ExecuteRequest{
+		{Command: "pwd", ExecuteTimeout: 5, WorkDir: workDir},
+		{Args: []string{"pwd"}, ExecuteTimeout: 5, WorkDir: workDir},
+	} {
+		response := Execute(req, "test-work-dir")
+		if !response.Success || strings.TrimSpace(response.Output) != workDir {
+			t.Fatalf("expected command to run in %q, got %+v", workDir, response)
+		}
+	}
+}
+
+func TestExecuteRejectsInvalidWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	testCases := map[string]string{
+		filepath.Join(dir, "missing"): "does not exist",
+		file:                          "is not a directory",
+		"   ":                         "work_dir must not be blank",
+	}
+	for workDir, want := range testCases {
+		response := Execute(ExecuteRequest{Command: "echo hi", ExecuteTimeout: 5, WorkDir: workDir, CleanupCommand: "echo cleanup"}, "test-work-dir")
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || !strings.Contains(response.Error, want) {
+			t.Fatalf("work_dir %q: expected invalid request containing %q, got %+v", workDir, want, response)
+		}
+	}
+}
+
+func TestExecuteWritesStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX cat")
+	}
+	input := "apiVersion: v1\nkind: ConfigMap\n"
+	for _, req := range []ExecuteRequest{
+		{Command: "cat", ExecuteTimeout: 5, Stdin: input},
+		{Args: []string{"cat"}, ExecuteTimeout: 5, Stdin: input},
+	} {
+		response := Execute(req, "test-stdin")
+		if !response.Success || response.Output != input {
+			t.Fatalf("expected stdin to be echoed, got %+v", response)
+		}
+	}
+}
+
+func TestExecuteDoesNotBlockWhenStdinIsUnread(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX true")
+	}
+	start := time.Now()
+	response := Execute(ExecuteRequest{Command: "true", ExecuteTimeout: 5, Stdin: strings.Repeat("x", 1<<20)}, "test-stdin")
+	if !response.Success {
+		t.Fatalf("expected command ignoring stdin to succeed, got %+v", response)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("command ignoring stdin took too long: %s", elapsed)
+	}
+}
+
+func TestExecuteArgsValidation(t *testing.T) {
+	testCases := []struct {
+		name string
+		req  ExecuteRequest
+		want string
+	}{
+		{name: "command and args", req: ExecuteRequest{Command: "echo hi", Args: []string{"echo", "hi"}, ExecuteTimeout: 5}, want: "mutually exclusive"},
+		{name: "shell and args", req: ExecuteRequest{Shell: "bash", Args: []string{"echo", "hi"}, ExecuteTimeout: 5}, want: "shell cannot be combined"},
+		{name: "empty program", req: ExecuteRequest{Args: []string{" ", "hi"}, ExecuteTimeout: 5}, want: "args[0]"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			response := Execute(tt.req, "test-args")
+			if response.Success || response.Code != utils.ErrorCodeInvalidRequest || !strings.Contains(response.Error, tt.want) {
+				t.Fatalf("unexpected response: %+v", response)
+			}
+		})
+	}
+
+	response := Execute(ExecuteRequest{Args: []string{"bk_lite_missing_binary_for_test", "--version"}, ExecuteTimeout: 5}, "test-args")
+	if response.Code != utils.ErrorCodeCommandNotFound || response.MissingCommand != "bk_lite_missing_binary_for_test" {
+		t.Fatalf("expected missing program to be reported, got %+v", response)
 	}
 }
 
-func TestExecuteRejectsNonPositiveTimeout(t *testing.T) {
+func TestExecuteReportsCommandNotFound(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+
 	response := Execute(ExecuteRequest{
-		Command:        "echo hi",
-		ExecuteTimeout: 0,
+		Command:        "bk_lite_missing_binary_for_test --version",
+		ExecuteTimeout: 5,
 		Shell:          "sh",
-	}, "test-invalid-timeout")
+	}, "instance-missing")
 
 	if response.Success {
-		t.Fatal("expected non-positive timeout to be rejected")
+		t.Fatal("expected missing binary to fail")
 	}
-	if response.Code != utils.ErrorCodeInvalidRequest {
+	if response.Code != utils.ErrorCodeCommandNotFound || response.MissingCommand != "bk_lite_missing_binary_for_test" {
 		t.Fatalf("unexpected response: %+v", response)
 	}
-	if !strings.Contains(response.Error, "execute timeout must be greater than 0") {
-		t.Fatalf("unexpected error: %+v", response)
-	}
 }
 
-func TestContains(t *testing.T) {
-	if !contains("prefix-scp-suffix", "scp") {
-		t.Fatal("expected substring to be found")
-	}
+func TestExecuteReportsMissingShellAsCommandNotFound(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo ok",
+		ExecuteTimeout: 5,
+		Shell:          "bash",
+	}, "instance-missing-shell")
 
-	if contains("prefix-suffix", "scp") {
-		t.Fatal("did not expect missing substring to be found")
+	if response.Code != utils.ErrorCodeCommandNotFound || response.MissingCommand != "bash" {
+		t.Fatalf("unexpected response: %+v", response)
 	}
 }
 
-func BenchmarkContains(b *testing.B) {
-	input := strings.Repeat("abcdefghij", 128) + "sshpass"
-	b.ReportAllocs()
-	for b.Loop() {
-		if !contains(input, "sshpass") {
-			b.Fatal("expected substring")
-		}
+func TestDetectCommandNotFound(t *testing.T) {
+	testCases := []struct {
+		name     string
+		output   string
+		exitCode int
+		shell    string
+		want     string
+		wantOK   bool
+	}{
+		{name: "dash", output: "sh: 1: kubectl: not found\n", exitCode: 127, shell: "sh", want: "kubectl", wantOK: true},
+		{name: "bash", output: "bash: line 1: helm: command not found\n", exitCode: 127, shell: "bash", want: "helm", wantOK: true},
+		{name: "busybox", output: "/bin/sh: jq: not found", exitCode: 127, shell: "sh", want: "jq", wantOK: true},
+		{name: "cmd", output: "'robocopyx' is not recognized as an internal or external command,", exitCode: 9009, shell: "cmd", want: "robocopyx", wantOK: true},
+		{name: "powershell", output: "The term 'Get-Foo' is not recognized as the name of a cmdlet", exitCode: 1, shell: "powershell", want: "Get-Foo", wantOK: true},
+		{name: "regular failure", output: "grep: pattern not found in file", exitCode: 1, shell: "sh", wantOK: false},
+		{name: "exit 127 without marker", output: "custom failure", exitCode: 127, shell: "sh", wantOK: false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := detectCommandNotFound(tt.output, tt.exitCode, tt.shell)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("detectCommandNotFound() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
 	}
 }
 
@@ -309,7 +481,7 @@ func TestLocalExecuteStartFailureAndMalformedResponsePaths(t *testing.T) {
 			ExecuteTimeout: 3,
 			Shell:          ShellTypePwsh,
 		}, "instance-start-failure")
-		if response.Success || response.Code != utils.ErrorCodeExecutionFailure {
+		if response.Success || response.Code != utils.ErrorCodeCommandNotFound || response.MissingCommand != "pwsh" {
 			t.Fatalf("unexpected response: %+v", response)
 		}
 		if !strings.Contains(response.Error, "failed to start command") {
@@ -0,0 +1,147 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	readinessMaxInflightEnv = "READINESS_MAX_INFLIGHT_JOBS"
+	readinessProbeTimeout   = 2 * time.Second
+
+	readinessStatusReady    = "ready"
+	readinessStatusNotReady = "not_ready"
+)
+
+var (
+	natsConnected = func(nc downloadConn) bool {
+		natsConn, _ := nc.(*nats.Conn)
+		return natsConn != nil && natsConn.IsConnected()
+	}
+	probeObjectStore = func(nc downloadConn) error {
+		natsConn, _ := nc.(*nats.Conn)
+		if natsConn == nil {
+			return errors.New("nats connection is not available")
+		}
+		js, err := natsConn.JetStream(nats.MaxWait(readinessProbeTimeout))
+		if err != nil {
+			return err
+		}
+		_, err = js.AccountInfo()
+		return err
+	}
+	subscribeHealthLiveFn  = subscribeHealthLive
+	subscribeHealthReadyFn = subscribeHealthReady
+)
+
+// configuredMaxInflightJobs 读取未设置 max_concurrent_jobs 时就绪检查使用的并发上限，未配置或非法时为 0（不限制）
+func configuredMaxInflightJobs() int {
+	value := strings.TrimSpace(os.Getenv(readinessMaxInflightEnv))
+	if value == "" {
+		return 0
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		logger.Warnf("[Health Ready] invalid %s=%q, concurrency limit disabled", readinessMaxInflightEnv, value)
+		return 0
+	}
+	return limit
+}
+
+// handleHealthReadyMessage 检查本实例当前能否承接任务：NATS 已连接、对象存储可达、未超过并发上限
+func handleHealthReadyMessage(instanceId string, nc downloadConn) []byte {
+	var checks []ReadinessCheck
+	ready := true
+	addCheck := func(name string, err error) {
+		check := ReadinessCheck{Name: name, Ok: err == nil}
+		if err != nil {
+			check.Detail = err.Error()
+			ready = false
+		}
+		checks = append(checks, check)
+	}
+
+	if natsConnected(nc) {
+		addCheck("nats", nil)
+		addCheck("object_store", probeObjectStore(nc))
+	} else {
+		addCheck("nats", errors.New("not connected"))
+		addCheck("object_store", errors.New("skipped: nats not connected"))
+	}
+
+	inflight := len(utils.DefaultJobs.List(utils.JobStatusRunning))
+	var concurrencyErr error
+	limit := utils.DefaultLimits.Snapshot().MaxConcurrentJobs
+	if limit == 0 {
+		limit = configuredMaxInflightJobs()
+	}
+	if limit > 0 && inflight >= limit {
+		concurrencyErr = fmt.Errorf("%d running jobs reached limit %d", inflight, limit)
+	}
+	addCheck("concurrency", concurrencyErr)
+
+	status := readinessStatusReady
+	if !ready {
+		status = readinessStatusNotReady
+	}
+	responseContent, _ := json.Marshal(ReadinessResponse{
+		Success:      ready,
+		Status:       status,
+		InstanceId:   instanceId,
+		Timestamp:    nowUTC().Format(time.RFC3339),
+		InflightJobs: inflight,
+		Checks:       checks,
+	})
+	return responseContent
+}
+
+func respondHealthReadySubscription(msg inboundMsg, instanceId string, nc downloadConn) bool {
+	if err := msg.Respond(handleHealthReadyMessage(instanceId, nc)); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Health Ready Subscribe] Error responding to readiness check: %v", err)
+		return false
+	}
+	return true
+}
+
+// subscribeHealthLive 存活检查只表示进程仍能处理消息，不做任何依赖探测
+func subscribeHealthLive(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("health.live.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Health Live Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondHealthCheckSubscription(natsInboundMsg{msg}, *instanceId, subject)
+	})
+	return err
+}
+
+func subscribeHealthReady(sub subscriber, nc downloadConn, instanceId *string) error {
+	subject := fmt.Sprintf("health.ready.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Health Ready Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondHealthReadySubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func SubscribeHealthLive(nc *nats.Conn, instanceId *string) {
+	if err := subscribeHealthLiveFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Health Live Subscribe] Failed to subscribe: %v", err)
+	}
+}
+
+func SubscribeHealthReady(nc *nats.Conn, instanceId *string) {
+	if err := subscribeHealthReadyFn(nc, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Health Ready Subscribe] Failed to subscribe: %v", err)
+	}
+}
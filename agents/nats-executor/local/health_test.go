@@ -0,0 +1,95 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func withReadinessProbes(t *testing.T, connected bool, objectStoreErr error) {
+	t.Helper()
+	originalConnected := natsConnected
+	originalProbe := probeObjectStore
+	natsConnected = func(downloadConn) bool { return connected }
+	probeObjectStore = func(downloadConn) error { return objectStoreErr }
+	t.Cleanup(func() {
+		natsConnected = originalConnected
+		probeObjectStore = originalProbe
+	})
+}
+
+func decodeReadiness(t *testing.T, payload []byte) ReadinessResponse {
+	t.Helper()
+	var response ReadinessResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal readiness: %v", err)
+	}
+	return response
+}
+
+func readinessCheck(response ReadinessResponse, name string) ReadinessCheck {
+	for _, check := range response.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return ReadinessCheck{Name: name}
+}
+
+func TestHandleHealthReadyMessageReportsReady(t *testing.T) {
+	withReadinessProbes(t, true, nil)
+
+	response := decodeReadiness(t, handleHealthReadyMessage("instance-1", nil))
+	if !response.Success || response.Status != readinessStatusReady || len(response.Checks) != 3 {
+		t.Fatalf("expected ready response, got %+v", response)
+	}
+}
+
+func TestHandleHealthReadyMessageReportsFailedDependencies(t *testing.T) {
+	t.Run("nats disconnected", func(t *testing.T) {
+		withReadinessProbes(t, false, nil)
+		response := decodeReadiness(t, handleHealthReadyMessage("instance-1", nil))
+		if response.Success || response.Status != readinessStatusNotReady || readinessCheck(response, "nats").Ok || readinessCheck(response, "object_store").Ok {
+			t.Fatalf("expected nats failure, got %+v", response)
+		}
+	})
+
+	t.Run("object store unreachable", func(t *testing.T) {
+		withReadinessProbes(t, true, errors.New("jetstream not enabled"))
+		response := decodeReadiness(t, handleHealthReadyMessage("instance-1", nil))
+		check := readinessCheck(response, "object_store")
+		if response.Success || check.Ok || check.Detail != "jetstream not enabled" {
+			t.Fatalf("expected object store failure, got %+v", response)
+		}
+	})
+}
+
+func TestHandleHealthReadyMessageHonorsConcurrencyLimit(t *testing.T) {
+	withReadinessProbes(t, true, nil)
+	t.Setenv(readinessMaxInflightEnv, "1")
+	done := utils.DefaultJobs.Track(utils.JobInfo{ID: "job-1", Operation: "local.execute", Command: "sleep 30"})
+	defer done()
+
+	response := decodeReadiness(t, handleHealthReadyMessage("instance-1", nil))
+	if response.Success || response.InflightJobs != 1 || readinessCheck(response, "concurrency").Ok {
+		t.Fatalf("expected concurrency limit to fail readiness, got %+v", response)
+	}
+
+	t.Setenv(readinessMaxInflightEnv, "invalid")
+	if response := decodeReadiness(t, handleHealthReadyMessage("instance-1", nil)); !response.Success {
+		t.Fatalf("expected invalid limit to be ignored, got %+v", response)
+	}
+}
+
+func TestSubscribeHealthProbesRegisterSubjects(t *testing.T) {
+	live := &stubSubscriber{}
+	if err := subscribeHealthLive(live, stringPointer("instance-1")); err != nil || live.subject != "health.live.instance-1" || live.handler == nil {
+		t.Fatalf("unexpected live subscription: %+v err=%v", live, err)
+	}
+	ready := &stubSubscriber{}
+	if err := subscribeHealthReady(ready, nil, stringPointer("instance-1")); err != nil || ready.subject != "health.ready.instance-1" || ready.handler == nil {
+		t.Fatalf("unexpected ready subscription: %+v err=%v", ready, err)
+	}
+}
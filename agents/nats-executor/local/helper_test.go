@@ -1,6 +1,7 @@
 package local
 
 import (
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -73,7 +74,7 @@ func TestFormatCapturedExecuteOutputIncludesStdoutStderrAndTruncation(t *testing
 		TotalWritten:  128,
 	}
 
-	got := formatCapturedExecuteOutput(snapshot, ShellTypeSh)
+	got := formatCapturedExecuteOutput(snapshot, ShellTypeSh, 0, "")
 	for _, want := range []string{"stdout payload", "stderr payload", "output truncated"} {
 		if !strings.Contains(got, want) {
 			t.Fatalf("expected formatted output to contain %q, got %q", want, got)
@@ -124,17 +125,17 @@ func TestLocalCrossPlatformHelperScenarios(t *testing.T) {
 		t.Fatalf("unexpected gbk strategy: output=%q strategy=%q", got, strategy)
 	}
 
-	if got := wrapPowerShellCommandForOS("Write-Output test", "windows"); !strings.Contains(got, "[Console]::OutputEncoding") {
+	if got := wrapPowerShellCommandForOS("Write-Output test", 0, "windows"); !strings.Contains(got, "[Console]::OutputEncoding") {
 		t.Fatalf("expected windows powershell wrapper, got %q", got)
 	}
-	if got := wrapPowerShellCommandForOS("echo test", "linux"); got != "echo test" {
+	if got := wrapPowerShellCommandForOS("echo test", 0, "linux"); got != "echo test" {
 		t.Fatalf("unexpected non-windows powershell wrapper: %q", got)
 	}
 
-	if got := wrapCmdCommandForOS("echo test", "windows"); !strings.HasPrefix(got, "chcp 65001 >nul && ") {
+	if got := wrapCmdCommandForOS("echo test", 0, "windows"); !strings.HasPrefix(got, "chcp 65001 >nul && ") {
 		t.Fatalf("expected windows cmd wrapper, got %q", got)
 	}
-	if got := wrapCmdCommandForOS("echo test", "linux"); got != "echo test" {
+	if got := wrapCmdCommandForOS("echo test", 0, "linux"); got != "echo test" {
 		t.Fatalf("unexpected non-windows cmd wrapper: %q", got)
 	}
 
@@ -146,3 +147,26 @@ func TestLocalCrossPlatformHelperScenarios(t *testing.T) {
 	writer := newSCPStreamLogWriter("instance-1", "stdout", ShellTypeSh, "ctx")
 	writer.logLine("   \n")
 }
+
+func TestPowerShellArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("wrapper prepends console encoding setup on windows")
+	}
+	if got := strings.Join(powerShellArgs(ExecuteRequest{Command: "Get-Date"}), " "); got != "-Command Get-Date" {
+		t.Fatalf("unexpected default args: %q", got)
+	}
+	if got := strings.Join(powerShellArgs(ExecuteRequest{Command: "Get-Date", PowerShellBypass: true}), " "); got != "-NoProfile -NonInteractive -ExecutionPolicy Bypass -Command Get-Date" {
+		t.Fatalf("unexpected bypass args: %q", got)
+	}
+	args := powerShellArgs(ExecuteRequest{Command: `Write-Output "a'b"`, PowerShellEncodedCommand: true})
+	if len(args) != 2 || args[0] != "-EncodedCommand" || args[1] != encodePowerShellCommand(`Write-Output "a'b"`) {
+		t.Fatalf("unexpected encoded args: %q", args)
+	}
+}
+
+func TestEncodePowerShellCommand(t *testing.T) {
+	// 与 [Convert]::ToBase64String([Text.Encoding]::Unicode.GetBytes('dir 中')) 的结果一致
+	if got := encodePowerShellCommand("dir 中"); got != "ZABpAHIAIAAtTg==" {
+		t.Fatalf("unexpected encoded command: %q", got)
+	}
+}
@@ -0,0 +1,22 @@
+package local
+
+// interpreter 描述可通过 shell 字段直接执行内联代码的脚本解释器
+type interpreter struct {
+	inlineFlag string // 执行内联代码的参数，如 python -c、node -e
+	extension  string // script_object 下载后的脚本扩展名
+}
+
+// interpreters 为已知解释器及其调用方式；其他程序无法确定参数约定，须通过 args 显式传入
+var interpreters = map[string]interpreter{
+	"python":  {inlineFlag: "-c", extension: ".py"},
+	"python3": {inlineFlag: "-c", extension: ".py"},
+	"node":    {inlineFlag: "-e", extension: ".js"},
+	"perl":    {inlineFlag: "-e", extension: ".pl"},
+	"ruby":    {inlineFlag: "-e", extension: ".rb"},
+	"php":     {inlineFlag: "-r", extension: ".php"},
+}
+
+func lookupInterpreter(shell string) (interpreter, bool) {
+	entry, ok := interpreters[shell]
+	return entry, ok
+}
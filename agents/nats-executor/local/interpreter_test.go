@@ -0,0 +1,67 @@
+package local
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func requireInterpreter(t *testing.T, name string) {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not available", name)
+	}
+}
+
+func TestExecuteRunsInlineCodeWithKnownInterpreters(t *testing.T) {
+	cases := map[string]string{
+		"python3": `import sys; print("py", sys.argv[0])`,
+		"node":    `console.log("js", typeof process.argv[1])`,
+		"perl":    `print "pl ", $0, "\n"`,
+	}
+	want := map[string]string{
+		"python3": "py -c",
+		"node":    "js undefined",
+		"perl":    "pl -e",
+	}
+	for shell, code := range cases {
+		t.Run(shell, func(t *testing.T) {
+			requireInterpreter(t, shell)
+			response := Execute(ExecuteRequest{Command: code, Shell: shell, ExecuteTimeout: 10}, "test-interpreter")
+			if !response.Success || strings.TrimSpace(response.Output) != want[shell] {
+				t.Fatalf("unexpected response: %+v", response)
+			}
+		})
+	}
+}
+
+func TestExecuteRejectsUnknownInterpreterWithArgsHint(t *testing.T) {
+	response := Execute(ExecuteRequest{Command: "print('hi')", Shell: "lua", ExecuteTimeout: 5}, "test-interpreter")
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("expected unknown interpreter to be rejected, got %+v", response)
+	}
+	if !strings.Contains(response.Error, "unsupported shell: lua") || !strings.Contains(response.Error, "use args") {
+		t.Fatalf("expected args hint, got %q", response.Error)
+	}
+}
+
+func TestExecuteScriptObjectRunsInterpreterScript(t *testing.T) {
+	requireInterpreter(t, "python3")
+	captured := stubScriptDownload(t, "import os\nprint('from-python', os.environ['GREETING'])\n", nil)
+
+	response := executeScriptObject(ExecuteRequest{
+		ScriptObject:   &ScriptObject{BucketName: "scripts", FileKey: "collect.py"},
+		Shell:          "python3",
+		Env:            map[string]string{"GREETING": "hi"},
+		ExecuteTimeout: 10,
+	}, "instance-1", nil)
+
+	if !response.Success || strings.TrimSpace(response.Output) != "from-python hi" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if captured.FileName != "script.py" {
+		t.Fatalf("expected interpreter extension, got %q", captured.FileName)
+	}
+}
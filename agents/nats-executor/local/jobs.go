@@ -0,0 +1,78 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var subscribeJobsListFn = subscribeJobsList
+
+func jobsListErrorResponse(instanceId, code, message string) ([]byte, bool) {
+	responseContent, _ := json.Marshal(JobsListResponse{
+		InstanceId: instanceId,
+		Success:    false,
+		Jobs:       []utils.JobInfo{},
+		Code:       code,
+		Error:      message,
+	})
+	return responseContent, true
+}
+
+// handleJobsListMessage 返回本实例正在处理的任务；请求体可为空，或携带 {"args":[{"status":"running"}]} 过滤
+func handleJobsListMessage(data []byte, instanceId string) ([]byte, bool) {
+	var listRequest JobsListRequest
+	if len(strings.TrimSpace(string(data))) > 0 {
+		var incoming incomingMessage
+		if err := json.Unmarshal(data, &incoming); err != nil {
+			return jobsListErrorResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload")
+		}
+		if len(incoming.Args) > 0 {
+			if err := json.Unmarshal(incoming.Args[0], &listRequest); err != nil {
+				return jobsListErrorResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload")
+			}
+		}
+	}
+
+	status := strings.ToLower(strings.TrimSpace(listRequest.Status))
+	if status != "" && !utils.IsKnownJobStatus(status) {
+		return jobsListErrorResponse(instanceId, utils.ErrorCodeInvalidRequest, fmt.Sprintf("unsupported status: %s", listRequest.Status))
+	}
+
+	responseContent, _ := json.Marshal(JobsListResponse{
+		InstanceId: instanceId,
+		Success:    true,
+		Jobs:       utils.DefaultJobs.List(status),
+	})
+	return responseContent, true
+}
+
+func respondJobsListSubscription(msg inboundMsg, instanceId string) bool {
+	responseContent, _ := handleJobsListMessage(msg.Payload(), instanceId)
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Jobs List Subscribe] Error responding to jobs list request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeJobsList(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("jobs.list.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Jobs List Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondJobsListSubscription(natsInboundMsg{msg}, *instanceId)
+	})
+	return err
+}
+
+func SubscribeJobsList(nc *nats.Conn, instanceId *string) {
+	if err := subscribeJobsListFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Jobs List Subscribe] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package local
+
+import (
+	"encoding/json"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func decodeJobsList(t *testing.T, payload []byte) JobsListResponse {
+	t.Helper()
+	var response JobsListResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal jobs list: %v", err)
+	}
+	return response
+}
+
+func TestHandleJobsListMessageReportsInFlightExecution(t *testing.T) {
+	original := executeLocalCommand
+	defer func() { executeLocalCommand = original }()
+
+	var listed JobsListResponse
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		payload, _ := handleJobsListMessage([]byte(`{"args":[{"status":"running"}],"kwargs":{}}`), instanceId)
+		listed = decodeJobsList(t, payload)
+		return ExecuteResponse{Success: true, InstanceId: instanceId}
+	}
+
+	handleLocalExecuteMessage([]byte(`{"args":[{"command":"sleep 30","execute_timeout":60,"job_id":"job-42"}],"kwargs":{}}`), "instance-1")
+
+	if !listed.Success || len(listed.Jobs) != 1 {
+		t.Fatalf("expected one running job during execution, got %+v", listed)
+	}
+	job := listed.Jobs[0]
+	if job.ID != "job-42" || job.Command != "sleep 30" || job.Operation != "local.execute" || job.Status != utils.JobStatusRunning {
+		t.Fatalf("unexpected job info: %+v", job)
+	}
+
+	payload, _ := handleJobsListMessage(nil, "instance-1")
+	if response := decodeJobsList(t, payload); !response.Success || len(response.Jobs) != 0 {
+		t.Fatalf("expected finished job to be removed, got %+v", response)
+	}
+}
+
+func TestHandleJobsListMessageRejectsUnknownStatus(t *testing.T) {
+	payload, _ := handleJobsListMessage([]byte(`{"args":[{"status":"cron"}]}`), "instance-1")
+	response := decodeJobsList(t, payload)
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != "unsupported status: cron" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	payload, _ = handleJobsListMessage([]byte(`not-json`), "instance-1")
+	if response := decodeJobsList(t, payload); response.Success || response.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("unexpected malformed payload response: %+v", response)
+	}
+}
+
+func TestSubscribeJobsListRegistersSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeJobsList(sub, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "jobs.list.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
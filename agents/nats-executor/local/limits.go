@@ -0,0 +1,91 @@
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var subscribeLimitsFn = subscribeLimits
+
+func handleLimitsGetMessage(instanceId string) []byte {
+	responseContent, _ := json.Marshal(LimitsResponse{
+		InstanceId: instanceId,
+		Success:    true,
+		Limits:     utils.DefaultLimits.Snapshot(),
+	})
+	return responseContent
+}
+
+// handleLimitsSetMessage 在配置的上限内调整执行限制，只影响之后到达的请求
+func handleLimitsSetMessage(data []byte, instanceId string) []byte {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return limitsErrorResponse(instanceId, "invalid request payload")
+	}
+	var limits utils.Limits
+	decoder := json.NewDecoder(bytes.NewReader(incoming.Args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&limits); err != nil {
+		return limitsErrorResponse(instanceId, "invalid request payload")
+	}
+
+	snapshot, err := utils.DefaultLimits.Set(limits)
+	if err != nil {
+		return limitsErrorResponse(instanceId, err.Error())
+	}
+	logger.WithInstance(instanceId).Infof("[Limits] max_concurrent_jobs set to %d (running: %d)", snapshot.MaxConcurrentJobs, snapshot.RunningJobs)
+	responseContent, _ := json.Marshal(LimitsResponse{InstanceId: instanceId, Success: true, Limits: snapshot})
+	return responseContent
+}
+
+func limitsErrorResponse(instanceId, message string) []byte {
+	responseContent, _ := json.Marshal(LimitsResponse{
+		InstanceId: instanceId,
+		Success:    false,
+		Limits:     utils.DefaultLimits.Snapshot(),
+		Code:       utils.ErrorCodeInvalidRequest,
+		Error:      message,
+	})
+	return responseContent
+}
+
+func respondLimitsSubscription(msg inboundMsg, instanceId string, set bool) bool {
+	responseContent := handleLimitsGetMessage(instanceId)
+	if set {
+		responseContent = handleLimitsSetMessage(msg.Payload(), instanceId)
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Limits Subscribe] Error responding to limits request: %v", err)
+		return false
+	}
+	return true
+}
+
+// subscribeLimits 订阅 limits.{id}（查询）与 limits.set.{id}（调整）
+func subscribeLimits(sub subscriber, instanceId *string) error {
+	getSubject := fmt.Sprintf("limits.%s", *instanceId)
+	setSubject := fmt.Sprintf("limits.set.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Limits Subscribe] Subscribing to subjects: %s, %s", getSubject, setSubject)
+
+	if _, err := sub.Subscribe(getSubject, func(msg *nats.Msg) {
+		respondLimitsSubscription(natsInboundMsg{msg}, *instanceId, false)
+	}); err != nil {
+		return err
+	}
+	_, err := sub.Subscribe(setSubject, func(msg *nats.Msg) {
+		respondLimitsSubscription(natsInboundMsg{msg}, *instanceId, true)
+	})
+	return err
+}
+
+func SubscribeLimits(nc *nats.Conn, instanceId *string) {
+	if err := subscribeLimitsFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Limits Subscribe] Failed to subscribe: %v", err)
+	}
+}
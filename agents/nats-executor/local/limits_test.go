@@ -0,0 +1,81 @@
+package local
+
+import (
+	"encoding/json"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func withLimits(t *testing.T, limits utils.Limits, ceiling int) {
+	t.Helper()
+	if err := utils.DefaultLimits.Configure(limits, ceiling); err != nil {
+		t.Fatalf("configure limits: %v", err)
+	}
+	t.Cleanup(func() { _ = utils.DefaultLimits.Configure(utils.Limits{}, 0) })
+}
+
+func decodeLimits(t *testing.T, payload []byte) LimitsResponse {
+	t.Helper()
+	var response LimitsResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal limits: %v", err)
+	}
+	return response
+}
+
+func TestHandleLimitsMessagesReadAndAdjust(t *testing.T) {
+	withLimits(t, utils.Limits{MaxConcurrentJobs: 4}, 10)
+
+	current := decodeLimits(t, handleLimitsGetMessage("instance-1"))
+	if !current.Success || current.Limits.MaxConcurrentJobs != 4 || current.Limits.MaxConcurrentJobsCeiling != 10 {
+		t.Fatalf("unexpected limits: %+v", current)
+	}
+
+	updated := decodeLimits(t, handleLimitsSetMessage([]byte(`{"args":[{"max_concurrent_jobs":2}],"kwargs":{}}`), "instance-1"))
+	if !updated.Success || updated.Limits.MaxConcurrentJobs != 2 {
+		t.Fatalf("expected effective limit 2, got %+v", updated)
+	}
+
+	for _, payload := range []string{
+		`{"args":[{"max_concurrent_jobs":11}],"kwargs":{}}`,
+		`{"args":[{"max_concurrent_job":3}],"kwargs":{}}`,
+		`not-json`,
+	} {
+		rejected := decodeLimits(t, handleLimitsSetMessage([]byte(payload), "instance-1"))
+		if rejected.Success || rejected.Code != utils.ErrorCodeInvalidRequest || rejected.Limits.MaxConcurrentJobs != 2 {
+			t.Fatalf("expected %s to be rejected without changes, got %+v", payload, rejected)
+		}
+	}
+}
+
+func TestHandleLocalExecuteMessageRejectsWhenConcurrencyLimitReached(t *testing.T) {
+	withLimits(t, utils.Limits{MaxConcurrentJobs: 1}, 0)
+	release, _ := utils.DefaultLimits.Acquire("local.execute")
+	defer release()
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		t.Fatal("expected request to be rejected before execution")
+		return ExecuteResponse{}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	payload, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"uptime","execute_timeout":5}],"kwargs":{}}`), "instance-1")
+	var response ExecuteResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if response.Success || response.Code != utils.ErrorCodeTooManyRequests {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestSubscribeLimitsRegistersSubjects(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeLimits(sub, stringPointer("instance-1")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "limits.set.instance-1" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
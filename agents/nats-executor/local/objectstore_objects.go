@@ -0,0 +1,203 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"nats-executor/jetstream"
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+// objectsListTimeout 为列出 bucket 内对象的超时
+const objectsListTimeout = 10 * time.Second
+
+var (
+	listBucketObjects = func(ctx context.Context, nc *nats.Conn, bucketName string) ([]*nats.ObjectInfo, error) {
+		client, err := jetstream.SharedJetStreamClient(nc, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		return client.ListObjects(ctx)
+	}
+	deleteBucketObject = func(nc *nats.Conn, bucketName, fileKey string) error {
+		client, err := jetstream.SharedJetStreamClient(nc, bucketName)
+		if err != nil {
+			return err
+		}
+		return client.DeleteObject(fileKey)
+	}
+	subscribeObjectsListFn  = subscribeObjectsList
+	subscribeObjectDeleteFn = subscribeObjectDelete
+)
+
+func newObjectEntry(info *nats.ObjectInfo) ObjectEntry {
+	entry := ObjectEntry{
+		Name:        info.Name,
+		Description: info.Description,
+		Size:        info.Size,
+		Chunks:      info.Chunks,
+		Digest:      info.Digest,
+		ObjectID:    info.NUID,
+	}
+	if info.Metadata != nil {
+		entry.SHA256 = info.Metadata[jetstream.MetadataSHA256]
+	}
+	if !info.ModTime.IsZero() {
+		entry.ModTime = info.ModTime.UTC().Format(time.RFC3339)
+	}
+	return entry
+}
+
+// listObjects 返回 bucket 中的对象元信息，按名称排序
+func listObjects(req ObjectsListRequest, instanceId string, nc *nats.Conn) ObjectsListResponse {
+	response := ObjectsListResponse{InstanceId: instanceId, BucketName: req.BucketName, Objects: []ObjectEntry{}}
+	if strings.TrimSpace(req.BucketName) == "" {
+		response.Code = utils.ErrorCodeInvalidRequest
+		response.Error = "bucket_name is required"
+		return response
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectsListTimeout)
+	defer cancel()
+	objects, err := listBucketObjects(ctx, nc, req.BucketName)
+	if err != nil {
+		response.Code = objectStoreErrorCode(err)
+		response.Error = fmt.Sprintf("Failed to list objects: %v", err)
+		logger.WithInstance(instanceId).Warnf("[Objects List] Failed to list objects in %s: %v", req.BucketName, err)
+		return response
+	}
+	for _, info := range objects {
+		if strings.HasPrefix(info.Name, req.Prefix) {
+			response.Objects = append(response.Objects, newObjectEntry(info))
+		}
+	}
+	response.Success = true
+	return response
+}
+
+// deleteObject 删除 bucket 中的对象，对象不存在时返回 object_not_found
+func deleteObject(req ObjectDeleteRequest, instanceId string, nc *nats.Conn) ObjectDeleteResponse {
+	response := ObjectDeleteResponse{InstanceId: instanceId, BucketName: req.BucketName, FileKey: req.FileKey}
+	switch {
+	case strings.TrimSpace(req.BucketName) == "":
+		response.Code, response.Error = utils.ErrorCodeInvalidRequest, "bucket_name is required"
+		return response
+	case strings.TrimSpace(req.FileKey) == "":
+		response.Code, response.Error = utils.ErrorCodeInvalidRequest, "file_key is required"
+		return response
+	}
+
+	if err := deleteBucketObject(nc, req.BucketName, req.FileKey); err != nil {
+		response.Code = objectStoreErrorCode(err)
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			response.Code = utils.ErrorCodeObjectNotFound
+		}
+		response.Error = fmt.Sprintf("Failed to delete object: %v", err)
+		logger.WithInstance(instanceId).Warnf("[Object Delete] Failed to delete %s/%s: %v", req.BucketName, req.FileKey, err)
+		return response
+	}
+	logger.WithInstance(instanceId).Infof("[Object Delete] Deleted %s/%s", req.BucketName, req.FileKey)
+	response.Success = true
+	return response
+}
+
+func handleObjectsListMessage(data []byte, instanceId string, nc *nats.Conn) ([]byte, bool) {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	if mismatch := utils.InstanceMismatchResponse("Objects List", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
+
+	var listRequest ObjectsListRequest
+	if err := json.Unmarshal(incoming.Args[0], &listRequest); err != nil {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	responseContent, _ := json.Marshal(listObjects(listRequest, instanceId, nc))
+	return responseContent, true
+}
+
+func handleObjectDeleteMessage(data []byte, instanceId string, nc *nats.Conn) ([]byte, bool) {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	if mismatch := utils.InstanceMismatchResponse("Object Delete", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
+
+	var deleteRequest ObjectDeleteRequest
+	if err := json.Unmarshal(incoming.Args[0], &deleteRequest); err != nil {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	responseContent, _ := json.Marshal(deleteObject(deleteRequest, instanceId, nc))
+	return responseContent, true
+}
+
+func respondObjectsListSubscription(msg inboundMsg, instanceId string, nc *nats.Conn) bool {
+	responseContent, ok := handleObjectsListMessage(msg.Payload(), instanceId, nc)
+	if !ok {
+		logger.WithInstance(instanceId).Errorf("[Objects List Subscribe] Error unmarshalling incoming message")
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Objects List Subscribe] Error responding to objects list request: %v", err)
+		return false
+	}
+	return true
+}
+
+func respondObjectDeleteSubscription(msg inboundMsg, instanceId string, nc *nats.Conn) bool {
+	responseContent, ok := handleObjectDeleteMessage(msg.Payload(), instanceId, nc)
+	if !ok {
+		logger.WithInstance(instanceId).Errorf("[Object Delete Subscribe] Error unmarshalling incoming message")
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Object Delete Subscribe] Error responding to object delete request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeObjectsList(sub subscriber, nc *nats.Conn, instanceId *string) error {
+	subject := fmt.Sprintf("objectstore.list.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Objects List Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("objectstore.list")()
+		respondObjectsListSubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func subscribeObjectDelete(sub subscriber, nc *nats.Conn, instanceId *string) error {
+	subject := fmt.Sprintf("objectstore.delete.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Object Delete Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("objectstore.delete")()
+		respondObjectDeleteSubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func SubscribeObjectsList(nc *nats.Conn, instanceId *string) {
+	if err := subscribeObjectsListFn(nc, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Objects List Subscribe] Failed to subscribe: %v", err)
+	}
+}
+
+func SubscribeObjectDelete(nc *nats.Conn, instanceId *string) {
+	if err := subscribeObjectDeleteFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Object Delete Subscribe] Failed to subscribe: %v", err)
+	}
+}
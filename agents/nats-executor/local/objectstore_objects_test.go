@@ -0,0 +1,185 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func withObjectsStubs(t *testing.T, list func(context.Context, *nats.Conn, string) ([]*nats.ObjectInfo, error), remove func(*nats.Conn, string, string) error) {
+	t.Helper()
+	originalList, originalDelete := listBucketObjects, deleteBucketObject
+	if list != nil {
+		listBucketObjects = list
+	}
+	if remove != nil {
+		deleteBucketObject = remove
+	}
+	t.Cleanup(func() { listBucketObjects, deleteBucketObject = originalList, originalDelete })
+}
+
+func objectsPayload(args any) []byte {
+	encoded, _ := json.Marshal(args)
+	return []byte(`{"args":[` + string(encoded) + `],"kwargs":{}}`)
+}
+
+func TestListObjectsReturnsMetadataFilteredByPrefix(t *testing.T) {
+	modTime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	withObjectsStubs(t, func(ctx context.Context, nc *nats.Conn, bucket string) ([]*nats.ObjectInfo, error) {
+		if _, ok := ctx.Deadline(); !ok || bucket != "packages" {
+			t.Fatalf("unexpected list call: bucket=%s", bucket)
+		}
+		return []*nats.ObjectInfo{
+			{ObjectMeta: nats.ObjectMeta{Name: "agent/v1.tar.gz", Metadata: map[string]string{"sha256": "abc"}}, Size: 10, Chunks: 1, Digest: "SHA-256=xyz", NUID: "n1", ModTime: modTime},
+			{ObjectMeta: nats.ObjectMeta{Name: "scripts/run.sh"}, Size: 3, Chunks: 1},
+		}, nil
+	}, nil)
+
+	response := listObjects(ObjectsListRequest{BucketName: "packages", Prefix: "agent/"}, "instance-a", nil)
+	if !response.Success || len(response.Objects) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	want := ObjectEntry{Name: "agent/v1.tar.gz", Size: 10, Chunks: 1, ModTime: "2024-05-06T07:08:09Z", Digest: "SHA-256=xyz", SHA256: "abc", ObjectID: "n1"}
+	if response.Objects[0] != want {
+		t.Fatalf("unexpected entry: %+v", response.Objects[0])
+	}
+}
+
+func TestListObjectsMapsErrors(t *testing.T) {
+	withObjectsStubs(t, func(context.Context, *nats.Conn, string) ([]*nats.ObjectInfo, error) {
+		return nil, fmt.Errorf("failed to list objects: %w", context.DeadlineExceeded)
+	}, nil)
+
+	response := listObjects(ObjectsListRequest{BucketName: "packages"}, "instance-a", nil)
+	if response.Success || response.Code != utils.ErrorCodeTimeout || response.Objects == nil {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if response := listObjects(ObjectsListRequest{}, "instance-a", nil); response.Code != utils.ErrorCodeInvalidRequest || response.Error != "bucket_name is required" {
+		t.Fatalf("expected validation error, got %+v", response)
+	}
+}
+
+func TestDeleteObjectReportsObjectNotFound(t *testing.T) {
+	withObjectsStubs(t, nil, func(nc *nats.Conn, bucket, key string) error {
+		return fmt.Errorf("object %s not found: %w", key, nats.ErrObjectNotFound)
+	})
+
+	response := deleteObject(ObjectDeleteRequest{BucketName: "packages", FileKey: "agent/old.tar.gz"}, "instance-a", nil)
+	if response.Success || response.Code != utils.ErrorCodeObjectNotFound || response.FileKey != "agent/old.tar.gz" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if response := deleteObject(ObjectDeleteRequest{BucketName: "packages"}, "instance-a", nil); response.Code != utils.ErrorCodeInvalidRequest || response.Error != "file_key is required" {
+		t.Fatalf("expected validation error, got %+v", response)
+	}
+}
+
+func TestHandleObjectsMessagesRejectInstanceMismatch(t *testing.T) {
+	withObjectsStubs(t, func(context.Context, *nats.Conn, string) ([]*nats.ObjectInfo, error) {
+		t.Fatal("mismatched request should not list")
+		return nil, nil
+	}, func(*nats.Conn, string, string) error {
+		t.Fatal("mismatched request should not delete")
+		return nil
+	})
+
+	payload := []byte(`{"args":[{"bucket_name":"packages","file_key":"a"}],"kwargs":{"instance_id":"other"}}`)
+	for name, handle := range map[string]func([]byte, string, *nats.Conn) ([]byte, bool){
+		"list":   handleObjectsListMessage,
+		"delete": handleObjectDeleteMessage,
+	} {
+		responseContent, _ := handle(payload, "instance-a", nil)
+		var response struct {
+			Code string `json:"code"`
+		}
+		if err := json.Unmarshal(responseContent, &response); err != nil || response.Code != utils.ErrorCodeInstanceMismatch {
+			t.Fatalf("%s: expected instance mismatch, got %s", name, responseContent)
+		}
+	}
+}
+
+func TestSubscribeObjectsSubjects(t *testing.T) {
+	for subject, subscribe := range map[string]func(subscriber, *nats.Conn, *string) error{
+		"objectstore.list.instance-a":   subscribeObjectsList,
+		"objectstore.delete.instance-a": subscribeObjectDelete,
+	} {
+		sub := &stubSubscriber{}
+		if err := subscribe(sub, nil, stringPointer("instance-a")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if sub.subject != subject || sub.handler == nil {
+			t.Fatalf("unexpected subscription state: %+v", sub)
+		}
+	}
+}
+
+func TestObjectsListAndDeleteThroughJetStream(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "dispatched"})
+	if err != nil {
+		t.Fatalf("create object store: %v", err)
+	}
+
+	decodeList := func() ObjectsListResponse {
+		responseContent, _ := handleObjectsListMessage(objectsPayload(ObjectsListRequest{BucketName: "dispatched"}), "instance-a", nc)
+		var response ObjectsListResponse
+		if err := json.Unmarshal(responseContent, &response); err != nil {
+			t.Fatalf("unmarshal list response: %v", err)
+		}
+		return response
+	}
+	if response := decodeList(); !response.Success || len(response.Objects) != 0 {
+		t.Fatalf("expected empty bucket listing, got %+v", response)
+	}
+
+	for _, name := range []string{"pkg/b.tar.gz", "pkg/a.tar.gz"} {
+		if _, err := store.PutString(name, "content of "+name); err != nil {
+			t.Fatalf("put %s: %v", name, err)
+		}
+	}
+	listed := decodeList()
+	if !listed.Success || len(listed.Objects) != 2 || listed.Objects[0].Name != "pkg/a.tar.gz" || listed.Objects[0].Size == 0 || listed.Objects[0].Digest == "" || listed.Objects[0].ModTime == "" {
+		t.Fatalf("unexpected listing: %+v", listed)
+	}
+
+	deleteOnce := func() ObjectDeleteResponse {
+		responseContent, _ := handleObjectDeleteMessage(objectsPayload(ObjectDeleteRequest{BucketName: "dispatched", FileKey: "pkg/a.tar.gz"}), "instance-a", nc)
+		var response ObjectDeleteResponse
+		if err := json.Unmarshal(responseContent, &response); err != nil {
+			t.Fatalf("unmarshal delete response: %v", err)
+		}
+		return response
+	}
+	if response := deleteOnce(); !response.Success {
+		t.Fatalf("expected delete to succeed, got %+v", response)
+	}
+	if response := deleteOnce(); response.Success || response.Code != utils.ErrorCodeObjectNotFound {
+		t.Fatalf("expected second delete to report object_not_found, got %+v", response)
+	}
+	if listed := decodeList(); len(listed.Objects) != 1 || listed.Objects[0].Name != "pkg/b.tar.gz" {
+		t.Fatalf("expected deleted object to disappear from listing, got %+v", listed)
+	}
+}
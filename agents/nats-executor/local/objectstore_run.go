@@ -0,0 +1,53 @@
+package local
+
+import (
+	"fmt"
+	"strings"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var subscribeObjectStoreRunFn = subscribeObjectStoreRun
+
+// handleObjectStoreRunMessage 将 run.objectstore 请求转为 script_object 执行：下载失败不进入执行阶段，
+// 临时脚本目录无论成功失败都会删除
+func handleObjectStoreRunMessage(data []byte, instanceId string) ([]byte, bool) {
+	var runRequest ObjectStoreRunRequest
+	if errResponse := decodeExecuteArgs(data, instanceId, "Object Store Run", &runRequest); errResponse != nil {
+		return errResponse, true
+	}
+	switch {
+	case runRequest.ScriptObject != nil || strings.TrimSpace(runRequest.Command) != "" || len(runRequest.Args) > 0:
+		return invalidRequestResponse(instanceId, "run.objectstore does not accept command, args or script_object")
+	case strings.TrimSpace(runRequest.BucketName) == "":
+		return invalidRequestResponse(instanceId, "bucket_name is required")
+	case strings.TrimSpace(runRequest.FileKey) == "":
+		return invalidRequestResponse(instanceId, "file_key is required")
+	}
+	req := runRequest.ExecuteRequest
+	req.ScriptObject = &ScriptObject{BucketName: runRequest.BucketName, FileKey: runRequest.FileKey}
+	return runLocalExecuteRequest(req, instanceId, "run.objectstore")
+}
+
+func subscribeObjectStoreRun(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("run.objectstore.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Object Store Run Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("run.objectstore")()
+		respondExecuteMessage(natsInboundMsg{msg}, msg.Data, *instanceId, handleObjectStoreRunMessage)
+	})
+	return err
+}
+
+func SubscribeObjectStoreRun(nc *nats.Conn, instanceId *string) {
+	if nc != nil {
+		localScriptConn = nc
+	}
+	if err := subscribeObjectStoreRunFn(utils.QueueSubscriber{Conn: nc}, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Object Store Run Subscribe] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nats-executor/jetstream"
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	uploadObjectFile = func(req utils.UploadFileRequest, nc downloadConn) (*nats.ObjectInfo, error) {
+		natsConn, _ := nc.(*nats.Conn)
+		return utils.UploadFile(req, natsConn)
+	}
+	subscribeObjectStoreUploadFn = subscribeObjectStoreUpload
+)
+
+func validateObjectStoreUploadRequest(req ObjectStoreUploadRequest) string {
+	switch {
+	case strings.TrimSpace(req.BucketName) == "":
+		return "bucket_name is required"
+	case strings.TrimSpace(req.SourcePath) == "":
+		return "source_path is required"
+	case req.ExecuteTimeout <= 0:
+		return "execute timeout must be greater than 0"
+	default:
+		return ""
+	}
+}
+
+// uploadToObjectStore 将本机文件流式写入对象存储，返回写入的字节数与对象元信息
+func uploadToObjectStore(req ObjectStoreUploadRequest, instanceId string, nc downloadConn) ObjectStoreUploadResponse {
+	if validationErr := validateObjectStoreUploadRequest(req); validationErr != "" {
+		return ObjectStoreUploadResponse{Output: validationErr, InstanceId: instanceId, Code: utils.ErrorCodeInvalidRequest, Error: validationErr}
+	}
+	fileKey := req.FileKey
+	if strings.TrimSpace(fileKey) == "" {
+		fileKey = fmt.Sprintf("upload/%s/%s", instanceId, filepath.Base(req.SourcePath))
+	}
+
+	logger.WithInstance(instanceId).Infof("[Object Store Upload] upload %s -> %s/%s", req.SourcePath, req.BucketName, fileKey)
+	info, err := uploadObjectFile(utils.UploadFileRequest{
+		BucketName:     req.BucketName,
+		FileKey:        fileKey,
+		SourcePath:     req.SourcePath,
+		ExecuteTimeout: req.ExecuteTimeout,
+	}, nc)
+	if err != nil {
+		message := fmt.Sprintf("Failed to upload file: %v", err)
+		return ObjectStoreUploadResponse{
+			Output:     message,
+			InstanceId: instanceId,
+			Code:       objectStoreErrorCode(err),
+			Error:      message,
+			BucketName: req.BucketName,
+			FileKey:    fileKey,
+		}
+	}
+
+	response := ObjectStoreUploadResponse{
+		Output:     fmt.Sprintf("File %s uploaded to %s/%s (%d bytes)", req.SourcePath, req.BucketName, fileKey, info.Size),
+		InstanceId: instanceId,
+		Success:    true,
+		BucketName: req.BucketName,
+		FileKey:    fileKey,
+		Size:       info.Size,
+		Chunks:     info.Chunks,
+		Digest:     info.Digest,
+		ObjectID:   info.NUID,
+	}
+	if info.Metadata != nil {
+		response.SHA256 = info.Metadata[jetstream.MetadataSHA256]
+	}
+	if !info.ModTime.IsZero() {
+		response.ModTime = info.ModTime.UTC().Format(time.RFC3339)
+	}
+	return response
+}
+
+func handleObjectStoreUploadMessage(data []byte, instanceId string, nc downloadConn) ([]byte, bool) {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	if mismatch := utils.InstanceMismatchResponse("Object Store Upload", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
+
+	var uploadRequest ObjectStoreUploadRequest
+	if err := json.Unmarshal(incoming.Args[0], &uploadRequest); err != nil {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+
+	responseContent, _ := json.Marshal(uploadToObjectStore(uploadRequest, instanceId, nc))
+	return responseContent, true
+}
+
+func respondObjectStoreUploadSubscription(msg inboundMsg, instanceId string, nc downloadConn) bool {
+	responseContent, ok := handleObjectStoreUploadMessage(msg.Payload(), instanceId, nc)
+	if !ok {
+		logger.WithInstance(instanceId).Errorf("[Object Store Upload Subscribe] Error unmarshalling incoming message")
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Object Store Upload Subscribe] Error responding to upload request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeObjectStoreUpload(sub subscriber, nc downloadConn, instanceId *string) error {
+	subject := fmt.Sprintf("upload.objectstore.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Object Store Upload Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("upload.objectstore")()
+		respondObjectStoreUploadSubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func SubscribeObjectStoreUpload(nc *nats.Conn, instanceId *string) {
+	if err := subscribeObjectStoreUploadFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Object Store Upload Subscribe] Failed to subscribe: %v", err)
+	}
+}
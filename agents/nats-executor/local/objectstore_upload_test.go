@@ -0,0 +1,149 @@
+package local
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+	"nats-executor/utils/downloaderr"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func withObjectUploadStub(t *testing.T, upload func(utils.UploadFileRequest, downloadConn) (*nats.ObjectInfo, error)) {
+	t.Helper()
+	original := uploadObjectFile
+	uploadObjectFile = upload
+	t.Cleanup(func() { uploadObjectFile = original })
+}
+
+func TestUploadToObjectStoreReturnsObjectInfo(t *testing.T) {
+	var uploaded utils.UploadFileRequest
+	withObjectUploadStub(t, func(req utils.UploadFileRequest, nc downloadConn) (*nats.ObjectInfo, error) {
+		uploaded = req
+		return &nats.ObjectInfo{
+			ObjectMeta: nats.ObjectMeta{Name: req.FileKey, Metadata: map[string]string{"sha256": "abc"}},
+			Size:       2048,
+			Chunks:     1,
+			NUID:       "nuid-1",
+			Digest:     "SHA-256=xyz",
+			ModTime:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		}, nil
+	})
+
+	response := uploadToObjectStore(ObjectStoreUploadRequest{BucketName: "logs", SourcePath: "/var/log/app.log", ExecuteTimeout: 30}, "instance-a", nil)
+	if !response.Success || response.FileKey != "upload/instance-a/app.log" || uploaded.FileKey != response.FileKey {
+		t.Fatalf("unexpected response: %+v (uploaded %+v)", response, uploaded)
+	}
+	if response.Size != 2048 || response.Chunks != 1 || response.SHA256 != "abc" || response.Digest != "SHA-256=xyz" || response.ObjectID != "nuid-1" || response.ModTime != "2024-01-02T03:04:05Z" {
+		t.Fatalf("unexpected object info: %+v", response)
+	}
+}
+
+func TestUploadToObjectStoreMapsUploadErrors(t *testing.T) {
+	withObjectUploadStub(t, func(req utils.UploadFileRequest, nc downloadConn) (*nats.ObjectInfo, error) {
+		return nil, downloaderr.New(downloaderr.KindIO, os.ErrNotExist)
+	})
+
+	response := uploadToObjectStore(ObjectStoreUploadRequest{BucketName: "logs", FileKey: "custom/key", SourcePath: "/missing", ExecuteTimeout: 30}, "instance-a", nil)
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure || response.FileKey != "custom/key" || response.Size != 0 {
+		t.Fatalf("unexpected failure response: %+v", response)
+	}
+}
+
+func TestHandleObjectStoreUploadMessageValidatesRequest(t *testing.T) {
+	withObjectUploadStub(t, func(req utils.UploadFileRequest, nc downloadConn) (*nats.ObjectInfo, error) {
+		t.Fatalf("invalid request should not upload: %+v", req)
+		return nil, nil
+	})
+
+	for payload, want := range map[string]string{
+		`{"args":[{"source_path":"/data/a","execute_timeout":5}],"kwargs":{}}`:                                           "bucket_name is required",
+		`{"args":[{"bucket_name":"logs","execute_timeout":5}],"kwargs":{}}`:                                              "source_path is required",
+		`{"args":[{"bucket_name":"logs","source_path":"/data/a"}],"kwargs":{}}`:                                          "execute timeout must be greater than 0",
+		`{"args":[{"bucket_name":"logs","source_path":"/data/a","execute_timeout":5}],"kwargs":{"instance_id":"other"}}`: "",
+	} {
+		responseContent, ok := handleObjectStoreUploadMessage([]byte(payload), "instance-a", nil)
+		if !ok {
+			t.Fatalf("expected handler to respond to %s", payload)
+		}
+		var response ObjectStoreUploadResponse
+		if err := json.Unmarshal(responseContent, &response); err != nil {
+			t.Fatalf("unmarshal response: %v", err)
+		}
+		if want == "" {
+			if response.Code != utils.ErrorCodeInstanceMismatch {
+				t.Fatalf("expected instance mismatch, got %+v", response)
+			}
+			continue
+		}
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != want {
+			t.Fatalf("payload %s: expected %q, got %+v", payload, want, response)
+		}
+	}
+}
+
+func TestSubscribeObjectStoreUploadRegistersSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeObjectStoreUpload(sub, nil, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "upload.objectstore.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
+
+func TestObjectStoreUploadStreamsFileThroughJetStream(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "collected"})
+	if err != nil {
+		t.Fatalf("create object store: %v", err)
+	}
+
+	// 多个分块大小的内容，确认按分块流式写入
+	content := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	sourcePath := filepath.Join(t.TempDir(), "collected.log")
+	if err := os.WriteFile(sourcePath, content, 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	args, _ := json.Marshal(ObjectStoreUploadRequest{BucketName: "collected", SourcePath: sourcePath, ExecuteTimeout: 30})
+	responseContent, _ := handleObjectStoreUploadMessage([]byte(`{"args":[`+string(args)+`],"kwargs":{}}`), "instance-a", nc)
+	var response ObjectStoreUploadResponse
+	if err := json.Unmarshal(responseContent, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	sum := sha256.Sum256(content)
+	if !response.Success || response.Size != uint64(len(content)) || response.Chunks < 2 || response.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	stored, err := store.GetBytes("upload/instance-a/collected.log")
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Fatalf("stored object does not match source (err=%v, %d bytes)", err, len(stored))
+	}
+}
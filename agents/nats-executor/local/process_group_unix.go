@@ -0,0 +1,30 @@
+//go:build !windows
+
+package local
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 让命令自成进程组，超时或取消时信号可作用到其派生的全部子进程
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcessGroup(cmd *exec.Cmd, signal syscall.Signal) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, signal)
+}
+
+// terminateProcessGroup 向整个进程组发送 SIGTERM，给命令清理临时文件、释放锁的机会
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+// killProcessGroup 宽限期结束后仍未退出时 SIGKILL 整个进程组
+func killProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
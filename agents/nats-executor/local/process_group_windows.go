@@ -0,0 +1,27 @@
+//go:build windows
+
+package local
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// Windows 没有进程组信号，保持默认的进程创建方式，终止时按进程树结束
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup 在 Windows 上没有 SIGTERM 等价物，直接结束进程树
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+// killProcessGroup 用 taskkill /T 结束进程及其派生的子进程，taskkill 失败时退回只结束主进程
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -11,12 +11,12 @@ import (
 )
 
 func TestRegressionLocalExecuteOutputDecoding(t *testing.T) {
-	wrappedCmd := wrapCmdCommand("ipconfig")
+	wrappedCmd := wrapCmdCommand("ipconfig", 0)
 	if runtime.GOOS == "windows" && !strings.Contains(wrappedCmd, "chcp 65001") {
 		t.Fatalf("expected cmd command wrapper to switch code page, got %q", wrappedCmd)
 	}
 
-	wrapped := wrapPowerShellCommand("Write-Output test")
+	wrapped := wrapPowerShellCommand("Write-Output test", 0)
 	if runtime.GOOS == "windows" && !strings.Contains(wrapped, "[Console]::OutputEncoding") {
 		t.Fatalf("expected PowerShell command wrapper to force UTF-8 output, got %q", wrapped)
 	}
@@ -136,6 +136,51 @@ func TestRegressionLocalExecuteAppliesSharedCapAcrossStdoutAndStderr(t *testing.
 	}
 }
 
+func TestRegressionLocalExecuteHonorsRequestedOutputLimit(t *testing.T) {
+	response := Execute(ExecuteRequest{
+		Command:        "yes 1234567890 | head -c 1500000",
+		ExecuteTimeout: 5,
+		Shell:          ShellTypeSh,
+		MaxOutputBytes: 2 * utils.CommandOutputLimitBytes,
+	}, "instance-1")
+
+	if !response.Success || response.Truncated {
+		t.Fatalf("expected full output below requested limit, got success=%v truncated=%v", response.Success, response.Truncated)
+	}
+	if len(response.Output) != 1500000 {
+		t.Fatalf("expected untruncated output, got %d bytes", len(response.Output))
+	}
+
+	response = Execute(ExecuteRequest{
+		Command:        "yes 1234567890 | head -c 4096",
+		ExecuteTimeout: 5,
+		Shell:          ShellTypeSh,
+		MaxOutputBytes: 1024,
+	}, "instance-1")
+	if !response.Success || !response.Truncated || len(response.Output) > 1024 {
+		t.Fatalf("expected output capped at requested limit, got truncated=%v len=%d", response.Truncated, len(response.Output))
+	}
+}
+
+func TestRegressionLocalExecuteCapsRequestAboveHardLimit(t *testing.T) {
+	response := Execute(ExecuteRequest{
+		Command:        fmt.Sprintf("yes 1234567890 | head -c %d", utils.CommandOutputHardLimitBytes+4096),
+		ExecuteTimeout: 10,
+		Shell:          ShellTypeSh,
+		MaxOutputBytes: 4 * utils.CommandOutputHardLimitBytes,
+	}, "instance-1")
+
+	if !response.Success || !response.Truncated {
+		t.Fatalf("expected truncated success at hard limit, got success=%v truncated=%v", response.Success, response.Truncated)
+	}
+	if len(response.Output) > utils.CommandOutputHardLimitBytes {
+		t.Fatalf("expected output within hard limit, got %d bytes", len(response.Output))
+	}
+	if !strings.Contains(response.Output[len(response.Output)-64:], "output truncated") {
+		t.Fatal("expected truncation marker at hard limit")
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,81 @@
+//go:build !windows
+
+package local
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"nats-executor/utils"
+)
+
+var currentEUID = os.Geteuid
+
+// runAsUser 为以 run_as_user 身份运行子进程所需的凭据和环境
+type runAsUser struct {
+	credential *syscall.Credential
+	env        []string
+}
+
+// resolveRunAsUser 将用户名或数字 uid 解析为子进程凭据。切换到其他用户需要执行器以 root 运行，
+// 否则返回 permission_denied
+func resolveRunAsUser(name string) (*runAsUser, string, string) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		var unknown user.UnknownUserError
+		if _, numErr := strconv.Atoi(name); numErr != nil || !errors.As(err, &unknown) {
+			return nil, utils.ErrorCodeInvalidRequest, fmt.Sprintf("run_as_user %s not found: %v", name, err)
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return nil, utils.ErrorCodeInvalidRequest, fmt.Sprintf("run_as_user %s not found: %v", name, err)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, utils.ErrorCodeInvalidRequest, fmt.Sprintf("run_as_user %s has non-numeric uid %s", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, utils.ErrorCodeInvalidRequest, fmt.Sprintf("run_as_user %s has non-numeric gid %s", name, u.Gid)
+	}
+	if euid := currentEUID(); euid != 0 && uint64(euid) != uid {
+		return nil, utils.ErrorCodePermissionDenied, fmt.Sprintf("run_as_user %s requires the executor to run as root (current uid %d)", name, euid)
+	}
+
+	// 非 root 只能以自身身份运行，此时无权调用 setgroups，保留现有附加组
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: currentEUID() != 0}
+	// 附加组查询失败（如无 cgo 时的部分 NSS 配置）只保留主组
+	if groupIDs, err := u.GroupIds(); err == nil && !credential.NoSetGroups {
+		for _, id := range groupIDs {
+			if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(group))
+			}
+		}
+	}
+	return &runAsUser{
+		credential: credential,
+		env:        []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username},
+	}, "", ""
+}
+
+// apply 在 setProcessGroup 之后设置子进程凭据，并把 HOME/USER/LOGNAME 指向目标用户
+func (r *runAsUser) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = r.credential
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, r.env...)
+}
+
+// chown 把 path 的属主改为目标用户，使其能访问执行器以 root 创建的临时文件
+func (r *runAsUser) chown(path string) error {
+	return os.Chown(path, int(r.credential.Uid), int(r.credential.Gid))
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+
+package local
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func TestExecuteRunAsUnknownUser(t *testing.T) {
+	response := Execute(ExecuteRequest{
+		Command:        "id -u",
+		ExecuteTimeout: 5,
+		RunAsUser:      "bklite-no-such-user",
+	}, "test-run-as-unknown")
+
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("expected invalid_request, got %+v", response)
+	}
+}
+
+func TestExecuteRunAsOtherUserRequiresRoot(t *testing.T) {
+	original := currentEUID
+	currentEUID = func() int { return 12345 }
+	defer func() { currentEUID = original }()
+
+	response := Execute(ExecuteRequest{
+		Command:        "id -u",
+		ExecuteTimeout: 5,
+		RunAsUser:      "0",
+	}, "test-run-as-denied")
+
+	if response.Success || response.Code != utils.ErrorCodePermissionDenied {
+		t.Fatalf("expected permission_denied, got %+v", response)
+	}
+}
+
+func TestExecuteRunAsCurrentUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unavailable: %v", err)
+	}
+	response := Execute(ExecuteRequest{
+		Command:        "id -u; echo $USER",
+		ExecuteTimeout: 5,
+		RunAsUser:      current.Username,
+	}, "test-run-as-self")
+
+	if !response.Success {
+		t.Fatalf("expected success, got %+v", response)
+	}
+	lines := strings.Fields(response.Output)
+	if len(lines) != 2 || lines[0] != current.Uid || lines[1] != current.Username {
+		t.Fatalf("unexpected output %q", response.Output)
+	}
+}
+
+func TestExecuteRunAsNobodyWhenRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("nobody user unavailable: %v", err)
+	}
+	response := Execute(ExecuteRequest{
+		Command:        "id -u",
+		ExecuteTimeout: 5,
+		RunAsUser:      "nobody",
+		WorkDir:        os.TempDir(),
+	}, "test-run-as-nobody")
+
+	if !response.Success {
+		t.Fatalf("expected success, got %+v", response)
+	}
+	if uid, _ := strconv.Atoi(strings.TrimSpace(response.Output)); strconv.Itoa(uid) != nobody.Uid {
+		t.Fatalf("expected uid %s, got %q", nobody.Uid, response.Output)
+	}
+}
+
+func TestExecuteScriptObjectRunAsNobodyWhenRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("nobody user unavailable: %v", err)
+	}
+	stubScriptDownload(t, "#!/bin/sh\nid -u\n", nil)
+
+	response := executeScriptObject(ExecuteRequest{
+		ScriptObject:   &ScriptObject{BucketName: "scripts", FileKey: "whoami.sh"},
+		Shell:          "sh",
+		ExecuteTimeout: 5,
+		RunAsUser:      "nobody",
+		WorkDir:        os.TempDir(),
+	}, "test-script-object-run-as", nil)
+
+	if !response.Success || strings.TrimSpace(response.Output) != nobody.Uid {
+		t.Fatalf("expected script to run as nobody, got %+v", response)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package local
+
+import (
+	"os/exec"
+
+	"nats-executor/utils"
+)
+
+type runAsUser struct{}
+
+// resolveRunAsUser 在 Windows 上不支持切换用户，需要令牌与登录凭据，超出执行器的职责
+func resolveRunAsUser(name string) (*runAsUser, string, string) {
+	return nil, utils.ErrorCodeInvalidRequest, "run_as_user is not supported on Windows"
+}
+
+func (r *runAsUser) apply(cmd *exec.Cmd) {}
+
+func (r *runAsUser) chown(path string) error { return nil }
@@ -0,0 +1,133 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+)
+
+// localScriptConn 在 SubscribeLocalExecutor 时被设为本进程的 NATS 连接，用于下载 script_object
+var localScriptConn downloadConn
+
+var createScriptDir = func() (string, error) {
+	return os.MkdirTemp("", "nats-executor-script-")
+}
+
+func validateScriptObject(req ExecuteRequest) string {
+	switch {
+	case strings.TrimSpace(req.Command) != "" || len(req.Args) > 0:
+		return "script_object cannot be combined with command or args"
+	case strings.TrimSpace(req.ScriptObject.BucketName) == "":
+		return "script_object.bucket_name is required"
+	case strings.TrimSpace(req.ScriptObject.FileKey) == "":
+		return "script_object.file_key is required"
+	default:
+		return ""
+	}
+}
+
+// scriptFileName 按 shell 选择脚本扩展名，cmd 与 PowerShell 依赖扩展名识别脚本
+func scriptFileName(shell string) string {
+	switch shell {
+	case ShellTypeBat, ShellTypeCmd:
+		return "script.bat"
+	case ShellTypePowerShell, ShellTypePwsh:
+		return "script.ps1"
+	default:
+		if entry, ok := lookupInterpreter(shell); ok {
+			return "script" + entry.extension
+		}
+		return "script.sh"
+	}
+}
+
+// scriptInvocation 返回在对应 shell 中执行脚本文件的命令
+func scriptInvocation(shell, path string) string {
+	switch shell {
+	case ShellTypeBat, ShellTypeCmd:
+		return fmt.Sprintf(`call "%s"`, path)
+	case ShellTypePowerShell, ShellTypePwsh:
+		return fmt.Sprintf("& '%s'", path)
+	default:
+		return fmt.Sprintf("%s '%s'", shell, path)
+	}
+}
+
+// executeScriptObject 下载对象存储中的脚本到临时目录并执行，结束后删除临时目录
+func executeScriptObject(req ExecuteRequest, instanceId string, nc downloadConn) ExecuteResponse {
+	if validationErr := validateScriptObject(req); validationErr != "" {
+		return invalidExecuteResponse(instanceId, validationErr)
+	}
+	req.ExecuteTimeout = utils.ExecuteTimeout(req.ExecuteTimeout)
+	shell := normalizeShell(req.Shell)
+	if !isSupportedShell(shell) {
+		return invalidExecuteResponse(instanceId, fmt.Sprintf("unsupported shell: %s", strings.TrimSpace(req.Shell)))
+	}
+
+	// run_as_user 在下载前解析，临时目录与脚本需交给目标用户，否则其无法读取
+	var runAs *runAsUser
+	if strings.TrimSpace(req.RunAsUser) != "" {
+		var code, message string
+		if runAs, code, message = resolveRunAsUser(strings.TrimSpace(req.RunAsUser)); runAs == nil {
+			return runAsErrorResponse(instanceId, code, message)
+		}
+	}
+
+	dir, err := createScriptDir()
+	if err != nil {
+		message := fmt.Sprintf("Failed to create script directory: %v", err)
+		return ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: utils.ErrorCodeExecutionFailure, Error: message}
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.WithInstance(instanceId).Warnf("[Local Execute] Failed to clean up script directory %s: %v", dir, err)
+		}
+	}()
+
+	object := *req.ScriptObject
+	fileName := scriptFileName(shell)
+	logger.WithInstance(instanceId).Debugf("[Local Execute] Downloading script object %s/%s", object.BucketName, object.FileKey)
+	if err := downloadToLocalFile(utils.DownloadFileRequest{
+		BucketName:     object.BucketName,
+		FileKey:        object.FileKey,
+		FileName:       fileName,
+		TargetPath:     dir,
+		ExecuteTimeout: req.ExecuteTimeout,
+	}, nc); err != nil {
+		message := fmt.Sprintf("Failed to download script object %s/%s: %v", object.BucketName, object.FileKey, err)
+		return ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: objectStoreErrorCode(err), Error: message}
+	}
+
+	scriptPath := filepath.Join(dir, fileName)
+	if err := os.Chmod(scriptPath, 0o700); err != nil {
+		logger.WithInstance(instanceId).Warnf("[Local Execute] Failed to chmod script %s: %v", scriptPath, err)
+	}
+	if runAs != nil {
+		for _, path := range []string{dir, scriptPath} {
+			if err := runAs.chown(path); err != nil {
+				message := fmt.Sprintf("Failed to hand script %s to run_as_user %s: %v", path, req.RunAsUser, err)
+				return ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: utils.ErrorCodeExecutionFailure, Error: message}
+			}
+		}
+	}
+
+	req.ScriptObject = nil
+	if _, ok := lookupInterpreter(shell); ok {
+		// 解释器直接以脚本路径为参数执行，不经过 shell
+		req.Shell = ""
+		req.Args = []string{shell, scriptPath}
+		if req.LogCommand == "" {
+			req.LogCommand = fmt.Sprintf("script_object %s/%s", object.BucketName, object.FileKey)
+		}
+		return executeLocalCommand(req, instanceId)
+	}
+	req.Command = scriptInvocation(shell, scriptPath)
+	if req.LogCommand == "" {
+		req.LogCommand = fmt.Sprintf("script_object %s/%s", object.BucketName, object.FileKey)
+	}
+	return executeLocalCommand(req, instanceId)
+}
@@ -0,0 +1,159 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+	"nats-executor/utils/downloaderr"
+)
+
+func stubScriptDownload(t *testing.T, script string, downloadErr error) *utils.DownloadFileRequest {
+	t.Helper()
+	var captured utils.DownloadFileRequest
+	original := downloadToLocalFile
+	downloadToLocalFile = func(req utils.DownloadFileRequest, nc downloadConn) error {
+		captured = req
+		if downloadErr != nil {
+			return downloadErr
+		}
+		return os.WriteFile(filepath.Join(req.TargetPath, req.FileName), []byte(script), 0o600)
+	}
+	t.Cleanup(func() { downloadToLocalFile = original })
+	return &captured
+}
+
+func TestHandleLocalExecuteMessageRunsScriptObject(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	captured := stubScriptDownload(t, "#!/bin/sh\necho from-object \"$GREETING\"\n", nil)
+
+	payload := []byte(`{"args":[{"script_object":{"bucket_name":"scripts","file_key":"deploy/install.sh"},"env":{"GREETING":"hi"},"execute_timeout":5}],"kwargs":{}}`)
+	responseContent, ok := handleLocalExecuteMessage(payload, "instance-1")
+	if !ok {
+		t.Fatal("expected handler to return a response")
+	}
+	var response ExecuteResponse
+	if err := json.Unmarshal(responseContent, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if !response.Success || strings.TrimSpace(response.Output) != "from-object hi" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if captured.BucketName != "scripts" || captured.FileKey != "deploy/install.sh" || captured.FileName != "script.sh" {
+		t.Fatalf("unexpected download request: %+v", *captured)
+	}
+	if _, err := os.Stat(captured.TargetPath); !os.IsNotExist(err) {
+		t.Fatalf("expected script directory to be removed, stat err=%v", err)
+	}
+}
+
+func TestExecuteScriptObjectRejectsInvalidRequests(t *testing.T) {
+	stubScriptDownload(t, "", nil)
+	cases := map[string]ExecuteRequest{
+		"script_object cannot be combined with command or args": {Command: "uptime", ScriptObject: &ScriptObject{BucketName: "b", FileKey: "k"}, ExecuteTimeout: 5},
+		"script_object.bucket_name is required":                 {ScriptObject: &ScriptObject{FileKey: "k"}, ExecuteTimeout: 5},
+		"script_object.file_key is required":                    {ScriptObject: &ScriptObject{BucketName: "b"}, ExecuteTimeout: 5},
+		"unsupported shell: zsh":                                {ScriptObject: &ScriptObject{BucketName: "b", FileKey: "k"}, Shell: "zsh", ExecuteTimeout: 5},
+	}
+	for message, req := range cases {
+		response := executeScriptObject(req, "instance-1", nil)
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != message {
+			t.Fatalf("expected %q, got %+v", message, response)
+		}
+	}
+}
+
+func TestExecuteScriptObjectReportsDownloadFailure(t *testing.T) {
+	captured := stubScriptDownload(t, "", downloaderr.New(downloaderr.KindDependency, errors.New("object not found")))
+
+	response := executeScriptObject(ExecuteRequest{ScriptObject: &ScriptObject{BucketName: "b", FileKey: "missing.sh"}, ExecuteTimeout: 5}, "instance-1", nil)
+	if response.Success || response.Code != utils.ErrorCodeDependencyFailure || !strings.Contains(response.Error, "object not found") {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if _, err := os.Stat(captured.TargetPath); !os.IsNotExist(err) {
+		t.Fatalf("expected script directory to be removed, stat err=%v", err)
+	}
+}
+
+func TestScriptInvocationMatchesShell(t *testing.T) {
+	cases := map[string]string{
+		ShellTypeSh:         "sh '/tmp/s/script.sh'",
+		ShellTypeBash:       "bash '/tmp/s/script.sh'",
+		ShellTypeCmd:        `call "/tmp/s/script.sh"`,
+		ShellTypePowerShell: "& '/tmp/s/script.sh'",
+	}
+	for shell, want := range cases {
+		if got := scriptInvocation(shell, "/tmp/s/script.sh"); got != want {
+			t.Fatalf("shell %s: expected %q, got %q", shell, want, got)
+		}
+	}
+}
+
+func TestHandleObjectStoreRunMessageRunsScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	captured := stubScriptDownload(t, "#!/bin/sh\necho ran \"$GREETING\"\n", nil)
+
+	payload := []byte(`{"args":[{"bucket_name":"scripts","file_key":"deploy/install.sh","env":{"GREETING":"hi"},"execute_timeout":5}],"kwargs":{}}`)
+	responseContent, ok := handleObjectStoreRunMessage(payload, "instance-1")
+	if !ok {
+		t.Fatal("expected handler to return a response")
+	}
+	var response ExecuteResponse
+	if err := json.Unmarshal(responseContent, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if !response.Success || strings.TrimSpace(response.Output) != "ran hi" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if captured.BucketName != "scripts" || captured.FileKey != "deploy/install.sh" {
+		t.Fatalf("unexpected download request: %+v", *captured)
+	}
+	if _, err := os.Stat(captured.TargetPath); !os.IsNotExist(err) {
+		t.Fatalf("expected script directory to be removed, stat err=%v", err)
+	}
+}
+
+func TestHandleObjectStoreRunMessageRejectsInvalidRequests(t *testing.T) {
+	stubScriptDownload(t, "", nil)
+	cases := map[string]string{
+		`{"file_key":"k"}`:    "bucket_name is required",
+		`{"bucket_name":"b"}`: "file_key is required",
+		`{"bucket_name":"b","file_key":"k","command":"uptime"}`: "run.objectstore does not accept command, args or script_object",
+	}
+	for args, message := range cases {
+		responseContent, _ := handleObjectStoreRunMessage([]byte(`{"args":[`+args+`]}`), "instance-1")
+		var response ExecuteResponse
+		if err := json.Unmarshal(responseContent, &response); err != nil {
+			t.Fatalf("unmarshal response: %v", err)
+		}
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != message {
+			t.Fatalf("expected %q, got %+v", message, response)
+		}
+	}
+}
+
+func TestHandleObjectStoreRunMessageSkipsExecutionOnDownloadFailure(t *testing.T) {
+	captured := stubScriptDownload(t, "", downloaderr.New(downloaderr.KindDependency, errors.New("object not found")))
+
+	payload := []byte(`{"args":[{"bucket_name":"b","file_key":"missing.sh","execute_timeout":5}]}`)
+	responseContent, _ := handleObjectStoreRunMessage(payload, "instance-1")
+	var response ExecuteResponse
+	if err := json.Unmarshal(responseContent, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if response.Success || response.Code != utils.ErrorCodeDependencyFailure || !strings.Contains(response.Error, "object not found") {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if _, err := os.Stat(captured.TargetPath); !os.IsNotExist(err) {
+		t.Fatalf("expected script directory to be removed, stat err=%v", err)
+	}
+}
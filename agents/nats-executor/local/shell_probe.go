@@ -0,0 +1,58 @@
+package local
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"nats-executor/logger"
+)
+
+var lookPath = exec.LookPath
+
+// shellBinary 返回执行该类型脚本时实际调用的解释器
+func shellBinary(shell string) string {
+	switch shell {
+	case ShellTypeBat, ShellTypeCmd:
+		return "cmd"
+	default:
+		return shell
+	}
+}
+
+// platformShells 为当前系统上应当可用的 shell，其余 shell 缺失属于预期，不告警
+func platformShells(goos string) []string {
+	if goos == "windows" {
+		return []string{ShellTypeCmd, ShellTypePowerShell, ShellTypePwsh}
+	}
+	return []string{ShellTypeSh, ShellTypeBash, ShellTypePwsh}
+}
+
+// ProbeShells 检查各 shell 的解释器是否在 PATH 中，返回缺失的 shell
+func ProbeShells(shells []string) []string {
+	var missing []string
+	for _, shell := range shells {
+		if _, err := lookPath(shellBinary(shell)); err != nil {
+			missing = append(missing, shell)
+		}
+	}
+	return missing
+}
+
+// CheckShells 启动时探测本机可用的 shell 并对缺失项告警；required 中的 shell 缺失时返回错误
+func CheckShells(required []string) error {
+	for _, shell := range required {
+		if !isSupportedShell(shell) {
+			return fmt.Errorf("unsupported required shell: %s", shell)
+		}
+	}
+
+	for _, shell := range ProbeShells(platformShells(runtime.GOOS)) {
+		logger.Warnf("[Shell Probe] %s interpreter %q not found in PATH, requests with shell=%s will fail", shell, shellBinary(shell), shell)
+	}
+	if missing := ProbeShells(required); len(missing) > 0 {
+		return fmt.Errorf("required shells not available: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package local
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func withLookPath(t *testing.T, available ...string) *[]string {
+	t.Helper()
+	var looked []string
+	original := lookPath
+	lookPath = func(file string) (string, error) {
+		looked = append(looked, file)
+		for _, name := range available {
+			if name == file {
+				return "/usr/bin/" + file, nil
+			}
+		}
+		return "", errors.New("executable file not found in $PATH")
+	}
+	t.Cleanup(func() { lookPath = original })
+	return &looked
+}
+
+func TestProbeShellsReportsMissingInterpreters(t *testing.T) {
+	looked := withLookPath(t, "sh", "cmd")
+
+	missing := ProbeShells([]string{ShellTypeSh, ShellTypeBash, ShellTypeBat, ShellTypePwsh})
+	if !reflect.DeepEqual(missing, []string{ShellTypeBash, ShellTypePwsh}) {
+		t.Fatalf("unexpected missing shells: %v", missing)
+	}
+	if !reflect.DeepEqual(*looked, []string{"sh", "bash", "cmd", "pwsh"}) {
+		t.Fatalf("unexpected lookups: %v", *looked)
+	}
+}
+
+func TestCheckShells(t *testing.T) {
+	t.Run("missing optional shells only warn", func(t *testing.T) {
+		withLookPath(t)
+		if err := CheckShells(nil); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("missing required shell fails", func(t *testing.T) {
+		withLookPath(t, "sh", "bash")
+		err := CheckShells([]string{ShellTypeBash, ShellTypePwsh})
+		if err == nil || !strings.Contains(err.Error(), "required shells not available: pwsh") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("available required shells pass", func(t *testing.T) {
+		withLookPath(t, "bash", "pwsh")
+		if err := CheckShells([]string{ShellTypeBash, ShellTypePwsh}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("unsupported required shell fails", func(t *testing.T) {
+		withLookPath(t, "zsh")
+		if err := CheckShells([]string{"zsh"}); err == nil || !strings.Contains(err.Error(), "unsupported required shell: zsh") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPlatformShells(t *testing.T) {
+	if shells := platformShells("windows"); !reflect.DeepEqual(shells, []string{ShellTypeCmd, ShellTypePowerShell, ShellTypePwsh}) {
+		t.Fatalf("unexpected windows shells: %v", shells)
+	}
+	if shells := platformShells("linux"); !reflect.DeepEqual(shells, []string{ShellTypeSh, ShellTypeBash, ShellTypePwsh}) {
+		t.Fatalf("unexpected linux shells: %v", shells)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -103,6 +106,40 @@ func TestExecuteInjectsEnvironmentVariables(t *testing.T) {
 	}
 }
 
+func TestExecuteEnvOverridesInheritedVariables(t *testing.T) {
+	t.Setenv("BK_LITE_ENV_OVERRIDE", "inherited")
+	response := Execute(ExecuteRequest{
+		Command:        "printf '%s %s' \"$BK_LITE_ENV_OVERRIDE\" \"$HOME\"",
+		ExecuteTimeout: 5,
+		Shell:          "sh",
+		Env:            map[string]string{"BK_LITE_ENV_OVERRIDE": "from-request"},
+	}, "instance-env")
+
+	if !response.Success {
+		t.Fatalf("expected success response: %+v", response)
+	}
+	if want := "from-request " + os.Getenv("HOME"); strings.TrimSpace(response.Output) != want {
+		t.Fatalf("expected request env to override inherited env and keep the rest, got output=%q", response.Output)
+	}
+}
+
+func TestExecuteInjectsEnvironmentVariablesOnWindows(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("Skipping cmd env test on non-Windows")
+	}
+	for shell, command := range map[string]string{"cmd": "echo %ORACLE_HOME%", "powershell": "Write-Output $env:ORACLE_HOME"} {
+		response := Execute(ExecuteRequest{
+			Command:        command,
+			ExecuteTimeout: 10,
+			Shell:          shell,
+			Env:            map[string]string{"ORACLE_HOME": `C:\oracle`},
+		}, "instance-env")
+		if !response.Success || strings.TrimSpace(response.Output) != `C:\oracle` {
+			t.Fatalf("%s: expected command to receive env, got %+v", shell, response)
+		}
+	}
+}
+
 func TestHandleLocalExecuteMessageRejectsMalformedJSON(t *testing.T) {
 	response, ok := handleLocalExecuteMessage([]byte("not-json"), "instance-1")
 	if !ok {
@@ -171,6 +208,257 @@ func TestHandleLocalExecuteMessageReturnsExecutionResponse(t *testing.T) {
 	}
 }
 
+func TestHandleLocalExecuteMessageFiltersOutputLines(t *testing.T) {
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: "INFO start\nERROR disk full\nINFO done\n", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	decode := func(payload string) ExecuteResponse {
+		t.Helper()
+		response, _ := handleLocalExecuteMessage([]byte(payload), "instance-1")
+		var result ExecuteResponse
+		if err := json.Unmarshal(response, &result); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return result
+	}
+
+	matched := decode(`{"args":[{"command":"deploy","execute_timeout":5,"output_grep":"^ERROR","include_full_output":true}],"kwargs":{}}`)
+	if matched.Output != "ERROR disk full\n" || matched.FullOutput != "INFO start\nERROR disk full\nINFO done\n" {
+		t.Fatalf("unexpected matched response: %+v", matched)
+	}
+	inverted := decode(`{"args":[{"command":"deploy","execute_timeout":5,"output_grep":"^ERROR","output_grep_invert":true}],"kwargs":{}}`)
+	if inverted.Output != "INFO start\nINFO done\n" || inverted.FullOutput != "" {
+		t.Fatalf("unexpected inverted response: %+v", inverted)
+	}
+	invalid := decode(`{"args":[{"command":"deploy","execute_timeout":5,"output_grep":"(["}],"kwargs":{}}`)
+	if invalid.Success || invalid.Code != utils.ErrorCodeInvalidRequest || !strings.Contains(invalid.Error, "invalid output_grep") {
+		t.Fatalf("unexpected invalid pattern response: %+v", invalid)
+	}
+}
+
+func TestHandleLocalExecuteMessageRejectsInstanceMismatch(t *testing.T) {
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		t.Fatal("command must not run on instance mismatch")
+		return ExecuteResponse{}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	response, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"uptime","execute_timeout":5,"instance_id":"node-b"}],"kwargs":{}}`), "node-a")
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Success || result.Code != utils.ErrorCodeInstanceMismatch || result.InstanceId != "node-a" {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
+func TestHandleLocalExecuteMessageRunsCleanupCommand(t *testing.T) {
+	var commands []ExecuteRequest
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		commands = append(commands, req)
+		if req.Command == "rm -rf /tmp/job" {
+			return ExecuteResponse{Output: "cleaned", InstanceId: instanceId, Success: true}
+		}
+		return ExecuteResponse{Output: "timed out", InstanceId: instanceId, Success: false, Code: utils.ErrorCodeTimeout, Error: "timed out"}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	response, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"deploy","execute_timeout":5,"shell":"bash","env":{"A":"1"},"cleanup_command":"rm -rf /tmp/job"}],"kwargs":{}}`), "instance-1")
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Success || result.Code != utils.ErrorCodeTimeout {
+		t.Fatalf("cleanup must not change the main result: %+v", result)
+	}
+	if result.Cleanup == nil || !result.Cleanup.Success || result.Cleanup.Output != "cleaned" {
+		t.Fatalf("unexpected cleanup result: %+v", result.Cleanup)
+	}
+	if len(commands) != 2 {
+		t.Fatalf("expected main and cleanup commands, got %+v", commands)
+	}
+	cleanup := commands[1]
+	if cleanup.ExecuteTimeout != utils.DefaultCleanupTimeoutSeconds || cleanup.Shell != "bash" || cleanup.Env["A"] != "1" {
+		t.Fatalf("unexpected cleanup request: %+v", cleanup)
+	}
+
+	commands = nil
+	response, _ = handleLocalExecuteMessage([]byte(`{"args":[{"execute_timeout":5,"script_object":{"bucket_name":"b"},"cleanup_command":"rm -rf /tmp/job"}],"kwargs":{}}`), "instance-1")
+	var invalid ExecuteResponse
+	if err := json.Unmarshal(response, &invalid); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if invalid.Code != utils.ErrorCodeInvalidRequest || invalid.Cleanup != nil || len(commands) != 0 {
+		t.Fatalf("cleanup must not run for invalid requests: %+v commands=%+v", invalid, commands)
+	}
+}
+
+func TestHandleLocalExecuteMessageDropsFullOutputWhenOversized(t *testing.T) {
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{MaxBytes: 512})
+	defer utils.SetResponseSizeConfig(utils.ResponseSizeConfig{})
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: strings.Repeat("noise\n", 500) + "ERROR failed\n", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	response, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"deploy","execute_timeout":5,"output_grep":"ERROR","include_full_output":true}],"kwargs":{}}`), "instance-1")
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Output != "ERROR failed\n" || result.FullOutput != "" || result.Truncated {
+		t.Fatalf("expected full output to be dropped without truncating filtered output: %+v", result)
+	}
+}
+
+func TestHandleLocalExecuteMessageCompressesOutputAboveThreshold(t *testing.T) {
+	const threshold = 1024
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{CompressThreshold: threshold})
+	defer utils.SetResponseSizeConfig(utils.ResponseSizeConfig{})
+
+	var output string
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: output, InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	cases := []struct {
+		name       string
+		size       int
+		compressed bool
+	}{
+		{name: "below threshold", size: threshold - 1},
+		{name: "at threshold", size: threshold},
+		{name: "above threshold", size: threshold + 1, compressed: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			output = strings.Repeat("a", tc.size)
+			response, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"cat big.log","execute_timeout":5}],"kwargs":{}}`), "instance-1")
+			var result ExecuteResponse
+			if err := json.Unmarshal(response, &result); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if !tc.compressed {
+				if result.OutputEncoding != "" || result.Output != output {
+					t.Fatalf("expected plain output, got encoding=%q len=%d", result.OutputEncoding, len(result.Output))
+				}
+				return
+			}
+			if result.OutputEncoding != utils.OutputEncodingGzipBase64 || len(result.Output) >= tc.size {
+				t.Fatalf("expected compressed output, got encoding=%q len=%d", result.OutputEncoding, len(result.Output))
+			}
+			decoded, err := utils.DecodeOutput(result.Output, result.OutputEncoding)
+			if err != nil || decoded != output {
+				t.Fatalf("decoded output mismatch: err=%v", err)
+			}
+		})
+	}
+}
+
+func TestHandleLocalExecuteMessageCapsResponseSize(t *testing.T) {
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{MaxBytes: 512})
+	defer utils.SetResponseSizeConfig(utils.ResponseSizeConfig{})
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: strings.Repeat("line\n", 1000), InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	response, _ := handleLocalExecuteMessage([]byte(`{"args":[{"command":"cat big.log","execute_timeout":5,"job_id":"job-big"}],"kwargs":{}}`), "instance-1")
+	if len(response) > 512 {
+		t.Fatalf("expected response within 512 bytes, got %d", len(response))
+	}
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !result.Success || !result.Truncated || !strings.HasPrefix(result.Output, "line\n") {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+	if cached, ok := localResultCache.Get("job-big"); !ok || string(cached) != string(response) {
+		t.Fatal("expected cached result to match the capped response")
+	}
+}
+
+func TestHandleLocalExecuteMessageRespondsWhenHandlerDeadlineExceeded(t *testing.T) {
+	returned := make(chan struct{})
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		// 处理器截止时取消 ctx，替身随之返回
+		<-req.requestContext().Done()
+		return ExecuteResponse{Output: "late", InstanceId: instanceId, Success: true}
+	}
+	originalDeadline := handlerDeadline
+	handlerDeadline = func(int) time.Duration { return 20 * time.Millisecond }
+	originalReturned := handlerReturned
+	handlerReturned = func() { close(returned) }
+	defer func() {
+		executeLocalCommand = original
+		handlerDeadline = originalDeadline
+		handlerReturned = originalReturned
+	}()
+
+	start := time.Now()
+	response, ok := handleLocalExecuteMessage([]byte(`{"args":[{"command":"sleep 60","execute_timeout":5}],"kwargs":{}}`), "instance-1")
+	if !ok {
+		t.Fatal("expected execution payload to produce response")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected handler to respond at its deadline, took %s", elapsed)
+	}
+	if running := utils.DefaultLimits.Snapshot().RunningJobs; running != 0 {
+		t.Fatalf("expected the concurrency slot to be released at the deadline, %d still running", running)
+	}
+	if jobs := utils.DefaultJobs.List(""); len(jobs) != 0 {
+		t.Fatalf("expected the job entry to be removed at the deadline, got %+v", jobs)
+	}
+	// 等后台处理函数返回后再恢复包级变量，避免与后续测试竞争
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler to stop once its deadline passed")
+	}
+
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.Success || result.Code != utils.ErrorCodeTimeout || !strings.Contains(result.Error, "Handler deadline exceeded") {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
+func TestHandleLocalExecuteMessageKillsCommandAtHandlerDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	returned := make(chan struct{})
+	originalDeadline := handlerDeadline
+	handlerDeadline = func(int) time.Duration { return 100 * time.Millisecond }
+	originalReturned := handlerReturned
+	handlerReturned = func() { close(returned) }
+	defer func() {
+		handlerDeadline = originalDeadline
+		handlerReturned = originalReturned
+	}()
+
+	handleLocalExecuteMessage([]byte(`{"args":[{"command":"sleep 60","execute_timeout":60,"kill_grace_period":1}],"kwargs":{}}`), "instance-1")
+	select {
+	case <-returned:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the command to be killed at the handler deadline")
+	}
+}
+
 func TestHandleLocalExecuteMessagePassesEnvironmentVariables(t *testing.T) {
 	original := executeLocalCommand
 	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
@@ -269,6 +557,157 @@ func TestRespondLocalExecuteMessageReturnsFalseWhenRespondFails(t *testing.T) {
 	}
 }
 
+type stubReplyMsg struct {
+	stubResponseMsg
+	reply string
+}
+
+func (s stubReplyMsg) ReplySubject() string { return s.reply }
+
+func withLocalResultCache(t *testing.T) {
+	t.Helper()
+	original := localResultCache
+	localResultCache = utils.NewResultCache(time.Minute, 8)
+	t.Cleanup(func() { localResultCache = original })
+}
+
+func TestRespondLocalExecuteMessageCachesJobResultForRedelivery(t *testing.T) {
+	withLocalResultCache(t)
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: "hello", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	payload := []byte(`{"args":[{"command":"echo hello","execute_timeout":5,"job_id":"job-1"}],"kwargs":{}}`)
+	msg := stubReplyMsg{
+		stubResponseMsg: stubResponseMsg{respond: func(response []byte) error { return nats.ErrConnectionClosed }},
+		reply:           "_INBOX.job-1",
+	}
+
+	if ok := respondLocalExecuteMessage(msg, payload, "instance-1"); ok {
+		t.Fatal("expected respond failure to return false")
+	}
+
+	publisher := &stubStreamPublisher{}
+	if delivered := RedeliverPendingResults(publisher); delivered != 1 {
+		t.Fatalf("expected one redelivered result, got %d", delivered)
+	}
+	if len(publisher.events) != 1 || publisher.events[0].topic != "_INBOX.job-1" {
+		t.Fatalf("unexpected redelivery events: %+v", publisher.events)
+	}
+	var got ExecuteResponse
+	if err := json.Unmarshal(publisher.events[0].payload, &got); err != nil || got.Output != "hello" {
+		t.Fatalf("unexpected redelivered payload: %+v err=%v", got, err)
+	}
+	if delivered := RedeliverPendingResults(publisher); delivered != 0 {
+		t.Fatalf("expected delivered result not to be resent, got %d", delivered)
+	}
+}
+
+func TestRedeliverPendingResultsKeepsResultWhenPublishFails(t *testing.T) {
+	withLocalResultCache(t)
+	localResultCache.Store("job-1", []byte(`{"success":true}`))
+	localResultCache.MarkPending("job-1", "_INBOX.job-1")
+
+	if delivered := RedeliverPendingResults(&stubStreamPublisher{err: errors.New("still offline")}); delivered != 0 {
+		t.Fatalf("expected no delivery while offline, got %d", delivered)
+	}
+	if pending := localResultCache.Pending(); len(pending) != 1 {
+		t.Fatalf("expected result to stay pending, got %+v", pending)
+	}
+	if delivered := RedeliverPendingResults(nil); delivered != 0 {
+		t.Fatalf("expected nil publisher to be ignored, got %d", delivered)
+	}
+}
+
+func TestRespondResultFetchSubscriptionServesCachedResult(t *testing.T) {
+	withLocalResultCache(t)
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: "cached", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	if _, ok := handleLocalExecuteMessage([]byte(`{"args":[{"command":"echo cached","execute_timeout":5,"job_id":"job-9"}],"kwargs":{}}`), "instance-1"); !ok {
+		t.Fatal("expected execute message to be handled")
+	}
+
+	var got ExecuteResponse
+	msg := stubResponseMsg{respond: func(response []byte) error { return json.Unmarshal(response, &got) }}
+	if ok := respondResultFetchSubscription(msg, "result.fetch.job-9", "instance-1"); !ok {
+		t.Fatal("expected cached result to be served")
+	}
+	if !got.Success || got.Output != "cached" {
+		t.Fatalf("unexpected fetched result: %+v", got)
+	}
+
+	responded := false
+	silent := stubResponseMsg{respond: func(response []byte) error { responded = true; return nil }}
+	if ok := respondResultFetchSubscription(silent, "result.fetch.unknown", "instance-1"); ok || responded {
+		t.Fatal("expected unknown job to stay silent")
+	}
+	if ok := respondResultFetchSubscription(silent, "result.fetch.", "instance-1"); ok || responded {
+		t.Fatal("expected empty job id to stay silent")
+	}
+}
+
+func TestRespondLocalExecuteMessagePersistsResultWhenConnectionClosed(t *testing.T) {
+	withLocalResultCache(t)
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: "done", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	stored := map[string][]byte{}
+	utils.SetResultStoreConfig(utils.ResultStoreConfig{
+		Bucket: "results",
+		Save:   func(bucket, key string, data []byte) error { stored[bucket+"/"+key] = data; return nil },
+		Load: func(bucket, key string) ([]byte, error) {
+			if data, ok := stored[bucket+"/"+key]; ok {
+				return data, nil
+			}
+			return nil, errors.New("object not found")
+		},
+	})
+	defer utils.SetResultStoreConfig(utils.ResultStoreConfig{})
+
+	payload := []byte(`{"args":[{"command":"echo done","execute_timeout":5,"job_id":"job-7"}],"kwargs":{}}`)
+	closed := stubResponseMsg{respond: func(response []byte) error { return nats.ErrConnectionClosed }}
+	if ok := respondLocalExecuteMessage(closed, payload, "instance-1"); ok {
+		t.Fatal("expected respond failure to return false")
+	}
+	if _, ok := stored["results/results/instance-1/job-7"]; !ok {
+		t.Fatalf("expected result to be persisted, got keys %v", stored)
+	}
+
+	// 模拟进程重启后内存缓存为空，仍可从对象存储补取
+	withLocalResultCache(t)
+	var got ExecuteResponse
+	msg := stubResponseMsg{respond: func(response []byte) error { return json.Unmarshal(response, &got) }}
+	if ok := respondResultFetchSubscription(msg, "result.fetch.job-7", "instance-1"); !ok || got.Output != "done" {
+		t.Fatalf("expected persisted result to be served, ok=%v got=%+v", ok, got)
+	}
+}
+
+func TestHandleLocalExecuteMessageSkipsCacheWithoutJobID(t *testing.T) {
+	withLocalResultCache(t)
+	original := executeLocalCommand
+	executeLocalCommand = func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{Output: "hello", InstanceId: instanceId, Success: true}
+	}
+	defer func() { executeLocalCommand = original }()
+
+	handleLocalExecuteMessage([]byte(`{"args":[{"command":"echo hello","execute_timeout":5}],"kwargs":{}}`), "instance-1")
+	if _, ok := localResultCache.Get(""); ok {
+		t.Fatal("expected result without job id not to be cached")
+	}
+	if pending := localResultCache.Pending(); len(pending) != 0 {
+		t.Fatalf("expected no pending results, got %+v", pending)
+	}
+}
+
 func TestHandleDownloadToLocalMessageReturnsDownloadError(t *testing.T) {
 	original := downloadToLocalFile
 	downloadToLocalFile = func(req utils.DownloadFileRequest, _ downloadConn) error {
@@ -396,11 +835,11 @@ func TestHandleDownloadToLocalMessageRejectsInvalidArgPayload(t *testing.T) {
 
 func TestHandleUnzipToLocalMessageReturnsParentDir(t *testing.T) {
 	original := unzipLocalArchive
-	unzipLocalArchive = func(req utils.UnzipRequest) (string, error) {
+	unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
 		if req.ZipPath != "/tmp/demo.zip" || req.DestDir != "/tmp/out" {
 			t.Fatalf("unexpected unzip request: %+v", req)
 		}
-		return "parent-dir", nil
+		return utils.UnzipResult{ParentDir: "parent-dir"}, nil
 	}
 	defer func() { unzipLocalArchive = original }()
 
@@ -425,10 +864,32 @@ func TestHandleUnzipToLocalMessageReturnsParentDir(t *testing.T) {
 	}
 }
 
+func TestHandleUnzipToLocalMessageReportsFailedEntries(t *testing.T) {
+	original := unzipLocalArchive
+	unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
+		if !req.ContinueOnError {
+			t.Fatalf("expected continue_on_error to be passed through: %+v", req)
+		}
+		return utils.UnzipResult{ParentDir: "parent-dir", Failures: []utils.UnzipEntryError{{Name: "../evil.txt", Error: "illegal file path: ../evil.txt"}}}, nil
+	}
+	defer func() { unzipLocalArchive = original }()
+
+	payload := []byte(`{"args":[{"zip_path":"/tmp/demo.zip","dest_dir":"/tmp/out","continue_on_error":true}],"kwargs":{}}`)
+	response, _ := handleUnzipToLocalMessage(payload, "instance-1")
+
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if !result.Success || result.Output != "parent-dir" || len(result.UnzipFailures) != 1 || result.UnzipFailures[0].Name != "../evil.txt" {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
 func TestHandleUnzipToLocalMessageReturnsErrorResponse(t *testing.T) {
 	original := unzipLocalArchive
-	unzipLocalArchive = func(req utils.UnzipRequest) (string, error) {
-		return "", errors.New("bad zip")
+	unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
+		return utils.UnzipResult{}, errors.New("bad zip")
 	}
 	defer func() { unzipLocalArchive = original }()
 
@@ -469,6 +930,25 @@ func TestHandleHealthCheckMessageReturnsStablePayload(t *testing.T) {
 	if !result.Success || result.Status != "ok" || result.InstanceId != "instance-1" || result.Timestamp != "2026-03-23T12:00:00Z" {
 		t.Fatalf("unexpected health response: %+v", result)
 	}
+	if result.Version != utils.Version || result.Goroutines <= 0 || result.MemoryBytes == 0 {
+		t.Fatalf("expected version and runtime metrics, got %+v", result)
+	}
+}
+
+func TestHandleHealthCheckMessageReportsActiveJobs(t *testing.T) {
+	done := utils.DefaultJobs.Track(utils.JobInfo{ID: "health-job", Operation: "local.execute"})
+	defer done()
+	originalNow := usageNow
+	usageNow = func() time.Time { return processStartTime.Add(90 * time.Second) }
+	defer func() { usageNow = originalNow }()
+
+	var result HealthCheckResponse
+	if err := json.Unmarshal(handleHealthCheckMessage("instance-1"), &result); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if result.ActiveJobs < 1 || result.UptimeSeconds != 90 {
+		t.Fatalf("unexpected health metrics: %+v", result)
+	}
 }
 
 func TestLocalSubscriptionSeams(t *testing.T) {
@@ -484,6 +964,7 @@ func TestLocalSubscriptionSeams(t *testing.T) {
 			}},
 			{name: "unzip", subject: "unzip.local.instance-1", subFn: func(sub *stubSubscriber) error { return subscribeUnzipToLocal(sub, stringPointer("instance-1")) }},
 			{name: "health", subject: "health.check.instance-1", subFn: func(sub *stubSubscriber) error { return subscribeHealthCheck(sub, stringPointer("instance-1")) }},
+			{name: "result fetch", subject: "result.fetch.*", subFn: func(sub *stubSubscriber) error { return subscribeResultFetch(sub, stringPointer("instance-1")) }},
 		}
 
 		for _, tt := range testCases {
@@ -508,7 +989,9 @@ func TestLocalSubscriptionSeams(t *testing.T) {
 			return ExecuteResponse{Success: true, Output: "ok", InstanceId: instanceId}
 		}
 		downloadToLocalFile = func(req utils.DownloadFileRequest, _ downloadConn) error { return nil }
-		unzipLocalArchive = func(req utils.UnzipRequest) (string, error) { return "parent", nil }
+		unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
+			return utils.UnzipResult{ParentDir: "parent"}, nil
+		}
 		nowUTC = func() time.Time { return time.Date(2026, 5, 9, 8, 0, 0, 0, time.UTC) }
 		defer func() {
 			executeLocalCommand = origExec
@@ -609,7 +1092,9 @@ func TestLocalSubscriptionSeams(t *testing.T) {
 
 	t.Run("unzip wrapper writes response", func(t *testing.T) {
 		original := unzipLocalArchive
-		unzipLocalArchive = func(req utils.UnzipRequest) (string, error) { return "parent-dir", nil }
+		unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
+			return utils.UnzipResult{ParentDir: "parent-dir"}, nil
+		}
 		defer func() { unzipLocalArchive = original }()
 
 		var got ExecuteResponse
@@ -642,7 +1127,9 @@ func TestLocalSubscriptionSeams(t *testing.T) {
 
 	t.Run("unzip wrapper reports respond failure", func(t *testing.T) {
 		original := unzipLocalArchive
-		unzipLocalArchive = func(req utils.UnzipRequest) (string, error) { return "parent-dir", nil }
+		unzipLocalArchive = func(req utils.UnzipRequest) (utils.UnzipResult, error) {
+			return utils.UnzipResult{ParentDir: "parent-dir"}, nil
+		}
 		defer func() { unzipLocalArchive = original }()
 
 		msg := stubInboundMsg{
@@ -691,3 +1178,45 @@ func TestLocalSubscriptionSeams(t *testing.T) {
 }
 
 func stringPointer(value string) *string { return &value }
+
+func TestHandleDownloadToLocalMessageRejectsTargetOutsideAllowedBaseDir(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv("DOWNLOAD_ALLOWED_BASE_DIR", base)
+	var downloaded []string
+	original := downloadToLocalFile
+	downloadToLocalFile = func(req utils.DownloadFileRequest, _ downloadConn) error {
+		downloaded = append(downloaded, req.TargetPath)
+		return nil
+	}
+	defer func() { downloadToLocalFile = original }()
+
+	request := func(targetPath string) ExecuteResponse {
+		t.Helper()
+		payload, _ := json.Marshal(map[string]any{"args": []map[string]any{{
+			"bucket_name": "bucket", "file_key": "file-key", "file_name": "demo.txt", "target_path": targetPath, "execute_timeout": 3,
+		}}})
+		response, ok := handleDownloadToLocalMessage(payload, "instance-1", nil)
+		if !ok {
+			t.Fatal("expected download handler to return response")
+		}
+		var result ExecuteResponse
+		if err := json.Unmarshal(response, &result); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return result
+	}
+
+	inBounds := filepath.Join(base, "releases")
+	if result := request(inBounds); !result.Success {
+		t.Fatalf("expected in-bounds download to succeed, got %+v", result)
+	}
+	for _, target := range []string{filepath.Join(base, "..", "etc"), "/etc", "relative/dir"} {
+		result := request(target)
+		if result.Success || result.Code != utils.ErrorCodePathForbidden {
+			t.Fatalf("target %q: expected path_forbidden, got %+v", target, result)
+		}
+	}
+	if len(downloaded) != 1 || downloaded[0] != inBounds {
+		t.Fatalf("expected only the in-bounds download to run, got %v", downloaded)
+	}
+}
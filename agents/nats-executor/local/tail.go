@@ -0,0 +1,89 @@
+package local
+
+import (
+	"encoding/json"
+	"strings"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var subscribeTailFn = subscribeTail
+
+// handleTailMessage 返回执行中任务最近的输出行；请求体可为空，或携带 {"args":[{"lines":100}]}。
+// 任务不在本实例执行时保持静默，由执行该任务的实例应答。
+func handleTailMessage(subject string, data []byte, instanceId string) ([]byte, bool) {
+	jobID := strings.TrimPrefix(subject, "tail.")
+	if jobID == "" || jobID == subject {
+		return nil, false
+	}
+	if _, running := utils.DefaultTails.Last(jobID, 1); !running {
+		return nil, false
+	}
+
+	var tailRequest TailRequest
+	if len(strings.TrimSpace(string(data))) > 0 {
+		var incoming incomingMessage
+		if err := json.Unmarshal(data, &incoming); err != nil {
+			return tailErrorResponse(instanceId, jobID, "invalid request payload")
+		}
+		if len(incoming.Args) > 0 {
+			if err := json.Unmarshal(incoming.Args[0], &tailRequest); err != nil {
+				return tailErrorResponse(instanceId, jobID, "invalid request payload")
+			}
+		}
+	}
+	if tailRequest.Lines < 0 {
+		return tailErrorResponse(instanceId, jobID, "lines must not be negative")
+	}
+
+	lines, running := utils.DefaultTails.Last(jobID, tailRequest.Lines)
+	if !running {
+		return nil, false
+	}
+	responseContent, _ := json.Marshal(TailResponse{InstanceId: instanceId, Success: true, JobID: jobID, Lines: lines})
+	return responseContent, true
+}
+
+func tailErrorResponse(instanceId, jobID, message string) ([]byte, bool) {
+	responseContent, _ := json.Marshal(TailResponse{
+		InstanceId: instanceId,
+		Success:    false,
+		JobID:      jobID,
+		Lines:      []string{},
+		Code:       utils.ErrorCodeInvalidRequest,
+		Error:      message,
+	})
+	return responseContent, true
+}
+
+func respondTailSubscription(msg inboundMsg, subject, instanceId string) bool {
+	responseContent, ok := handleTailMessage(subject, msg.Payload(), instanceId)
+	if !ok {
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Tail Subscribe] Error responding to tail request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeTail(sub subscriber, instanceId *string) error {
+	// job id 全局唯一，各实例订阅通配主题，仅执行该任务的实例应答
+	subject := "tail.*"
+	logger.WithInstance(*instanceId).Infof("[Tail Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondTailSubscription(natsInboundMsg{msg}, msg.Subject, *instanceId)
+	})
+	return err
+}
+
+func SubscribeTail(nc *nats.Conn, instanceId *string) {
+	if err := subscribeTailFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Tail Subscribe] Failed to subscribe: %v", err)
+	}
+}
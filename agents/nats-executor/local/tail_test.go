@@ -0,0 +1,90 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+)
+
+func decodeTail(t *testing.T, payload []byte) TailResponse {
+	t.Helper()
+	var response TailResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("unmarshal tail response: %v", err)
+	}
+	return response
+}
+
+func TestHandleTailMessageReturnsRecentLines(t *testing.T) {
+	tail, closeTail := utils.DefaultTails.Open("job-tail")
+	defer closeTail()
+	_, _ = io.WriteString(tail.Writer(), "step 1\nstep 2\nstep 3\n")
+
+	payload, ok := handleTailMessage("tail.job-tail", []byte(`{"args":[{"lines":2}],"kwargs":{}}`), "instance-1")
+	if !ok {
+		t.Fatal("expected running job to be answered")
+	}
+	response := decodeTail(t, payload)
+	if !response.Success || response.JobID != "job-tail" || !reflect.DeepEqual(response.Lines, []string{"step 2", "step 3"}) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	payload, _ = handleTailMessage("tail.job-tail", nil, "instance-1")
+	if response := decodeTail(t, payload); len(response.Lines) != 3 {
+		t.Fatalf("expected all buffered lines without lines, got %+v", response)
+	}
+
+	payload, _ = handleTailMessage("tail.job-tail", []byte(`{"args":[{"lines":-1}]}`), "instance-1")
+	if response := decodeTail(t, payload); response.Success || response.Code != utils.ErrorCodeInvalidRequest {
+		t.Fatalf("unexpected response for negative lines: %+v", response)
+	}
+}
+
+func TestHandleTailMessageStaysSilentForUnknownJobs(t *testing.T) {
+	if _, ok := handleTailMessage("tail.missing-job", nil, "instance-1"); ok {
+		t.Fatal("expected no response for a job that is not running here")
+	}
+	if _, ok := handleTailMessage("tail.", nil, "instance-1"); ok {
+		t.Fatal("expected no response without job id")
+	}
+}
+
+func TestExecuteFeedsTailWhileRunning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	done := make(chan ExecuteResponse, 1)
+	go func() {
+		done <- Execute(ExecuteRequest{Command: "echo first; echo second >&2; sleep 1", ExecuteTimeout: 5, JobID: "job-live"}, "instance-1")
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if lines, ok := utils.DefaultTails.Last("job-live", 0); ok && len(lines) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected tail to show output while the command runs")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	<-done
+	if _, ok := utils.DefaultTails.Last("job-live", 0); ok {
+		t.Fatal("expected tail to be removed after the command finished")
+	}
+}
+
+func TestSubscribeTailRegistersWildcardSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeTail(sub, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "tail.*" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
@@ -0,0 +1,98 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"nats-executor/logger"
+)
+
+// defaultKillGracePeriodSeconds 为超时或取消后 SIGTERM 到 SIGKILL 之间的默认宽限期（秒）
+const defaultKillGracePeriodSeconds = 5
+
+// killGracePeriod 返回 kill_grace_period 对应的宽限期，<= 0 时使用默认值
+func killGracePeriod(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultKillGracePeriodSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// killProcessGroupFn 为宽限期结束后结束进程树的函数，测试中可替换
+var killProcessGroupFn = killProcessGroup
+
+// processTerminator 在超时或取消时终止命令的整个进程树：先 SIGTERM 进程组，宽限期后仍未退出再 SIGKILL
+type processTerminator struct {
+	cmd        *exec.Cmd
+	grace      time.Duration
+	instanceId string
+	once       sync.Once
+	err        error
+
+	// mu 保护 timer 与 stopped；cmd.Wait 返回后 stopped 为 true，此后不再向可能已被复用的 pid / pgid 发信号
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func newProcessTerminator(cmd *exec.Cmd, grace time.Duration, instanceId string) *processTerminator {
+	return &processTerminator{cmd: cmd, grace: grace, instanceId: instanceId}
+}
+
+// terminate 作为 cmd.Cancel 使用，重复调用只生效一次
+func (p *processTerminator) terminate() error {
+	p.once.Do(func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.stopped {
+			p.err = os.ErrProcessDone
+			return
+		}
+		logger.WithInstance(p.instanceId).Debugf("[Local Execute] Sending SIGTERM to process group, SIGKILL in %s", p.grace)
+		err := terminateProcessGroup(p.cmd)
+		p.timer = time.AfterFunc(p.grace, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.stopped {
+				return
+			}
+			if err := killProcessGroupFn(p.cmd); err != nil && !isProcessGone(err) {
+				logger.WithInstance(p.instanceId).Warnf("[Local Execute] Failed to kill process group: %v", err)
+			}
+		})
+		if isProcessGone(err) {
+			err = os.ErrProcessDone
+		}
+		p.err = err
+	})
+	return p.err
+}
+
+// stop 在 cmd.Wait 返回后调用，取消尚未触发的 SIGKILL 定时器，避免宽限期内已退出的进程 ID 被复用后误杀
+func (p *processTerminator) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+// watch 在 ctx 结束时终止进程树，直到 done 关闭。exec 只在主进程仍在运行时调用 cmd.Cancel，
+// 主进程已退出而派生的后台子进程仍占用输出管道时（如 "sleep 100 &"），需要由这里结束子进程
+func (p *processTerminator) watch(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		p.terminate()
+	case <-done:
+	}
+}
+
+func isProcessGone(err error) bool {
+	return errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH)
+}
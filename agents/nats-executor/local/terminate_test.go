@@ -0,0 +1,108 @@
+package local
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+)
+
+func TestExecuteTimeoutSendsSIGTERMBeforeSIGKILL(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX signals")
+	}
+	marker := filepath.Join(t.TempDir(), "cleaned")
+
+	start := time.Now()
+	response := Execute(ExecuteRequest{
+		Command:         "trap 'echo cleaned > " + marker + "; exit 143' TERM; sleep 30 & wait",
+		ExecuteTimeout:  1,
+		KillGracePeriod: 5,
+	}, "test-graceful")
+	elapsed := time.Since(start)
+
+	if response.Success || response.Code != utils.ErrorCodeTimeout {
+		t.Fatalf("expected timeout response, got %+v", response)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("expected TERM trap to run before exit: %v", err)
+	}
+	if elapsed > 3*time.Second {
+		t.Fatalf("expected command to exit on SIGTERM without waiting for the grace period, took %s", elapsed)
+	}
+}
+
+func TestExecuteTimeoutKillsProcessIgnoringSIGTERMAfterGracePeriod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX signals")
+	}
+
+	start := time.Now()
+	response := Execute(ExecuteRequest{
+		Command:         "trap '' TERM; sleep 30 & wait; sleep 30",
+		ExecuteTimeout:  1,
+		KillGracePeriod: 1,
+	}, "test-graceful")
+	elapsed := time.Since(start)
+
+	if response.Success || response.Code != utils.ErrorCodeTimeout {
+		t.Fatalf("expected timeout response, got %+v", response)
+	}
+	if elapsed < 2*time.Second || elapsed > 5*time.Second {
+		t.Fatalf("expected SIGKILL after the 1s grace period, took %s", elapsed)
+	}
+}
+
+func TestKillGracePeriodDefaults(t *testing.T) {
+	if got := killGracePeriod(0); got != defaultKillGracePeriodSeconds*time.Second {
+		t.Fatalf("expected default grace period, got %s", got)
+	}
+	if got := killGracePeriod(2); got != 2*time.Second {
+		t.Fatalf("expected explicit grace period, got %s", got)
+	}
+}
+
+func TestProcessTerminatorStopCancelsPendingKill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX signals")
+	}
+	var killed atomic.Bool
+	original := killProcessGroupFn
+	killProcessGroupFn = func(cmd *exec.Cmd) error {
+		killed.Store(true)
+		return nil
+	}
+	defer func() { killProcessGroupFn = original }()
+
+	cmd := exec.Command("sh", "-c", "exit 0")
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	terminator := newProcessTerminator(cmd, 50*time.Millisecond, "test-stop")
+	terminator.terminate()
+	_ = cmd.Wait()
+	terminator.stop()
+
+	time.Sleep(150 * time.Millisecond)
+	if killed.Load() {
+		t.Fatal("expected SIGKILL timer to be cancelled after the process was reaped")
+	}
+}
+
+func TestProcessTerminatorSkipsSignalsAfterStop(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 0")
+	terminator := newProcessTerminator(cmd, time.Millisecond, "test-stop")
+	terminator.stop()
+	if err := terminator.terminate(); err != os.ErrProcessDone {
+		t.Fatalf("expected terminate after stop to be a no-op, got %v", err)
+	}
+	if terminator.timer != nil {
+		t.Fatal("expected no SIGKILL timer after stop")
+	}
+}
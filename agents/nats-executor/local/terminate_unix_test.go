@@ -0,0 +1,78 @@
+//go:build !windows
+
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+)
+
+// processAlive 判断进程是否仍在运行，已退出但未被回收的僵尸进程视为已结束
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func readPID(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read pid file: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("parse pid %q: %v", data, err)
+	}
+	return pid
+}
+
+func TestExecuteTimeoutKillsBackgroundChildren(t *testing.T) {
+	cases := map[string]string{
+		// 主进程等待后台子进程
+		"waiting parent": "sleep 100 & echo $! > %s; wait",
+		// 主进程立即退出，后台子进程继续占用输出管道
+		"exited parent": "sleep 100 & echo $! > %s",
+	}
+	for name, command := range cases {
+		t.Run(name, func(t *testing.T) {
+			pidFile := filepath.Join(t.TempDir(), "child.pid")
+			start := time.Now()
+			response := Execute(ExecuteRequest{
+				Command:         strings.Replace(command, "%s", pidFile, 1),
+				Shell:           "bash",
+				ExecuteTimeout:  1,
+				KillGracePeriod: 1,
+			}, "test-tree-kill")
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Fatalf("expected timeout to return promptly, took %s", elapsed)
+			}
+			if response.Success || response.Code != utils.ErrorCodeTimeout {
+				t.Fatalf("expected timeout response, got %+v", response)
+			}
+
+			pid := readPID(t, pidFile)
+			deadline := time.Now().Add(3 * time.Second)
+			for processAlive(pid) {
+				if time.Now().After(deadline) {
+					syscall.Kill(pid, syscall.SIGKILL)
+					t.Fatalf("expected background child %d to be killed after timeout", pid)
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		})
+	}
+}
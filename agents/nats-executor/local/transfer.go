@@ -0,0 +1,217 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+	"nats-executor/utils/downloaderr"
+
+	"github.com/nats-io/nats.go"
+)
+
+// transferResponseGrace 为目标实例回传下载结果预留的额外等待时间，
+// 使目标端自身超时能以结构化结果返回而不是表现为请求超时。
+const transferResponseGrace = 2 * time.Second
+
+var (
+	uploadLocalFile = func(req utils.UploadFileRequest, nc downloadConn) error {
+		natsConn, _ := nc.(*nats.Conn)
+		_, err := utils.UploadFile(req, natsConn)
+		return err
+	}
+	requestRemoteDownload = func(nc downloadConn, subject string, payload []byte, timeout time.Duration) ([]byte, error) {
+		natsConn, _ := nc.(*nats.Conn)
+		if natsConn == nil {
+			return nil, errors.New("nats connection is not available")
+		}
+		msg, err := natsConn.Request(subject, payload, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return msg.Data, nil
+	}
+	subscribeObjectStoreTransferFn = subscribeObjectStoreTransfer
+)
+
+func objectStoreErrorCode(err error) string {
+	switch {
+	case downloaderr.KindOf(err) == downloaderr.KindTimeout || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout):
+		return utils.ErrorCodeTimeout
+	case downloaderr.KindOf(err) == downloaderr.KindIO:
+		return utils.ErrorCodeExecutionFailure
+	default:
+		return utils.ErrorCodeDependencyFailure
+	}
+}
+
+func validateObjectStoreTransferRequest(req ObjectStoreTransferRequest) string {
+	switch {
+	case strings.TrimSpace(req.BucketName) == "":
+		return "bucket_name is required"
+	case strings.TrimSpace(req.SourcePath) == "":
+		return "source_path is required"
+	case strings.TrimSpace(req.TargetInstanceID) == "":
+		return "target_instance_id is required"
+	case strings.TrimSpace(req.TargetPath) == "":
+		return "target_path is required"
+	case req.ExecuteTimeout <= 0:
+		return "execute timeout must be greater than 0"
+	default:
+		return ""
+	}
+}
+
+func failedTransferResponse(instanceId, code, message string, upload ExecuteResponse, download *ExecuteResponse) ObjectStoreTransferResponse {
+	return ObjectStoreTransferResponse{
+		Output:     message,
+		InstanceId: instanceId,
+		Success:    false,
+		Code:       code,
+		Error:      message,
+		Upload:     upload,
+		Download:   download,
+	}
+}
+
+// transferViaObjectStore 先将本机文件上传到对象存储，再请求目标实例的 download.local 拉取，
+// 两段结果一并返回，源主机与目标主机之间无需直连。
+func transferViaObjectStore(req ObjectStoreTransferRequest, instanceId string, nc downloadConn) ObjectStoreTransferResponse {
+	if validationErr := validateObjectStoreTransferRequest(req); validationErr != "" {
+		return failedTransferResponse(instanceId, utils.ErrorCodeInvalidRequest, validationErr, invalidExecuteResponse(instanceId, validationErr), nil)
+	}
+
+	deadline := time.Now().Add(time.Duration(req.ExecuteTimeout) * time.Second)
+	fileName := req.FileName
+	if strings.TrimSpace(fileName) == "" {
+		fileName = filepath.Base(req.SourcePath)
+	}
+	fileKey := req.FileKey
+	if strings.TrimSpace(fileKey) == "" {
+		fileKey = fmt.Sprintf("transfer/%s/%s", instanceId, fileName)
+	}
+
+	logger.WithInstance(instanceId).Infof("[Object Store Transfer] upload %s -> %s/%s, target=%s", req.SourcePath, req.BucketName, fileKey, req.TargetInstanceID)
+	err := uploadLocalFile(utils.UploadFileRequest{
+		BucketName:     req.BucketName,
+		FileKey:        fileKey,
+		SourcePath:     req.SourcePath,
+		ExecuteTimeout: req.ExecuteTimeout,
+	}, nc)
+	if err != nil {
+		message := fmt.Sprintf("Failed to upload file: %v", err)
+		code := objectStoreErrorCode(err)
+		upload := ExecuteResponse{Output: message, InstanceId: instanceId, Success: false, Code: code, Error: message}
+		return failedTransferResponse(instanceId, code, message, upload, nil)
+	}
+	upload := ExecuteResponse{
+		Output:     fmt.Sprintf("File %s uploaded to %s/%s", req.SourcePath, req.BucketName, fileKey),
+		InstanceId: instanceId,
+		Success:    true,
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		message := fmt.Sprintf("Transfer timed out after upload (timeout: %ds)", req.ExecuteTimeout)
+		return failedTransferResponse(instanceId, utils.ErrorCodeTimeout, message, upload, nil)
+	}
+	remainingSeconds := int((remaining + time.Second - 1) / time.Second)
+
+	payload, _ := json.Marshal(map[string]any{
+		"args": []utils.DownloadFileRequest{{
+			BucketName:     req.BucketName,
+			FileKey:        fileKey,
+			FileName:       fileName,
+			TargetPath:     req.TargetPath,
+			ExecuteTimeout: remainingSeconds,
+		}},
+		"kwargs": map[string]any{},
+	})
+	subject := fmt.Sprintf("download.local.%s", req.TargetInstanceID)
+	logger.WithInstance(instanceId).Infof("[Object Store Transfer] requesting %s to download %s/%s", subject, req.BucketName, fileKey)
+
+	responseData, err := requestRemoteDownload(nc, subject, payload, remaining+transferResponseGrace)
+	if err != nil {
+		message := fmt.Sprintf("Failed to request download on target instance %s: %v", req.TargetInstanceID, err)
+		code := objectStoreErrorCode(err)
+		download := &ExecuteResponse{Output: message, InstanceId: req.TargetInstanceID, Success: false, Code: code, Error: message}
+		return failedTransferResponse(instanceId, code, message, upload, download)
+	}
+
+	var download ExecuteResponse
+	if err := json.Unmarshal(responseData, &download); err != nil {
+		message := fmt.Sprintf("Invalid download response from target instance %s: %v", req.TargetInstanceID, err)
+		invalid := &ExecuteResponse{Output: message, InstanceId: req.TargetInstanceID, Success: false, Code: utils.ErrorCodeDependencyFailure, Error: message}
+		return failedTransferResponse(instanceId, utils.ErrorCodeDependencyFailure, message, upload, invalid)
+	}
+	if !download.Success {
+		message := fmt.Sprintf("Target instance %s failed to download file: %s", req.TargetInstanceID, download.Error)
+		code := download.Code
+		if code == "" {
+			code = utils.ErrorCodeExecutionFailure
+		}
+		return failedTransferResponse(instanceId, code, message, upload, &download)
+	}
+
+	return ObjectStoreTransferResponse{
+		Output:     fmt.Sprintf("File %s transferred to %s:%s/%s via %s/%s", req.SourcePath, req.TargetInstanceID, req.TargetPath, fileName, req.BucketName, fileKey),
+		InstanceId: instanceId,
+		Success:    true,
+		Upload:     upload,
+		Download:   &download,
+	}
+}
+
+func handleObjectStoreTransferMessage(data []byte, instanceId string, nc downloadConn) ([]byte, bool) {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+	if mismatch := utils.InstanceMismatchResponse("Object Store Transfer", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
+
+	var transferRequest ObjectStoreTransferRequest
+	if err := json.Unmarshal(incoming.Args[0], &transferRequest); err != nil {
+		return invalidRequestResponse(instanceId, "invalid request payload")
+	}
+
+	responseContent, _ := json.Marshal(transferViaObjectStore(transferRequest, instanceId, nc))
+	return responseContent, true
+}
+
+func respondObjectStoreTransferSubscription(msg inboundMsg, instanceId string, nc downloadConn) bool {
+	responseContent, ok := handleObjectStoreTransferMessage(msg.Payload(), instanceId, nc)
+	if !ok {
+		logger.WithInstance(instanceId).Errorf("[Object Store Transfer Subscribe] Error unmarshalling incoming message")
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Object Store Transfer Subscribe] Error responding to transfer request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeObjectStoreTransfer(sub subscriber, nc downloadConn, instanceId *string) error {
+	subject := fmt.Sprintf("transfer.objectstore.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Object Store Transfer Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("transfer.objectstore")()
+		respondObjectStoreTransferSubscription(natsInboundMsg{msg}, *instanceId, nc)
+	})
+	return err
+}
+
+func SubscribeObjectStoreTransfer(nc *nats.Conn, instanceId *string) {
+	if err := subscribeObjectStoreTransferFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Object Store Transfer Subscribe] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+	"nats-executor/utils/downloaderr"
+
+	"github.com/nats-io/nats.go"
+)
+
+func withTransferStubs(t *testing.T, upload func(utils.UploadFileRequest, downloadConn) error, request func(downloadConn, string, []byte, time.Duration) ([]byte, error)) {
+	t.Helper()
+	originalUpload := uploadLocalFile
+	originalRequest := requestRemoteDownload
+	uploadLocalFile = upload
+	requestRemoteDownload = request
+	t.Cleanup(func() {
+		uploadLocalFile = originalUpload
+		requestRemoteDownload = originalRequest
+	})
+}
+
+func transferTestRequest() ObjectStoreTransferRequest {
+	return ObjectStoreTransferRequest{
+		BucketName:       "transfers",
+		SourcePath:       "/data/report.tar.gz",
+		TargetInstanceID: "instance-b",
+		TargetPath:       "/opt/incoming",
+		ExecuteTimeout:   30,
+	}
+}
+
+func TestTransferViaObjectStoreReturnsBothLegs(t *testing.T) {
+	var uploaded utils.UploadFileRequest
+	var requestedSubject string
+	var requested utils.DownloadFileRequest
+	withTransferStubs(t,
+		func(req utils.UploadFileRequest, nc downloadConn) error {
+			uploaded = req
+			return nil
+		},
+		func(nc downloadConn, subject string, payload []byte, timeout time.Duration) ([]byte, error) {
+			requestedSubject = subject
+			var incoming struct {
+				Args []utils.DownloadFileRequest `json:"args"`
+			}
+			if err := json.Unmarshal(payload, &incoming); err != nil || len(incoming.Args) != 1 {
+				t.Fatalf("unexpected download payload: %s err=%v", payload, err)
+			}
+			requested = incoming.Args[0]
+			if timeout <= transferResponseGrace {
+				t.Fatalf("expected remaining budget plus grace, got %s", timeout)
+			}
+			return json.Marshal(ExecuteResponse{Success: true, Output: "downloaded", InstanceId: "instance-b"})
+		},
+	)
+
+	response := transferViaObjectStore(transferTestRequest(), "instance-a", nil)
+	if !response.Success || !response.Upload.Success || response.Download == nil || !response.Download.Success {
+		t.Fatalf("expected both legs to succeed, got %+v", response)
+	}
+	if uploaded.FileKey != "transfer/instance-a/report.tar.gz" || uploaded.SourcePath != "/data/report.tar.gz" {
+		t.Fatalf("unexpected upload request: %+v", uploaded)
+	}
+	if requestedSubject != "download.local.instance-b" {
+		t.Fatalf("unexpected download subject: %s", requestedSubject)
+	}
+	if requested.FileKey != uploaded.FileKey || requested.FileName != "report.tar.gz" || requested.TargetPath != "/opt/incoming" || requested.ExecuteTimeout <= 0 {
+		t.Fatalf("unexpected download request: %+v", requested)
+	}
+}
+
+func TestTransferViaObjectStoreStopsWhenUploadFails(t *testing.T) {
+	requested := false
+	withTransferStubs(t,
+		func(req utils.UploadFileRequest, nc downloadConn) error {
+			return downloaderr.New(downloaderr.KindIO, errors.New("no such file"))
+		},
+		func(nc downloadConn, subject string, payload []byte, timeout time.Duration) ([]byte, error) {
+			requested = true
+			return nil, nil
+		},
+	)
+
+	response := transferViaObjectStore(transferTestRequest(), "instance-a", nil)
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure || response.Upload.Success {
+		t.Fatalf("expected upload failure, got %+v", response)
+	}
+	if requested || response.Download != nil {
+		t.Fatalf("expected download leg to be skipped, got %+v", response.Download)
+	}
+}
+
+func TestTransferViaObjectStoreReportsDownloadLegFailures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  func(downloadConn, string, []byte, time.Duration) ([]byte, error)
+		wantCode string
+		wantErr  string
+	}{
+		{
+			name: "no responders",
+			request: func(downloadConn, string, []byte, time.Duration) ([]byte, error) {
+				return nil, nats.ErrNoResponders
+			},
+			wantCode: utils.ErrorCodeDependencyFailure,
+			wantErr:  "Failed to request download on target instance instance-b",
+		},
+		{
+			name: "request timeout",
+			request: func(downloadConn, string, []byte, time.Duration) ([]byte, error) {
+				return nil, nats.ErrTimeout
+			},
+			wantCode: utils.ErrorCodeTimeout,
+			wantErr:  "Failed to request download on target instance instance-b",
+		},
+		{
+			name: "target download failed",
+			request: func(downloadConn, string, []byte, time.Duration) ([]byte, error) {
+				return json.Marshal(ExecuteResponse{Success: false, Code: utils.ErrorCodeExecutionFailure, Error: "disk full", InstanceId: "instance-b"})
+			},
+			wantCode: utils.ErrorCodeExecutionFailure,
+			wantErr:  "Target instance instance-b failed to download file: disk full",
+		},
+		{
+			name: "invalid response",
+			request: func(downloadConn, string, []byte, time.Duration) ([]byte, error) {
+				return []byte("not-json"), nil
+			},
+			wantCode: utils.ErrorCodeDependencyFailure,
+			wantErr:  "Invalid download response",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			withTransferStubs(t, func(utils.UploadFileRequest, downloadConn) error { return nil }, tt.request)
+
+			response := transferViaObjectStore(transferTestRequest(), "instance-a", nil)
+			if response.Success || !response.Upload.Success || response.Download == nil || response.Download.Success {
+				t.Fatalf("expected upload success and download failure, got %+v", response)
+			}
+			if response.Code != tt.wantCode || !strings.Contains(response.Error, tt.wantErr) {
+				t.Fatalf("unexpected failure: code=%s error=%s", response.Code, response.Error)
+			}
+		})
+	}
+}
+
+func TestHandleObjectStoreTransferMessageValidatesRequest(t *testing.T) {
+	responseContent, ok := handleObjectStoreTransferMessage([]byte(`{"args":[{"bucket_name":"transfers","source_path":"/data/a","target_path":"/tmp","execute_timeout":5}],"kwargs":{}}`), "instance-a", nil)
+	if !ok {
+		t.Fatal("expected handler to respond")
+	}
+	var response ObjectStoreTransferResponse
+	if err := json.Unmarshal(responseContent, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != "target_instance_id is required" {
+		t.Fatalf("unexpected validation response: %+v", response)
+	}
+
+	responseContent, _ = handleObjectStoreTransferMessage([]byte(`not-json`), "instance-a", nil)
+	if !strings.Contains(string(responseContent), "invalid request payload") {
+		t.Fatalf("unexpected malformed payload response: %s", responseContent)
+	}
+}
+
+func TestSubscribeObjectStoreTransferRegistersSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeObjectStoreTransfer(sub, nil, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "transfer.objectstore.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
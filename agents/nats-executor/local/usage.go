@@ -0,0 +1,105 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+var subscribeUsageFn = subscribeUsage
+
+var (
+	processStartTime = time.Now()
+	usageNow         = time.Now
+	processCPUTimeFn = processCPUTime
+)
+
+// cpuSampler 记录上次查询时的进程 CPU 时间，cpu_percent 按两次查询之间的增量计算
+type cpuSampler struct {
+	mu      sync.Mutex
+	at      time.Time
+	cpuTime time.Duration
+}
+
+var usageCPUSampler = &cpuSampler{}
+
+// sample 返回距上次采样（首次为进程启动）的 CPU 使用率，单核满载为 100
+func (s *cpuSampler) sample(now time.Time, cpuTime time.Duration) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since, previous := s.at, s.cpuTime
+	if since.IsZero() {
+		since, previous = processStartTime, 0
+	}
+	s.at, s.cpuTime = now, cpuTime
+	wall := now.Sub(since)
+	if wall <= 0 || cpuTime < previous {
+		return 0
+	}
+	return float64(cpuTime-previous) / float64(wall) * 100
+}
+
+// collectUsage 采集执行器进程自身（而非整机）的负载，供调度方判断实例繁忙程度
+func collectUsage(instanceId string) UsageResponse {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	now := usageNow()
+	completed := utils.DefaultMetrics.CompletedRecently()
+	window := utils.DefaultMetrics.ThroughputWindow()
+
+	response := UsageResponse{
+		InstanceId:          instanceId,
+		Success:             true,
+		InFlightOperations:  utils.DefaultMetrics.TotalInFlight(),
+		Goroutines:          runtime.NumGoroutine(),
+		MemoryBytes:         memStats.Sys,
+		HeapAllocBytes:      memStats.HeapAlloc,
+		CompletedLastMinute: completed,
+		ThroughputPerSecond: float64(completed) / window.Seconds(),
+		UptimeSeconds:       int64(now.Sub(processStartTime).Seconds()),
+	}
+	cpuTime, err := processCPUTimeFn()
+	if err != nil {
+		logger.WithInstance(instanceId).Warnf("[Usage] Failed to read process CPU time: %v", err)
+		return response
+	}
+	response.CPUSeconds = cpuTime.Seconds()
+	response.CPUPercent = usageCPUSampler.sample(now, cpuTime)
+	return response
+}
+
+func handleUsageMessage(instanceId string) []byte {
+	responseContent, _ := json.Marshal(collectUsage(instanceId))
+	return responseContent
+}
+
+func respondUsageSubscription(msg inboundMsg, instanceId string) bool {
+	if err := msg.Respond(handleUsageMessage(instanceId)); err != nil {
+		logger.WithInstance(instanceId).Errorf("[Usage Subscribe] Error responding to usage request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeUsage(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("usage.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[Usage Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondUsageSubscription(natsInboundMsg{msg}, *instanceId)
+	})
+	return err
+}
+
+func SubscribeUsage(nc *nats.Conn, instanceId *string) {
+	if err := subscribeUsageFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Usage Subscribe] Failed to subscribe: %v", err)
+	}
+}
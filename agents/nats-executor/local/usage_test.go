@@ -0,0 +1,59 @@
+package local
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+)
+
+func TestHandleUsageMessageReportsProcessUsage(t *testing.T) {
+	done := utils.DefaultMetrics.Begin("local.execute")
+	finished := utils.DefaultMetrics.Begin("local.execute")
+	finished()
+	defer done()
+
+	// 消耗一些 CPU，确保 cpu_seconds 非零
+	deadline := time.Now().Add(20 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+
+	var response UsageResponse
+	if err := json.Unmarshal(handleUsageMessage("instance-1"), &response); err != nil {
+		t.Fatalf("unmarshal usage: %v", err)
+	}
+	if !response.Success || response.InstanceId != "instance-1" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if response.InFlightOperations < 1 || response.CompletedLastMinute < 1 || response.ThroughputPerSecond <= 0 {
+		t.Fatalf("expected in-flight and throughput to be populated, got %+v", response)
+	}
+	if response.Goroutines <= 0 || response.MemoryBytes == 0 || response.HeapAllocBytes == 0 || response.CPUSeconds <= 0 {
+		t.Fatalf("expected process stats to be populated, got %+v", response)
+	}
+}
+
+func TestCPUSamplerMeasuresDeltaBetweenSamples(t *testing.T) {
+	sampler := &cpuSampler{}
+	start := processStartTime
+	if got := sampler.sample(start.Add(2*time.Second), time.Second); got != 50 {
+		t.Fatalf("expected 50%% since process start, got %v", got)
+	}
+	if got := sampler.sample(start.Add(3*time.Second), 3*time.Second); got != 200 {
+		t.Fatalf("expected 200%% across two busy cores, got %v", got)
+	}
+	if got := sampler.sample(start.Add(3*time.Second), 3*time.Second); got != 0 {
+		t.Fatalf("expected 0 without elapsed time, got %v", got)
+	}
+}
+
+func TestSubscribeUsageRegistersSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeUsage(sub, stringPointer("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "usage.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
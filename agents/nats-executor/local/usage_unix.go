@@ -0,0 +1,17 @@
+//go:build !windows
+
+package local
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime 返回本进程累计的用户态与内核态 CPU 时间
+func processCPUTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package local
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime 返回本进程累计的用户态与内核态 CPU 时间
+func processCPUTime() (time.Duration, error) {
+	handle, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, err
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	// Filetime 以 100ns 为单位
+	ticks := int64(kernel.HighDateTime)<<32 | int64(kernel.LowDateTime)
+	ticks += int64(user.HighDateTime)<<32 | int64(user.LowDateTime)
+	return time.Duration(ticks * 100), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+)
+
+// LogLevelRequest 为 log.level.<instance_id> 的请求体，level 为空时只查询当前级别
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	InstanceId string `json:"instance_id"`
+	Success    bool   `json:"success"`
+	Level      string `json:"level"` // 处理后生效的日志级别
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleLogLevelMessage 在运行时调整日志级别，非法级别返回 invalid_request 且不做修改
+func handleLogLevelMessage(data []byte, instanceID string) LogLevelResponse {
+	response := LogLevelResponse{InstanceId: instanceID, Level: logger.GetLevel()}
+	var request LogLevelRequest
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &request); err != nil {
+			response.Code = utils.ErrorCodeInvalidRequest
+			response.Error = "invalid request payload"
+			return response
+		}
+	}
+
+	level := strings.TrimSpace(request.Level)
+	if level == "" {
+		response.Success = true
+		return response
+	}
+	if !isValidLogLevel(level) {
+		response.Code = utils.ErrorCodeInvalidRequest
+		response.Error = fmt.Sprintf("invalid level %q: must be one of debug, info, warn, error", level)
+		return response
+	}
+	previous := logger.GetLevel()
+	logger.SetLevel(level)
+	response.Success = true
+	response.Level = logger.GetLevel()
+	logger.Infof("[Log Level] Instance: %s, Log level changed from %s to %s", instanceID, previous, response.Level)
+	return response
+}
+
+func subscribeLogLevelSubject(sub configSubscriber, instanceID string) error {
+	subject := fmt.Sprintf("log.level.%s", instanceID)
+	logger.Infof("[Log Level Subscribe] Instance: %s, Subscribing to subject: %s", instanceID, subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		responseContent, _ := json.Marshal(handleLogLevelMessage(msg.Data, instanceID))
+		if err := msg.Respond(responseContent); err != nil {
+			logger.Errorf("[Log Level Subscribe] Instance: %s, Error responding to log level request: %v", instanceID, err)
+		}
+	})
+	return err
+}
+
+func SubscribeLogLevel(nc *nats.Conn, instanceID *string) {
+	if err := subscribeLogLevelSubject(nc, *instanceID); err != nil {
+		logger.Errorf("[Log Level Subscribe] Instance: %s, Failed to subscribe: %v", *instanceID, err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+)
+
+func TestHandleLogLevelMessageChangesAndReportsLevel(t *testing.T) {
+	originalLevel := logger.GetLevel()
+	defer logger.SetLevel(originalLevel)
+	logger.SetLevel("info")
+
+	if response := handleLogLevelMessage([]byte(`{"level":"DEBUG"}`), "instance-1"); !response.Success || response.Level != "debug" || logger.GetLevel() != "debug" {
+		t.Fatalf("expected level to change to debug, got %+v", response)
+	}
+	if response := handleLogLevelMessage(nil, "instance-1"); !response.Success || response.Level != "debug" {
+		t.Fatalf("expected empty request to report current level, got %+v", response)
+	}
+	for _, payload := range []string{`{"level":"verbose"}`, `not json`} {
+		response := handleLogLevelMessage([]byte(payload), "instance-1")
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Level != "debug" || logger.GetLevel() != "debug" {
+			t.Fatalf("%s: expected rejection without changes, got %+v", payload, response)
+		}
+	}
+	if response := handleLogLevelMessage([]byte(`{"level":"verbose"}`), "instance-1"); !strings.Contains(response.Error, `invalid level "verbose"`) {
+		t.Fatalf("expected error to name the invalid level, got %q", response.Error)
+	}
+}
+
+func TestSubscribeLogLevelRegistersSubject(t *testing.T) {
+	sub := &stubConfigSubscriber{}
+	if err := subscribeLogLevelSubject(sub, "instance-1"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "log.level.instance-1" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withFileOutput(t *testing.T, cfg FileConfig) {
+	t.Helper()
+	originalFormat := GetFormat()
+	currentLevel = &slog.LevelVar{}
+	SetOutput(cfg)
+	t.Cleanup(func() {
+		SetOutput(FileConfig{})
+		SetFormat(originalFormat)
+	})
+}
+
+func countLines(t *testing.T, dir, marker string) (int, int) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read log dir: %v", err)
+	}
+	lines := 0
+	for _, entry := range entries {
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("open %s: %v", entry.Name(), err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), marker) {
+				lines++
+			}
+		}
+		file.Close()
+	}
+	return lines, len(entries)
+}
+
+func TestFileConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_FILE", " /var/log/nats-executor.log ")
+	t.Setenv("LOG_FILE_MAX_SIZE_MB", "20")
+	t.Setenv("LOG_FILE_MAX_AGE_DAYS", "-1")
+	t.Setenv("LOG_FILE_MAX_BACKUPS", "abc")
+	t.Setenv("LOG_TO_STDOUT", "TRUE")
+
+	want := FileConfig{Path: "/var/log/nats-executor.log", MaxSizeMB: 20, MaxAgeDays: defaultLogFileMaxAgeDays, MaxBackups: defaultLogFileMaxBackups, Stdout: true}
+	if got := fileConfigFromEnv(); got != want {
+		t.Fatalf("fileConfigFromEnv() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetOutputWritesFileAndStdout(t *testing.T) {
+	originalStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = originalStdout }()
+
+	path := filepath.Join(t.TempDir(), "executor.log")
+	withFileOutput(t, FileConfig{Path: path, MaxSizeMB: 1, Stdout: true})
+	Infof("written to %s", "both")
+	w.Close()
+	os.Stdout = originalStdout
+
+	stdout, _ := io.ReadAll(r)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	if !strings.Contains(string(data), "written to both") || !strings.Contains(string(stdout), "written to both") {
+		t.Fatalf("expected record in file and stdout, file=%q stdout=%q", data, stdout)
+	}
+}
+
+func TestSetOutputRotatesBySizeWithoutLosingRecords(t *testing.T) {
+	dir := t.TempDir()
+	withFileOutput(t, FileConfig{Path: filepath.Join(dir, "executor.log"), MaxSizeMB: 1, MaxBackups: 10})
+	SetFormat(FormatJSON)
+
+	// 约 1.5MB，至少触发一次滚动
+	payload := strings.Repeat("x", 500)
+	const records = 3000
+	for i := 0; i < records; i++ {
+		Info("rotation-record", "seq", i, "payload", payload)
+	}
+
+	lines, files := countLines(t, dir, "rotation-record")
+	if files < 2 {
+		t.Fatalf("expected log file to be rotated, found %d files", files)
+	}
+	if lines != records {
+		t.Fatalf("expected %d records across rotated files, got %d", records, lines)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestStdoutTeeWriterKeepsStdoutWhenFileFails(t *testing.T) {
+	var stdout bytes.Buffer
+	writer := stdoutTeeWriter{stdout: &stdout, file: failingWriter{}}
+	n, err := writer.Write([]byte("still visible\n"))
+	if err != nil || n != len("still visible\n") {
+		t.Fatalf("expected stdout write to succeed, n=%d err=%v", n, err)
+	}
+	if stdout.String() != "still visible\n" {
+		t.Fatalf("unexpected stdout: %q", stdout.String())
+	}
+}
@@ -2,26 +2,76 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
 var (
-	defaultLogger *slog.Logger
+	defaultLogger atomic.Pointer[slog.Logger]
 	currentLevel  *slog.LevelVar
 	exitFunc      = os.Exit
+
+	formatMu      sync.Mutex
+	currentFormat = FormatText
+
+	// output 为日志写入目标，outputFile 为其中的滚动日志文件（未配置时为 nil）
+	output     io.Writer = os.Stdout
+	outputFile *lumberjack.Logger
 )
 
 func init() {
 	currentLevel = &slog.LevelVar{}
 	setLevelFromEnv()
+	output, outputFile = newOutput(fileConfigFromEnv())
+	setFormatFromEnv()
+}
+
+// setFormatFromEnv 按 LOG_FORMAT（json / text）选择输出格式，未设置或无法识别时使用 text
+func setFormatFromEnv() {
+	if !SetFormat(os.Getenv("LOG_FORMAT")) {
+		SetFormat(FormatText)
+	}
+}
+
+// SetFormat 以 text 或 json 格式重建日志 handler，级别沿用当前设置；无法识别的格式不做修改并返回 false
+func SetFormat(format string) bool {
+	format = strings.ToLower(strings.TrimSpace(format))
+	options := &slog.HandlerOptions{Level: currentLevel}
+
+	formatMu.Lock()
+	defer formatMu.Unlock()
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(output, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(output, options)
+	default:
+		return false
+	}
+	currentFormat = format
+	logger := slog.New(handler)
+	defaultLogger.Store(logger)
+	slog.SetDefault(logger)
+	return true
+}
 
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: currentLevel,
-	})
-	defaultLogger = slog.New(handler)
-	slog.SetDefault(defaultLogger)
+// GetFormat 返回当前的日志输出格式
+func GetFormat() string {
+	formatMu.Lock()
+	defer formatMu.Unlock()
+	return currentFormat
 }
 
 func setLevelFromEnv() {
@@ -69,43 +119,101 @@ func GetLevel() string {
 }
 
 func Debug(msg string, args ...any) {
-	defaultLogger.Debug(msg, args...)
+	defaultLogger.Load().Debug(msg, args...)
 }
 
 func Debugf(format string, args ...any) {
-	defaultLogger.Debug(fmt.Sprintf(format, args...))
+	defaultLogger.Load().Debug(fmt.Sprintf(format, args...))
 }
 
 func Info(msg string, args ...any) {
-	defaultLogger.Info(msg, args...)
+	defaultLogger.Load().Info(msg, args...)
 }
 
 func Infof(format string, args ...any) {
-	defaultLogger.Info(fmt.Sprintf(format, args...))
+	defaultLogger.Load().Info(fmt.Sprintf(format, args...))
 }
 
 func Warn(msg string, args ...any) {
-	defaultLogger.Warn(msg, args...)
+	defaultLogger.Load().Warn(msg, args...)
 }
 
 func Warnf(format string, args ...any) {
-	defaultLogger.Warn(fmt.Sprintf(format, args...))
+	defaultLogger.Load().Warn(fmt.Sprintf(format, args...))
 }
 
 func Error(msg string, args ...any) {
-	defaultLogger.Error(msg, args...)
+	defaultLogger.Load().Error(msg, args...)
 }
 
 func Errorf(format string, args ...any) {
-	defaultLogger.Error(fmt.Sprintf(format, args...))
+	defaultLogger.Load().Error(fmt.Sprintf(format, args...))
 }
 
 func Fatal(msg string, args ...any) {
-	defaultLogger.Error(msg, args...)
+	defaultLogger.Load().Error(msg, args...)
 	exitFunc(1)
 }
 
 func Fatalf(format string, args ...any) {
-	defaultLogger.Error(fmt.Sprintf(format, args...))
+	defaultLogger.Load().Error(fmt.Sprintf(format, args...))
 	exitFunc(1)
 }
+
+// Logger 为携带固定字段的子 logger，每次输出时使用当前的 handler，运行时切换格式或输出目标后仍然生效
+type Logger struct {
+	attrs []any
+}
+
+// With 返回附带 args（键值对，与 slog 相同）的子 logger，字段作为独立属性输出，便于按字段过滤
+func With(args ...any) *Logger {
+	return &Logger{attrs: args}
+}
+
+// WithInstance 返回附带 instance_id 字段的子 logger
+func WithInstance(instanceID string) *Logger {
+	return With("instance_id", instanceID)
+}
+
+// With 在现有字段之后追加 args
+func (l *Logger) With(args ...any) *Logger {
+	attrs := make([]any, 0, len(l.attrs)+len(args))
+	return &Logger{attrs: append(append(attrs, l.attrs...), args...)}
+}
+
+// Slog 返回带固定字段的 *slog.Logger，基于调用时的 handler
+func (l *Logger) Slog() *slog.Logger {
+	return defaultLogger.Load().With(l.attrs...)
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	l.Slog().Debug(msg, args...)
+}
+
+func (l *Logger) Debugf(format string, args ...any) {
+	l.Slog().Debug(fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	l.Slog().Info(msg, args...)
+}
+
+func (l *Logger) Infof(format string, args ...any) {
+	l.Slog().Info(fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	l.Slog().Warn(msg, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...any) {
+	l.Slog().Warn(fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Error(msg string, args ...any) {
+	l.Slog().Error(msg, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...any) {
+	l.Slog().Error(fmt.Sprintf(format, args...))
+}
@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"log/slog"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +93,98 @@ func TestLogHelpersAndFatalUseConfiguredExit(t *testing.T) {
 		t.Fatalf("expected Fatal and Fatalf to invoke exit twice, got %d", calls)
 	}
 }
+
+func withOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	originalOutput, originalFormat := output, GetFormat()
+	output = &buf
+	t.Cleanup(func() {
+		output = originalOutput
+		SetFormat(originalFormat)
+	})
+	return &buf
+}
+
+func TestSetFormatJSONWritesValidJSONLines(t *testing.T) {
+	buf := withOutput(t)
+	currentLevel = &slog.LevelVar{}
+	if !SetFormat("JSON") || GetFormat() != FormatJSON {
+		t.Fatalf("expected json format to be applied, got %q", GetFormat())
+	}
+
+	Infof("download %s finished", "pkg.tar.gz")
+	Warn("slow transfer", "bucket", "packages", "bytes", 42)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+	var first, second map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("first line is not valid JSON: %v (%s)", err, lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("second line is not valid JSON: %v (%s)", err, lines[1])
+	}
+	if first["level"] != "INFO" || first["msg"] != "download pkg.tar.gz finished" || first["time"] == nil {
+		t.Fatalf("unexpected first record: %v", first)
+	}
+	if second["level"] != "WARN" || second["bucket"] != "packages" || second["bytes"] != float64(42) {
+		t.Fatalf("unexpected second record: %v", second)
+	}
+}
+
+func TestSetFormatKeepsLevelAndRejectsUnknownFormat(t *testing.T) {
+	buf := withOutput(t)
+	currentLevel = &slog.LevelVar{}
+	SetLevel("warn")
+	SetFormat(FormatText)
+	if SetFormat("xml") || GetFormat() != FormatText {
+		t.Fatalf("expected unknown format to be ignored, got %q", GetFormat())
+	}
+
+	Info("hidden")
+	Error("shown")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "level=ERROR msg=shown") {
+		t.Fatalf("unexpected text output: %q", got)
+	}
+}
+
+func TestSetFormatFromEnvDefaultsToText(t *testing.T) {
+	withOutput(t)
+	for env, want := range map[string]string{"json": FormatJSON, "text": FormatText, "": FormatText, "yaml": FormatText} {
+		t.Setenv("LOG_FORMAT", env)
+		SetFormat(FormatJSON)
+		setFormatFromEnv()
+		if got := GetFormat(); got != want {
+			t.Fatalf("LOG_FORMAT=%q: got %q, want %q", env, got, want)
+		}
+	}
+}
+
+func TestWithInstanceAddsStructuredField(t *testing.T) {
+	buf := withOutput(t)
+	currentLevel = &slog.LevelVar{}
+	SetFormat(FormatJSON)
+
+	// 子 logger 先于格式切换创建，输出仍应使用切换后的 handler
+	log := WithInstance("instance-a").With("subject", "local.execute")
+	SetFormat(FormatJSON)
+	log.Infof("[Local Execute] Executing %s", "uptime")
+
+	var record map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record); err != nil {
+		t.Fatalf("invalid JSON record %q: %v", buf.String(), err)
+	}
+	if record["instance_id"] != "instance-a" || record["subject"] != "local.execute" || record["msg"] != "[Local Execute] Executing uptime" {
+		t.Fatalf("unexpected record: %v", record)
+	}
+
+	buf.Reset()
+	SetFormat(FormatText)
+	log.Warn("slow", "elapsed_ms", 1200)
+	if got := buf.String(); !strings.Contains(got, "instance_id=instance-a") || !strings.Contains(got, "elapsed_ms=1200") {
+		t.Fatalf("expected fields in text output, got %q", got)
+	}
+}
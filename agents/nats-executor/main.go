@@ -6,40 +6,89 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"gopkg.in/yaml.v3"
 
+	"nats-executor/jetstream"
 	"nats-executor/local"
 	"nats-executor/logger"
 	"nats-executor/ssh"
+	"nats-executor/utils"
 )
 
-const version = "3.0.0"
+// defaultReconnectWait 为未配置 nats_reconnect_wait 时两次重连之间的间隔
+const defaultReconnectWait = 2 * time.Second
+
+// defaultShutdownTimeout 为未配置 shutdown_timeout 时等待处理中任务完成的时间
+const defaultShutdownTimeout = 30 * time.Second
+
+// drainPollInterval 为优雅关闭时检查 Drain 是否已关闭连接的间隔
+const drainPollInterval = 50 * time.Millisecond
+
+// natsQueueGroupEnv 为未在配置文件中设置 nats_queue_group 时读取的环境变量
+const natsQueueGroupEnv = "NATS_QUEUE_GROUP"
+
+// responseOffloadTimeout 为超大响应输出写入对象存储的超时
+const responseOffloadTimeout = 30 * time.Second
 
 var (
 	subscribeLocalExecutor    = local.SubscribeLocalExecutor
 	subscribeDownloadToLocal  = local.SubscribeDownloadToLocal
 	subscribeUnzipToLocal     = local.SubscribeUnzipToLocal
 	subscribeHealthCheck      = local.SubscribeHealthCheck
+	subscribeHealthLive       = local.SubscribeHealthLive
+	subscribeHealthReady      = local.SubscribeHealthReady
+	subscribeResultFetch      = local.SubscribeResultFetch
+	subscribeObjectTransfer   = local.SubscribeObjectStoreTransfer
+	subscribeObjectUpload     = local.SubscribeObjectStoreUpload
+	subscribeJobsList         = local.SubscribeJobsList
+	subscribeTail             = local.SubscribeTail
+	subscribeLimits           = local.SubscribeLimits
+	subscribeLogLevel         = SubscribeLogLevel
+	subscribeUsage            = local.SubscribeUsage
+	subscribeBucketsList      = local.SubscribeBucketsList
+	subscribeObjectsList      = local.SubscribeObjectsList
+	subscribeObjectDelete     = local.SubscribeObjectDelete
+	subscribeLocalCancel      = local.SubscribeLocalCancel
+	subscribeObjectStoreRun   = local.SubscribeObjectStoreRun
 	subscribeSSHExecutor      = ssh.SubscribeSSHExecutor
 	subscribeDownloadToRemote = ssh.SubscribeDownloadToRemote
 	subscribeUploadToRemote   = ssh.SubscribeUploadToRemote
+	subscribeSSHCancel        = ssh.SubscribeSSHCancel
+	subscribeSSHBatchExecutor = ssh.SubscribeSSHBatchExecutor
 	connectNATS               = nats.Connect
 	closeNATSConn             = func(nc *nats.Conn) { nc.Close() }
+	drainNATSConn             = func(nc *nats.Conn) error { return nc.Drain() }
+	natsConnClosed            = func(nc *nats.Conn) bool { return nc.IsClosed() }
 	loadConfigFn              = loadConfig
 	buildNATSOptionsFn        = buildNATSOptions
 	registerSubscriptionsFn   = registerSubscriptions
+	redeliverPendingResults   = local.RedeliverPendingResults
+	startMetricsServerFn      = startMetricsServer
+	subscribeConfigReloadFn   = SubscribeConfigReload
+	checkShellsFn             = local.CheckShells
 )
 
 type Config struct {
 	NATSUrls        string `yaml:"nats_urls"`
 	NATSInstanceID  string `yaml:"nats_instanceId"`
 	NatsConnTimeout int    `yaml:"nats_conn_timeout"`
+	// 断线后两次重连之间的间隔（秒），0 表示使用默认值 2
+	NATSReconnectWait int `yaml:"nats_reconnect_wait"`
+	// 最大重连次数，0 表示无限重连（默认），正数为次数上限，耗尽后连接关闭
+	NATSMaxReconnects int `yaml:"nats_max_reconnects"`
+	// 断线期间缓冲待发送消息的字节数，0 表示使用 nats 默认值 8MB
+	NATSReconnectBufSize int `yaml:"nats_reconnect_buf_size"`
+	// 执行与传输类主题的 queue group，同组实例中每条消息只由一个实例处理；为空时取环境变量 NATS_QUEUE_GROUP
+	NATSQueueGroup string `yaml:"nats_queue_group"`
 
 	// TLS 配置（都先用 string，后面自己解析）
 	TLSEnabled    string `yaml:"tls_enabled"`
@@ -48,6 +97,36 @@ type Config struct {
 	TLSCertFile   string `yaml:"tls_cert_file"`
 	TLSKeyFile    string `yaml:"tls_key_file"`
 	TLSSkipVerify string `yaml:"tls_skip_verify"`
+
+	// Prometheus 指标监听地址（如 ":9105"），为空时不启用
+	MetricsListen string `yaml:"metrics_listen"`
+
+	// 日志级别（debug/info/warn/error），为空时沿用 LOG_LEVEL；可通过 config.reload 热更新
+	LogLevel string `yaml:"log_level"`
+
+	// 单条响应最大字节数，为 0 时取 NATS 服务端的 max_payload
+	MaxResponseBytes int `yaml:"max_response_bytes"`
+	// 响应超限时的处理方式：truncate（默认，截断输出）或 offload（完整输出写入 response_offload_bucket）
+	ResponseSizePolicy    string `yaml:"response_size_policy"`
+	ResponseOffloadBucket string `yaml:"response_offload_bucket"`
+	// 输出超过该字节数时自动 gzip 压缩并以 output_encoding 标记，0 表示不压缩
+	CompressOutputThreshold int `yaml:"compress_output_threshold"`
+
+	// 同时执行的 local.execute / ssh.execute 数量，0 表示不限制；可通过 limits.set 在 ceiling 内调整
+	MaxConcurrentJobs        int `yaml:"max_concurrent_jobs"`
+	MaxConcurrentJobsCeiling int `yaml:"max_concurrent_jobs_ceiling"`
+
+	// tail.<job_id> 为每个执行中任务保留的最近输出行数，0 表示使用默认值 200
+	TailBufferLines int `yaml:"tail_buffer_lines"`
+
+	// 启动时必须可用的 shell（逗号分隔，如 "bash,pwsh"），缺失时拒绝启动
+	RequiredShells string `yaml:"required_shells"`
+
+	// respond 时 NATS 连接已关闭的执行结果转存到该 bucket，可通过 result.fetch 补取；为空时不转存
+	ResultBucket string `yaml:"result_bucket"`
+
+	// 收到 SIGINT/SIGTERM 后等待处理中任务完成的最长时间（秒），0 表示使用默认值 30
+	ShutdownTimeout int `yaml:"shutdown_timeout"`
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -63,12 +142,19 @@ func loadConfig(path string) (*Config, error) {
 	// 渲染所有 string 配置中的环境变量占位符，避免 TLS/实例 ID 等字段静默失效。
 	cfg.NATSUrls = renderEnvVars(cfg.NATSUrls)
 	cfg.NATSInstanceID = renderEnvVars(cfg.NATSInstanceID)
+	cfg.NATSQueueGroup = renderEnvVars(cfg.NATSQueueGroup)
 	cfg.TLSEnabled = renderEnvVars(cfg.TLSEnabled)
 	cfg.TLSHostname = renderEnvVars(cfg.TLSHostname)
 	cfg.TLSCAFile = renderEnvVars(cfg.TLSCAFile)
 	cfg.TLSCertFile = renderEnvVars(cfg.TLSCertFile)
 	cfg.TLSKeyFile = renderEnvVars(cfg.TLSKeyFile)
 	cfg.TLSSkipVerify = renderEnvVars(cfg.TLSSkipVerify)
+	cfg.MetricsListen = renderEnvVars(cfg.MetricsListen)
+	cfg.LogLevel = renderEnvVars(cfg.LogLevel)
+	cfg.ResponseSizePolicy = renderEnvVars(cfg.ResponseSizePolicy)
+	cfg.ResponseOffloadBucket = renderEnvVars(cfg.ResponseOffloadBucket)
+	cfg.RequiredShells = renderEnvVars(cfg.RequiredShells)
+	cfg.ResultBucket = renderEnvVars(cfg.ResultBucket)
 
 	return &cfg, nil
 }
@@ -117,6 +203,17 @@ func parseBool(s string) bool {
 	}
 }
 
+// parseList 解析逗号分隔的配置项，忽略空项并统一小写
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(parseString(s), ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // 解析 string 类型（占位符 -> ""）
 func parseString(s string) string {
 	if isPlaceholder(s) {
@@ -185,6 +282,40 @@ func buildNATSOptions(cfg *Config) ([]nats.Option, error) {
 		nats.Name("nats-executor"),
 		nats.Compression(true),
 		nats.Timeout(time.Duration(cfg.NatsConnTimeout) * time.Second),
+		// 断线后持续重连，期间发布的消息写入缓冲，重连后 nats 库自动恢复全部订阅
+		nats.MaxReconnects(resolveMaxReconnects(cfg)),
+		nats.ReconnectWait(resolveReconnectWait(cfg)),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				logger.Warnf("Disconnected from NATS server: %v, reconnecting", err)
+				return
+			}
+			logger.Info("Disconnected from NATS server")
+		}),
+		// 重连后丢弃缓存的对象存储客户端，并补发 respond 阶段因断线丢失的任务结果
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Infof("Reconnected to NATS server %s (reconnects: %d)", nc.ConnectedUrlRedacted(), nc.Stats().Reconnects)
+			jetstream.InvalidateJetStreamClients(nc)
+			redeliverPendingResults(nc)
+			utils.NotifyReconnect(nc)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			if err := nc.LastError(); err != nil {
+				logger.Warnf("NATS connection closed: %v", err)
+				return
+			}
+			logger.Info("NATS connection closed")
+		}),
+		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			if sub != nil {
+				logger.Errorf("NATS async error on subject %s: %v", sub.Subject, err)
+				return
+			}
+			logger.Errorf("NATS async error: %v", err)
+		}),
+	}
+	if cfg.NATSReconnectBufSize > 0 {
+		opts = append(opts, nats.ReconnectBufSize(cfg.NATSReconnectBufSize))
 	}
 
 	tlsConfig, err := buildTLSConfig(cfg)
@@ -198,15 +329,127 @@ func buildNATSOptions(cfg *Config) ([]nats.Option, error) {
 	return opts, nil
 }
 
+// resolveMaxReconnects 将 nats_max_reconnects 转换为 nats 选项，未配置时无限重连
+func resolveMaxReconnects(cfg *Config) int {
+	if cfg.NATSMaxReconnects > 0 {
+		return cfg.NATSMaxReconnects
+	}
+	return -1
+}
+
+// resolveReconnectWait 返回两次重连之间的间隔，未配置时为 defaultReconnectWait
+func resolveReconnectWait(cfg *Config) time.Duration {
+	if cfg.NATSReconnectWait > 0 {
+		return time.Duration(cfg.NATSReconnectWait) * time.Second
+	}
+	return defaultReconnectWait
+}
+
+// configureResultStore 设置未送达结果的转存；原连接已关闭时另建短连接写入对象存储
+func configureResultStore(cfg *Config, nc *nats.Conn, opts []nats.Option) {
+	bucket := parseString(cfg.ResultBucket)
+	if bucket == "" {
+		utils.SetResultStoreConfig(utils.ResultStoreConfig{})
+		return
+	}
+	utils.SetResultStoreConfig(utils.ResultStoreConfig{
+		Bucket: bucket,
+		Save: func(bucket, key string, data []byte) error {
+			conn := nc
+			if conn.IsClosed() {
+				fresh, err := connectNATS(cfg.NATSUrls, opts...)
+				if err != nil {
+					return fmt.Errorf("failed to reconnect to NATS server: %w", err)
+				}
+				defer closeNATSConn(fresh)
+				conn = fresh
+			}
+			return utils.UploadBytes(conn, bucket, key, data, responseOffloadTimeout)
+		},
+		Load: func(bucket, key string) ([]byte, error) {
+			return utils.DownloadBytes(nc, bucket, key, responseOffloadTimeout)
+		},
+	})
+	logger.Infof("Undelivered results will be persisted to bucket %s", bucket)
+}
+
 func registerSubscriptions(nc *nats.Conn, instanceID string) {
 	subscribeLocalExecutor(nc, &instanceID)
 	subscribeDownloadToLocal(nc, &instanceID)
 	subscribeUnzipToLocal(nc, &instanceID)
 	subscribeHealthCheck(nc, &instanceID)
+	subscribeHealthLive(nc, &instanceID)
+	subscribeHealthReady(nc, &instanceID)
+	subscribeResultFetch(nc, &instanceID)
+	subscribeObjectTransfer(nc, &instanceID)
+	subscribeObjectUpload(nc, &instanceID)
+	subscribeJobsList(nc, &instanceID)
+	subscribeTail(nc, &instanceID)
+	subscribeLimits(nc, &instanceID)
+	subscribeLogLevel(nc, &instanceID)
+	subscribeUsage(nc, &instanceID)
+	subscribeBucketsList(nc, &instanceID)
+	subscribeObjectsList(nc, &instanceID)
+	subscribeObjectDelete(nc, &instanceID)
+	subscribeLocalCancel(nc, &instanceID)
+	subscribeObjectStoreRun(nc, &instanceID)
 
 	subscribeSSHExecutor(nc, &instanceID)
 	subscribeDownloadToRemote(nc, &instanceID)
 	subscribeUploadToRemote(nc, &instanceID)
+	subscribeSSHCancel(nc, &instanceID)
+	subscribeSSHBatchExecutor(nc, &instanceID)
+}
+
+// configureResponseSize 设置执行响应的大小上限，未配置时以服务端 max_payload 为准
+func configureResponseSize(cfg *Config, nc *nats.Conn) {
+	maxBytes := cfg.MaxResponseBytes
+	if serverMax := int(nc.MaxPayload()); serverMax > 0 && (maxBytes <= 0 || maxBytes > serverMax) {
+		if maxBytes > serverMax {
+			logger.Warnf("max_response_bytes=%d exceeds server max_payload=%d, using server limit", maxBytes, serverMax)
+		}
+		maxBytes = serverMax
+	}
+	policy := parseString(cfg.ResponseSizePolicy)
+	if policy == "" {
+		policy = utils.ResponseSizePolicyTruncate
+	}
+	bucket := parseString(cfg.ResponseOffloadBucket)
+	if policy == utils.ResponseSizePolicyOffload && bucket == "" {
+		logger.Warn("response_size_policy is offload but response_offload_bucket is empty, oversized responses will be truncated")
+	}
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{
+		MaxBytes:      maxBytes,
+		Policy:        policy,
+		OffloadBucket: bucket,
+		Offload: func(bucket, key string, data []byte) error {
+			return utils.UploadBytes(nc, bucket, key, data, responseOffloadTimeout)
+		},
+		CompressThreshold: cfg.CompressOutputThreshold,
+	})
+	logger.Infof("Response size limit: %dB (policy: %s)", maxBytes, policy)
+	if cfg.CompressOutputThreshold > 0 {
+		logger.Infof("Outputs larger than %dB are returned gzip-compressed", cfg.CompressOutputThreshold)
+	}
+}
+
+// resolveQueueGroup 返回配置的 queue group，配置文件未设置时取环境变量 NATS_QUEUE_GROUP
+func resolveQueueGroup(cfg *Config) string {
+	if queueGroup := parseString(cfg.NATSQueueGroup); queueGroup != "" {
+		return queueGroup
+	}
+	return strings.TrimSpace(os.Getenv(natsQueueGroupEnv))
+}
+
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", utils.DefaultMetrics.Handler())
+	go func() {
+		logger.Infof("Metrics endpoint listening on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Errorf("Metrics endpoint stopped: %v", err)
+		}
+	}()
 }
 
 func run(args []string, stdout io.Writer, wait func()) error {
@@ -215,7 +458,7 @@ func run(args []string, stdout io.Writer, wait func()) error {
 		return fmt.Errorf("failed to parse arguments: %w", err)
 	}
 	if showVersion {
-		_, err := fmt.Fprintln(stdout, version)
+		_, err := fmt.Fprintln(stdout, utils.Version)
 		return err
 	}
 
@@ -231,6 +474,26 @@ func run(args []string, stdout io.Writer, wait func()) error {
 	if cfg.NATSInstanceID == "" || isPlaceholder(cfg.NATSInstanceID) {
 		return fmt.Errorf("invalid NATSInstanceID %q: must be a resolved non-empty value", cfg.NATSInstanceID)
 	}
+	if err := applyLiveConfig(cfg); err != nil {
+		return err
+	}
+	if policy := parseString(cfg.ResponseSizePolicy); !utils.IsKnownResponseSizePolicy(policy) {
+		return fmt.Errorf("invalid response_size_policy %q: must be truncate or offload", policy)
+	}
+	if err := checkShellsFn(parseList(cfg.RequiredShells)); err != nil {
+		return err
+	}
+	if cfg.TailBufferLines < 0 {
+		return fmt.Errorf("invalid tail_buffer_lines %d: must not be negative", cfg.TailBufferLines)
+	}
+	utils.DefaultTails.SetCapacity(cfg.TailBufferLines)
+	if err := utils.DefaultLimits.Configure(utils.Limits{MaxConcurrentJobs: cfg.MaxConcurrentJobs}, cfg.MaxConcurrentJobsCeiling); err != nil {
+		return fmt.Errorf("invalid concurrency limits: %w", err)
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("invalid shutdown_timeout %d: must not be negative", cfg.ShutdownTimeout)
+	}
+	shutdownTimeout := resolveShutdownTimeout(cfg)
 
 	opts, err := buildNATSOptionsFn(cfg)
 	if err != nil {
@@ -239,6 +502,8 @@ func run(args []string, stdout io.Writer, wait func()) error {
 	if parseBool(cfg.TLSEnabled) {
 		logger.Info("TLS enabled for NATS connection")
 	}
+	// Drain 等待订阅回调结束的时间与优雅关闭一致，避免 nats 库先行关闭连接导致响应丢失
+	opts = append(opts, nats.DrainTimeout(shutdownTimeout))
 
 	nc, err := connectNATS(cfg.NATSUrls, opts...)
 	if err != nil {
@@ -250,18 +515,82 @@ func run(args []string, stdout io.Writer, wait func()) error {
 		}
 	}()
 	logger.Info("Connected to NATS server")
+	configureResponseSize(cfg, nc)
+	configureResultStore(cfg, nc, opts)
 
+	if queueGroup := resolveQueueGroup(cfg); queueGroup != "" {
+		utils.SetQueueGroup(queueGroup)
+		logger.Infof("Execute and transfer subjects use queue group %s", queueGroup)
+	}
 	registerSubscriptionsFn(nc, cfg.NATSInstanceID)
+	subscribeConfigReloadFn(nc, cfg.NATSInstanceID, newConfigReloader(configPath, cfg))
+
+	if metricsListen := parseString(cfg.MetricsListen); metricsListen != "" {
+		startMetricsServerFn(metricsListen)
+	}
 
 	logger.Infof("Waiting for messages... (log level: %s)", logger.GetLevel())
 	wait()
+	gracefulShutdown(nc, shutdownTimeout)
 	return nil
 }
 
+// resolveShutdownTimeout 返回优雅关闭的等待时间，未配置时为 defaultShutdownTimeout
+func resolveShutdownTimeout(cfg *Config) time.Duration {
+	if cfg.ShutdownTimeout > 0 {
+		return time.Duration(cfg.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// gracefulShutdown 停止接收新消息，在 timeout 内等待处理中的任务完成、Drain 发出剩余响应并关闭连接，
+// 超时则记录未完成的任务
+func gracefulShutdown(nc *nats.Conn, timeout time.Duration) {
+	logger.Infof("Shutting down: draining NATS subscriptions, waiting up to %s for in-flight tasks", timeout)
+	deadline := time.Now().Add(timeout)
+	drainErr := drainNATSConn(nc)
+	if drainErr != nil {
+		logger.Warnf("Failed to drain NATS connection: %v", drainErr)
+	}
+	// 退出前关闭连接池中的空闲 SSH 连接，借出中的连接由各自请求结束时关闭
+	defer ssh.CloseIdleConnections()
+	if utils.DefaultMetrics.Wait(time.Until(deadline)) {
+		logger.Info("All in-flight tasks finished")
+		// Drain 是异步的，等其把剩余响应发出并关闭连接后再退出
+		if drainErr == nil && !waitNATSConnClosed(nc, deadline) {
+			logger.Warnf("NATS connection still draining after %s, closing it", timeout)
+		}
+		return
+	}
+	running := utils.DefaultJobs.List(utils.JobStatusRunning)
+	logger.Warnf("Shutdown timeout after %s, %d in-flight operations unfinished", timeout, utils.DefaultMetrics.TotalInFlight())
+	for _, job := range running {
+		logger.Warnf("Unfinished task: id=%s operation=%s started_at=%s command=%s", job.ID, job.Operation, job.StartedAt.Format(time.RFC3339), job.Command)
+	}
+}
+
+// waitNATSConnClosed 轮询直到 Drain 关闭连接或到达 deadline，连接已关闭时返回 true
+func waitNATSConnClosed(nc *nats.Conn, deadline time.Time) bool {
+	for !natsConnClosed(nc) {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// waitForShutdownSignal 阻塞直到收到 SIGINT 或 SIGTERM
+func waitForShutdownSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+	logger.Infof("Received signal %s", sig)
+}
+
 func main() {
-	if err := run(os.Args[1:], os.Stdout, func() {
-		select {}
-	}); err != nil {
+	if err := run(os.Args[1:], os.Stdout, waitForShutdownSignal); err != nil {
 		logger.Fatal(err.Error())
 	}
 }
@@ -10,12 +10,16 @@ import (
 	"errors"
 	"io"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 )
 
@@ -146,8 +150,8 @@ func TestBuildNATSOptionsAddsTLSOptionWhenEnabled(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
-	if len(opts) != 4 {
-		t.Fatalf("expected 4 NATS options with TLS enabled, got %d", len(opts))
+	if len(opts) != 10 {
+		t.Fatalf("expected 10 NATS options with TLS enabled, got %d", len(opts))
 	}
 }
 
@@ -156,8 +160,47 @@ func TestBuildNATSOptionsKeepsBaseOptionsWithoutTLS(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
-	if len(opts) != 3 {
-		t.Fatalf("expected 3 base NATS options, got %d", len(opts))
+	if len(opts) != 9 {
+		t.Fatalf("expected 9 base NATS options, got %d", len(opts))
+	}
+}
+
+func applyNATSOptions(t *testing.T, opts []nats.Option) nats.Options {
+	t.Helper()
+	options := nats.GetDefaultOptions()
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			t.Fatalf("apply NATS option: %v", err)
+		}
+	}
+	return options
+}
+
+func TestBuildNATSOptionsReconnectsForeverByDefault(t *testing.T) {
+	opts, err := buildNATSOptions(&Config{NatsConnTimeout: 3})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	options := applyNATSOptions(t, opts)
+	if !options.AllowReconnect || options.MaxReconnect != -1 || options.ReconnectWait != defaultReconnectWait {
+		t.Fatalf("unexpected reconnect settings: allow=%v max=%d wait=%s", options.AllowReconnect, options.MaxReconnect, options.ReconnectWait)
+	}
+	if options.ReconnectBufSize != nats.DefaultReconnectBufSize {
+		t.Fatalf("expected default reconnect buffer, got %d", options.ReconnectBufSize)
+	}
+	if options.DisconnectedErrCB == nil || options.ReconnectedCB == nil || options.ClosedCB == nil || options.AsyncErrorCB == nil {
+		t.Fatal("expected connection event handlers to be set")
+	}
+}
+
+func TestBuildNATSOptionsUsesConfiguredReconnectSettings(t *testing.T) {
+	opts, err := buildNATSOptions(&Config{NatsConnTimeout: 3, NATSReconnectWait: 5, NATSMaxReconnects: 20, NATSReconnectBufSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	options := applyNATSOptions(t, opts)
+	if options.MaxReconnect != 20 || options.ReconnectWait != 5*time.Second || options.ReconnectBufSize != 1<<20 {
+		t.Fatalf("unexpected reconnect settings: max=%d wait=%s buf=%d", options.MaxReconnect, options.ReconnectWait, options.ReconnectBufSize)
 	}
 }
 
@@ -166,12 +209,24 @@ func TestRunScenarios(t *testing.T) {
 	originalBuildNATSOptions := buildNATSOptionsFn
 	originalConnectNATS := connectNATS
 	originalCloseNATSConn := closeNATSConn
+	originalDrainNATSConn := drainNATSConn
+	originalNATSConnClosed := natsConnClosed
+	natsConnClosed = func(nc *nats.Conn) bool { return true }
 	originalRegisterSubscriptions := registerSubscriptionsFn
+	originalSubscribeConfigReload := subscribeConfigReloadFn
+	originalCheckShells := checkShellsFn
+	drainNATSConn = func(nc *nats.Conn) error { return nil }
+	subscribeConfigReloadFn = func(nc *nats.Conn, instanceID string, reloader *configReloader) {}
+	checkShellsFn = func(required []string) error { return nil }
 	defer func() {
+		checkShellsFn = originalCheckShells
+		subscribeConfigReloadFn = originalSubscribeConfigReload
 		loadConfigFn = originalLoadConfig
 		buildNATSOptionsFn = originalBuildNATSOptions
 		connectNATS = originalConnectNATS
 		closeNATSConn = originalCloseNATSConn
+		drainNATSConn = originalDrainNATSConn
+		natsConnClosed = originalNATSConnClosed
 		registerSubscriptionsFn = originalRegisterSubscriptions
 	}()
 
@@ -180,7 +235,7 @@ func TestRunScenarios(t *testing.T) {
 		if err := run([]string{"version"}, &stdout, func() { t.Fatal("wait should not be called") }); err != nil {
 			t.Fatalf("expected no error, got %v", err)
 		}
-		if got := stdout.String(); got != version+"\n" {
+		if got := stdout.String(); got != utils.Version+"\n" {
 			t.Fatalf("unexpected version output: %q", got)
 		}
 	})
@@ -225,6 +280,68 @@ func TestRunScenarios(t *testing.T) {
 		}
 	})
 
+	t.Run("starts metrics endpoint when configured", func(t *testing.T) {
+		originalStartMetrics := startMetricsServerFn
+		defer func() { startMetricsServerFn = originalStartMetrics }()
+
+		loadConfigFn = func(path string) (*Config, error) {
+			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", MetricsListen: " 127.0.0.1:9105 "}, nil
+		}
+		buildNATSOptionsFn = func(cfg *Config) ([]nats.Option, error) { return nil, nil }
+		connectNATS = func(url string, options ...nats.Option) (*nats.Conn, error) { return &nats.Conn{}, nil }
+		closeNATSConn = func(nc *nats.Conn) {}
+		registerSubscriptionsFn = func(nc *nats.Conn, instanceID string) {}
+
+		var metricsAddr string
+		startMetricsServerFn = func(addr string) { metricsAddr = addr }
+		if err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() {}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if metricsAddr != "127.0.0.1:9105" {
+			t.Fatalf("unexpected metrics address: %q", metricsAddr)
+		}
+	})
+
+	t.Run("invalid log level fails startup", func(t *testing.T) {
+		loadConfigFn = func(path string) (*Config, error) {
+			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", LogLevel: "verbose"}, nil
+		}
+		err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() {})
+		if err == nil || !strings.Contains(err.Error(), `invalid log_level "verbose"`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid response size policy fails startup", func(t *testing.T) {
+		loadConfigFn = func(path string) (*Config, error) {
+			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", ResponseSizePolicy: "drop"}, nil
+		}
+		err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() {})
+		if err == nil || !strings.Contains(err.Error(), `invalid response_size_policy "drop"`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("missing required shell fails startup", func(t *testing.T) {
+		loadConfigFn = func(path string) (*Config, error) {
+			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", RequiredShells: " Bash, ,pwsh "}, nil
+		}
+		var required []string
+		checkShellsFn = func(shells []string) error {
+			required = shells
+			return errors.New("required shells not available: pwsh")
+		}
+		defer func() { checkShellsFn = func([]string) error { return nil } }()
+
+		err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() {})
+		if err == nil || !strings.Contains(err.Error(), "required shells not available: pwsh") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(required, ",") != "bash,pwsh" {
+			t.Fatalf("unexpected required shells: %v", required)
+		}
+	})
+
 	t.Run("registers subscriptions and waits", func(t *testing.T) {
 		loadConfigFn = func(path string) (*Config, error) {
 			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", TLSEnabled: "false"}, nil
@@ -237,8 +354,16 @@ func TestRunScenarios(t *testing.T) {
 			return &nats.Conn{}, nil
 		}
 
-		var closed, waited bool
+		var closed, waited, drained bool
 		closeNATSConn = func(nc *nats.Conn) { closed = true }
+		drainNATSConn = func(nc *nats.Conn) error {
+			if !waited || closed {
+				t.Fatalf("expected drain after wait and before close, waited=%v closed=%v", waited, closed)
+			}
+			drained = true
+			return nil
+		}
+		defer func() { drainNATSConn = func(nc *nats.Conn) error { return nil } }()
 		registerSubscriptionsFn = func(nc *nats.Conn, instanceID string) {
 			if nc == nil || instanceID != "instance-1" {
 				t.Fatalf("unexpected registration inputs: nc=%#v instanceID=%q", nc, instanceID)
@@ -248,8 +373,174 @@ func TestRunScenarios(t *testing.T) {
 		if err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() { waited = true }); err != nil {
 			t.Fatalf("expected no error, got %v", err)
 		}
-		if !closed || !waited {
-			t.Fatalf("expected close and wait to run, closed=%v waited=%v", closed, waited)
+		if !closed || !waited || !drained {
+			t.Fatalf("expected wait, drain and close to run, closed=%v waited=%v drained=%v", closed, waited, drained)
+		}
+	})
+
+	t.Run("negative shutdown timeout fails startup", func(t *testing.T) {
+		loadConfigFn = func(path string) (*Config, error) {
+			return &Config{NATSUrls: "nats://demo:4222", NATSInstanceID: "instance-1", ShutdownTimeout: -1}, nil
+		}
+		err := run([]string{"--config", "/tmp/config.yaml"}, io.Discard, func() {})
+		if err == nil || !strings.Contains(err.Error(), "invalid shutdown_timeout -1") {
+			t.Fatalf("unexpected error: %v", err)
 		}
 	})
 }
+
+func TestGracefulShutdownWaitsForInFlightTasks(t *testing.T) {
+	originalDrain := drainNATSConn
+	defer func() { drainNATSConn = originalDrain }()
+	drainNATSConn = func(nc *nats.Conn) error { return errors.New("not connected") }
+
+	finish := utils.DefaultMetrics.Begin("local.execute")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		finish()
+	}()
+	start := time.Now()
+	gracefulShutdown(nil, time.Second)
+	if utils.DefaultMetrics.TotalInFlight() != 0 {
+		t.Fatal("expected shutdown to wait for the in-flight task")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("expected shutdown to return once tasks finished, took %s", elapsed)
+	}
+}
+
+func TestGracefulShutdownGivesUpAfterTimeout(t *testing.T) {
+	originalDrain := drainNATSConn
+	defer func() { drainNATSConn = originalDrain }()
+	drainNATSConn = func(nc *nats.Conn) error { return nil }
+
+	finish := utils.DefaultMetrics.Begin("ssh.execute")
+	defer finish()
+	done := utils.DefaultJobs.Track(utils.JobInfo{ID: "stuck-job", Operation: "ssh.execute", Command: "sleep 600"})
+	defer done()
+
+	start := time.Now()
+	gracefulShutdown(nil, 30*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected shutdown to stop waiting after the timeout, took %s", elapsed)
+	}
+}
+
+func TestResolveShutdownTimeout(t *testing.T) {
+	if got := resolveShutdownTimeout(&Config{}); got != defaultShutdownTimeout {
+		t.Fatalf("expected default shutdown timeout, got %s", got)
+	}
+	if got := resolveShutdownTimeout(&Config{ShutdownTimeout: 90}); got != 90*time.Second {
+		t.Fatalf("expected configured shutdown timeout, got %s", got)
+	}
+}
+
+func TestNATSConnectionResumesSubscriptionsAfterServerRestart(t *testing.T) {
+	startServer := func(port int) *server.Server {
+		ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+		if err != nil {
+			t.Fatalf("start nats server: %v", err)
+		}
+		go ns.Start()
+		if !ns.ReadyForConnections(10 * time.Second) {
+			t.Fatal("nats server not ready")
+		}
+		return ns
+	}
+	ns := startServer(-1)
+	port := ns.Addr().(*net.TCPAddr).Port
+
+	opts, err := buildNATSOptions(&Config{NatsConnTimeout: 2})
+	if err != nil {
+		t.Fatalf("build options: %v", err)
+	}
+	reconnected := make(chan struct{}, 1)
+	opts = append(opts, nats.ReconnectWait(50*time.Millisecond), nats.ReconnectJitter(0, 0))
+	utils.OnReconnect(func(nc *nats.Conn) {
+		select {
+		case reconnected <- struct{}{}:
+		default:
+		}
+	})
+	nc, err := nats.Connect(ns.ClientURL(), opts...)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	if _, err := nc.Subscribe("health.check.instance-1", func(msg *nats.Msg) { _ = msg.Respond([]byte("ok")) }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	ns.Shutdown()
+	ns.WaitForShutdown()
+	restarted := startServer(port)
+	defer restarted.Shutdown()
+
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected reconnect callback after server restart")
+	}
+	client, err := nats.Connect(restarted.ClientURL())
+	if err != nil {
+		t.Fatalf("connect client: %v", err)
+	}
+	defer client.Close()
+	reply, err := client.Request("health.check.instance-1", nil, 5*time.Second)
+	if err != nil || string(reply.Data) != "ok" {
+		t.Fatalf("expected subscription to resume after reconnect, reply=%v err=%v", reply, err)
+	}
+}
+
+func TestGracefulShutdownDeliversResponsesBeforeReturning(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL(), nats.DrainTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	started := make(chan struct{})
+	if _, err := nc.Subscribe("local.execute.instance-1", func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("local.execute")()
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_ = msg.Respond([]byte("done"))
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	client, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect client: %v", err)
+	}
+	defer client.Close()
+	replies := make(chan *nats.Msg, 1)
+	go func() {
+		reply, err := client.Request("local.execute.instance-1", nil, 5*time.Second)
+		if err != nil {
+			t.Errorf("request: %v", err)
+		}
+		replies <- reply
+	}()
+	<-started
+
+	gracefulShutdown(nc, 5*time.Second)
+	if !nc.IsClosed() {
+		t.Fatal("expected drained connection to be closed when shutdown returns")
+	}
+	if reply := <-replies; reply == nil || string(reply.Data) != "done" {
+		t.Fatalf("expected in-flight request to get its reply, got %v", reply)
+	}
+}
@@ -161,6 +161,20 @@ func TestParseString(t *testing.T) {
 	}
 }
 
+func TestResolveQueueGroupPrefersConfigOverEnv(t *testing.T) {
+	t.Setenv(natsQueueGroupEnv, "from-env")
+	if got := resolveQueueGroup(&Config{NATSQueueGroup: " from-config "}); got != "from-config" {
+		t.Fatalf("expected config queue group, got %q", got)
+	}
+	if got := resolveQueueGroup(&Config{NATSQueueGroup: "${UNSET_QUEUE_GROUP}"}); got != "from-env" {
+		t.Fatalf("unresolved placeholder should fall back to env, got %q", got)
+	}
+	t.Setenv(natsQueueGroupEnv, "")
+	if got := resolveQueueGroup(&Config{}); got != "" {
+		t.Fatalf("expected queue group to be disabled, got %q", got)
+	}
+}
+
 func TestParseCLIArgsSupportsVersionSubcommand(t *testing.T) {
 	configPath, showVersion, err := parseCLIArgs([]string{"version"})
 	if err != nil {
@@ -199,17 +213,51 @@ func TestRegisterSubscriptionsRegistersAllHandlers(t *testing.T) {
 	originalDownloadToLocal := subscribeDownloadToLocal
 	originalUnzipToLocal := subscribeUnzipToLocal
 	originalHealthCheck := subscribeHealthCheck
+	originalHealthLive := subscribeHealthLive
+	originalHealthReady := subscribeHealthReady
+	originalResultFetch := subscribeResultFetch
+	originalObjectTransfer := subscribeObjectTransfer
+	originalObjectUpload := subscribeObjectUpload
+	originalJobsList := subscribeJobsList
+	originalTail := subscribeTail
+	originalLimits := subscribeLimits
+	originalUsage := subscribeUsage
+	originalBucketsList := subscribeBucketsList
+	originalLogLevel := subscribeLogLevel
+	originalObjectsList := subscribeObjectsList
+	originalObjectDelete := subscribeObjectDelete
+	originalLocalCancel := subscribeLocalCancel
+	originalObjectStoreRun := subscribeObjectStoreRun
 	originalSSHExecutor := subscribeSSHExecutor
 	originalDownloadToRemote := subscribeDownloadToRemote
 	originalUploadToRemote := subscribeUploadToRemote
+	originalSSHCancel := subscribeSSHCancel
+	originalSSHBatchExecutor := subscribeSSHBatchExecutor
 	defer func() {
 		subscribeLocalExecutor = originalLocalExecutor
 		subscribeDownloadToLocal = originalDownloadToLocal
 		subscribeUnzipToLocal = originalUnzipToLocal
 		subscribeHealthCheck = originalHealthCheck
+		subscribeHealthLive = originalHealthLive
+		subscribeHealthReady = originalHealthReady
+		subscribeResultFetch = originalResultFetch
+		subscribeObjectTransfer = originalObjectTransfer
+		subscribeObjectUpload = originalObjectUpload
+		subscribeJobsList = originalJobsList
+		subscribeTail = originalTail
+		subscribeLimits = originalLimits
+		subscribeUsage = originalUsage
+		subscribeBucketsList = originalBucketsList
+		subscribeLogLevel = originalLogLevel
+		subscribeObjectsList = originalObjectsList
+		subscribeObjectDelete = originalObjectDelete
+		subscribeLocalCancel = originalLocalCancel
+		subscribeObjectStoreRun = originalObjectStoreRun
 		subscribeSSHExecutor = originalSSHExecutor
 		subscribeDownloadToRemote = originalDownloadToRemote
 		subscribeUploadToRemote = originalUploadToRemote
+		subscribeSSHCancel = originalSSHCancel
+		subscribeSSHBatchExecutor = originalSSHBatchExecutor
 	}()
 
 	var calls []string
@@ -229,9 +277,26 @@ func TestRegisterSubscriptionsRegistersAllHandlers(t *testing.T) {
 	subscribeDownloadToLocal = record("download.local")
 	subscribeUnzipToLocal = record("unzip.local")
 	subscribeHealthCheck = record("health.check")
+	subscribeHealthLive = record("health.live")
+	subscribeHealthReady = record("health.ready")
+	subscribeResultFetch = record("result.fetch")
+	subscribeObjectTransfer = record("transfer.objectstore")
+	subscribeObjectUpload = record("upload.objectstore")
+	subscribeJobsList = record("jobs.list")
+	subscribeTail = record("tail")
+	subscribeLimits = record("limits")
+	subscribeLogLevel = record("log.level")
+	subscribeUsage = record("usage")
+	subscribeBucketsList = record("objectstore.buckets")
+	subscribeObjectsList = record("objectstore.list")
+	subscribeObjectDelete = record("objectstore.delete")
+	subscribeLocalCancel = record("local.cancel")
+	subscribeObjectStoreRun = record("run.objectstore")
 	subscribeSSHExecutor = record("ssh.execute")
 	subscribeDownloadToRemote = record("download.remote")
 	subscribeUploadToRemote = record("upload.remote")
+	subscribeSSHCancel = record("ssh.cancel")
+	subscribeSSHBatchExecutor = record("ssh.batch_execute")
 
 	registerSubscriptions(nil, "instance-1")
 
@@ -240,9 +305,26 @@ func TestRegisterSubscriptionsRegistersAllHandlers(t *testing.T) {
 		"download.local",
 		"unzip.local",
 		"health.check",
+		"health.live",
+		"health.ready",
+		"result.fetch",
+		"transfer.objectstore",
+		"upload.objectstore",
+		"jobs.list",
+		"tail",
+		"limits",
+		"log.level",
+		"usage",
+		"objectstore.buckets",
+		"objectstore.list",
+		"objectstore.delete",
+		"local.cancel",
+		"run.objectstore",
 		"ssh.execute",
 		"download.remote",
 		"upload.remote",
+		"ssh.cancel",
+		"ssh.batch_execute",
 	}
 	if len(calls) != len(expected) {
 		t.Fatalf("registered %d handlers, want %d (%v)", len(calls), len(expected), calls)
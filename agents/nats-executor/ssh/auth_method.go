@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	authMethodKey      = "key"
+	authMethodPassword = "password"
+)
+
+// authRecorder 记录最近一次被尝试的认证方式。客户端按 Auth 顺序逐个尝试，上一种被拒绝才会尝试下一种，
+// 因此握手成功时最后被尝试的即为实际通过认证的方式
+type authRecorder struct {
+	mu     sync.Mutex
+	method string
+}
+
+func (r *authRecorder) record(method string) {
+	r.mu.Lock()
+	r.method = method
+	r.mu.Unlock()
+}
+
+func (r *authRecorder) Method() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.method
+}
+
+// keyAuth 返回尝试时登记为 key 的私钥认证
+func (r *authRecorder) keyAuth(signer ssh.Signer) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		r.record(authMethodKey)
+		return []ssh.Signer{signer}, nil
+	})
+}
+
+// passwordAuth 返回尝试时登记为 password 的密码认证
+func (r *authRecorder) passwordAuth(password string) ssh.AuthMethod {
+	return ssh.PasswordCallback(func() (string, error) {
+		r.record(authMethodPassword)
+		return password, nil
+	})
+}
@@ -0,0 +1,149 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// handshakeWithServer 用真实的 SSH 握手校验客户端认证：服务端只接受 acceptKey 为 true 时的私钥与 password，
+// 握手成功后命令在本地 shell 中执行
+func handshakeWithServer(t *testing.T, signer gossh.Signer, acceptKey bool, password string) func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostSigner, err := gossh.NewSignerFromSigner(hostKey)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	serverConfig := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if acceptKey && string(key.Marshal()) == string(signer.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("public key rejected")
+		},
+		PasswordCallback: func(conn gossh.ConnMetadata, pass []byte) (*gossh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("password rejected")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer serverConn.Close()
+				if conn, _, _, err := gossh.NewServerConn(serverConn, serverConfig); err == nil {
+					conn.Close()
+				}
+			}()
+		}
+	}()
+
+	localShell := runWithLocalShell(t)
+	return func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		clientConfig := *config
+		clientConfig.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+		client, err := gossh.Dial("tcp", listener.Addr().String(), &clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		client.Close()
+		return localShell(network, addr, config)
+	}
+}
+
+func TestExecuteReportsAuthMethod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	signer, err := gossh.NewSignerFromSigner(clientKey)
+	if err != nil {
+		t.Fatalf("client signer: %v", err)
+	}
+	originalParse := parsePrivateKeyFn
+	originalDial := sshDialFn
+	parsePrivateKeyFn = func(pemBytes []byte) (gossh.Signer, error) { return signer, nil }
+	defer func() {
+		parsePrivateKeyFn = originalParse
+		sshDialFn = originalDial
+	}()
+
+	tests := []struct {
+		name       string
+		acceptKey  bool
+		privateKey string
+		authOrder  string
+		want       string
+	}{
+		{name: "key accepted", acceptKey: true, privateKey: "key", want: authMethodKey},
+		{name: "key rejected falls back to password", acceptKey: false, privateKey: "key", want: authMethodPassword},
+		{name: "password only", acceptKey: true, want: authMethodPassword},
+		{name: "password first", acceptKey: true, privateKey: "key", authOrder: authOrderPasswordFirst, want: authMethodPassword},
+	}
+	for _, tt := range tests {
+		sshDialFn = handshakeWithServer(t, signer, tt.acceptKey, "secret")
+		response := Execute(ExecuteRequest{
+			Command:        "echo ok",
+			ExecuteTimeout: 5,
+			Host:           "10.0.0.1",
+			Port:           22,
+			User:           "root",
+			Password:       "secret",
+			PrivateKey:     tt.privateKey,
+			AuthOrder:      tt.authOrder,
+		}, "instance-1")
+		if !response.Success || response.AuthMethod != tt.want {
+			t.Fatalf("%s: expected auth_method %q, got %+v", tt.name, tt.want, response)
+		}
+	}
+}
+
+func TestExecuteOmitsAuthMethodWhenAuthenticationFails(t *testing.T) {
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	signer, err := gossh.NewSignerFromSigner(clientKey)
+	if err != nil {
+		t.Fatalf("client signer: %v", err)
+	}
+	originalDial := sshDialFn
+	sshDialFn = handshakeWithServer(t, signer, false, "other")
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo ok",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+	}, "instance-1")
+	if response.Success || response.Category != sshCategoryAuth || response.AuthMethod != "" {
+		t.Fatalf("expected auth failure without auth_method, got %+v", response)
+	}
+}
@@ -0,0 +1,288 @@
+package ssh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultBatchWorkers = 10
+	maxBatchWorkers     = 64
+
+	batchTimedOutPerTarget = "per_target"
+	batchTimedOutBatch     = "batch"
+)
+
+var subscribeSSHBatchExecutorFn = subscribeSSHBatchExecutor
+
+// batchWorkers 返回同时执行的主机数：未设置时取默认值，不超过上限与主机数
+func batchWorkers(workers, hosts int) int {
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	if workers > maxBatchWorkers {
+		workers = maxBatchWorkers
+	}
+	if workers > hosts {
+		workers = hosts
+	}
+	return workers
+}
+
+// batchHostRequest 以 hosts[index] 的连接信息覆盖请求中的目标主机
+func batchHostRequest(req BatchExecuteRequest, index int) ExecuteRequest {
+	host := req.Hosts[index]
+	hostReq := req.ExecuteRequest
+	hostReq.Host = host.Host
+	if host.Port != 0 {
+		hostReq.Port = host.Port
+	}
+	if host.User != "" {
+		hostReq.User = host.User
+	}
+	if host.Password != "" || host.PrivateKey != "" {
+		hostReq.Password = host.Password
+		hostReq.PrivateKey = host.PrivateKey
+		hostReq.Passphrase = host.Passphrase
+	}
+	if host.HostKeyFingerprint != "" {
+		hostReq.HostKeyFingerprint = host.HostKeyFingerprint
+	}
+	return hostReq
+}
+
+// validateBatchExecuteRequest 在执行前校验全部主机，任一主机参数有误时整批不执行
+func validateBatchExecuteRequest(req BatchExecuteRequest) string {
+	switch {
+	case len(req.Hosts) == 0:
+		return "hosts is required"
+	case req.Workers < 0:
+		return "workers must not be negative"
+	case req.PerTargetTimeout < 0:
+		return "per_target_timeout must not be negative"
+	case req.BatchTimeout < 0:
+		return "batch_timeout must not be negative"
+	case req.TaskID != "" || req.Stream:
+		// 取消与输出流按 task_id 区分任务，多台主机共用一个 task_id 时无法区分
+		return "task_id and stream are not supported by ssh.batch_execute"
+	}
+	for i := range req.Hosts {
+		if strings.TrimSpace(req.Hosts[i].Host) == "" {
+			return fmt.Sprintf("hosts[%d].host is required", i)
+		}
+		hostReq := batchHostRequest(req, i)
+		message := ""
+		if len(hostReq.Commands) > 0 {
+			message = validateCommands(hostReq)
+		} else {
+			message = validateExecuteRequest(hostReq)
+		}
+		if message != "" {
+			return fmt.Sprintf("hosts[%d]: %s", i, message)
+		}
+	}
+	return ""
+}
+
+// executeBatch 以有界并发在各主机上执行命令；每台主机独立建连并受各自的超时约束，
+// 单台主机失败或卡住不影响其他主机。设置 batch_timeout 时整批共用一个截止时间
+func executeBatch(req BatchExecuteRequest, instanceId string, outputFilter *utils.OutputFilter) BatchExecuteResponse {
+	var batchDeadline time.Time
+	if req.BatchTimeout > 0 {
+		batchDeadline = time.Now().Add(time.Duration(req.BatchTimeout) * time.Second)
+	}
+	results := make([]BatchHostResult, len(req.Hosts))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < batchWorkers(req.Workers, len(req.Hosts)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = executeBatchHost(req, i, instanceId, batchDeadline, outputFilter)
+			}
+		}()
+	}
+	for i := range req.Hosts {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	response := BatchExecuteResponse{InstanceId: instanceId, Total: len(results), Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+		if result.TimedOut != "" {
+			response.TimedOut++
+		}
+	}
+	response.Success = response.Failed == 0
+	if !response.Success {
+		response.Code = utils.ErrorCodeExecutionFailure
+		response.Error = fmt.Sprintf("%d of %d hosts failed", response.Failed, response.Total)
+	}
+	return response
+}
+
+// batchHostDeadline 返回主机的执行时限及超时类型：per_target_timeout 与处理器超时取较小者，
+// 整批剩余时间更短时以整批超时为准
+func batchHostDeadline(req BatchExecuteRequest, hostReq ExecuteRequest, batchDeadline time.Time) (time.Duration, string) {
+	deadline := handlerDeadline(utils.ExecuteTimeout(hostReq.ExecuteTimeout) + utils.CleanupTimeout(hostReq.CleanupCommand, hostReq.CleanupTimeout))
+	if perTarget := time.Duration(req.PerTargetTimeout) * time.Second; perTarget > 0 && perTarget < deadline {
+		deadline = perTarget
+	}
+	if !batchDeadline.IsZero() {
+		if remaining := time.Until(batchDeadline); remaining < deadline {
+			return remaining, batchTimedOutBatch
+		}
+	}
+	return deadline, batchTimedOutPerTarget
+}
+
+func executeBatchHost(req BatchExecuteRequest, index int, instanceId string, batchDeadline time.Time, outputFilter *utils.OutputFilter) BatchHostResult {
+	hostReq := batchHostRequest(req, index)
+	result := BatchHostResult{Index: index, Host: hostReq.Host, Port: hostReq.Port}
+	deadline, scope := batchHostDeadline(req, hostReq, batchDeadline)
+	if deadline <= 0 {
+		message := fmt.Sprintf("Batch timeout exceeded before the host started (batch_timeout: %ds)", req.BatchTimeout)
+		result.TimedOut = batchTimedOutBatch
+		result.ExecuteResponse = timeoutStageResponse(instanceId, "", message, "", sshCategoryRemoteTimeout)
+		return result
+	}
+	response, timedOut := utils.RunWithDeadline(deadline, func(ctx context.Context) ExecuteResponse {
+		return executeSSHCommand(hostReq.withContext(ctx), instanceId)
+	}, func() ExecuteResponse {
+		message := fmt.Sprintf("Handler deadline exceeded after %s (timeout: %ds)", deadline, hostReq.ExecuteTimeout)
+		switch {
+		case scope == batchTimedOutBatch:
+			message = fmt.Sprintf("Batch timeout exceeded after %ds", req.BatchTimeout)
+		case req.PerTargetTimeout > 0 && deadline == time.Duration(req.PerTargetTimeout)*time.Second:
+			message = fmt.Sprintf("Per-target timeout exceeded after %ds", req.PerTargetTimeout)
+		}
+		return timeoutStageResponse(instanceId, "", message, sshStageCommandRun, sshCategoryRemoteTimeout)
+	})
+	if timedOut {
+		result.TimedOut = scope
+		logger.WithInstance(instanceId).Warnf("[SSH Batch Execute] Command on %s did not finish within %s (%s timeout)", hostReq.Host, deadline, scope)
+	}
+	if outputFilter != nil {
+		if hostReq.IncludeFullOutput {
+			response.FullOutput = response.Output
+		}
+		response.Output = outputFilter.Apply(response.Output)
+	}
+	result.ExecuteResponse = response
+	return result
+}
+
+// fitBatchExecuteResponse 响应超过 max_response_bytes 时舍弃各主机与 result 重复的 stdout / stderr / full_output
+func fitBatchExecuteResponse(instanceId string, response BatchExecuteResponse, payload []byte) []byte {
+	if !utils.ExceedsResponseLimit(len(payload)) {
+		return payload
+	}
+	for i := range response.Results {
+		result := &response.Results[i]
+		if result.Stdout != "" || result.Stderr != "" || result.FullOutput != "" {
+			result.Stdout = ""
+			result.Stderr = ""
+			result.FullOutput = ""
+			result.Truncated = true
+		}
+	}
+	trimmed, err := json.Marshal(response)
+	if err != nil {
+		return payload
+	}
+	if utils.ExceedsResponseLimit(len(trimmed)) {
+		logger.WithInstance(instanceId).Warnf("[SSH Batch Execute] Response size %dB still exceeds max_response_bytes after dropping stdout/stderr", len(trimmed))
+	}
+	return trimmed
+}
+
+func handleSSHBatchExecuteMessage(data []byte, instanceId string) ([]byte, bool) {
+	incoming, ok := decodeIncomingMessage(data)
+	if !ok {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
+	}
+	if mismatch := utils.InstanceMismatchResponse("SSH Batch Execute", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
+
+	var batchRequest BatchExecuteRequest
+	if err := json.Unmarshal(incoming.Args[0], &batchRequest); err != nil {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
+	}
+	if validationErr := validateBatchExecuteRequest(batchRequest); validationErr != "" {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, validationErr), true
+	}
+	outputFilter, err := utils.NewOutputFilter(batchRequest.OutputGrep, batchRequest.OutputGrepInvert)
+	if err != nil {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, err.Error()), true
+	}
+
+	// 整批占用一个并发名额，批内并发由 workers 控制
+	release, acquired := utils.DefaultLimits.Acquire("ssh.batch_execute")
+	if !acquired {
+		limit := utils.DefaultLimits.Snapshot().MaxConcurrentJobs
+		logger.WithInstance(instanceId).Warnf("[SSH Batch Execute] Rejecting request: max_concurrent_jobs=%d reached", limit)
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeTooManyRequests, fmt.Sprintf("max_concurrent_jobs limit %d reached", limit)), true
+	}
+	defer release()
+	defer utils.DefaultJobs.Track(utils.JobInfo{
+		ID:        batchRequest.ExecutionID,
+		Operation: "ssh.batch_execute",
+		Command:   batchRequest.Command,
+		Host:      fmt.Sprintf("%d hosts", len(batchRequest.Hosts)),
+	})()
+
+	logger.WithInstance(instanceId).Infof("[SSH Batch Execute] Executing on %d hosts with %d workers", len(batchRequest.Hosts), batchWorkers(batchRequest.Workers, len(batchRequest.Hosts)))
+	responseData := executeBatch(batchRequest, instanceId, outputFilter)
+	responseContent, _ := json.Marshal(responseData)
+	return fitBatchExecuteResponse(instanceId, responseData, responseContent), true
+}
+
+func respondSSHBatchExecuteSubscription(msg inboundMsg, instanceId string) bool {
+	responseContent, ok := handleSSHBatchExecuteMessage(msg.Payload(), instanceId)
+	if !ok {
+		logger.WithInstance(instanceId).Errorf("[SSH Batch Execute] Error unmarshalling incoming message")
+		return false
+	}
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[SSH Batch Execute] Error responding to batch request: %v", err)
+		utils.PersistUndeliveredResult("SSH Batch Execute", instanceId, utils.ResultIDFromRequest(msg.Payload()), responseContent, err)
+		return false
+	}
+	logger.WithInstance(instanceId).Debugf("[SSH Batch Execute] Response sent successfully, size: %d bytes", len(responseContent))
+	return true
+}
+
+func subscribeSSHBatchExecutor(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("ssh.batch_execute.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[SSH Batch Execute] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		defer utils.DefaultMetrics.Begin("ssh.batch_execute")()
+		logger.WithInstance(*instanceId).Debugf("[SSH Batch Execute] Received message, size: %d bytes", len(msg.Data))
+		respondSSHBatchExecuteSubscription(natsInboundMsg{msg}, *instanceId)
+	})
+	return err
+}
+
+func SubscribeSSHBatchExecutor(nc *nats.Conn, instanceId *string) {
+	if err := subscribeSSHBatchExecutorFn(utils.QueueSubscriber{Conn: nc}, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[SSH Batch Execute] Failed to subscribe: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package ssh
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+func withExecuteStub(t *testing.T, fn func(req ExecuteRequest, instanceId string) ExecuteResponse) {
+	t.Helper()
+	original := executeSSHCommand
+	executeSSHCommand = fn
+	t.Cleanup(func() { executeSSHCommand = original })
+}
+
+func decodeBatchResponse(t *testing.T, payload []byte) BatchExecuteResponse {
+	t.Helper()
+	var response BatchExecuteResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return response
+}
+
+func TestHandleSSHBatchExecuteMessageAggregatesPerHostResults(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]ExecuteRequest{}
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		mu.Lock()
+		seen[req.Host] = req
+		mu.Unlock()
+		if req.Host == "10.0.0.2" {
+			return ExecuteResponse{InstanceId: instanceId, Output: "connection refused", Code: utils.ErrorCodeDependencyFailure, Error: "connection refused", ExitCode: exitCodeUnavailable}
+		}
+		return ExecuteResponse{InstanceId: instanceId, Success: true, Output: "ok " + req.Host, Stdout: "ok " + req.Host}
+	})
+
+	payload := []byte(`{"args":[{"command":"uptime","execute_timeout":5,"port":22,"user":"root","password":"shared","workers":2,
+		"hosts":[{"host":"10.0.0.1"},{"host":"10.0.0.2","port":2222},{"host":"10.0.0.3","user":"ops","private_key":"KEY"}]}],"kwargs":{}}`)
+	response := decodeBatchResponse(t, mustHandleBatch(t, payload))
+
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure || response.Error != "1 of 3 hosts failed" {
+		t.Fatalf("unexpected batch status: %+v", response)
+	}
+	if response.Total != 3 || response.Succeeded != 2 || response.Failed != 1 || len(response.Results) != 3 {
+		t.Fatalf("unexpected batch counts: %+v", response)
+	}
+	for i, want := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if response.Results[i].Index != i || response.Results[i].Host != want {
+			t.Fatalf("results[%d] = %+v, want host %s", i, response.Results[i], want)
+		}
+	}
+	if !response.Results[0].Success || response.Results[1].Success || !response.Results[2].Success {
+		t.Fatalf("unexpected per-host success: %+v", response.Results)
+	}
+	if response.Results[1].Port != 2222 || response.Results[1].ExitCode != exitCodeUnavailable {
+		t.Fatalf("unexpected failed host result: %+v", response.Results[1])
+	}
+
+	if req := seen["10.0.0.1"]; req.User != "root" || req.Password != "shared" || req.Port != 22 || req.Command != "uptime" {
+		t.Fatalf("host without overrides should inherit request fields: %+v", req)
+	}
+	if req := seen["10.0.0.3"]; req.User != "ops" || req.PrivateKey != "KEY" || req.Password != "" {
+		t.Fatalf("host credentials should replace request credentials: %+v", req)
+	}
+}
+
+func TestExecuteBatchBoundsConcurrencyByWorkers(t *testing.T) {
+	var running, peak int32
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			observed := atomic.LoadInt32(&peak)
+			if current <= observed || atomic.CompareAndSwapInt32(&peak, observed, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return ExecuteResponse{InstanceId: instanceId, Success: true}
+	})
+
+	req := BatchExecuteRequest{ExecuteRequest: ExecuteRequest{Command: "uptime", Port: 22, User: "root", Password: "x"}, Workers: 3}
+	for i := 0; i < 9; i++ {
+		req.Hosts = append(req.Hosts, HostSpec{Host: "10.0.0.1"})
+	}
+	response := executeBatch(req, "instance-1", nil)
+	if !response.Success || response.Succeeded != 9 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	if peak != 3 {
+		t.Fatalf("expected 3 hosts to run concurrently, peak was %d", peak)
+	}
+}
+
+func TestExecuteBatchTimesOutStuckHostWithoutBlockingOthers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		if req.Host == "stuck" {
+			<-release
+		}
+		return ExecuteResponse{InstanceId: instanceId, Success: true}
+	})
+	originalDeadline := handlerDeadline
+	handlerDeadline = func(int) time.Duration { return 20 * time.Millisecond }
+	defer func() { handlerDeadline = originalDeadline }()
+
+	req := BatchExecuteRequest{
+		ExecuteRequest: ExecuteRequest{Command: "uptime", Port: 22, User: "root", Password: "x"},
+		Hosts:          []HostSpec{{Host: "stuck"}, {Host: "10.0.0.2"}},
+		Workers:        1,
+	}
+	response := executeBatch(req, "instance-1", nil)
+	if response.Results[0].Success || response.Results[0].Code != utils.ErrorCodeTimeout {
+		t.Fatalf("expected stuck host to time out, got %+v", response.Results[0])
+	}
+	if !response.Results[1].Success {
+		t.Fatalf("expected next host to run after stuck host timed out, got %+v", response.Results[1])
+	}
+}
+
+func TestExecuteBatchPerTargetTimeoutCancelsOnlySlowHost(t *testing.T) {
+	slowCancelled := make(chan struct{})
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		if req.Host == "slow" {
+			<-req.requestContext().Done()
+			close(slowCancelled)
+			return ExecuteResponse{InstanceId: instanceId}
+		}
+		return ExecuteResponse{InstanceId: instanceId, Success: true, Output: "ok " + req.Host}
+	})
+
+	req := BatchExecuteRequest{
+		ExecuteRequest:   ExecuteRequest{Command: "uptime", ExecuteTimeout: 60, Port: 22, User: "root", Password: "x"},
+		Hosts:            []HostSpec{{Host: "10.0.0.1"}, {Host: "slow"}, {Host: "10.0.0.3"}, {Host: "10.0.0.4"}},
+		Workers:          2,
+		PerTargetTimeout: 1,
+	}
+	started := time.Now()
+	response := executeBatch(req, "instance-1", nil)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("slow host should be cut off at per_target_timeout, batch took %s", elapsed)
+	}
+
+	if response.Succeeded != 3 || response.Failed != 1 || response.TimedOut != 1 {
+		t.Fatalf("unexpected batch counts: %+v", response)
+	}
+	slow := response.Results[1]
+	if slow.Success || slow.Code != utils.ErrorCodeTimeout || slow.TimedOut != batchTimedOutPerTarget || !strings.Contains(slow.Error, "Per-target timeout") {
+		t.Fatalf("expected slow host to be marked as a per-target timeout, got %+v", slow)
+	}
+	for _, i := range []int{0, 2, 3} {
+		if result := response.Results[i]; !result.Success || result.TimedOut != "" {
+			t.Fatalf("fast host %d should succeed without a timeout mark, got %+v", i, result)
+		}
+	}
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow host's context to be cancelled at its timeout")
+	}
+}
+
+func TestExecuteBatchBatchTimeoutStopsRunningAndPendingHosts(t *testing.T) {
+	var executed atomic.Int32
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		executed.Add(1)
+		if req.Host == "slow" {
+			<-req.requestContext().Done()
+			return ExecuteResponse{InstanceId: instanceId}
+		}
+		return ExecuteResponse{InstanceId: instanceId, Success: true}
+	})
+
+	req := BatchExecuteRequest{
+		ExecuteRequest: ExecuteRequest{Command: "uptime", ExecuteTimeout: 60, Port: 22, User: "root", Password: "x"},
+		Hosts:          []HostSpec{{Host: "10.0.0.1"}, {Host: "slow"}, {Host: "10.0.0.3"}},
+		Workers:        1,
+		BatchTimeout:   1,
+	}
+	response := executeBatch(req, "instance-1", nil)
+
+	if response.Succeeded != 1 || response.Failed != 2 || response.TimedOut != 2 {
+		t.Fatalf("unexpected batch counts: %+v", response)
+	}
+	if !response.Results[0].Success || response.Results[0].TimedOut != "" {
+		t.Fatalf("host finished before the batch deadline should succeed, got %+v", response.Results[0])
+	}
+	for _, i := range []int{1, 2} {
+		if result := response.Results[i]; result.Code != utils.ErrorCodeTimeout || result.TimedOut != batchTimedOutBatch {
+			t.Fatalf("host %d should be marked as a batch timeout, got %+v", i, result)
+		}
+	}
+	if executed.Load() != 2 {
+		t.Fatalf("host queued after the batch deadline should not start, executed %d", executed.Load())
+	}
+}
+
+func TestHandleSSHBatchExecuteMessageRejectsInvalidRequestBeforeExecuting(t *testing.T) {
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		t.Fatalf("no host should be executed for an invalid batch, got %+v", req)
+		return ExecuteResponse{}
+	})
+
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x"}`, want: "hosts is required"},
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"}],"workers":-1}`, want: "workers must not be negative"},
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"}],"per_target_timeout":-1}`, want: "per_target_timeout must not be negative"},
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"}],"batch_timeout":-1}`, want: "batch_timeout must not be negative"},
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"}],"task_id":"t1"}`, want: "task_id and stream are not supported by ssh.batch_execute"},
+		{args: `{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"},{"host":" "}]}`, want: "hosts[1].host is required"},
+		{args: `{"command":"uptime","port":22,"password":"x","hosts":[{"host":"a","user":"ops"},{"host":"b"}]}`, want: "hosts[1]: user is required"},
+		{args: `{"port":22,"user":"root","password":"x","hosts":[{"host":"a"}]}`, want: "hosts[0]: command is required"},
+	}
+	for _, tt := range tests {
+		response := decodeBatchResponse(t, mustHandleBatch(t, []byte(`{"args":[`+tt.args+`],"kwargs":{}}`)))
+		if response.Success || response.Code != utils.ErrorCodeInvalidRequest || response.Error != tt.want {
+			t.Fatalf("args %s: expected %q, got %+v", tt.args, tt.want, response)
+		}
+	}
+}
+
+func TestFitBatchExecuteResponseDropsDuplicateStreams(t *testing.T) {
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{MaxBytes: 400})
+	defer utils.SetResponseSizeConfig(utils.ResponseSizeConfig{})
+
+	response := BatchExecuteResponse{InstanceId: "instance-1", Success: true, Total: 1, Succeeded: 1, Results: []BatchHostResult{{
+		Host:            "10.0.0.1",
+		ExecuteResponse: ExecuteResponse{Success: true, Output: strings.Repeat("a", 100), Stdout: strings.Repeat("a", 100), Stderr: strings.Repeat("b", 100)},
+	}}}
+	payload, _ := json.Marshal(response)
+	fitted := decodeBatchResponse(t, fitBatchExecuteResponse("instance-1", response, payload))
+	result := fitted.Results[0]
+	if result.Stdout != "" || result.Stderr != "" || !result.Truncated || result.Output != strings.Repeat("a", 100) {
+		t.Fatalf("unexpected fitted result: %+v", result)
+	}
+}
+
+func TestBatchWorkers(t *testing.T) {
+	tests := []struct{ workers, hosts, want int }{
+		{workers: 0, hosts: 100, want: defaultBatchWorkers},
+		{workers: 0, hosts: 3, want: 3},
+		{workers: 5, hosts: 100, want: 5},
+		{workers: 1000, hosts: 1000, want: maxBatchWorkers},
+	}
+	for _, tt := range tests {
+		if got := batchWorkers(tt.workers, tt.hosts); got != tt.want {
+			t.Fatalf("batchWorkers(%d, %d) = %d, want %d", tt.workers, tt.hosts, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribeSSHBatchExecutorRegistersSubject(t *testing.T) {
+	withExecuteStub(t, func(req ExecuteRequest, instanceId string) ExecuteResponse {
+		return ExecuteResponse{InstanceId: instanceId, Success: true}
+	})
+	sub := &stubSubscriber{}
+	if err := subscribeSSHBatchExecutor(sub, strPtr("instance-1")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "ssh.batch_execute.instance-1" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+	sub.handler(&nats.Msg{Data: []byte(`{"args":[{"command":"uptime","port":22,"user":"root","password":"x","hosts":[{"host":"a"}]}],"kwargs":{}}`)})
+}
+
+func mustHandleBatch(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	response, ok := handleSSHBatchExecuteMessage(payload, "instance-1")
+	if !ok {
+		t.Fatal("expected batch response")
+	}
+	return response
+}
@@ -0,0 +1,40 @@
+package ssh
+
+import (
+	"fmt"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+// sshCancels 记录带 task_id 的 ssh.execute 任务，供 ssh.cancel 取消
+var sshCancels = utils.NewCancelRegistry()
+
+var subscribeSSHCancelFn = subscribeSSHCancel
+
+func respondSSHCancelSubscription(msg inboundMsg, instanceId string) bool {
+	responseContent := sshCancels.HandleCancelMessage("SSH Cancel", msg.Payload(), instanceId)
+	if err := msg.Respond(responseContent); err != nil {
+		logger.WithInstance(instanceId).Errorf("[SSH Cancel Subscribe] Error responding to cancel request: %v", err)
+		return false
+	}
+	return true
+}
+
+func subscribeSSHCancel(sub subscriber, instanceId *string) error {
+	subject := fmt.Sprintf("ssh.cancel.%s", *instanceId)
+	logger.WithInstance(*instanceId).Infof("[SSH Cancel Subscribe] Subscribing to subject: %s", subject)
+
+	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
+		respondSSHCancelSubscription(natsInboundMsg{msg}, *instanceId)
+	})
+	return err
+}
+
+func SubscribeSSHCancel(nc *nats.Conn, instanceId *string) {
+	if err := subscribeSSHCancelFn(nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[SSH Cancel Subscribe] Failed to subscribe: %v", err)
+	}
+}
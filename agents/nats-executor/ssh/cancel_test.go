@@ -0,0 +1,67 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSSHCancelTerminatesRunningCommand(t *testing.T) {
+	originalDial := sshDialFn
+	closed := make(chan struct{})
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			return &stubSSHSession{
+				run: func(cmd string) error {
+					<-closed
+					return errors.New("session closed")
+				},
+				close: func() error {
+					select {
+					case <-closed:
+					default:
+						close(closed)
+					}
+					return nil
+				},
+			}, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	done := make(chan ExecuteResponse, 1)
+	go func() {
+		done <- Execute(ExecuteRequest{Command: "sleep 60", ExecuteTimeout: 30, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret", TaskID: "ssh-task"}, "instance-1")
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !sshCancels.Cancel("ssh-task") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected running task to be cancelable")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	select {
+	case response := <-done:
+		if response.Success || response.Code != utils.ErrorCodeCanceled || response.Termination != terminationCanceled {
+			t.Fatalf("unexpected response: %+v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected canceled command to return")
+	}
+}
+
+func TestSubscribeSSHCancelRegistersInstanceSubject(t *testing.T) {
+	sub := &stubSubscriber{}
+	if err := subscribeSSHCancel(sub, strPtr("instance-a")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if sub.subject != "ssh.cancel.instance-a" || sub.handler == nil {
+		t.Fatalf("unexpected subscription state: %+v", sub)
+	}
+}
@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// runSSHCleanup 在同一连接的独立会话中执行 cleanup_command，沿用 source_files / work_dir / sudo，超时后发送 SIGKILL
+func runSSHCleanup(client sshClient, req ExecuteRequest, instanceId string) *ExecuteResponse {
+	timeout := time.Duration(utils.CleanupTimeout(req.CleanupCommand, req.CleanupTimeout)) * time.Second
+	logger.WithInstance(instanceId).Debugf("[SSH Execute] Running cleanup command, Timeout: %s", timeout)
+
+	session, err := client.NewSession()
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to create SSH session for cleanup command: %v", err)
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
+		response := newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSessionCreate, sshCategoryDependency)
+		return &response
+	}
+	defer session.Close()
+
+	capture := utils.NewSharedOutputCapture(utils.ResolveOutputLimit(req.MaxOutputBytes))
+	session.SetStdout(capture.StdoutWriter())
+	session.SetStderr(capture.StderrWriter())
+	session.SetStdin(sudoStdin(req))
+
+	cleanupReq := req
+	cleanupReq.Command = req.CleanupCommand
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- session.Run(buildRemoteCommand(cleanupReq))
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var runErr error
+	select {
+	case <-timer.C:
+		session.Signal(ssh.SIGKILL)
+		snapshot := capture.Snapshot()
+		errMsg := fmt.Sprintf("Cleanup command timed out after %s", timeout)
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
+		response := timeoutStageResponse(instanceId, utils.FormatCapturedOutput(string(snapshot.Stdout), string(snapshot.Stderr), snapshot), errMsg, sshStageCommandRun, sshCategoryRemoteTimeout)
+		response.Stdout = string(snapshot.Stdout)
+		response.Stderr = string(snapshot.Stderr)
+		response.Truncated = snapshot.Truncated
+		return &response
+	case runErr = <-errChan:
+	}
+
+	snapshot := capture.Snapshot()
+	response := ExecuteResponse{
+		Output:     utils.FormatCapturedOutput(string(snapshot.Stdout), string(snapshot.Stderr), snapshot),
+		Stdout:     string(snapshot.Stdout),
+		Stderr:     string(snapshot.Stderr),
+		InstanceId: instanceId,
+		Success:    runErr == nil,
+		Truncated:  snapshot.Truncated,
+		ExitCode:   responseExitCode(runErr),
+	}
+	if runErr != nil {
+		response.Code = utils.ErrorCodeExecutionFailure
+		response.Error = fmt.Sprintf("Cleanup command failed: %v", runErr)
+		response.Stage = sshStageCommandRun
+		response.Category = sshCategoryRemoteExit
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", response.Error)
+	}
+	return &response
+}
+
+// hasCleanupCommand 判断请求是否携带 cleanup_command
+func hasCleanupCommand(req ExecuteRequest) bool {
+	return strings.TrimSpace(req.CleanupCommand) != ""
+}
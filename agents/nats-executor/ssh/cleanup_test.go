@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func TestExecuteRunsCleanupCommandAfterMainCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	workDir := t.TempDir()
+	base := ExecuteRequest{
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		WorkDir:        workDir,
+	}
+
+	t.Run("runs after failure in the same work_dir", func(t *testing.T) {
+		req := base
+		req.Command = "touch state.tmp; exit 2"
+		req.CleanupCommand = "rm state.tmp && echo cleaned"
+		response := Execute(req, "instance-1")
+		if response.Success || response.ExitCode != 2 {
+			t.Fatalf("cleanup must not change the main result: %+v", response)
+		}
+		if response.Cleanup == nil || !response.Cleanup.Success || response.Cleanup.ExitCode != 0 || !strings.Contains(response.Cleanup.Output, "cleaned") {
+			t.Fatalf("unexpected cleanup result: %+v", response.Cleanup)
+		}
+		if _, err := os.Stat(filepath.Join(workDir, "state.tmp")); !os.IsNotExist(err) {
+			t.Fatalf("expected cleanup to remove state file, stat err=%v", err)
+		}
+	})
+
+	t.Run("runs after timeout", func(t *testing.T) {
+		req := base
+		req.Command = "sleep 2"
+		req.ExecuteTimeout = 1
+		req.CleanupCommand = "echo cleaned"
+		response := Execute(req, "instance-1")
+		if response.Code != utils.ErrorCodeTimeout {
+			t.Fatalf("expected main command timeout, got %+v", response)
+		}
+		if response.Cleanup == nil || !response.Cleanup.Success {
+			t.Fatalf("expected cleanup to run after timeout, got %+v", response.Cleanup)
+		}
+	})
+
+	t.Run("failure is reported separately", func(t *testing.T) {
+		req := base
+		req.Command = "echo ok"
+		req.CleanupCommand = "exit 4"
+		response := Execute(req, "instance-1")
+		if !response.Success {
+			t.Fatalf("expected main command to succeed, got %+v", response)
+		}
+		if response.Cleanup == nil || response.Cleanup.Success || response.Cleanup.ExitCode != 4 || response.Cleanup.Code != utils.ErrorCodeExecutionFailure {
+			t.Fatalf("unexpected cleanup result: %+v", response.Cleanup)
+		}
+	})
+
+	t.Run("not run without cleanup_command", func(t *testing.T) {
+		req := base
+		req.Command = "echo ok"
+		if response := Execute(req, "instance-1"); response.Cleanup != nil {
+			t.Fatalf("unexpected cleanup result: %+v", response.Cleanup)
+		}
+	})
+}
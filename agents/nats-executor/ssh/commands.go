@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+func validateCommands(req ExecuteRequest) string {
+	if strings.TrimSpace(req.Command) != "" {
+		return "commands cannot be combined with command"
+	}
+	for i, command := range req.Commands {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Sprintf("commands[%d] is required", i)
+		}
+	}
+	first := req
+	first.Command = req.Commands[0]
+	return validateExecuteRequest(first)
+}
+
+// executeCommands 依次执行 commands，各命令共享 execute_timeout 预算；默认遇到第一个失败即停止，
+// continue_on_error 时执行全部命令。超时与取消始终中止后续命令，cleanup_command 在全部命令结束后执行一次
+func executeCommands(req ExecuteRequest, instanceId string, nc *nats.Conn, stream *outputStream) ExecuteResponse {
+	if validationErr := validateCommands(req); validationErr != "" {
+		return invalidSSHExecuteResponse(instanceId, validationErr)
+	}
+	req.ExecuteTimeout = utils.ExecuteTimeout(req.ExecuteTimeout)
+	deadline := time.Now().Add(time.Duration(req.ExecuteTimeout) * time.Second)
+
+	var result ExecuteResponse
+	var outputs, stdouts, stderrs []string
+	connected := false
+	for i, command := range req.Commands {
+		remaining := remainingBudgetSeconds(deadline)
+		if remaining <= 0 {
+			errMsg := fmt.Sprintf("SSH execution timed out before commands[%d] (timeout: %ds)", i, req.ExecuteTimeout)
+			response := timeoutStageResponse(instanceId, "", errMsg, sshStageCommandRun, sshCategoryRemoteTimeout)
+			result.CommandResults = append(result.CommandResults, commandResult(i, command, response))
+			markFailedCommand(&result, i, response)
+			break
+		}
+
+		commandReq := req
+		commandReq.Commands = nil
+		commandReq.Command = command
+		commandReq.ExecuteTimeout = remaining
+		commandReq.CleanupCommand = ""
+		response := executeCommand(commandReq, instanceId, nc, stream)
+		if response.Termination != "" {
+			connected = true
+		}
+		outputs = append(outputs, response.Output)
+		stdouts = append(stdouts, response.Stdout)
+		stderrs = append(stderrs, response.Stderr)
+		result.Truncated = result.Truncated || response.Truncated
+		if result.RemoteEnv == nil {
+			result.RemoteEnv = response.RemoteEnv
+		}
+		if result.AuthMethod == "" {
+			result.AuthMethod = response.AuthMethod
+		}
+		result.PTY = result.PTY || response.PTY
+		result.ExitCode = response.ExitCode
+		result.Termination = response.Termination
+		result.CommandResults = append(result.CommandResults, commandResult(i, command, response))
+
+		if response.Success {
+			continue
+		}
+		markFailedCommand(&result, i, response)
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] commands[%d] failed: %s", i, response.Error)
+		// 只有命令正常结束时才继续；连接失败、超时与取消时后续命令同样无法执行
+		if !req.ContinueOnError || response.Termination != terminationExited {
+			break
+		}
+	}
+
+	result.InstanceId = instanceId
+	result.Success = result.FailedCommandIndex == nil
+	result.Output = strings.Join(outputs, "")
+	result.Stdout = strings.Join(stdouts, "")
+	result.Stderr = strings.Join(stderrs, "")
+	if result.FailedCommandIndex != nil {
+		result.ExitCode = result.CommandResults[*result.FailedCommandIndex].ExitCode
+	}
+	if connected && hasCleanupCommand(req) {
+		cleanupReq := req
+		cleanupReq.Commands = nil
+		cleanupReq.Command = req.CleanupCommand
+		cleanupReq.ExecuteTimeout = utils.CleanupTimeout(req.CleanupCommand, req.CleanupTimeout)
+		cleanupReq.CleanupCommand = ""
+		cleanupReq.TaskID = ""
+		cleanupReq.Stream = false
+		cleanup := executeWithConn(cleanupReq, instanceId, nil)
+		result.Cleanup = &cleanup
+	}
+	return result
+}
+
+// markFailedCommand 以第一个失败命令的错误作为整体结果的错误
+func markFailedCommand(result *ExecuteResponse, index int, response ExecuteResponse) {
+	if result.FailedCommandIndex != nil {
+		return
+	}
+	failed := index
+	result.FailedCommandIndex = &failed
+	result.Code = response.Code
+	result.Error = fmt.Sprintf("commands[%d] failed: %s", index, response.Error)
+	result.Stage = response.Stage
+	result.Category = response.Category
+}
+
+func commandResult(index int, command string, response ExecuteResponse) CommandResult {
+	return CommandResult{
+		Index:       index,
+		Command:     command,
+		Output:      response.Output,
+		Stdout:      response.Stdout,
+		Stderr:      response.Stderr,
+		Success:     response.Success,
+		Code:        response.Code,
+		Error:       response.Error,
+		Stage:       response.Stage,
+		Category:    response.Category,
+		ExitCode:    response.ExitCode,
+		Termination: response.Termination,
+	}
+}
@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+)
+
+func commandsRequest(continueOnError bool) ExecuteRequest {
+	return ExecuteRequest{
+		Commands:        []string{"echo one", "echo failing >&2; exit 3", "echo three"},
+		ContinueOnError: continueOnError,
+		ExecuteTimeout:  5,
+		Host:            "10.0.0.1",
+		Port:            22,
+		User:            "root",
+		Password:        "secret",
+	}
+}
+
+func TestExecuteCommandsStopsAtFirstFailureByDefault(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(commandsRequest(false), "instance-1")
+
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure {
+		t.Fatalf("expected execution failure, got %+v", response)
+	}
+	if response.FailedCommandIndex == nil || *response.FailedCommandIndex != 1 || response.ExitCode != 3 {
+		t.Fatalf("expected commands[1] to be reported as failed with exit code 3, got %+v", response)
+	}
+	if len(response.CommandResults) != 2 {
+		t.Fatalf("expected execution to stop after the failing command, got %+v", response.CommandResults)
+	}
+	if !response.CommandResults[0].Success || strings.TrimSpace(response.CommandResults[0].Stdout) != "one" {
+		t.Fatalf("unexpected first command result: %+v", response.CommandResults[0])
+	}
+	if strings.Contains(response.Stdout, "three") || !strings.Contains(response.Error, "commands[1]") {
+		t.Fatalf("unexpected aggregated response: %+v", response)
+	}
+}
+
+func TestExecuteCommandsContinueOnErrorRunsAllCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(commandsRequest(true), "instance-1")
+
+	if response.Success || response.FailedCommandIndex == nil || *response.FailedCommandIndex != 1 {
+		t.Fatalf("expected overall failure pointing at commands[1], got %+v", response)
+	}
+	if len(response.CommandResults) != 3 {
+		t.Fatalf("expected all commands to run, got %+v", response.CommandResults)
+	}
+	for i, want := range []bool{true, false, true} {
+		if got := response.CommandResults[i]; got.Index != i || got.Success != want {
+			t.Fatalf("unexpected result for commands[%d]: %+v", i, got)
+		}
+	}
+	if response.CommandResults[1].ExitCode != 3 || strings.TrimSpace(response.CommandResults[1].Stderr) != "failing" {
+		t.Fatalf("unexpected failing command result: %+v", response.CommandResults[1])
+	}
+	if response.Stdout != "one\nthree\n" {
+		t.Fatalf("unexpected aggregated stdout: %q", response.Stdout)
+	}
+}
+
+func TestExecuteCommandsSucceedsWhenAllCommandsPass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	req := commandsRequest(false)
+	req.Commands = []string{"echo one", "echo two"}
+	response := Execute(req, "instance-1")
+
+	if !response.Success || response.FailedCommandIndex != nil || len(response.CommandResults) != 2 || response.ExitCode != 0 {
+		t.Fatalf("expected all commands to succeed, got %+v", response)
+	}
+}
+
+func TestExecuteCommandsRejectsInvalidCombination(t *testing.T) {
+	req := commandsRequest(false)
+	req.Command = "uptime"
+	if response := Execute(req, "instance-1"); response.Code != utils.ErrorCodeInvalidRequest || !strings.Contains(response.Error, "cannot be combined") {
+		t.Fatalf("expected command/commands conflict to be rejected, got %+v", response)
+	}
+
+	req = commandsRequest(false)
+	req.Commands = []string{"uptime", " "}
+	if response := Execute(req, "instance-1"); response.Code != utils.ErrorCodeInvalidRequest || response.Error != "commands[1] is required" {
+		t.Fatalf("expected empty command to be rejected, got %+v", response)
+	}
+}
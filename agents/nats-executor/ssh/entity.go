@@ -1,28 +1,186 @@
 package ssh
 
+import "context"
+
 type ExecuteRequest struct {
-	Command        string `json:"command"`
-	ExecuteTimeout int    `json:"execute_timeout"`
-	Host           string `json:"host"`
-	Port           uint   `json:"port"`
-	User           string `json:"user"`
-	Password       string `json:"password"`    // 密码认证（可选）
-	PrivateKey     string `json:"private_key"` // PEM 格式私钥内容（可选）
-	Passphrase     string `json:"passphrase"`  // 私钥密码短语（可选）
-	ConnectionTest bool   `json:"connection_test,omitempty"`
-	ExecutionID    string `json:"execution_id,omitempty"`
-	StreamLogs     bool   `json:"stream_logs,omitempty"`
-	StreamLogTopic string `json:"stream_log_topic,omitempty"`
+	Command           string   `json:"command"`
+	ExecuteTimeout    int      `json:"execute_timeout"` // 执行超时（秒），<= 0 视为未设置，按默认 300 秒执行
+	Host              string   `json:"host"`
+	Port              uint     `json:"port"`
+	User              string   `json:"user"`
+	Password          string   `json:"password"`    // 密码认证（可选）
+	PrivateKey        string   `json:"private_key"` // PEM 格式私钥内容（可选）
+	Passphrase        string   `json:"passphrase"`  // 私钥密码短语（可选）
+	ConnectionTest    bool     `json:"connection_test,omitempty"`
+	ExecutionID       string   `json:"execution_id,omitempty"`
+	StreamLogs        bool     `json:"stream_logs,omitempty"`
+	StreamLogTopic    string   `json:"stream_log_topic,omitempty"`
+	Stream            bool     `json:"stream,omitempty"`              // 按行实时发布 stdout/stderr 到 ssh.output.<instance_id>.<task_id>，需同时提供 task_id
+	SourceFiles       []string `json:"source_files,omitempty"`        // 执行前依次加载的环境脚本（POSIX shell）
+	MaxOutputBytes    int      `json:"max_output_bytes,omitempty"`    // 输出上限（字节），默认 1MB，不超过 16MB
+	ExpectedExitCodes []int    `json:"expected_exit_codes,omitempty"` // 视为成功的退出码，默认 [0]
+	WorkDir           string   `json:"work_dir,omitempty"`            // 远端工作目录，执行前 cd 进入
+	CaptureEnv        bool     `json:"capture_env,omitempty"`         // 调试用：在 remote_env 中返回远端环境变量（敏感变量已脱敏）
+	AuthOrder         string   `json:"auth_order,omitempty"`          // 同时提供密码与私钥时的尝试顺序：key_first（默认）/ password_first
+	OutputGrep        string   `json:"output_grep,omitempty"`         // 只返回匹配该正则的输出行
+	OutputGrepInvert  bool     `json:"output_grep_invert,omitempty"`  // 返回不匹配 output_grep 的行
+	IncludeFullOutput bool     `json:"include_full_output,omitempty"` // 设置 output_grep 时在 full_output 中同时返回过滤前的输出
+	CleanupCommand    string   `json:"cleanup_command,omitempty"`     // 主命令结束后（含失败、超时）始终执行的清理命令
+	CleanupTimeout    int      `json:"cleanup_timeout,omitempty"`     // 清理命令超时（秒），默认 30
+	KillOnTimeout     bool     `json:"kill_on_timeout,omitempty"`     // 超时或取消后另开会话按记录的 PID 结束远端进程组（POSIX shell）
+	TaskID            string   `json:"task_id,omitempty"`             // 任务 ID，命令执行期间可通过 ssh.cancel.<instance_id> 取消
+	Commands          []string `json:"commands,omitempty"`            // 依次执行的多条命令，与 command 互斥，共享 execute_timeout
+	ContinueOnError   bool     `json:"continue_on_error,omitempty"`   // commands 中某条命令失败后是否继续执行后续命令，默认遇错即停
+	FailOnStderr      bool     `json:"fail_on_stderr,omitempty"`      // 命令正常结束但 stderr 非空时视为失败（code 为 stderr_present）
+
+	// stream 模式每秒最多发布的行数，0 为不限速；发布跟不上时行在队列中等待，队列写满时丢弃最旧的行
+	StreamMaxLinesPerSecond int `json:"stream_max_lines_per_second,omitempty"`
+	// stream 模式待发布行的队列长度，默认 1024
+	StreamBufferLines int `json:"stream_buffer_lines,omitempty"`
+	// 跳板机，按顺序逐级连接，最后一级连接目标主机；为空时直连
+	Jump []JumpHost `json:"jump,omitempty"`
+	// 目标主机公钥的 SHA256 指纹（SHA256:...），不一致时拒绝连接
+	HostKeyFingerprint string `json:"host_key_fingerprint,omitempty"`
+	// 校验主机密钥的 known_hosts 文件，优先于环境变量 SSH_KNOWN_HOSTS_FILE
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+	// 显式跳过主机密钥校验（含已配置的 SSH_KNOWN_HOSTS_FILE），每次使用都会记录告警日志
+	InsecureIgnoreHostKey bool `json:"insecure_ignore_host_key,omitempty"`
+	// 单次建连（TCP 连接与 SSH 握手）超时（秒），0 为默认 30 秒；与 execute_timeout 相互独立，
+	// 后者是包含建连与命令执行在内的整体超时，建连超时不会超过其剩余时间
+	ConnectTimeout int `json:"connect_timeout,omitempty"`
+	// 为会话分配 PTY，供需要 tty 的命令（如 sudo、top）使用；此时 stderr 合并到 stdout
+	RequestPTY bool `json:"request_pty,omitempty"`
+	// 以 sudo -S -p '' 提权执行命令（含 source_files / work_dir 与 cleanup_command）
+	Sudo bool `json:"sudo,omitempty"`
+	// sudo 密码，经 stdin 写入，不出现在命令行与日志中；为空时依赖远端 NOPASSWD
+	SudoPassword string `json:"sudo_password,omitempty"`
+	// ctx 由处理器在截止时间到达时取消以中止拨号与远端命令，不参与序列化；为空时不受处理器截止时间约束
+	ctx context.Context
+}
+
+// withContext 返回绑定 ctx 的请求副本
+func (r ExecuteRequest) withContext(ctx context.Context) ExecuteRequest {
+	r.ctx = ctx
+	return r
+}
+
+func (r ExecuteRequest) requestContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// JumpHost 为一级跳板机的连接信息，认证方式同目标主机
+type JumpHost struct {
+	Host       string `json:"host"`
+	Port       uint   `json:"port,omitempty"` // 默认 22
+	User       string `json:"user"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
 }
 
 type ExecuteResponse struct {
-	Output     string `json:"result"`
+	Output     string `json:"result"` // stdout + stderr 合并输出，兼容旧调用方
+	Stdout     string `json:"stdout"` // 远程命令的标准输出
+	Stderr     string `json:"stderr"` // 远程命令的标准错误
 	InstanceId string `json:"instance_id"`
 	Success    bool   `json:"success"`
 	Code       string `json:"code,omitempty"`
 	Error      string `json:"error,omitempty"` // 添加错误字段
 	Stage      string `json:"stage,omitempty"`
 	Category   string `json:"category,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"` // 输出超过上限被截断
+	// 远程命令退出码；超时被终止、连接失败等未拿到退出码时为 -1
+	ExitCode int `json:"exit_code"`
+	// capture_env 时采集的远端环境变量，名称含 PASS/SECRET/TOKEN/KEY 等的值以 *** 代替
+	RemoteEnv map[string]string `json:"remote_env,omitempty"`
+	// 响应超过 max_response_bytes 且策略为 offload 时，完整输出所在的对象 key
+	OutputObjectKey string `json:"output_object_key,omitempty"`
+	// include_full_output 时过滤前的完整输出，响应超过 max_response_bytes 时不返回
+	FullOutput string `json:"full_output,omitempty"`
+	// cleanup_command 的执行结果，不影响主命令的 success；SSH 连接未建立时不执行
+	Cleanup *ExecuteResponse `json:"cleanup,omitempty"`
+	// 输出超过 compress_output_threshold 时为 gzip+base64，result / stdout / stderr / full_output 需按此解码
+	OutputEncoding string `json:"output_encoding,omitempty"`
+	// 命令的结束方式：exited 为自然结束，timeout_killed 为超时后被强制终止，canceled 为被取消；命令未开始执行时为空
+	Termination string `json:"termination,omitempty"`
+	// SSH 连接实际通过认证的方式：key / password；连接未建立时为空
+	AuthMethod string `json:"auth_method,omitempty"`
+	// 请求中的 task_id，用于关联取消请求
+	TaskID string `json:"task_id,omitempty"`
+	// stream 模式下因发布跟不上而未发布到流上的行数，这些行仍按 max_output_bytes 保留在本响应的输出中
+	StreamDroppedLines int64 `json:"stream_dropped_lines,omitempty"`
+	// 命令在 PTY 中执行：远端 stderr 已合并到 stdout（换行为 \r\n），stderr 始终为空
+	PTY bool `json:"pty,omitempty"`
+	// commands 中各命令的执行结果，按执行顺序排列，未执行的命令不出现
+	CommandResults []CommandResult `json:"commands,omitempty"`
+	// commands 中第一个失败命令的下标，全部成功时为空
+	FailedCommandIndex *int `json:"failed_command_index,omitempty"`
+}
+
+// HostSpec 为批量执行中的一台主机；port / user 未填写时沿用请求中的值，
+// 填写了 password 或 private_key 时整体替换请求中的凭据（含 passphrase）
+type HostSpec struct {
+	Host       string `json:"host"`
+	Port       uint   `json:"port,omitempty"`
+	User       string `json:"user,omitempty"`
+	Password   string `json:"password,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// 该主机公钥的 SHA256 指纹，未填写时沿用请求中的 host_key_fingerprint
+	HostKeyFingerprint string `json:"host_key_fingerprint,omitempty"`
+}
+
+// BatchExecuteRequest 为在多台主机上执行同一命令的请求，命令及其余参数与 ssh.execute 相同
+type BatchExecuteRequest struct {
+	ExecuteRequest
+	Hosts   []HostSpec `json:"hosts"`
+	Workers int        `json:"workers,omitempty"` // 同时执行的主机数，默认 10，不超过 64
+	// 单台主机的超时（秒，含建连与 cleanup），超时只中止该主机；未设置时按 execute_timeout 推算
+	PerTargetTimeout int `json:"per_target_timeout,omitempty"`
+	// 整批的超时（秒），到期后中止执行中的主机并跳过尚未开始的主机；未设置时不限制
+	BatchTimeout int `json:"batch_timeout,omitempty"`
+}
+
+// BatchHostResult 为一台主机的执行结果，其余字段与 ssh.execute 的响应相同
+type BatchHostResult struct {
+	Index int    `json:"index"` // 主机在 hosts 中的下标
+	Host  string `json:"host"`
+	Port  uint   `json:"port"`
+	// 主机因超时中止时为 per_target（单台主机超时）或 batch（整批超时）
+	TimedOut string `json:"timed_out,omitempty"`
+	ExecuteResponse
+}
+
+type BatchExecuteResponse struct {
+	InstanceId string `json:"instance_id"`
+	Success    bool   `json:"success"` // 全部主机执行成功时为 true
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Total      int    `json:"total"`
+	Succeeded  int    `json:"succeeded"`
+	Failed     int    `json:"failed"`
+	TimedOut   int    `json:"timed_out"` // 因超时中止的主机数，已计入 failed
+	// 按 hosts 顺序排列的各主机结果
+	Results []BatchHostResult `json:"results"`
+}
+
+// CommandResult 为 commands 中单条命令的执行结果
+type CommandResult struct {
+	Index       int    `json:"index"`
+	Command     string `json:"command"`
+	Output      string `json:"result"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	Success     bool   `json:"success"`
+	Code        string `json:"code,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Stage       string `json:"stage,omitempty"`
+	Category    string `json:"category,omitempty"`
+	ExitCode    int    `json:"exit_code"`
+	Termination string `json:"termination,omitempty"`
 }
 
 type DownloadFileRequest struct {
@@ -39,6 +197,10 @@ type DownloadFileRequest struct {
 	Passphrase     string `json:"passphrase"`  // 私钥密码短语（可选）
 	FastFail       bool   `json:"fast_fail,omitempty"`
 	ExecuteTimeout int    `json:"execute_timeout"`
+	// 传输方式：scp（默认，先下载到本机再 scp）或 sftp（对象直接流式写入远端，不占用本机磁盘）
+	TransferMode string `json:"transfer_mode,omitempty"`
+	// 期望的内容 SHA256（十六进制，可选），不一致时不写入目标文件
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
 }
 
 type UploadFileRequest struct {
@@ -51,4 +213,10 @@ type UploadFileRequest struct {
 	SourcePath     string `json:"source_path"`     // 本地文件路径
 	TargetPath     string `json:"target_path"`     // 远程目标路径
 	ExecuteTimeout int    `json:"execute_timeout"` // 执行超时时间（秒）
+	// 传输方式：scp（默认，依赖本机 scp / sshpass）或 sftp（纯 Go 实现，不启动外部进程）
+	TransferMode string `json:"transfer_mode,omitempty"`
+	// sftp 方式上传目录时必须开启；scp 方式为兼容旧调用方始终递归
+	Recursive bool `json:"recursive,omitempty"`
+	// 按源文件权限位设置远端文件与目录（scp -p）
+	PreserveMode bool `json:"preserve_mode,omitempty"`
 }
@@ -70,6 +70,8 @@ type sshSession interface {
 	Close() error
 	SetStdout(w io.Writer)
 	SetStderr(w io.Writer)
+	SetStdin(r io.Reader)
+	RequestPty(term string, h, w int, modes ssh.TerminalModes) error
 }
 
 type realSSHClient struct{ client *ssh.Client }
@@ -88,6 +90,7 @@ var (
 	parsePrivateKeyWithPassphraseFn = ssh.ParsePrivateKeyWithPassphrase
 	mkdirTempDir                    = os.MkdirTemp
 	removeAllPath                   = os.RemoveAll
+	handlerDeadline                 = utils.HandlerDeadline
 	tcpProbeFn                      = func(addr string, timeout time.Duration) error {
 		conn, err := net.DialTimeout("tcp", addr, timeout)
 		if err != nil {
@@ -107,8 +110,26 @@ var (
 	subscribeUploadToRemoteFn   = subscribeUploadToRemote
 )
 
+// handlerReturned 在截止时间内运行的处理函数返回时调用，测试据此等待后台处理结束后再恢复包级变量
+var handlerReturned = func() {}
+
+// sshConnectTimeout 为未指定 connect_timeout 时单次建连（TCP 连接与 SSH 握手）的超时
 const sshConnectTimeout = 30 * time.Second
 
+// requestConnectTimeout 返回单次建连的超时：connect_timeout 只约束拨号与握手，
+// execute_timeout 是包含建连在内的整体预算，建连超时同时受其剩余预算限制
+func requestConnectTimeout(req ExecuteRequest) time.Duration {
+	if req.ConnectTimeout > 0 {
+		return time.Duration(req.ConnectTimeout) * time.Second
+	}
+	return sshConnectTimeout
+}
+
+const (
+	authOrderKeyFirst      = "key_first"
+	authOrderPasswordFirst = "password_first"
+)
+
 const (
 	sshStageTCPConnect    = "tcp_connect"
 	sshStageSSHDial       = "ssh_dial"
@@ -139,6 +160,10 @@ func (s realSSHSession) Signal(sig ssh.Signal) error { return s.session.Signal(s
 func (s realSSHSession) Close() error                { return s.session.Close() }
 func (s realSSHSession) SetStdout(w io.Writer)       { s.session.Stdout = w }
 func (s realSSHSession) SetStderr(w io.Writer)       { s.session.Stderr = w }
+func (s realSSHSession) SetStdin(r io.Reader)        { s.session.Stdin = r }
+func (s realSSHSession) RequestPty(term string, h, w int, modes ssh.TerminalModes) error {
+	return s.session.RequestPty(term, h, w, modes)
+}
 
 func newStreamLogWriter(publisher eventPublisher, topic, executionID, stream string) *streamLogWriter {
 	return &streamLogWriter{publisher: publisher, topic: topic, executionID: executionID, stream: stream}
@@ -246,15 +271,108 @@ func handleSSHExecuteMessage(data []byte, instanceId string, natsConn *nats.Conn
 	if !ok {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
 	}
+	if mismatch := utils.InstanceMismatchResponse("SSH Execute", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
 
 	var sshExecuteRequest ExecuteRequest
 	if err := json.Unmarshal(incoming.Args[0], &sshExecuteRequest); err != nil {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
 	}
 
-	responseData := executeWithConn(sshExecuteRequest, instanceId, natsConn)
+	outputFilter, err := utils.NewOutputFilter(sshExecuteRequest.OutputGrep, sshExecuteRequest.OutputGrepInvert)
+	if err != nil {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, err.Error()), true
+	}
+
+	release, acquired := utils.DefaultLimits.Acquire("ssh.execute")
+	if !acquired {
+		limit := utils.DefaultLimits.Snapshot().MaxConcurrentJobs
+		logger.WithInstance(instanceId).Warnf("[SSH Subscribe] Rejecting request: max_concurrent_jobs=%d reached", limit)
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeTooManyRequests, fmt.Sprintf("max_concurrent_jobs limit %d reached", limit)), true
+	}
+
+	done := utils.DefaultJobs.Track(utils.JobInfo{
+		ID:        sshExecuteRequest.ExecutionID,
+		Operation: "ssh.execute",
+		Command:   sshExecuteRequest.Command,
+		Host:      sshExecuteRequest.Host,
+	})
+	deadline := handlerDeadline(utils.ExecuteTimeout(sshExecuteRequest.ExecuteTimeout) + utils.CleanupTimeout(sshExecuteRequest.CleanupCommand, sshExecuteRequest.CleanupTimeout))
+	responseData, timedOut := utils.RunWithDeadline(deadline, func(ctx context.Context) ExecuteResponse {
+		defer handlerReturned()
+		defer release()
+		defer done()
+		return executeWithConn(sshExecuteRequest.withContext(ctx), instanceId, natsConn)
+	}, func() ExecuteResponse {
+		message := fmt.Sprintf("Handler deadline exceeded after %s (timeout: %ds)", deadline, sshExecuteRequest.ExecuteTimeout)
+		return timeoutStageResponse(instanceId, "", message, sshStageCommandRun, sshCategoryRemoteTimeout)
+	})
+	if timedOut {
+		// 截止时已取消拨号与远端命令的上下文；名额与任务条目立即归还，不等后台收尾
+		release()
+		done()
+		logger.WithInstance(instanceId).Warnf("[SSH Subscribe] Command did not finish within handler deadline %s, responding with timeout", deadline)
+	}
+	responseData.TaskID = sshExecuteRequest.TaskID
+	if outputFilter != nil {
+		if sshExecuteRequest.IncludeFullOutput {
+			responseData.FullOutput = responseData.Output
+		}
+		responseData.Output = outputFilter.Apply(responseData.Output)
+	}
 	responseContent, _ := json.Marshal(responseData)
-	return responseContent, true
+	return fitSSHExecuteResponse(instanceId, responseData, responseContent), true
+}
+
+// fitSSHExecuteResponse 使响应不超过 max_response_bytes，超限时按配置截断或转存输出
+func fitSSHExecuteResponse(instanceId string, response ExecuteResponse, payload []byte) []byte {
+	// 输出超过 compress_output_threshold 时优先压缩返回，压缩后未变小或仍超限则按明文继续处理
+	if utils.ShouldCompressOutput(response.Output) {
+		compressed := response
+		compressed.Output = utils.EncodeOutput(response.Output)
+		compressed.Stdout = utils.EncodeOutput(response.Stdout)
+		compressed.Stderr = utils.EncodeOutput(response.Stderr)
+		compressed.FullOutput = utils.EncodeOutput(response.FullOutput)
+		compressed.OutputEncoding = utils.OutputEncodingGzipBase64
+		if data, err := json.Marshal(compressed); err == nil && len(data) < len(payload) && !utils.ExceedsResponseLimit(len(data)) {
+			return data
+		}
+	}
+	// 超限时先舍弃 full_output，只在仍超限时处理过滤后的输出
+	if response.FullOutput != "" && utils.ExceedsResponseLimit(len(payload)) {
+		response.FullOutput = ""
+		if trimmed, err := json.Marshal(response); err == nil {
+			payload = trimmed
+		}
+	}
+	// 仍超限时舍弃清理命令的输出，保留其执行状态
+	if response.Cleanup != nil && response.Cleanup.Output != "" && utils.ExceedsResponseLimit(len(payload)) {
+		cleanup := *response.Cleanup
+		cleanup.Output = ""
+		cleanup.Stdout = ""
+		cleanup.Stderr = ""
+		cleanup.Truncated = true
+		response.Cleanup = &cleanup
+		if trimmed, err := json.Marshal(response); err == nil {
+			payload = trimmed
+		}
+	}
+	// stdout / stderr 与 result 内容重复，仍超限时舍弃，只对合并输出截断或转存
+	if (response.Stdout != "" || response.Stderr != "") && utils.ExceedsResponseLimit(len(payload)) {
+		response.Stdout = ""
+		response.Stderr = ""
+		response.Truncated = true
+		if trimmed, err := json.Marshal(response); err == nil {
+			payload = trimmed
+		}
+	}
+	return utils.FitResponse("SSH Execute", instanceId, payload, response.Output, func(output, objectKey string, truncated bool) ([]byte, error) {
+		response.Output = output
+		response.OutputObjectKey = objectKey
+		response.Truncated = response.Truncated || truncated
+		return json.Marshal(response)
+	})
 }
 
 func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) ([]byte, bool) {
@@ -262,6 +380,9 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 	if !ok {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
 	}
+	if mismatch := utils.InstanceMismatchResponse("Download To Remote", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
 
 	var downloadRequest DownloadFileRequest
 	if err := json.Unmarshal(incoming.Args[0], &downloadRequest); err != nil {
@@ -270,6 +391,16 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 	if errMsg := validateTransferTimeout(downloadRequest.ExecuteTimeout); errMsg != "" {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, errMsg), true
 	}
+	if errMsg := validateTransferMode(downloadRequest.TransferMode); errMsg != "" {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, errMsg), true
+	}
+	if err := utils.ValidateSHA256(downloadRequest.ExpectedSHA256); err != nil {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, err.Error()), true
+	}
+	if errMsg := checkDownloadTargets(downloadRequest); errMsg != "" {
+		logger.WithInstance(instanceId).Warnf("[Download To Remote] Rejected download: %s", errMsg)
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodePathForbidden, errMsg), true
+	}
 
 	deadline := time.Now().Add(time.Duration(downloadRequest.ExecuteTimeout) * time.Second)
 	if downloadRequest.FastFail {
@@ -283,6 +414,14 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 		}
 	}
 
+	if downloadRequest.TransferMode == transferModeSFTP {
+		responseContent, err := json.Marshal(streamDownloadToRemote(downloadRequest, instanceId, nc, deadline))
+		if err != nil {
+			return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to marshal response: %v", err)), true
+		}
+		return responseContent, true
+	}
+
 	stagingBasePath := downloadRequest.LocalPath
 	if stagingBasePath == "" {
 		stagingBasePath = os.TempDir()
@@ -293,7 +432,7 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 	}
 	defer func() {
 		if err := removeAllPath(stagingDir); err != nil {
-			logger.Warnf("[SCP Transfer] Instance: %s, failed to clean staging dir %s: %v", instanceId, stagingDir, err)
+			logger.WithInstance(instanceId).Warnf("[SCP Transfer] failed to clean staging dir %s: %v", stagingDir, err)
 		}
 	}()
 
@@ -303,6 +442,7 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 		FileName:       downloadRequest.FileName,
 		TargetPath:     stagingDir,
 		ExecuteTimeout: remainingBudgetSeconds(deadline),
+		ExpectedSHA256: downloadRequest.ExpectedSHA256,
 	}
 
 	if err := downloadFromObjectStore(localdownloadRequest, nc); err != nil {
@@ -332,13 +472,13 @@ func handleDownloadToRemoteMessage(data []byte, instanceId string, nc sshConn) (
 		defer cleanup()
 	}
 	if err != nil {
-		logger.Errorf("[SCP Transfer] Instance: %s, build_failed | download %s@%s:%d %s -> %s | error=%v", instanceId, downloadRequest.User, downloadRequest.Host, downloadRequest.Port, sourcePath, downloadRequest.TargetPath, err)
+		logger.WithInstance(instanceId).Errorf("[SCP Transfer] build_failed | download %s@%s:%d %s -> %s | error=%v", downloadRequest.User, downloadRequest.Host, downloadRequest.Port, sourcePath, downloadRequest.TargetPath, err)
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to build SCP command: %v", err)), true
 	}
 
 	sourceMeta := describeTransferSource(sourcePath)
 	logContext := buildTransferLogContext("download", downloadRequest.Host, downloadRequest.Port, downloadRequest.User, sourcePath, downloadRequest.TargetPath, transferAuthMethod(downloadRequest.Password, downloadRequest.PrivateKey), sourceMeta)
-	logger.Debugf("[SCP] Instance: %s, prepared | %s | timeout=%ds | command=%s", instanceId, logContext, downloadRequest.ExecuteTimeout, redactSensitiveCommand(scpCommand))
+	logger.WithInstance(instanceId).Debugf("[SCP] prepared | %s | timeout=%ds | command=%s", logContext, downloadRequest.ExecuteTimeout, redactSensitiveCommand(scpCommand))
 
 	localExecuteRequest := local.ExecuteRequest{
 		Command:        scpCommand,
@@ -364,6 +504,9 @@ func handleUploadToRemoteMessage(data []byte, instanceId string) ([]byte, bool)
 	if !ok {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, "invalid request payload"), true
 	}
+	if mismatch := utils.InstanceMismatchResponse("Upload To Remote", data, instanceId); mismatch != nil {
+		return mismatch, true
+	}
 
 	var uploadRequest UploadFileRequest
 	if err := json.Unmarshal(incoming.Args[0], &uploadRequest); err != nil {
@@ -372,8 +515,18 @@ func handleUploadToRemoteMessage(data []byte, instanceId string) ([]byte, bool)
 	if errMsg := validateTransferTimeout(uploadRequest.ExecuteTimeout); errMsg != "" {
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, errMsg), true
 	}
+	if errMsg := validateTransferMode(uploadRequest.TransferMode); errMsg != "" {
+		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeInvalidRequest, errMsg), true
+	}
 
 	deadline := time.Now().Add(time.Duration(uploadRequest.ExecuteTimeout) * time.Second)
+	if uploadRequest.TransferMode == transferModeSFTP {
+		responseContent, err := json.Marshal(uploadToRemoteOverSFTP(uploadRequest, instanceId, deadline))
+		if err != nil {
+			return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to marshal response: %v", err)), true
+		}
+		return responseContent, true
+	}
 
 	scpCommand, cleanup, err := buildSCPCommandFn(
 		uploadRequest.User,
@@ -390,13 +543,17 @@ func handleUploadToRemoteMessage(data []byte, instanceId string) ([]byte, bool)
 		defer cleanup()
 	}
 	if err != nil {
-		logger.Errorf("[SCP Transfer] Instance: %s, build_failed | upload %s@%s:%d %s -> %s | error=%v", instanceId, uploadRequest.User, uploadRequest.Host, uploadRequest.Port, uploadRequest.SourcePath, uploadRequest.TargetPath, err)
+		logger.WithInstance(instanceId).Errorf("[SCP Transfer] build_failed | upload %s@%s:%d %s -> %s | error=%v", uploadRequest.User, uploadRequest.Host, uploadRequest.Port, uploadRequest.SourcePath, uploadRequest.TargetPath, err)
 		return utils.NewErrorExecuteResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to build SCP command: %v", err)), true
 	}
 
+	if uploadRequest.PreserveMode {
+		scpCommand = addSCPPreserveModeOption(scpCommand)
+	}
+
 	sourceMeta := describeTransferSource(uploadRequest.SourcePath)
 	logContext := buildTransferLogContext("upload", uploadRequest.Host, uploadRequest.Port, uploadRequest.User, uploadRequest.SourcePath, uploadRequest.TargetPath, transferAuthMethod(uploadRequest.Password, uploadRequest.PrivateKey), sourceMeta)
-	logger.Debugf("[SCP] Instance: %s, prepared | %s | timeout=%ds | command=%s", instanceId, logContext, uploadRequest.ExecuteTimeout, redactSensitiveCommand(scpCommand))
+	logger.WithInstance(instanceId).Debugf("[SCP] prepared | %s | timeout=%ds | command=%s", logContext, uploadRequest.ExecuteTimeout, redactSensitiveCommand(scpCommand))
 
 	localExecuteRequest := local.ExecuteRequest{
 		Command:        scpCommand,
@@ -416,96 +573,71 @@ func handleUploadToRemoteMessage(data []byte, instanceId string) ([]byte, bool)
 func respondSSHExecuteMessage(msg responseMsg, data []byte, instanceId string, nc *nats.Conn) bool {
 	responseContent, ok := handleSSHExecuteMessage(data, instanceId, nc)
 	if !ok {
-		logger.Errorf("[SSH Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[SSH Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[SSH Subscribe] Instance: %s, Error responding to SSH request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[SSH Subscribe] Error responding to SSH request: %v", err)
+		utils.PersistUndeliveredResult("SSH Subscribe", instanceId, utils.ResultIDFromRequest(data), responseContent, err)
 		return false
 	}
-	logger.Debugf("[SSH Subscribe] Instance: %s, Response sent successfully, size: %d bytes", instanceId, len(responseContent))
+	logger.WithInstance(instanceId).Debugf("[SSH Subscribe] Response sent successfully, size: %d bytes", len(responseContent))
 	return true
 }
 
 func respondDownloadToRemoteSubscription(msg inboundMsg, instanceId string, nc sshConn) bool {
 	responseContent, ok := handleDownloadToRemoteMessage(msg.Payload(), instanceId, nc)
 	if !ok {
-		logger.Errorf("[Download Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[Download Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Download Subscribe] Instance: %s, Error responding to download request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Download Subscribe] Error responding to download request: %v", err)
 		return false
 	}
-	logger.Debugf("[Download Subscribe] Instance: %s, Response sent successfully, size: %d bytes", instanceId, len(responseContent))
+	logger.WithInstance(instanceId).Debugf("[Download Subscribe] Response sent successfully, size: %d bytes", len(responseContent))
 	return true
 }
 
 func respondUploadToRemoteSubscription(msg inboundMsg, instanceId string) bool {
 	responseContent, ok := handleUploadToRemoteMessage(msg.Payload(), instanceId)
 	if !ok {
-		logger.Errorf("[Upload Subscribe] Instance: %s, Error unmarshalling incoming message", instanceId)
+		logger.WithInstance(instanceId).Errorf("[Upload Subscribe] Error unmarshalling incoming message")
 		return false
 	}
 	if err := msg.Respond(responseContent); err != nil {
-		logger.Errorf("[Upload Subscribe] Instance: %s, Error responding to upload request: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[Upload Subscribe] Error responding to upload request: %v", err)
 		return false
 	}
-	logger.Debugf("[Upload Subscribe] Instance: %s, Response sent successfully, size: %d bytes", instanceId, len(responseContent))
+	logger.WithInstance(instanceId).Debugf("[Upload Subscribe] Response sent successfully, size: %d bytes", len(responseContent))
 	return true
 }
 
+// buildSCPCommand 生成 scp 命令：isUpload 为 true 时把本地 sourcePath 上传到远端 targetPath，
+// 为 false 时把远端 sourcePath 下载到本地 targetPath。
 func buildSCPCommand(user, host, password, privateKey string, port uint, sourcePath, targetPath string, isUpload bool, profile sshCompatibilityProfile) (string, func(), error) {
 	var cleanup func()
 	var scpCommand string
 	sshOptions := scpOptionFlags(profile)
+	from, to := shellQuote(sourcePath), shellQuoteRemoteTarget(user, host, targetPath)
+	if !isUpload {
+		from, to = shellQuoteRemoteTarget(user, host, sourcePath), shellQuote(targetPath)
+	}
 
 	if privateKey != "" {
-		tmpDir := os.TempDir()
-		tempFile, err := os.CreateTemp(tmpDir, "ssh_key_*")
+		keyFile, removeKeyFile, err := writeTemporaryKeyFile(privateKey)
 		if err != nil {
-			return "", nil, fmt.Errorf("failed to create temporary key file: %v", err)
-		}
-		keyFile := tempFile.Name()
-
-		if _, err := tempFile.Write([]byte(privateKey)); err != nil {
-			tempFile.Close()
-			os.Remove(keyFile)
-			return "", nil, fmt.Errorf("failed to write private key to temp file: %v", err)
-		}
-		if err := tempFile.Close(); err != nil {
-			os.Remove(keyFile)
-			return "", nil, fmt.Errorf("failed to close temporary key file: %v", err)
-		}
-		if err := os.Chmod(keyFile, 0600); err != nil {
-			os.Remove(keyFile)
-			return "", nil, fmt.Errorf("failed to set private key permissions: %v", err)
-		}
-
-		cleanup = func() {
-			os.Remove(keyFile)
-			logger.Debugf("[SCP] Cleaned up temporary key file: %s", keyFile)
+			return "", nil, err
 		}
+		cleanup = removeKeyFile
 
-		if isUpload {
-			scpCommand = fmt.Sprintf("scp -i %s %s -P %d -r %s %s",
-				shellQuote(keyFile), sshOptions, port, shellQuote(sourcePath), shellQuoteRemoteTarget(user, host, targetPath))
-		} else {
-			scpCommand = fmt.Sprintf("scp -i %s %s -P %d -r %s %s",
-				shellQuote(keyFile), sshOptions, port, shellQuoteRemoteTarget(user, host, targetPath), shellQuote(sourcePath))
-		}
+		scpCommand = fmt.Sprintf("scp -i %s %s -P %d -r %s %s", shellQuote(keyFile), sshOptions, port, from, to)
 
 		logger.Debugf("[SCP] Using private key authentication with profile=%s", profile)
 	} else if password != "" {
 		cleanup = func() {}
 
-		if isUpload {
-			scpCommand = fmt.Sprintf("sshpass -e scp %s -P %d -r %s %s",
-				sshOptions, port, shellQuote(sourcePath), shellQuoteRemoteTarget(user, host, targetPath))
-		} else {
-			scpCommand = fmt.Sprintf("sshpass -e scp %s -P %d -r %s %s",
-				sshOptions, port, shellQuoteRemoteTarget(user, host, targetPath), shellQuote(sourcePath))
-		}
+		scpCommand = fmt.Sprintf("sshpass -e scp %s -P %d -r %s %s", sshOptions, port, from, to)
 
 		logger.Debugf("[SCP] Using password authentication with profile=%s", profile)
 	} else {
@@ -515,13 +647,40 @@ func buildSCPCommand(user, host, password, privateKey string, port uint, sourceP
 	return scpCommand, cleanup, nil
 }
 
+// writeTemporaryKeyFile 将私钥写入 os.CreateTemp 创建的唯一文件：文件创建时即为 0600，
+// 并发调用不会共用文件名；写入失败或 panic 时立即删除，成功时由调用方在 scp 结束后调用 cleanup 删除
+func writeTemporaryKeyFile(privateKey string) (keyFile string, cleanup func(), err error) {
+	tempFile, err := os.CreateTemp("", "ssh_key_*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary key file: %v", err)
+	}
+	keyFile = tempFile.Name()
+	defer func() {
+		if cleanup == nil {
+			tempFile.Close()
+			os.Remove(keyFile)
+		}
+	}()
+
+	if _, err := tempFile.WriteString(privateKey); err != nil {
+		return "", nil, fmt.Errorf("failed to write private key to temp file: %v", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to close temporary key file: %v", err)
+	}
+	return keyFile, func() {
+		os.Remove(keyFile)
+		logger.Debugf("[SCP] Cleaned up temporary key file: %s", keyFile)
+	}, nil
+}
+
 func executeSCPWithFallback(instanceId string, request local.ExecuteRequest) local.ExecuteResponse {
 	deadline := time.Now().Add(time.Duration(request.ExecuteTimeout) * time.Second)
 	request.ExecuteTimeout = remainingBudgetSeconds(deadline)
 	if request.ExecuteTimeout <= 0 {
 		return localTimeoutResponse(instanceId, fmt.Sprintf("SCP transfer timed out before execution (timeout budget exhausted): %s", request.LogContext))
 	}
-	logger.Debugf("[SCP] Instance: %s, attempt | profile=modern | %s", instanceId, request.LogContext)
+	logger.WithInstance(instanceId).Debugf("[SCP] attempt | profile=modern | %s", request.LogContext)
 	response := executeLocalSCPCommand(request, instanceId)
 	if response.Success {
 		return response
@@ -536,7 +695,7 @@ func executeSCPWithFallback(instanceId string, request local.ExecuteRequest) loc
 		return response
 	}
 
-	logger.Warnf("[SCP] Instance: %s, retry | profile=modern -> legacy | %s | reason=%s", instanceId, request.LogContext, response.Error)
+	logger.WithInstance(instanceId).Warnf("[SCP] retry | profile=modern -> legacy | %s | reason=%s", request.LogContext, response.Error)
 	legacyRequest := request
 	legacyRequest.Command = legacyCommand
 	legacyRequest.LogCommand = redactSensitiveCommand(legacyCommand)
@@ -547,9 +706,9 @@ func executeSCPWithFallback(instanceId string, request local.ExecuteRequest) loc
 
 	legacyResponse := executeLocalSCPCommand(legacyRequest, instanceId)
 	if legacyResponse.Success {
-		logger.Infof("[SCP] Instance: %s, success | profile=legacy | %s", instanceId, request.LogContext)
+		logger.WithInstance(instanceId).Infof("[SCP] success | profile=legacy | %s", request.LogContext)
 	} else {
-		logger.Warnf("[SCP] Instance: %s, failure | profile=legacy | %s | error=%s | last=%q", instanceId, request.LogContext, legacyResponse.Error, truncateTransferOutput(legacyResponse.Output))
+		logger.WithInstance(instanceId).Warnf("[SCP] failure | profile=legacy | %s | error=%s | last=%q", request.LogContext, legacyResponse.Error, truncateTransferOutput(legacyResponse.Output))
 	}
 
 	return legacyResponse
@@ -658,6 +817,14 @@ func addLegacySCPOptions(command string) string {
 	return command[:portFlagIndex] + legacyOptions + command[portFlagIndex:]
 }
 
+// addSCPPreserveModeOption 为 scp 命令加上 -p，保留源文件的权限位与修改时间
+func addSCPPreserveModeOption(command string) string {
+	if strings.Contains(command, " -p -r ") {
+		return command
+	}
+	return strings.Replace(command, " -r ", " -p -r ", 1)
+}
+
 func invalidSSHExecuteResponse(instanceId, message string) ExecuteResponse {
 	return ExecuteResponse{
 		InstanceId: instanceId,
@@ -665,6 +832,7 @@ func invalidSSHExecuteResponse(instanceId, message string) ExecuteResponse {
 		Output:     message,
 		Code:       utils.ErrorCodeInvalidRequest,
 		Error:      message,
+		ExitCode:   exitCodeUnavailable,
 	}
 }
 
@@ -677,6 +845,7 @@ func newSSHFailureResponse(instanceId, code, message, stage, category string) Ex
 		Error:      message,
 		Stage:      stage,
 		Category:   category,
+		ExitCode:   exitCodeUnavailable,
 	}
 }
 
@@ -689,6 +858,7 @@ func timeoutStageResponse(instanceId, output, message, stage, category string) E
 		Error:      message,
 		Stage:      stage,
 		Category:   category,
+		ExitCode:   exitCodeUnavailable,
 	}
 }
 
@@ -768,13 +938,42 @@ func validateExecuteRequest(req ExecuteRequest) string {
 		return "user is required"
 	case req.Port == 0:
 		return "port must be greater than 0"
-	case req.ExecuteTimeout <= 0:
-		return "execute timeout must be greater than 0"
+	case !isKnownAuthOrder(req.AuthOrder):
+		return fmt.Sprintf("unsupported auth_order: %s", req.AuthOrder)
 	default:
-		return ""
+		if message := validateSourceFiles(req.SourceFiles); message != "" {
+			return message
+		}
+		if message := validateStreamOptions(req); message != "" {
+			return message
+		}
+		if message := validateJumpHosts(req.Jump); message != "" {
+			return message
+		}
+		if message := validateHostKeyOptions(req); message != "" {
+			return message
+		}
+		if req.ConnectTimeout < 0 {
+			return "connect_timeout must not be negative"
+		}
+		if req.SudoPassword != "" && !req.Sudo {
+			return "sudo_password requires sudo"
+		}
+		return validateWorkDir(req.WorkDir)
 	}
 }
 
+// checkDownloadTargets 校验远端目标路径与本地暂存路径都在 DOWNLOAD_ALLOWED_BASE_DIR 内
+func checkDownloadTargets(req DownloadFileRequest) string {
+	if errMsg := utils.CheckDownloadTarget("target_path", req.TargetPath); errMsg != "" {
+		return errMsg
+	}
+	if req.LocalPath != "" {
+		return utils.CheckDownloadTarget("local_path", req.LocalPath)
+	}
+	return ""
+}
+
 func validateTransferTimeout(timeout int) string {
 	if timeout <= 0 {
 		return "execute timeout must be greater than 0"
@@ -828,17 +1027,38 @@ func Execute(req ExecuteRequest, instanceId string) ExecuteResponse {
 	return executeWithConn(req, instanceId, nil)
 }
 
-func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) ExecuteResponse {
+func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) (result ExecuteResponse) {
+	if req.Stream && nc != nil && validateStreamOptions(req) == "" {
+		stream := newOutputStream(nc, outputStreamSubject(instanceId, req.TaskID), req.TaskID, req.StreamBufferLines, req.StreamMaxLinesPerSecond)
+		defer func() {
+			stream.Close()
+			result.StreamDroppedLines = stream.DroppedLines()
+		}()
+		if len(req.Commands) > 0 {
+			return executeCommands(req, instanceId, nc, stream)
+		}
+		return executeCommand(req, instanceId, nc, stream)
+	}
+	if len(req.Commands) > 0 {
+		return executeCommands(req, instanceId, nc, nil)
+	}
+	return executeCommand(req, instanceId, nc, nil)
+}
+
+// executeCommand 执行单条命令；stream 非空时输出行同时发布到 stream
+func executeCommand(req ExecuteRequest, instanceId string, nc *nats.Conn, stream *outputStream) (result ExecuteResponse) {
 	if validationErr := validateExecuteRequest(req); validationErr != "" {
 		return invalidSSHExecuteResponse(instanceId, validationErr)
 	}
+	req.ExecuteTimeout = utils.ExecuteTimeout(req.ExecuteTimeout)
 
 	deadline := time.Now().Add(time.Duration(req.ExecuteTimeout) * time.Second)
 
-	logger.Debugf("[SSH Execute] Instance: %s, Starting SSH connection to %s@%s:%d", instanceId, req.User, req.Host, req.Port)
-	logger.Debugf("[SSH Execute] Instance: %s, Command: %s, Timeout: %ds", instanceId, req.Command, req.ExecuteTimeout)
+	logger.WithInstance(instanceId).Debugf("[SSH Execute] Starting SSH connection to %s@%s:%d", req.User, req.Host, req.Port)
+	logger.WithInstance(instanceId).Debugf("[SSH Execute] Command: %s, Timeout: %ds", req.Command, req.ExecuteTimeout)
 
-	var authMethods []ssh.AuthMethod
+	var keyAuth, passwordAuth ssh.AuthMethod
+	auth := &authRecorder{}
 
 	if req.PrivateKey != "" {
 		var signer ssh.Signer
@@ -852,33 +1072,37 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 
 		if err != nil {
 			errMsg := fmt.Sprintf("Failed to parse private key: %v", err)
-			logger.Errorf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+			logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
 			return ExecuteResponse{
 				InstanceId: instanceId,
 				Success:    false,
 				Output:     errMsg,
 				Code:       utils.ErrorCodeInvalidRequest,
 				Error:      errMsg,
+				ExitCode:   exitCodeUnavailable,
 			}
 		}
-		authMethods = append(authMethods, buildPublicKeyAuthMethod(signer, profileModern))
-		logger.Debugf("[SSH Execute] Instance: %s, Using public key authentication", instanceId)
+		keyAuth = auth.keyAuth(signerForProfile(signer, profileModern))
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] Using public key authentication")
 	}
 
 	if req.Password != "" {
-		authMethods = append(authMethods, ssh.Password(req.Password))
-		logger.Debugf("[SSH Execute] Instance: %s, Password authentication enabled", instanceId)
+		passwordAuth = auth.passwordAuth(req.Password)
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] Password authentication enabled")
 	}
 
+	authMethods := orderAuthMethods(req.AuthOrder, keyAuth, passwordAuth)
+
 	if len(authMethods) == 0 {
 		errMsg := "No authentication method provided (password or private key required)"
-		logger.Errorf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+		logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
 		return ExecuteResponse{
 			InstanceId: instanceId,
 			Success:    false,
 			Output:     errMsg,
 			Code:       utils.ErrorCodeInvalidRequest,
 			Error:      errMsg,
+			ExitCode:   exitCodeUnavailable,
 		}
 	}
 
@@ -893,7 +1117,12 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 		if probeTimeout <= 0 {
 			return timeoutStageResponse(instanceId, "", fmt.Sprintf("SSH execution timed out before TCP probe (timeout: %ds)", req.ExecuteTimeout), sshStageTCPConnect, sshCategoryRemoteTimeout)
 		}
-		if err := tcpProbeFn(addr, probeTimeout); err != nil {
+		// 经跳板机时目标通常无法直连，探测第一级跳板机
+		probeAddr := addr
+		if len(req.Jump) > 0 {
+			probeAddr = jumpHostAddr(req.Jump[0])
+		}
+		if err := tcpProbeFn(probeAddr, probeTimeout); err != nil {
 			if isLikelyTimeoutError(err) {
 				return timeoutStageResponse(instanceId, "", fmt.Sprintf("TCP connect timed out after %s", probeTimeout), sshStageTCPConnect, sshCategoryNetwork)
 			}
@@ -905,33 +1134,50 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 		}
 	}
 
-	hostKeyCallback, err := buildHostKeyCallback()
+	hostKeyCallback, jumpHostKeyCallback, err := buildRequestHostKeyCallbacks(req, instanceId)
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed to configure SSH host key verification: %v", err)
-		logger.Errorf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+		logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
 		return newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSSHDial, sshCategoryDependency)
 	}
 
+	dial := sshDialer(sshDialFn)
+	if len(req.Jump) > 0 {
+		hops, err := buildJumpHops(req.Jump, jumpHostKeyCallback)
+		if err != nil {
+			errMsg := err.Error()
+			logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
+			return ExecuteResponse{InstanceId: instanceId, Success: false, Output: errMsg, Code: utils.ErrorCodeInvalidRequest, Error: errMsg, ExitCode: exitCodeUnavailable}
+		}
+		dial = jumpDialer(hops)
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] Connecting through %d jump host(s)", len(hops))
+	}
+	dial = contextDialer(req.requestContext(), sshPool.dialer(sshPoolKey(req), sshPoolTarget(req), dial))
+
+	connectTimeout := requestConnectTimeout(req)
 	sshConfig := &ssh.ClientConfig{
 		User:              req.User,
 		Auth:              authMethods,
-		Timeout:           minDuration(sshConnectTimeout, remaining),
+		Timeout:           minDuration(connectTimeout, remaining),
 		HostKeyCallback:   hostKeyCallback,
 		HostKeyAlgorithms: hostKeyAlgorithmsForProfile(profileModern),
 	}
 
-	client, err := sshDialFn("tcp", addr, sshConfig)
+	retryPolicy := configuredDialRetryPolicy()
+	retryPolicy.connectTimeout = connectTimeout
+	activeConfig := sshConfig
+	client, err := dialSSHWithRetry(instanceId, dial, addr, sshConfig, deadline, retryPolicy)
 	if err != nil {
 		if shouldRetryWithLegacy(err.Error()) {
 			remaining = remainingBudget(deadline)
 			if remaining <= 0 {
 				errMsg := fmt.Sprintf("SSH dial timed out after %ds before legacy retry", req.ExecuteTimeout)
-				logger.Warnf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+				logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
 				return timeoutStageResponse(instanceId, "", errMsg, sshStageLegacyRetry, sshCategoryCompatibility)
 			}
-			logger.Warnf("[SSH Execute] Instance: %s, modern profile dial failed, retrying legacy profile for %s@%s:%d - Error: %v", instanceId, req.User, req.Host, req.Port, err)
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] modern profile dial failed, retrying legacy profile for %s@%s:%d - Error: %v", req.User, req.Host, req.Port, err)
 
-			legacyAuthMethods := make([]ssh.AuthMethod, 0, len(authMethods))
+			var legacyKeyAuth, legacyPasswordAuth ssh.AuthMethod
 			if req.PrivateKey != "" {
 				var legacySigner ssh.Signer
 				if req.Passphrase != "" {
@@ -942,35 +1188,37 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 
 				if err != nil {
 					errMsg := fmt.Sprintf("Failed to parse private key for legacy retry: %v", err)
-					logger.Errorf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
-					return ExecuteResponse{InstanceId: instanceId, Success: false, Output: errMsg, Code: utils.ErrorCodeInvalidRequest, Error: errMsg}
+					logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
+					return ExecuteResponse{InstanceId: instanceId, Success: false, Output: errMsg, Code: utils.ErrorCodeInvalidRequest, Error: errMsg, ExitCode: exitCodeUnavailable}
 				}
 
-				legacyAuthMethods = append(legacyAuthMethods, buildPublicKeyAuthMethod(legacySigner, profileLegacy))
+				legacyKeyAuth = auth.keyAuth(signerForProfile(legacySigner, profileLegacy))
 			}
 
 			if req.Password != "" {
-				legacyAuthMethods = append(legacyAuthMethods, ssh.Password(req.Password))
+				legacyPasswordAuth = auth.passwordAuth(req.Password)
 			}
+			legacyAuthMethods := orderAuthMethods(req.AuthOrder, legacyKeyAuth, legacyPasswordAuth)
 
 			legacyConfig := &ssh.ClientConfig{
 				User:              req.User,
 				Auth:              legacyAuthMethods,
-				Timeout:           minDuration(sshConnectTimeout, remaining),
+				Timeout:           minDuration(connectTimeout, remaining),
 				HostKeyCallback:   hostKeyCallback,
 				HostKeyAlgorithms: hostKeyAlgorithmsForProfile(profileLegacy),
 			}
 
-			client, err = sshDialFn("tcp", addr, legacyConfig)
+			client, err = dialSSHWithRetry(instanceId, dial, addr, legacyConfig, deadline, retryPolicy)
 			if err == nil {
-				logger.Warnf("[SSH Execute] Instance: %s, legacy profile dial succeeded for %s@%s:%d", instanceId, req.User, req.Host, req.Port)
+				activeConfig = legacyConfig
+				logger.WithInstance(instanceId).Warnf("[SSH Execute] legacy profile dial succeeded for %s@%s:%d", req.User, req.Host, req.Port)
 			}
 		}
 
 		if err != nil {
 			if remainingBudget(deadline) <= 0 || isLikelyTimeoutError(err) {
 				errMsg := fmt.Sprintf("SSH dial timed out after %ds", req.ExecuteTimeout)
-				logger.Warnf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+				logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
 				return timeoutStageResponse(instanceId, "", errMsg, sshStageSSHDial, sshCategoryNetwork)
 			}
 			if isLikelyAuthError(err) {
@@ -986,61 +1234,126 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 				return newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSSHDial, sshCategoryNetwork)
 			}
 			errMsg := fmt.Sprintf("Failed to create SSH client: %v", err)
-			logger.Errorf("[SSH Execute] Instance: %s, Failed to create SSH client for %s@%s:%d - Error: %v", instanceId, req.User, req.Host, req.Port, err)
+			logger.WithInstance(instanceId).Errorf("[SSH Execute] Failed to create SSH client for %s@%s:%d - Error: %v", req.User, req.Host, req.Port, err)
 			return newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSSHDial, sshCategoryDependency)
 		}
 	}
 
-	logger.Debugf("[SSH Execute] Instance: %s, SSH connection established successfully", instanceId)
+	authMethod := auth.Method()
+	if pooled, ok := client.(*pooledSSHClient); ok {
+		authMethod = pooled.rememberAuthMethod(authMethod)
+	}
+	logger.WithInstance(instanceId).Debugf("[SSH Execute] SSH connection established successfully, auth method: %s", authMethod)
+	defer func() {
+		result.AuthMethod = authMethod
+	}()
 	defer func() {
 		client.Close()
-		logger.Debugf("[SSH Execute] Instance: %s, SSH connection closed", instanceId)
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] SSH connection closed")
 	}()
 
-	session, err := client.NewSession()
+	client, session, err := openSessionWithRetry(instanceId, dial, addr, client, activeConfig, deadline, retryPolicy)
 	if err != nil {
 		if remainingBudget(deadline) <= 0 {
 			errMsg := fmt.Sprintf("SSH session setup timed out after %ds", req.ExecuteTimeout)
-			logger.Warnf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
 			return timeoutStageResponse(instanceId, "", errMsg, sshStageSessionCreate, sshCategoryRemoteTimeout)
 		}
 		errMsg := fmt.Sprintf("Failed to create SSH session: %v", err)
-		logger.Errorf("[SSH Execute] Instance: %s, Failed to create SSH session - Error: %v", instanceId, err)
+		logger.WithInstance(instanceId).Errorf("[SSH Execute] Failed to create SSH session - Error: %v", err)
 		return newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSessionCreate, sshCategoryDependency)
 	}
 	defer session.Close()
 
-	outputCapture := utils.NewSharedOutputCapture(utils.CommandOutputLimitBytes)
+	if req.RequestPTY {
+		if err := requestSessionPTY(session); err != nil {
+			errMsg := fmt.Sprintf("Failed to request PTY: %v", err)
+			logger.WithInstance(instanceId).Errorf("[SSH Execute] %s", errMsg)
+			return newSSHFailureResponse(instanceId, utils.ErrorCodeDependencyFailure, errMsg, sshStageSessionCreate, sshCategoryDependency)
+		}
+		defer func() {
+			result.PTY = true
+		}()
+	}
+
+	// 主命令结束（含失败、超时）后在关闭连接前执行 cleanup_command
+	defer func() {
+		if hasCleanupCommand(req) {
+			result.Cleanup = runSSHCleanup(client, req, instanceId)
+		}
+	}()
+
+	var remoteEnv map[string]string
+	if req.CaptureEnv {
+		env, err := captureRemoteEnv(client, req, deadline)
+		if err != nil {
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] %v", err)
+		} else {
+			remoteEnv = env
+		}
+	}
+
+	outputLimit := utils.ResolveOutputLimit(req.MaxOutputBytes)
+	if req.MaxOutputBytes > outputLimit {
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] Requested max_output_bytes=%d exceeds hard limit, capped to %dB", req.MaxOutputBytes, outputLimit)
+	}
+	outputCapture := utils.NewSharedOutputCapture(outputLimit)
 	stdoutWriter := outputCapture.StdoutWriter()
 	stderrWriter := outputCapture.StderrWriter()
 	var stdoutStreamWriter *streamLogWriter
 	var stderrStreamWriter *streamLogWriter
-	if req.StreamLogs && req.StreamLogTopic != "" && nc != nil {
-		stdoutStreamWriter = newStreamLogWriter(nc, req.StreamLogTopic, req.ExecutionID, "stdout")
-		stderrStreamWriter = newStreamLogWriter(nc, req.StreamLogTopic, req.ExecutionID, "stderr")
+	var streamPublisher eventPublisher
+	var streamTopic string
+	switch {
+	case stream != nil:
+		streamPublisher, streamTopic = stream, stream.subject
+	case req.StreamLogs && req.StreamLogTopic != "" && nc != nil:
+		streamPublisher, streamTopic = nc, req.StreamLogTopic
+	}
+	if streamPublisher != nil {
+		stdoutStreamWriter = newStreamLogWriter(streamPublisher, streamTopic, req.ExecutionID, "stdout")
+		stderrStreamWriter = newStreamLogWriter(streamPublisher, streamTopic, req.ExecutionID, "stderr")
 		stdoutWriter = io.MultiWriter(outputCapture.StdoutWriter(), stdoutStreamWriter)
 		stderrWriter = io.MultiWriter(outputCapture.StderrWriter(), stderrStreamWriter)
 	}
+	// 带 execution_id 的任务保留最近输出行，供 tail.<execution_id> 查询
+	if tail, closeTail := utils.DefaultTails.Open(req.ExecutionID); tail != nil {
+		defer closeTail()
+		stdoutWriter = io.MultiWriter(stdoutWriter, tail.Writer())
+		stderrWriter = io.MultiWriter(stderrWriter, tail.Writer())
+	}
 	session.SetStdout(stdoutWriter)
 	session.SetStderr(stderrWriter)
+	session.SetStdin(sudoStdin(req))
 
-	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	ctx, cancel := context.WithDeadline(req.requestContext(), deadline)
 	defer cancel()
+	defer sshCancels.Register(req.TaskID, cancel)()
 
-	logger.Debugf("[SSH Execute] Instance: %s, Executing command...", instanceId)
+	logger.WithInstance(instanceId).Debugf("[SSH Execute] Executing command...")
 	startTime := time.Now()
 
+	remoteCommand := buildRemoteCommand(req)
+	var pidFile string
+	if req.KillOnTimeout {
+		pidFile = newRemotePIDFile()
+		remoteCommand = wrapWithPIDFile(remoteCommand, pidFile)
+	}
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- session.Run(req.Command)
+		errChan <- session.Run(remoteCommand)
 	}()
 
 	select {
 	case <-ctx.Done():
 		duration := time.Since(startTime)
+		canceled := ctx.Err() == context.Canceled
 		errMsg := fmt.Sprintf("SSH execution timed out after %v (timeout: %ds)", duration, req.ExecuteTimeout)
-		logger.Warnf("[SSH Execute] Instance: %s, %s", instanceId, errMsg)
-		session.Signal(ssh.SIGKILL)
+		if canceled {
+			errMsg = fmt.Sprintf("SSH execution canceled after %v (task_id: %s)", duration, req.TaskID)
+		}
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
+		terminateRemoteCommand(client, session, req, pidFile, instanceId)
 		if stdoutStreamWriter != nil {
 			stdoutStreamWriter.Flush()
 		}
@@ -1050,11 +1363,25 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 		snapshot := outputCapture.Snapshot()
 		output := utils.FormatCapturedOutput(string(snapshot.Stdout), string(snapshot.Stderr), snapshot)
 		if snapshot.Truncated {
-			logger.Warnf("[SSH Execute] Instance: %s, Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", instanceId, snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
 		}
-		return timeoutStageResponse(instanceId, output, errMsg, sshStageCommandRun, sshCategoryRemoteTimeout)
+		response := timeoutStageResponse(instanceId, output, errMsg, sshStageCommandRun, sshCategoryRemoteTimeout)
+		response.Stdout = string(snapshot.Stdout)
+		response.Stderr = string(snapshot.Stderr)
+		response.Truncated = snapshot.Truncated
+		response.RemoteEnv = remoteEnv
+		response.Termination = terminationTimeoutKilled
+		if canceled {
+			response.Code = utils.ErrorCodeCanceled
+			response.Category = ""
+			response.Termination = terminationCanceled
+		}
+		return response
 	case err := <-errChan:
 		duration := time.Since(startTime)
+		if isConnectionLostError(err) {
+			discardPooledClient(client)
+		}
 		if stdoutStreamWriter != nil {
 			stdoutStreamWriter.Flush()
 		}
@@ -1064,34 +1391,74 @@ func executeWithConn(req ExecuteRequest, instanceId string, nc *nats.Conn) Execu
 		snapshot := outputCapture.Snapshot()
 		output := utils.FormatCapturedOutput(string(snapshot.Stdout), string(snapshot.Stderr), snapshot)
 
-		if err != nil {
+		exitCode := responseExitCode(err)
+		// source_files 加载或 work_dir 切换失败不受 expected_exit_codes 影响，始终视为失败
+		if runErr := checkExitCode(req, err); runErr != nil || (err != nil && setupFailureHint(snapshot.Stderr) != "") {
+			if runErr != nil {
+				err = runErr
+			}
 			errMsg := fmt.Sprintf("Command execution failed: %v", err)
-			logger.Warnf("[SSH Execute] Instance: %s, Command execution failed after %v - Error: %v", instanceId, duration, err)
-			logger.Debugf("[SSH Execute] Instance: %s, Output: %s", instanceId, output)
+			if hint := setupFailureHint(snapshot.Stderr); hint != "" {
+				errMsg = fmt.Sprintf("%s (%v)", hint, err)
+			}
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] Command execution failed after %v - Error: %v", duration, err)
+			logger.WithInstance(instanceId).Debugf("[SSH Execute] Output: %s", output)
 			if snapshot.Truncated {
-				logger.Warnf("[SSH Execute] Instance: %s, Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", instanceId, snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
+				logger.WithInstance(instanceId).Warnf("[SSH Execute] Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
 			}
 			return ExecuteResponse{
-				Output:     output,
-				InstanceId: instanceId,
-				Success:    false,
-				Code:       utils.ErrorCodeExecutionFailure,
-				Error:      errMsg,
-				Stage:      sshStageCommandRun,
-				Category:   sshCategoryRemoteExit,
+				Output:      output,
+				Stdout:      string(snapshot.Stdout),
+				Stderr:      string(snapshot.Stderr),
+				InstanceId:  instanceId,
+				Success:     false,
+				Code:        utils.ErrorCodeExecutionFailure,
+				Error:       errMsg,
+				Stage:       sshStageCommandRun,
+				Category:    sshCategoryRemoteExit,
+				Truncated:   snapshot.Truncated,
+				ExitCode:    exitCode,
+				RemoteEnv:   remoteEnv,
+				Termination: terminationExited,
 			}
 		}
 
-		logger.Debugf("[SSH Execute] Instance: %s, Command executed successfully in %v", instanceId, duration)
-		logger.Debugf("[SSH Execute] Instance: %s, Output length: %d bytes", instanceId, len(output))
+		if req.FailOnStderr && len(snapshot.Stderr) > 0 {
+			errMsg := fmt.Sprintf("Command wrote %d bytes to stderr and fail_on_stderr is set", len(snapshot.Stderr))
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] %s", errMsg)
+			return ExecuteResponse{
+				Output:      output,
+				Stdout:      string(snapshot.Stdout),
+				Stderr:      string(snapshot.Stderr),
+				InstanceId:  instanceId,
+				Success:     false,
+				Code:        utils.ErrorCodeStderrPresent,
+				Error:       errMsg,
+				Stage:       sshStageCommandRun,
+				Category:    sshCategoryRemoteExit,
+				Truncated:   snapshot.Truncated,
+				ExitCode:    exitCode,
+				RemoteEnv:   remoteEnv,
+				Termination: terminationExited,
+			}
+		}
+
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] Command executed successfully in %v", duration)
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] Output length: %d bytes", len(output))
 		if snapshot.Truncated {
-			logger.Warnf("[SSH Execute] Instance: %s, Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", instanceId, snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] Output exceeded shared capture limit and was truncated (stdout_dropped=%dB stderr_dropped=%dB total_written=%dB)", snapshot.StdoutDropped, snapshot.StderrDropped, snapshot.TotalWritten)
 		}
 
 		return ExecuteResponse{
-			Output:     output,
-			InstanceId: instanceId,
-			Success:    true,
+			Output:      output,
+			Stdout:      string(snapshot.Stdout),
+			Stderr:      string(snapshot.Stderr),
+			InstanceId:  instanceId,
+			Success:     true,
+			Truncated:   snapshot.Truncated,
+			ExitCode:    exitCode,
+			RemoteEnv:   remoteEnv,
+			Termination: terminationExited,
 		}
 	}
 }
@@ -1103,71 +1470,103 @@ func minDuration(a, b time.Duration) time.Duration {
 	return b
 }
 
+func isKnownAuthOrder(order string) bool {
+	switch order {
+	case "", authOrderKeyFirst, authOrderPasswordFirst:
+		return true
+	default:
+		return false
+	}
+}
+
+// orderAuthMethods 按 auth_order 排列认证方式，服务端按此顺序尝试；部分服务端在私钥失败若干次后会锁定账号
+func orderAuthMethods(order string, keyAuth, passwordAuth ssh.AuthMethod) []ssh.AuthMethod {
+	ordered := []ssh.AuthMethod{keyAuth, passwordAuth}
+	if order == authOrderPasswordFirst {
+		ordered = []ssh.AuthMethod{passwordAuth, keyAuth}
+	}
+	var authMethods []ssh.AuthMethod
+	for _, method := range ordered {
+		if method != nil {
+			authMethods = append(authMethods, method)
+		}
+	}
+	return authMethods
+}
+
 func buildPublicKeyAuthMethod(signer ssh.Signer, profile sshCompatibilityProfile) ssh.AuthMethod {
+	return ssh.PublicKeys(signerForProfile(signer, profile))
+}
+
+// signerForProfile 按兼容档位限定 RSA 私钥可用的签名算法，其他类型的私钥原样返回
+func signerForProfile(signer ssh.Signer, profile sshCompatibilityProfile) ssh.Signer {
 	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
-		return ssh.PublicKeys(signer)
+		return signer
 	}
 
 	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
 	if !ok {
-		return ssh.PublicKeys(signer)
+		return signer
 	}
 
 	rsaSigner, err := ssh.NewSignerWithAlgorithms(algorithmSigner, rsaSignerAlgorithmsForProfile(profile))
 	if err != nil {
-		return ssh.PublicKeys(signer)
+		return signer
 	}
 
-	return ssh.PublicKeys(rsaSigner)
+	return rsaSigner
 }
 
 func subscribeSSHExecutor(sub subscriber, nc *nats.Conn, instanceId *string) error {
 	subject := fmt.Sprintf("ssh.execute.%s", *instanceId)
-	logger.Infof("[SSH Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[SSH Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
-		logger.Debugf("[SSH Subscribe] Instance: %s, Received message, size: %d bytes", *instanceId, len(msg.Data))
+		defer utils.DefaultMetrics.Begin("ssh.execute")()
+		logger.WithInstance(*instanceId).Debugf("[SSH Subscribe] Received message, size: %d bytes", len(msg.Data))
 		respondSSHExecuteMessage(natsInboundMsg{msg}, msg.Data, *instanceId, nc)
 	})
 	return err
 }
 
 func SubscribeSSHExecutor(nc *nats.Conn, instanceId *string) {
-	if err := subscribeSSHExecutorFn(nc, nc, instanceId); err != nil {
-		logger.Errorf("[SSH Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeSSHExecutorFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[SSH Subscribe] Failed to subscribe: %v", err)
 	}
 }
 
 func subscribeDownloadToRemote(sub subscriber, nc sshConn, instanceId *string) error {
 	subject := fmt.Sprintf("download.remote.%s", *instanceId)
-	logger.Infof("[Download Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Download Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
-		logger.Debugf("[Download Subscribe] Instance: %s, Received download request, size: %d bytes", *instanceId, len(msg.Data))
+		defer utils.DefaultMetrics.Begin("download.remote")()
+		logger.WithInstance(*instanceId).Debugf("[Download Subscribe] Received download request, size: %d bytes", len(msg.Data))
 		respondDownloadToRemoteSubscription(natsInboundMsg{msg}, *instanceId, nc)
 	})
 	return err
 }
 
 func SubscribeDownloadToRemote(nc *nats.Conn, instanceId *string) {
-	if err := subscribeDownloadToRemoteFn(nc, nc, instanceId); err != nil {
-		logger.Errorf("[Download Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeDownloadToRemoteFn(utils.QueueSubscriber{Conn: nc}, nc, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Download Subscribe] Failed to subscribe: %v", err)
 	}
 }
 
 func subscribeUploadToRemote(sub subscriber, instanceId *string) error {
 	subject := fmt.Sprintf("upload.remote.%s", *instanceId)
-	logger.Infof("[Upload Subscribe] Instance: %s, Subscribing to subject: %s", *instanceId, subject)
+	logger.WithInstance(*instanceId).Infof("[Upload Subscribe] Subscribing to subject: %s", subject)
 
 	_, err := sub.Subscribe(subject, func(msg *nats.Msg) {
-		logger.Debugf("[Upload Subscribe] Instance: %s, Received upload request, size: %d bytes", *instanceId, len(msg.Data))
+		defer utils.DefaultMetrics.Begin("upload.remote")()
+		logger.WithInstance(*instanceId).Debugf("[Upload Subscribe] Received upload request, size: %d bytes", len(msg.Data))
 		respondUploadToRemoteSubscription(natsInboundMsg{msg}, *instanceId)
 	})
 	return err
 }
 
 func SubscribeUploadToRemote(nc *nats.Conn, instanceId *string) {
-	if err := subscribeUploadToRemoteFn(nc, instanceId); err != nil {
-		logger.Errorf("[Upload Subscribe] Instance: %s, Failed to subscribe: %v", *instanceId, err)
+	if err := subscribeUploadToRemoteFn(utils.QueueSubscriber{Conn: nc}, instanceId); err != nil {
+		logger.WithInstance(*instanceId).Errorf("[Upload Subscribe] Failed to subscribe: %v", err)
 	}
 }
@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// exitCodeUnavailable 为命令未执行完成（超时被终止、连接失败等）时返回的退出码，与本地执行器一致
+const exitCodeUnavailable = -1
+
+// remoteExitStatus 从 session.Run 的错误中取出远程命令退出码；
+// 非退出类错误（连接断开、会话异常等）返回 false。
+func remoteExitStatus(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var status interface{ ExitStatus() int }
+	if errors.As(err, &status) {
+		return status.ExitStatus(), true
+	}
+	var code interface{ ExitCode() int }
+	if errors.As(err, &code) {
+		return code.ExitCode(), true
+	}
+	return 0, false
+}
+
+// responseExitCode 返回写入响应的退出码，拿不到退出状态时为 exitCodeUnavailable
+func responseExitCode(err error) int {
+	if exitCode, ok := remoteExitStatus(err); ok {
+		return exitCode
+	}
+	return exitCodeUnavailable
+}
+
+// expectedExitCodes 返回判定成功的退出码集合，未指定时为 [0]
+func expectedExitCodes(req ExecuteRequest) []int {
+	if len(req.ExpectedExitCodes) == 0 {
+		return []int{0}
+	}
+	return req.ExpectedExitCodes
+}
+
+// checkExitCode 按 expected_exit_codes 判定命令结果，返回 nil 表示成功
+func checkExitCode(req ExecuteRequest, runErr error) error {
+	exitCode, ok := remoteExitStatus(runErr)
+	if !ok {
+		return runErr
+	}
+	expected := expectedExitCodes(req)
+	if slices.Contains(expected, exitCode) {
+		return nil
+	}
+	if runErr != nil && len(req.ExpectedExitCodes) == 0 {
+		return runErr
+	}
+	return fmt.Errorf("exit code %d not in expected_exit_codes %v", exitCode, expected)
+}
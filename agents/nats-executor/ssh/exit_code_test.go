@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCheckExitCode(t *testing.T) {
+	exitWith := func(code int) error {
+		return stubExitError{code: code}
+	}
+	testCases := []struct {
+		name     string
+		expected []int
+		runErr   error
+		wantErr  string
+	}{
+		{name: "default accepts zero", runErr: nil},
+		{name: "default rejects non-zero", runErr: exitWith(2), wantErr: "exit status 2"},
+		{name: "custom accepts listed code", expected: []int{0, 2}, runErr: exitWith(2)},
+		{name: "custom rejects zero when not listed", expected: []int{1}, runErr: nil, wantErr: "exit code 0 not in expected_exit_codes [1]"},
+		{name: "custom rejects unlisted code", expected: []int{0, 2}, runErr: exitWith(3), wantErr: "exit code 3 not in expected_exit_codes [0 2]"},
+		{name: "non-exit errors always fail", expected: []int{0, 1}, runErr: errors.New("session closed"), wantErr: "session closed"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExitCode(ExecuteRequest{ExpectedExitCodes: tt.expected}, tt.runErr)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected success, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+type stubExitError struct{ code int }
+
+func (e stubExitError) Error() string   { return fmt.Sprintf("exit status %d", e.code) }
+func (e stubExitError) ExitStatus() int { return e.code }
+
+func TestExecuteHonorsExpectedExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	request := ExecuteRequest{
+		Command:           "echo already-done; exit 3",
+		ExecuteTimeout:    5,
+		Host:              "10.0.0.1",
+		Port:              22,
+		User:              "root",
+		Password:          "secret",
+		ExpectedExitCodes: []int{0, 3},
+	}
+	response := Execute(request, "instance-1")
+	if !response.Success || !strings.Contains(response.Output, "already-done") {
+		t.Fatalf("expected exit 3 to count as success, got %+v", response)
+	}
+
+	request.Command = "exit 0"
+	request.ExpectedExitCodes = []int{1}
+	response = Execute(request, "instance-1")
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure || !strings.Contains(response.Error, "expected_exit_codes [1]") {
+		t.Fatalf("expected exit 0 to fail when not expected, got %+v", response)
+	}
+}
+
+func TestResponseExitCode(t *testing.T) {
+	if got := responseExitCode(nil); got != 0 {
+		t.Fatalf("expected 0 for success, got %d", got)
+	}
+	if got := responseExitCode(stubExitError{code: 2}); got != 2 {
+		t.Fatalf("expected remote exit code 2, got %d", got)
+	}
+	if got := responseExitCode(errors.New("connection lost")); got != exitCodeUnavailable {
+		t.Fatalf("expected %d without exit status, got %d", exitCodeUnavailable, got)
+	}
+}
+
+func TestExecuteReportsRemoteExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	request := ExecuteRequest{Command: "echo partial; exit 2", ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret"}
+	if response := Execute(request, "instance-1"); response.Success || response.ExitCode != 2 {
+		t.Fatalf("expected failure with exit code 2, got %+v", response)
+	}
+
+	request.Command = "true"
+	if response := Execute(request, "instance-1"); !response.Success || response.ExitCode != 0 {
+		t.Fatalf("expected success with exit code 0, got %+v", response)
+	}
+
+	request.Command = "sleep 5"
+	request.ExecuteTimeout = 1
+	if response := Execute(request, "instance-1"); response.Code != utils.ErrorCodeTimeout || response.ExitCode != exitCodeUnavailable {
+		t.Fatalf("expected timeout with exit code %d, got %+v", exitCodeUnavailable, response)
+	}
+}
+
+func TestExecuteReportsUnavailableExitCodeWhenDialFails(t *testing.T) {
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return nil, errors.New("connection refused")
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(ExecuteRequest{Command: "uptime", ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret"}, "instance-1")
+	if response.Success || response.ExitCode != exitCodeUnavailable {
+		t.Fatalf("expected exit code %d for dial failure, got %+v", exitCodeUnavailable, response)
+	}
+}
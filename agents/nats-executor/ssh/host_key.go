@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"nats-executor/logger"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const hostKeyFingerprintPrefix = "SHA256:"
+
+func validateHostKeyOptions(req ExecuteRequest) string {
+	fingerprint := strings.TrimSpace(req.HostKeyFingerprint)
+	switch {
+	case fingerprint != "" && !strings.HasPrefix(fingerprint, hostKeyFingerprintPrefix):
+		return "host_key_fingerprint must be a SHA256 fingerprint (SHA256:...)"
+	case req.InsecureIgnoreHostKey && (fingerprint != "" || strings.TrimSpace(req.KnownHostsFile) != ""):
+		return "insecure_ignore_host_key cannot be combined with host_key_fingerprint or known_hosts_file"
+	default:
+		return ""
+	}
+}
+
+// buildRequestHostKeyCallbacks 返回目标主机与跳板机的主机密钥校验：
+// insecure_ignore_host_key 显式跳过校验并告警；known_hosts_file 优先于 SSH_KNOWN_HOSTS_FILE；
+// host_key_fingerprint 只用于校验目标主机，跳板机仍按 known_hosts 配置校验
+func buildRequestHostKeyCallbacks(req ExecuteRequest, instanceId string) (target, jump ssh.HostKeyCallback, err error) {
+	switch {
+	case req.InsecureIgnoreHostKey:
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] Host key verification disabled by insecure_ignore_host_key for %s", req.Host)
+		jump = ssh.InsecureIgnoreHostKey()
+	case strings.TrimSpace(req.KnownHostsFile) != "":
+		knownHostsFile := strings.TrimSpace(req.KnownHostsFile)
+		jump, err = knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load SSH known_hosts file %s: %w", knownHostsFile, err)
+		}
+	default:
+		jump, err = buildHostKeyCallback()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	target = jump
+	if fingerprint := strings.TrimSpace(req.HostKeyFingerprint); fingerprint != "" {
+		target = fingerprintHostKeyCallback(fingerprint)
+	}
+	return target, jump, nil
+}
+
+// fingerprintHostKeyCallback 要求主机公钥的 SHA256 指纹（与 ssh-keygen -lf 输出一致）与期望值相同
+func fingerprintHostKeyCallback(expected string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if actual := ssh.FingerprintSHA256(key); actual != expected {
+			return fmt.Errorf("host key fingerprint mismatch for %s: got %s, expected %s", hostname, actual, expected)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func writeKnownHosts(t *testing.T, addr string, key gossh.PublicKey) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("write known_hosts: %v", err)
+	}
+	return path
+}
+
+func TestExecuteVerifiesHostKeyPerRequest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	t.Setenv(sshKnownHostsFileEnv, "")
+	server := startTestSSHServer(t, "secret")
+	other := startTestSSHServer(t, "secret")
+	base := ExecuteRequest{Command: "echo verified", ExecuteTimeout: 10, Host: "127.0.0.1", Port: server.port(), User: "root", Password: "secret"}
+
+	tests := []struct {
+		name    string
+		mutate  func(*ExecuteRequest)
+		success bool
+	}{
+		{name: "matching fingerprint", mutate: func(req *ExecuteRequest) { req.HostKeyFingerprint = gossh.FingerprintSHA256(server.hostKey) }, success: true},
+		{name: "mismatched fingerprint", mutate: func(req *ExecuteRequest) { req.HostKeyFingerprint = gossh.FingerprintSHA256(other.hostKey) }},
+		{name: "matching known_hosts_file", mutate: func(req *ExecuteRequest) { req.KnownHostsFile = writeKnownHosts(t, server.addr, server.hostKey) }, success: true},
+		{name: "mismatched known_hosts_file", mutate: func(req *ExecuteRequest) { req.KnownHostsFile = writeKnownHosts(t, server.addr, other.hostKey) }},
+	}
+	for _, tt := range tests {
+		req := base
+		tt.mutate(&req)
+		response := Execute(req, "instance-1")
+		if response.Success != tt.success {
+			t.Fatalf("%s: expected success=%v, got %+v", tt.name, tt.success, response)
+		}
+		if !tt.success && response.Termination != "" {
+			t.Fatalf("%s: expected connection to be refused before running the command, got %+v", tt.name, response)
+		}
+	}
+
+	req := base
+	req.HostKeyFingerprint = gossh.FingerprintSHA256(other.hostKey)
+	if response := Execute(req, "instance-1"); !strings.Contains(response.Error, "host key fingerprint mismatch") {
+		t.Fatalf("expected fingerprint mismatch error, got %+v", response)
+	}
+}
+
+func TestExecuteInsecureIgnoreHostKeyOverridesConfiguredKnownHosts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	server := startTestSSHServer(t, "secret")
+	other := startTestSSHServer(t, "secret")
+	t.Setenv(sshKnownHostsFileEnv, writeKnownHosts(t, server.addr, other.hostKey))
+	base := ExecuteRequest{Command: "echo ok", ExecuteTimeout: 10, Host: "127.0.0.1", Port: server.port(), User: "root", Password: "secret"}
+
+	if response := Execute(base, "instance-1"); response.Success {
+		t.Fatalf("expected SSH_KNOWN_HOSTS_FILE mismatch to refuse the connection, got %+v", response)
+	}
+	req := base
+	req.InsecureIgnoreHostKey = true
+	if response := Execute(req, "instance-1"); !response.Success {
+		t.Fatalf("expected explicit insecure opt-in to skip verification, got %+v", response)
+	}
+}
+
+func TestValidateHostKeyOptions(t *testing.T) {
+	base := ExecuteRequest{Command: "uptime", Host: "10.0.0.1", Port: 22, User: "root", Password: "secret"}
+	tests := []struct {
+		mutate func(*ExecuteRequest)
+		want   string
+	}{
+		{mutate: func(req *ExecuteRequest) { req.HostKeyFingerprint = "MD5:aa:bb" }, want: "host_key_fingerprint must be a SHA256 fingerprint (SHA256:...)"},
+		{mutate: func(req *ExecuteRequest) {
+			req.InsecureIgnoreHostKey = true
+			req.KnownHostsFile = "/etc/ssh/known_hosts"
+		}, want: "insecure_ignore_host_key cannot be combined with host_key_fingerprint or known_hosts_file"},
+	}
+	for _, tt := range tests {
+		req := base
+		tt.mutate(&req)
+		response := Execute(req, "instance-1")
+		if response.Code != utils.ErrorCodeInvalidRequest || response.Error != tt.want {
+			t.Fatalf("expected %q, got %+v", tt.want, response)
+		}
+	}
+}
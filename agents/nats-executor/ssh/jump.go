@@ -0,0 +1,176 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSSHPort 为跳板机未指定 port 时使用的端口
+const defaultSSHPort = 22
+
+// sshDialer 建立到 addr 的 SSH 连接，直连时为 sshDialFn，经跳板机时为 jumpDialer
+type sshDialer func(network, addr string, config *ssh.ClientConfig) (sshClient, error)
+
+// contextDialer 在 ctx 取消时立即放弃等待拨号结果，拨号随后建立的连接直接关闭
+func contextDialer(ctx context.Context, dial sshDialer) sshDialer {
+	if ctx.Done() == nil {
+		return dial
+	}
+	return func(network, addr string, config *ssh.ClientConfig) (sshClient, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		type result struct {
+			client sshClient
+			err    error
+		}
+		done := make(chan result, 1)
+		go func() {
+			client, err := dial(network, addr, config)
+			done <- result{client: client, err: err}
+		}()
+		select {
+		case r := <-done:
+			return r.client, r.err
+		case <-ctx.Done():
+			go func() {
+				if r := <-done; r.client != nil {
+					r.client.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type jumpHop struct {
+	addr   string
+	config *ssh.ClientConfig
+}
+
+// jumpedSSHClient 为经跳板机建立的目标连接，关闭时先关闭目标连接，再由近及远关闭各级跳板机连接
+type jumpedSSHClient struct {
+	sshClient
+	jumps []*ssh.Client
+}
+
+func (c jumpedSSHClient) Close() error {
+	err := c.sshClient.Close()
+	closeJumpChain(c.jumps)
+	return err
+}
+
+func closeJumpChain(jumps []*ssh.Client) {
+	for i := len(jumps) - 1; i >= 0; i-- {
+		jumps[i].Close()
+	}
+}
+
+func jumpHostAddr(jump JumpHost) string {
+	port := jump.Port
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	return net.JoinHostPort(jump.Host, fmt.Sprint(port))
+}
+
+func validateJumpHosts(jumps []JumpHost) string {
+	for i, jump := range jumps {
+		switch {
+		case strings.TrimSpace(jump.Host) == "":
+			return fmt.Sprintf("jump[%d].host is required", i)
+		case strings.TrimSpace(jump.User) == "":
+			return fmt.Sprintf("jump[%d].user is required", i)
+		case jump.Password == "" && jump.PrivateKey == "":
+			return fmt.Sprintf("jump[%d] requires password or private_key", i)
+		}
+	}
+	return ""
+}
+
+// buildJumpHops 为每级跳板机构造客户端配置，主机密钥校验与目标主机一致
+func buildJumpHops(jumps []JumpHost, hostKeyCallback ssh.HostKeyCallback) ([]jumpHop, error) {
+	hops := make([]jumpHop, 0, len(jumps))
+	for i, jump := range jumps {
+		var authMethods []ssh.AuthMethod
+		if jump.PrivateKey != "" {
+			var signer ssh.Signer
+			var err error
+			if jump.Passphrase != "" {
+				signer, err = parsePrivateKeyWithPassphraseFn([]byte(jump.PrivateKey), []byte(jump.Passphrase))
+			} else {
+				signer, err = parsePrivateKeyFn([]byte(jump.PrivateKey))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse jump[%d] private key: %w", i, err)
+			}
+			authMethods = append(authMethods, buildPublicKeyAuthMethod(signer, profileModern))
+		}
+		if jump.Password != "" {
+			authMethods = append(authMethods, ssh.Password(jump.Password))
+		}
+		hops = append(hops, jumpHop{
+			addr: jumpHostAddr(jump),
+			config: &ssh.ClientConfig{
+				User:              jump.User,
+				Auth:              authMethods,
+				HostKeyCallback:   hostKeyCallback,
+				HostKeyAlgorithms: hostKeyAlgorithmsForProfile(profileModern),
+			},
+		})
+	}
+	return hops, nil
+}
+
+// jumpDialer 依次连接各级跳板机，经最后一级建立到目标的隧道后完成与目标的握手；
+// 每次拨号都重建整条链路，连接重置重试与直连一致
+func jumpDialer(hops []jumpHop) sshDialer {
+	return func(network, addr string, config *ssh.ClientConfig) (sshClient, error) {
+		var chain []*ssh.Client
+		var via *ssh.Client
+		for i, hop := range hops {
+			hopConfig := *hop.config
+			hopConfig.Timeout = config.Timeout
+			client, err := dialSSHHop(via, network, hop.addr, &hopConfig)
+			if err != nil {
+				closeJumpChain(chain)
+				return nil, fmt.Errorf("jump[%d] %s: %w", i, hop.addr, err)
+			}
+			chain = append(chain, client)
+			via = client
+		}
+		target, err := dialSSHHop(via, network, addr, config)
+		if err != nil {
+			closeJumpChain(chain)
+			return nil, err
+		}
+		return jumpedSSHClient{sshClient: realSSHClient{client: target}, jumps: chain}, nil
+	}
+}
+
+// dialSSHHop 经上一级连接（为空时直连）建立到 addr 的 SSH 连接；隧道连接不支持读写超时，
+// 握手超过 config.Timeout 时关闭隧道使握手失败
+func dialSSHHop(via *ssh.Client, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial(network, addr, config)
+	}
+	conn, err := via.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		timer := time.AfterFunc(config.Timeout, func() { conn.Close() })
+		defer timer.Stop()
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,251 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testSSHServer 为进程内 SSH 服务端：只接受 password 认证，支持 exec（本地 sh 执行）、pty-req 与 direct-tcpip 端口转发
+type testSSHServer struct {
+	addr    string
+	hostKey gossh.PublicKey
+	closed  chan struct{} // 每个客户端连接断开时写入一次
+}
+
+func startTestSSHServer(t *testing.T, password string) testSSHServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostSigner, err := gossh.NewSignerFromSigner(hostKey)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(conn gossh.ConnMetadata, pass []byte) (*gossh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("password rejected")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := testSSHServer{addr: listener.Addr().String(), hostKey: hostSigner.PublicKey(), closed: make(chan struct{}, 16)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func (s testSSHServer) port() uint {
+	_, port, _ := net.SplitHostPort(s.addr)
+	value, _ := strconv.Atoi(port)
+	return uint(value)
+}
+
+func (s testSSHServer) serve(conn net.Conn, config *gossh.ServerConfig) {
+	defer conn.Close()
+	serverConn, channels, requests, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer func() { s.closed <- struct{}{} }()
+	go gossh.DiscardRequests(requests)
+	go func() {
+		for newChannel := range channels {
+			switch newChannel.ChannelType() {
+			case "direct-tcpip":
+				go forwardDirectTCPIP(newChannel)
+			case "session":
+				go serveExecSession(newChannel)
+			default:
+				newChannel.Reject(gossh.UnknownChannelType, "unsupported channel type")
+			}
+		}
+	}()
+	serverConn.Wait()
+}
+
+func forwardDirectTCPIP(newChannel gossh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := gossh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(gossh.ConnectionFailed, "invalid direct-tcpip payload")
+		return
+	}
+	upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.FormatUint(uint64(target.Port), 10)))
+	if err != nil {
+		newChannel.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	go gossh.DiscardRequests(requests)
+	go func() {
+		io.Copy(upstream, channel)
+		upstream.Close()
+	}()
+	io.Copy(channel, upstream)
+	channel.Close()
+}
+
+func serveExecSession(newChannel gossh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	pty := false
+	for req := range requests {
+		// 与真实终端一致，分配 PTY 后 stderr 合并写入 stdout
+		if req.Type == "pty-req" {
+			pty = true
+			req.Reply(true, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if req.Type != "exec" || gossh.Unmarshal(req.Payload, &payload) != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		if pty {
+			cmd.Stderr = channel
+		}
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+		channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+func waitForClosedConn(t *testing.T, server testSSHServer, name string) {
+	t.Helper()
+	select {
+	case <-server.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s connection to be closed", name)
+	}
+}
+
+func TestExecuteThroughMultipleJumpHosts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	t.Setenv(sshKnownHostsFileEnv, "")
+	target := startTestSSHServer(t, "target-secret")
+	firstJump := startTestSSHServer(t, "jump1-secret")
+	secondJump := startTestSSHServer(t, "jump2-secret")
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo via-jump",
+		ExecuteTimeout: 10,
+		Host:           "127.0.0.1",
+		Port:           target.port(),
+		User:           "root",
+		Password:       "target-secret",
+		Jump: []JumpHost{
+			{Host: "127.0.0.1", Port: firstJump.port(), User: "jump", Password: "jump1-secret"},
+			{Host: "127.0.0.1", Port: secondJump.port(), User: "jump", Password: "jump2-secret"},
+		},
+	}, "instance-1")
+	if !response.Success || strings.TrimSpace(response.Stdout) != "via-jump" {
+		t.Fatalf("expected command to run through jump hosts, got %+v", response)
+	}
+
+	waitForClosedConn(t, target, "target")
+	waitForClosedConn(t, secondJump, "second jump")
+	waitForClosedConn(t, firstJump, "first jump")
+}
+
+func TestExecuteReportsJumpHostAuthenticationFailure(t *testing.T) {
+	t.Setenv(sshKnownHostsFileEnv, "")
+	target := startTestSSHServer(t, "target-secret")
+	jump := startTestSSHServer(t, "jump-secret")
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo unreachable",
+		ExecuteTimeout: 10,
+		Host:           "127.0.0.1",
+		Port:           target.port(),
+		User:           "root",
+		Password:       "target-secret",
+		Jump:           []JumpHost{{Host: "127.0.0.1", Port: jump.port(), User: "jump", Password: "wrong"}},
+	}, "instance-1")
+	if response.Success || response.Category != sshCategoryAuth || !strings.Contains(response.Error, "jump[0]") {
+		t.Fatalf("expected jump host auth failure, got %+v", response)
+	}
+}
+
+func TestValidateJumpHosts(t *testing.T) {
+	tests := []struct {
+		jump JumpHost
+		want string
+	}{
+		{jump: JumpHost{User: "root", Password: "secret"}, want: "jump[0].host is required"},
+		{jump: JumpHost{Host: "10.0.0.1", Password: "secret"}, want: "jump[0].user is required"},
+		{jump: JumpHost{Host: "10.0.0.1", User: "root"}, want: "jump[0] requires password or private_key"},
+		{jump: JumpHost{Host: "10.0.0.1", User: "root", Password: "secret"}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := validateJumpHosts([]JumpHost{tt.jump}); got != tt.want {
+			t.Fatalf("jump %+v: expected %q, got %q", tt.jump, tt.want, got)
+		}
+	}
+
+	response := Execute(ExecuteRequest{Command: "uptime", Host: "10.0.0.1", Port: 22, User: "root", Password: "secret", Jump: []JumpHost{{Host: "10.0.0.2"}}}, "instance-1")
+	if response.Code != utils.ErrorCodeInvalidRequest || response.Error != "jump[0].user is required" {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+}
+
+func TestJumpHostAddrDefaultsToPort22(t *testing.T) {
+	if got := jumpHostAddr(JumpHost{Host: "bastion"}); got != "bastion:22" {
+		t.Fatalf("unexpected addr: %q", got)
+	}
+	if got := jumpHostAddr(JumpHost{Host: "fe80::1", Port: 2222}); got != "[fe80::1]:2222" {
+		t.Fatalf("unexpected addr: %q", got)
+	}
+}
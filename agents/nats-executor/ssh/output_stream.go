@@ -0,0 +1,173 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nats-executor/logger"
+)
+
+// defaultOutputStreamBufferLines 为未指定 stream_buffer_lines 时待发布行数的上限
+const defaultOutputStreamBufferLines = 1024
+
+// outputStreamDrainTimeout 为命令结束后等待剩余行发布完毕的上限，超时后剩余行计为丢弃；
+// 需小于 utils.HandlerDeadlineGrace，命令用满超时时最终响应仍能在处理器截止前返回
+const outputStreamDrainTimeout = 2 * time.Second
+
+func outputStreamSubject(instanceId, taskID string) string {
+	return fmt.Sprintf("ssh.output.%s.%s", instanceId, taskID)
+}
+
+func validateStreamOptions(req ExecuteRequest) string {
+	switch {
+	case req.Stream && strings.TrimSpace(req.TaskID) == "":
+		return "task_id is required when stream is enabled"
+	case req.StreamMaxLinesPerSecond < 0:
+		return "stream_max_lines_per_second must not be negative"
+	case req.StreamBufferLines < 0:
+		return "stream_buffer_lines must not be negative"
+	default:
+		return ""
+	}
+}
+
+// streamEndEvent 为流式输出的结束标记，订阅方收到后即可退订
+type streamEndEvent struct {
+	TaskID       string `json:"task_id"`
+	Done         bool   `json:"done"`
+	DroppedLines int64  `json:"dropped_lines,omitempty"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// outputStream 把 stream 模式的输出行经有界队列异步发布到 ssh.output.<instance_id>.<task_id>，
+// 结束时发布 done 事件；commands 中的各条命令共用同一个 outputStream。
+// 发布跟不上（NATS 变慢或达到 stream_max_lines_per_second）时队列写满，丢弃最旧的行，远端命令不受影响
+type outputStream struct {
+	publisher eventPublisher
+	subject   string
+	taskID    string
+	interval  time.Duration
+	queue     chan []byte
+	abort     chan struct{}
+	done      chan struct{}
+	dropped   atomic.Int64
+	mu        sync.Mutex
+	closed    bool
+}
+
+func newOutputStream(publisher eventPublisher, subject, taskID string, bufferLines, maxLinesPerSecond int) *outputStream {
+	if bufferLines <= 0 {
+		bufferLines = defaultOutputStreamBufferLines
+	}
+	s := &outputStream{
+		publisher: publisher,
+		subject:   subject,
+		taskID:    taskID,
+		queue:     make(chan []byte, bufferLines),
+		abort:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if maxLinesPerSecond > 0 {
+		s.interval = time.Second / time.Duration(maxLinesPerSecond)
+	}
+	go s.run()
+	return s
+}
+
+// Publish 只入队不等待发布，队列已满时丢弃最旧的行并计数；超时返回后远端仍可能写入，关闭后的写入直接忽略
+func (s *outputStream) Publish(subject string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	for {
+		select {
+		case s.queue <- data:
+			return nil
+		default:
+		}
+		select {
+		case <-s.queue:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// DroppedLines 返回因队列写满或排空超时而未发布的行数
+func (s *outputStream) DroppedLines() int64 {
+	return s.dropped.Load()
+}
+
+func (s *outputStream) run() {
+	defer close(s.done)
+	var ticker *time.Ticker
+	if s.interval > 0 {
+		ticker = time.NewTicker(s.interval)
+		defer ticker.Stop()
+	}
+	aborted := false
+	for payload := range s.queue {
+		if aborted {
+			s.dropped.Add(1)
+			continue
+		}
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-s.abort:
+				aborted = true
+				s.dropped.Add(1)
+				continue
+			}
+		}
+		if err := s.publisher.Publish(s.subject, payload); err != nil {
+			logger.Warnf("[SSH Execute] stream publish failed: %v", err)
+		}
+	}
+	dropped := s.dropped.Load()
+	if dropped > 0 {
+		logger.Warnf("[SSH Execute] Dropped %d output lines on %s because publishing fell behind", dropped, s.subject)
+	}
+	payload, _ := json.Marshal(streamEndEvent{
+		TaskID:       s.taskID,
+		Done:         true,
+		DroppedLines: dropped,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := s.publisher.Publish(s.subject, payload); err != nil {
+		logger.Warnf("[SSH Execute] stream end publish failed: %v", err)
+	}
+}
+
+// Close 在所有写入结束后调用，等待剩余行与结束事件发布完毕；限速下排空超时时放弃剩余行并立即发布结束事件
+func (s *outputStream) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(outputStreamDrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+		return
+	case <-timer.C:
+	}
+	close(s.abort)
+	timer.Reset(outputStreamDrainTimeout)
+	select {
+	case <-s.done:
+	case <-timer.C:
+		logger.Warnf("[SSH Execute] Timed out draining output stream %s", s.subject)
+	}
+}
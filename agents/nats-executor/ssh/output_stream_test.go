@@ -0,0 +1,245 @@
+package ssh
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	"github.com/nats-io/nats.go"
+)
+
+type blockingPublisher struct {
+	release   chan struct{}
+	published chan []byte
+}
+
+func (p *blockingPublisher) Publish(subject string, data []byte) error {
+	<-p.release
+	p.published <- data
+	return nil
+}
+
+func decodeStreamEnd(t *testing.T, payload []byte) streamEndEvent {
+	t.Helper()
+	var event streamEndEvent
+	if err := json.Unmarshal(payload, &event); err != nil || !event.Done {
+		t.Fatalf("expected stream end event, got %s (%v)", payload, err)
+	}
+	return event
+}
+
+func TestOutputStreamPublishesLinesThenEndEvent(t *testing.T) {
+	publisher := &stubPublisher{}
+	stream := newOutputStream(publisher, outputStreamSubject("instance-1", "task-1"), "task-1", 0, 0)
+	writer := newStreamLogWriter(stream, stream.subject, "exec-1", "stdout")
+	_, _ = writer.Write([]byte("first\nsecond\n"))
+	stream.Close()
+
+	if len(publisher.events) != 3 {
+		t.Fatalf("expected two lines and an end event, got %d events", len(publisher.events))
+	}
+	for i, want := range []string{"first", "second"} {
+		var event streamEvent
+		if err := json.Unmarshal(publisher.events[i].payload, &event); err != nil || event.Line != want {
+			t.Fatalf("event %d: expected line %q, got %s", i, want, publisher.events[i].payload)
+		}
+		if publisher.events[i].topic != "ssh.output.instance-1.task-1" {
+			t.Fatalf("unexpected subject %q", publisher.events[i].topic)
+		}
+	}
+	if end := decodeStreamEnd(t, publisher.events[2].payload); end.TaskID != "task-1" || end.DroppedLines != 0 {
+		t.Fatalf("unexpected end event: %+v", end)
+	}
+}
+
+func TestOutputStreamDropsLinesInsteadOfBlockingWhenPublishingFallsBehind(t *testing.T) {
+	publisher := &blockingPublisher{release: make(chan struct{}), published: make(chan []byte, defaultOutputStreamBufferLines+16)}
+	stream := newOutputStream(publisher, "ssh.output.instance-1.task-1", "task-1", 0, 0)
+
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < defaultOutputStreamBufferLines+10; i++ {
+			_ = stream.Publish(stream.subject, []byte("line"))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Publish not to block while the publisher is stalled")
+	}
+
+	close(publisher.release)
+	stream.Close()
+	close(publisher.published)
+	var last []byte
+	count := 0
+	for payload := range publisher.published {
+		last = payload
+		count++
+	}
+	end := decodeStreamEnd(t, last)
+	if end.DroppedLines == 0 || int64(count-1)+end.DroppedLines != defaultOutputStreamBufferLines+10 {
+		t.Fatalf("expected published and dropped lines to add up, got published=%d dropped=%d", count-1, end.DroppedLines)
+	}
+}
+
+func TestOutputStreamIgnoresWritesAfterClose(t *testing.T) {
+	publisher := &stubPublisher{}
+	stream := newOutputStream(publisher, "ssh.output.instance-1.task-1", "task-1", 0, 0)
+	stream.Close()
+	stream.Close()
+	if err := stream.Publish(stream.subject, []byte("late")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(publisher.events) != 1 {
+		t.Fatalf("expected only the end event, got %d events", len(publisher.events))
+	}
+}
+
+func TestExecuteValidatesStreamOptions(t *testing.T) {
+	base := ExecuteRequest{Command: "uptime", Host: "10.0.0.1", Port: 22, User: "root", Password: "secret", Stream: true, TaskID: "task-1"}
+	tests := []struct {
+		mutate func(*ExecuteRequest)
+		want   string
+	}{
+		{mutate: func(req *ExecuteRequest) { req.TaskID = "" }, want: "task_id is required when stream is enabled"},
+		{mutate: func(req *ExecuteRequest) { req.StreamMaxLinesPerSecond = -1 }, want: "stream_max_lines_per_second must not be negative"},
+		{mutate: func(req *ExecuteRequest) { req.StreamBufferLines = -1 }, want: "stream_buffer_lines must not be negative"},
+	}
+	for _, tt := range tests {
+		req := base
+		tt.mutate(&req)
+		response := Execute(req, "instance-1")
+		if response.Code != utils.ErrorCodeInvalidRequest || response.Error != tt.want {
+			t.Fatalf("expected %q, got %+v", tt.want, response)
+		}
+	}
+}
+
+func TestExecuteStreamsOutputLinesToTaskSubject(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	nc := startE2ENATS(t)
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	messages := make(chan *nats.Msg, 16)
+	sub, err := nc.ChanSubscribe("ssh.output.instance-1.task-1", messages)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	response := executeWithConn(ExecuteRequest{
+		Commands:       []string{"echo one", "echo two >&2"},
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		TaskID:         "task-1",
+		Stream:         true,
+	}, "instance-1", nc)
+	if !response.Success {
+		t.Fatalf("expected success, got %+v", response)
+	}
+
+	var lines []streamEvent
+	for {
+		select {
+		case msg := <-messages:
+			var end streamEndEvent
+			if json.Unmarshal(msg.Data, &end) == nil && end.Done {
+				if len(lines) != 2 || lines[0].Stream != "stdout" || lines[0].Line != "one" || lines[1].Stream != "stderr" || lines[1].Line != "two" {
+					t.Fatalf("unexpected streamed lines: %+v", lines)
+				}
+				return
+			}
+			var event streamEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				t.Fatalf("decode event: %v", err)
+			}
+			lines = append(lines, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected end event, got lines %+v", lines)
+		}
+	}
+}
+
+func TestExecuteStreamRateCapDropsOldestLinesAndKeepsCommandRunning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	nc := startE2ENATS(t)
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	messages := make(chan *nats.Msg, 1024)
+	sub, err := nc.ChanSubscribe("ssh.output.instance-1.task-1", messages)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	start := time.Now()
+	response := executeWithConn(ExecuteRequest{
+		Command:                 "seq 1 5000",
+		ExecuteTimeout:          10,
+		Host:                    "10.0.0.1",
+		Port:                    22,
+		User:                    "root",
+		Password:                "secret",
+		TaskID:                  "task-1",
+		Stream:                  true,
+		StreamMaxLinesPerSecond: 100,
+		StreamBufferLines:       10,
+	}, "instance-1", nc)
+	if !response.Success || !strings.HasSuffix(strings.TrimSpace(response.Stdout), "\n5000") {
+		t.Fatalf("expected full output in the response, got %+v", response)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected rate cap not to slow the command down, took %s", elapsed)
+	}
+
+	var lines []string
+	for {
+		select {
+		case msg := <-messages:
+			var end streamEndEvent
+			if json.Unmarshal(msg.Data, &end) == nil && end.Done {
+				if end.DroppedLines == 0 || end.DroppedLines != response.StreamDroppedLines {
+					t.Fatalf("expected dropped lines in end event and response, got end=%d response=%d", end.DroppedLines, response.StreamDroppedLines)
+				}
+				if int64(len(lines))+end.DroppedLines != 5000 {
+					t.Fatalf("expected published and dropped lines to add up, got published=%d dropped=%d", len(lines), end.DroppedLines)
+				}
+				// 丢弃的是最旧的行，最后几行总能发布出去
+				if len(lines) == 0 || lines[len(lines)-1] != "5000" {
+					t.Fatalf("expected newest line to be published, got %v", lines)
+				}
+				return
+			}
+			var event streamEvent
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				t.Fatalf("decode event: %v", err)
+			}
+			lines = append(lines, event.Line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected end event, got %d lines", len(lines))
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package ssh
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nats-executor/logger"
+	"nats-executor/utils"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshPoolMaxConnsEnv           = "SSH_POOL_MAX_CONNS"
+	sshPoolIdleTimeoutEnv        = "SSH_POOL_IDLE_TIMEOUT_SECONDS"
+	defaultSSHPoolIdleTimeoutSec = 60
+)
+
+type sshPoolSettings struct {
+	maxConns    int // 池中最多保留的空闲连接数，0 为不启用连接池
+	idleTimeout time.Duration
+}
+
+// configuredSSHPoolSettings 读取连接池配置，非法值回退默认值
+func configuredSSHPoolSettings() sshPoolSettings {
+	settings := sshPoolSettings{idleTimeout: defaultSSHPoolIdleTimeoutSec * time.Second}
+	if value := strings.TrimSpace(os.Getenv(sshPoolMaxConnsEnv)); value != "" {
+		if maxConns, err := strconv.Atoi(value); err == nil && maxConns >= 0 {
+			settings.maxConns = maxConns
+		} else {
+			logger.Warnf("[SSH Pool] invalid %s=%q, connection pool disabled", sshPoolMaxConnsEnv, value)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(sshPoolIdleTimeoutEnv)); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			settings.idleTimeout = time.Duration(seconds) * time.Second
+		} else {
+			logger.Warnf("[SSH Pool] invalid %s=%q, using default %ds", sshPoolIdleTimeoutEnv, value, defaultSSHPoolIdleTimeoutSec)
+		}
+	}
+	return settings
+}
+
+// sshPoolKeySecret 为进程内随机生成的 HMAC 密钥，连接池 key 不能离线比对出凭据
+var sshPoolKeySecret = newSSHPoolKeySecret()
+
+func newSSHPoolKeySecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("ssh pool: failed to generate key secret: %v", err))
+	}
+	return secret
+}
+
+// sshPoolTarget 返回连接池日志中使用的 user@host:port，不含任何凭据信息
+func sshPoolTarget(req ExecuteRequest) string {
+	return fmt.Sprintf("%s@%s:%d", req.User, req.Host, req.Port)
+}
+
+// sshPoolKey 以 user@host:port 加上认证与主机校验相关参数的 HMAC 作为连接池 key，
+// 凭据或跳板机不同的请求不会复用同一连接；key 只在进程内使用，不写入日志
+func sshPoolKey(req ExecuteRequest) string {
+	fingerprint, _ := json.Marshal(struct {
+		Password, PrivateKey, Passphrase  string
+		Jump                              []JumpHost
+		HostKeyFingerprint, KnownHostsEnv string
+		KnownHostsFile                    string
+		InsecureIgnoreHostKey             bool
+	}{
+		Password:              req.Password,
+		PrivateKey:            req.PrivateKey,
+		Passphrase:            req.Passphrase,
+		Jump:                  req.Jump,
+		HostKeyFingerprint:    req.HostKeyFingerprint,
+		KnownHostsEnv:         configuredKnownHostsFile(),
+		KnownHostsFile:        req.KnownHostsFile,
+		InsecureIgnoreHostKey: req.InsecureIgnoreHostKey,
+	})
+	mac := hmac.New(sha256.New, sshPoolKeySecret)
+	mac.Write(fingerprint)
+	return sshPoolTarget(req) + "#" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// pooledSSHClient 为从连接池借出的连接：同一时间只被一个请求使用，Close 时放回连接池
+type pooledSSHClient struct {
+	sshClient
+	pool *sshClientPool
+	key  string
+	// user@host:port，用于日志
+	target string
+	// 建立该连接时使用的拨号参数，复用时连接已失效则用其重新拨号
+	dial   sshDialer
+	addr   string
+	config *ssh.ClientConfig
+
+	mu         sync.Mutex
+	authMethod string
+	reused     bool
+	discarded  bool
+	idleSince  time.Time
+	idleTimer  *time.Timer
+}
+
+// NewSession 在复用的连接上创建会话；连接已被远端关闭等原因失败时重新拨号一次
+func (c *pooledSSHClient) NewSession() (sshSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session, err := c.sshClient.NewSession()
+	if err == nil || !c.reused {
+		// 只有复用后的第一个会话需要检测失效，之后的清理、结束进程等会话沿用同一连接
+		c.reused = false
+		return session, err
+	}
+	logger.Warnf("[SSH Pool] pooled connection %s is no longer usable, reconnecting - Error: %v", c.target, err)
+	c.sshClient.Close()
+	c.reused = false
+	fresh, dialErr := c.dial("tcp", c.addr, c.config)
+	if dialErr != nil {
+		c.discarded = true
+		return nil, dialErr
+	}
+	c.sshClient = fresh
+	return fresh.NewSession()
+}
+
+// Close 将连接放回连接池；已标记丢弃的连接直接关闭。重复调用只生效一次
+func (c *pooledSSHClient) Close() error {
+	return c.pool.put(c)
+}
+
+// rememberAuthMethod 记录新建连接的认证方式，复用连接时返回建立时记录的认证方式
+func (c *pooledSSHClient) rememberAuthMethod(method string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authMethod == "" {
+		c.authMethod = method
+	}
+	return c.authMethod
+}
+
+func (c *pooledSSHClient) discard() {
+	c.mu.Lock()
+	c.discarded = true
+	c.mu.Unlock()
+}
+
+// discardPooledClient 标记连接在关闭时不放回连接池，用于超时、取消或连接异常后
+func discardPooledClient(client sshClient) {
+	if pooled, ok := client.(*pooledSSHClient); ok {
+		pooled.discard()
+	}
+}
+
+type sshClientPool struct {
+	mu       sync.Mutex
+	settings func() sshPoolSettings
+	idle     map[string][]*pooledSSHClient
+	// 已借出的连接，防止同一连接被重复放回
+	inUse map[*pooledSSHClient]bool
+	// 上报空闲与借出的连接数，为 nil 时不上报
+	metrics *utils.OperationMetrics
+}
+
+var sshPool = newSSHClientPool(configuredSSHPoolSettings, utils.DefaultMetrics)
+
+func newSSHClientPool(settings func() sshPoolSettings, metrics *utils.OperationMetrics) *sshClientPool {
+	return &sshClientPool{settings: settings, idle: map[string][]*pooledSSHClient{}, inUse: map[*pooledSSHClient]bool{}, metrics: metrics}
+}
+
+// dialer 返回优先复用空闲连接的拨号函数；连接池未启用时原样返回 dial
+func (p *sshClientPool) dialer(key, target string, dial sshDialer) sshDialer {
+	if p.settings().maxConns <= 0 {
+		return dial
+	}
+	return func(network, addr string, config *ssh.ClientConfig) (sshClient, error) {
+		if pooled := p.get(key); pooled != nil {
+			logger.Debugf("[SSH Pool] reusing connection %s", target)
+			return pooled, nil
+		}
+		client, err := dial(network, addr, config)
+		if err != nil {
+			return nil, err
+		}
+		pooled := &pooledSSHClient{sshClient: client, pool: p, key: key, target: target, dial: dial, addr: addr, config: config}
+		p.mu.Lock()
+		p.inUse[pooled] = true
+		p.reportLocked()
+		p.mu.Unlock()
+		return pooled, nil
+	}
+}
+
+// get 借出 key 对应的最近使用的空闲连接
+func (p *sshClientPool) get(key string) *pooledSSHClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil
+	}
+	pooled := idle[len(idle)-1]
+	p.removeIdleLocked(pooled)
+	// 空闲超时回调若已触发，会因连接不在空闲列表中而放弃关闭
+	pooled.idleTimer.Stop()
+	pooled.reused = true
+	p.inUse[pooled] = true
+	p.reportLocked()
+	return pooled
+}
+
+// put 归还连接：连接池已停用、连接被丢弃或超过最大连接数时关闭多余连接
+func (p *sshClientPool) put(pooled *pooledSSHClient) error {
+	settings := p.settings()
+	p.mu.Lock()
+	if !p.inUse[pooled] {
+		p.mu.Unlock()
+		return nil
+	}
+	delete(p.inUse, pooled)
+	pooled.mu.Lock()
+	discarded := pooled.discarded
+	pooled.mu.Unlock()
+	if discarded || settings.maxConns <= 0 {
+		p.reportLocked()
+		p.mu.Unlock()
+		return pooled.sshClient.Close()
+	}
+
+	pooled.idleSince = time.Now()
+	pooled.idleTimer = time.AfterFunc(settings.idleTimeout, func() { p.expire(pooled) })
+	p.idle[pooled.key] = append(p.idle[pooled.key], pooled)
+	var evicted []*pooledSSHClient
+	for p.idleCountLocked() > settings.maxConns {
+		oldest := p.oldestIdleLocked()
+		p.removeIdleLocked(oldest)
+		oldest.idleTimer.Stop()
+		evicted = append(evicted, oldest)
+	}
+	p.reportLocked()
+	p.mu.Unlock()
+
+	for _, client := range evicted {
+		logger.Debugf("[SSH Pool] max connections reached, closing idle connection %s", client.target)
+		client.sshClient.Close()
+	}
+	return nil
+}
+
+// expire 关闭空闲超时的连接
+func (p *sshClientPool) expire(pooled *pooledSSHClient) {
+	p.mu.Lock()
+	removed := p.removeIdleLocked(pooled)
+	p.reportLocked()
+	p.mu.Unlock()
+	if removed {
+		logger.Debugf("[SSH Pool] closing idle connection %s", pooled.target)
+		pooled.sshClient.Close()
+	}
+}
+
+// CloseIdleConnections 关闭连接池中的全部空闲连接，供执行器退出时调用
+func CloseIdleConnections() {
+	sshPool.closeIdle()
+}
+
+// closeIdle 关闭全部空闲连接，借出中的连接归还时按当时配置处理
+func (p *sshClientPool) closeIdle() {
+	p.mu.Lock()
+	var idle []*pooledSSHClient
+	for _, clients := range p.idle {
+		idle = append(idle, clients...)
+	}
+	p.idle = map[string][]*pooledSSHClient{}
+	p.reportLocked()
+	p.mu.Unlock()
+	for _, pooled := range idle {
+		pooled.idleTimer.Stop()
+		pooled.sshClient.Close()
+	}
+}
+
+func (p *sshClientPool) removeIdleLocked(pooled *pooledSSHClient) bool {
+	idle := p.idle[pooled.key]
+	for i, candidate := range idle {
+		if candidate != pooled {
+			continue
+		}
+		idle = append(idle[:i], idle[i+1:]...)
+		if len(idle) == 0 {
+			delete(p.idle, pooled.key)
+		} else {
+			p.idle[pooled.key] = idle
+		}
+		return true
+	}
+	return false
+}
+
+func (p *sshClientPool) reportLocked() {
+	if p.metrics != nil {
+		p.metrics.SetPoolSize("ssh", p.idleCountLocked(), len(p.inUse))
+	}
+}
+
+func (p *sshClientPool) idleCountLocked() int {
+	count := 0
+	for _, idle := range p.idle {
+		count += len(idle)
+	}
+	return count
+}
+
+func (p *sshClientPool) oldestIdleLocked() *pooledSSHClient {
+	var oldest *pooledSSHClient
+	for _, idle := range p.idle {
+		// 每个 key 下按归还顺序排列，首个即该 key 最早空闲的连接
+		if len(idle) > 0 && (oldest == nil || idle[0].idleSince.Before(oldest.idleSince)) {
+			oldest = idle[0]
+		}
+	}
+	return oldest
+}
+
+// isConnectionLostError 判断命令未拿到退出状态即结束，此时连接已不可靠，不再放回连接池
+func isConnectionLostError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var exitErr *ssh.ExitError
+	return !errors.As(err, &exitErr)
+}
@@ -0,0 +1,286 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func enableSSHPool(t *testing.T, maxConns string) {
+	t.Helper()
+	t.Setenv(sshPoolMaxConnsEnv, maxConns)
+	t.Cleanup(sshPool.closeIdle)
+}
+
+// countingDial 每次拨号返回一个新的 stub 连接，记录拨号与关闭次数
+type countingDial struct {
+	dials   atomic.Int32
+	closes  atomic.Int32
+	session func(dial int32) (sshSession, error)
+}
+
+func (d *countingDial) dial(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+	n := d.dials.Add(1)
+	return stubSSHClient{
+		newSession: func() (sshSession, error) {
+			if d.session != nil {
+				return d.session(n)
+			}
+			return &stubSSHSession{}, nil
+		},
+		close: func() error {
+			d.closes.Add(1)
+			return nil
+		},
+	}, nil
+}
+
+func poolTestRequest(password string) ExecuteRequest {
+	return ExecuteRequest{Command: "uptime", ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: password}
+}
+
+func TestExecuteReusesPooledConnectionForSameTarget(t *testing.T) {
+	enableSSHPool(t, "4")
+	counter := &countingDial{}
+	originalDial := sshDialFn
+	sshDialFn = counter.dial
+	defer func() { sshDialFn = originalDial }()
+
+	for i := 0; i < 3; i++ {
+		if response := Execute(poolTestRequest("secret"), "instance-1"); !response.Success {
+			t.Fatalf("execute %d: unexpected response %+v", i, response)
+		}
+	}
+	if counter.dials.Load() != 1 || counter.closes.Load() != 0 {
+		t.Fatalf("expected one pooled connection, got dials=%d closes=%d", counter.dials.Load(), counter.closes.Load())
+	}
+
+	if response := Execute(poolTestRequest("other"), "instance-1"); !response.Success {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if counter.dials.Load() != 2 {
+		t.Fatalf("expected different credentials to use a separate connection, got dials=%d", counter.dials.Load())
+	}
+}
+
+func TestExecuteWithoutPoolDialsEveryTime(t *testing.T) {
+	t.Setenv(sshPoolMaxConnsEnv, "")
+	counter := &countingDial{}
+	originalDial := sshDialFn
+	sshDialFn = counter.dial
+	defer func() { sshDialFn = originalDial }()
+
+	Execute(poolTestRequest("secret"), "instance-1")
+	Execute(poolTestRequest("secret"), "instance-1")
+	if counter.dials.Load() != 2 || counter.closes.Load() != 2 {
+		t.Fatalf("expected a connection per request, got dials=%d closes=%d", counter.dials.Load(), counter.closes.Load())
+	}
+}
+
+func TestExecuteRedialsWhenPooledConnectionIsStale(t *testing.T) {
+	enableSSHPool(t, "4")
+	var sessions atomic.Int32
+	counter := &countingDial{session: func(dial int32) (sshSession, error) {
+		// 第一条连接只能创建一个会话，之后模拟远端已断开
+		if dial == 1 && sessions.Add(1) > 1 {
+			return nil, io.EOF
+		}
+		return &stubSSHSession{}, nil
+	}}
+	originalDial := sshDialFn
+	sshDialFn = counter.dial
+	defer func() { sshDialFn = originalDial }()
+
+	for i := 0; i < 2; i++ {
+		if response := Execute(poolTestRequest("secret"), "instance-1"); !response.Success {
+			t.Fatalf("execute %d: unexpected response %+v", i, response)
+		}
+	}
+	if counter.dials.Load() != 2 || counter.closes.Load() != 1 {
+		t.Fatalf("expected stale connection to be replaced, got dials=%d closes=%d", counter.dials.Load(), counter.closes.Load())
+	}
+}
+
+func TestExecuteDoesNotPoolConnectionAfterItIsLost(t *testing.T) {
+	enableSSHPool(t, "4")
+	counter := &countingDial{session: func(dial int32) (sshSession, error) {
+		if dial == 1 {
+			return &stubSSHSession{run: func(string) error { return errors.New("connection lost") }}, nil
+		}
+		return &stubSSHSession{}, nil
+	}}
+	originalDial := sshDialFn
+	sshDialFn = counter.dial
+	defer func() { sshDialFn = originalDial }()
+
+	if response := Execute(poolTestRequest("secret"), "instance-1"); response.Success {
+		t.Fatalf("expected failure, got %+v", response)
+	}
+	if response := Execute(poolTestRequest("secret"), "instance-1"); !response.Success {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if counter.dials.Load() != 2 || counter.closes.Load() != 1 {
+		t.Fatalf("expected lost connection to be closed instead of pooled, got dials=%d closes=%d", counter.dials.Load(), counter.closes.Load())
+	}
+}
+
+func TestTerminateRemoteCommandDiscardsPooledConnection(t *testing.T) {
+	pool := newSSHClientPool(func() sshPoolSettings { return sshPoolSettings{maxConns: 4, idleTimeout: time.Minute} }, nil)
+	counter := &countingDial{}
+	client, _ := pool.dialer("key", "root@10.0.0.1:22", counter.dial)("tcp", "10.0.0.1:22", &gossh.ClientConfig{})
+
+	terminateRemoteCommand(client, &stubSSHSession{}, ExecuteRequest{}, "", "instance-1")
+	client.Close()
+	if counter.closes.Load() != 1 || pool.get("key") != nil {
+		t.Fatalf("expected connection to be closed and not pooled, got closes=%d", counter.closes.Load())
+	}
+}
+
+func TestSSHClientPoolEnforcesMaxConnsAndIdleTimeout(t *testing.T) {
+	pool := newSSHClientPool(func() sshPoolSettings { return sshPoolSettings{maxConns: 2, idleTimeout: 50 * time.Millisecond} }, nil)
+	counter := &countingDial{}
+	dial := pool.dialer("key", "root@10.0.0.1:22", counter.dial)
+
+	var clients []sshClient
+	for i := 0; i < 3; i++ {
+		client, err := dial("tcp", "10.0.0.1:22", &gossh.ClientConfig{})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		clients = append(clients, client)
+	}
+	for _, client := range clients {
+		client.Close()
+		client.Close() // 重复关闭不会重复放回连接池
+	}
+	if counter.dials.Load() != 3 || counter.closes.Load() != 1 {
+		t.Fatalf("expected the oldest idle connection to be evicted, got dials=%d closes=%d", counter.dials.Load(), counter.closes.Load())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for counter.closes.Load() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if counter.closes.Load() != 3 || pool.get("key") != nil {
+		t.Fatalf("expected idle connections to expire, got closes=%d", counter.closes.Load())
+	}
+}
+
+func TestSSHClientPoolReportsConnectionCounts(t *testing.T) {
+	metrics := utils.NewOperationMetrics()
+	pool := newSSHClientPool(func() sshPoolSettings { return sshPoolSettings{maxConns: 4, idleTimeout: time.Minute} }, metrics)
+	defer pool.closeIdle()
+	counter := &countingDial{}
+	dial := pool.dialer("key", "root@10.0.0.1:22", counter.dial)
+
+	assertPool := func(idle, inUse int) {
+		t.Helper()
+		var out strings.Builder
+		if err := metrics.WritePrometheus(&out); err != nil {
+			t.Fatalf("write metrics: %v", err)
+		}
+		for _, want := range []string{
+			fmt.Sprintf(`nats_executor_pool_connections{pool="ssh",state="idle"} %d`, idle),
+			fmt.Sprintf(`nats_executor_pool_connections{pool="ssh",state="in_use"} %d`, inUse),
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Fatalf("expected %q in metrics output:\n%s", want, out.String())
+			}
+		}
+	}
+
+	first, _ := dial("tcp", "10.0.0.1:22", &gossh.ClientConfig{})
+	second, _ := dial("tcp", "10.0.0.1:22", &gossh.ClientConfig{})
+	assertPool(0, 2)
+	first.Close()
+	assertPool(1, 1)
+	reused, _ := dial("tcp", "10.0.0.1:22", &gossh.ClientConfig{})
+	assertPool(0, 2)
+	reused.Close()
+	second.Close()
+	assertPool(2, 0)
+	pool.closeIdle()
+	assertPool(0, 0)
+}
+
+func TestExecutePoolIsSafeForConcurrentRequests(t *testing.T) {
+	enableSSHPool(t, "4")
+	counter := &countingDial{}
+	originalDial := sshDialFn
+	sshDialFn = counter.dial
+	defer func() { sshDialFn = originalDial }()
+
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if !Execute(poolTestRequest("secret"), "instance-1").Success {
+					failures.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if failures.Load() != 0 || counter.dials.Load() > 8 {
+		t.Fatalf("expected concurrent requests to share at most one connection each, got failures=%d dials=%d", failures.Load(), counter.dials.Load())
+	}
+	if open := counter.dials.Load() - counter.closes.Load(); open > 4 {
+		t.Fatalf("expected at most 4 pooled connections to stay open, got %d", open)
+	}
+}
+
+func TestExecuteReportsAuthMethodForPooledConnection(t *testing.T) {
+	enableSSHPool(t, "4")
+	t.Setenv(sshKnownHostsFileEnv, "")
+	server := startTestSSHServer(t, "secret")
+	var dials atomic.Int32
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		dials.Add(1)
+		return originalDial(network, addr, config)
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	req := ExecuteRequest{Command: "echo pooled", ExecuteTimeout: 10, Host: "127.0.0.1", Port: server.port(), User: "root", Password: "secret"}
+	for i := 0; i < 2; i++ {
+		response := Execute(req, "instance-1")
+		if !response.Success || strings.TrimSpace(response.Stdout) != "pooled" || response.AuthMethod != authMethodPassword {
+			t.Fatalf("execute %d: unexpected response %+v", i, response)
+		}
+	}
+	if dials.Load() != 1 {
+		t.Fatalf("expected the second request to reuse the connection, got %d dials", dials.Load())
+	}
+}
+
+func TestSSHPoolKeyDoesNotExposeCredentials(t *testing.T) {
+	key := sshPoolKey(poolTestRequest("s3cret"))
+	if !strings.HasPrefix(key, "root@10.0.0.1:22#") || strings.Contains(key, "s3cret") {
+		t.Fatalf("unexpected pool key %q", key)
+	}
+	if key == sshPoolKey(poolTestRequest("other")) {
+		t.Fatal("expected different credentials to produce different keys")
+	}
+	// 未加盐的摘要可被离线暴力比对，key 必须依赖进程内密钥
+	original := sshPoolKeySecret
+	sshPoolKeySecret = newSSHPoolKeySecret()
+	defer func() { sshPoolKeySecret = original }()
+	if key == sshPoolKey(poolTestRequest("s3cret")) {
+		t.Fatal("expected pool key to depend on the per-process secret")
+	}
+	if target := sshPoolTarget(poolTestRequest("s3cret")); target != "root@10.0.0.1:22" {
+		t.Fatalf("unexpected pool log target %q", target)
+	}
+}
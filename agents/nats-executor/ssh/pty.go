@@ -0,0 +1,23 @@
+package ssh
+
+import "golang.org/x/crypto/ssh"
+
+// request_pty 时为会话分配的伪终端规格
+const (
+	ptyTerm   = "xterm"
+	ptyHeight = 40
+	ptyWidth  = 80
+)
+
+// ptyModes 关闭回显，避免远端把输入（如 sudo -S 读取的密码）回显到输出中
+var ptyModes = ssh.TerminalModes{
+	ssh.ECHO:          0,
+	ssh.TTY_OP_ISPEED: 14400,
+	ssh.TTY_OP_OSPEED: 14400,
+}
+
+// requestSessionPTY 为会话分配 PTY；分配后远端 stdout 与 stderr 合并写入同一终端，
+// 全部输出都出现在 stdout 中，换行为 \r\n
+func requestSessionPTY(session sshSession) error {
+	return session.RequestPty(ptyTerm, ptyHeight, ptyWidth, ptyModes)
+}
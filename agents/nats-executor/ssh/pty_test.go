@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestExecuteWithPTYMergesStderrIntoStdout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	t.Setenv(sshKnownHostsFileEnv, "")
+	server := startTestSSHServer(t, "secret")
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo out; echo err >&2",
+		ExecuteTimeout: 10,
+		Host:           "127.0.0.1",
+		Port:           server.port(),
+		User:           "root",
+		Password:       "secret",
+		RequestPTY:     true,
+	}, "instance-1")
+	if !response.Success || !response.PTY {
+		t.Fatalf("expected PTY execution to succeed, got %+v", response)
+	}
+	if response.Stdout != "out\nerr\n" || response.Stderr != "" {
+		t.Fatalf("expected stderr merged into stdout, got stdout=%q stderr=%q", response.Stdout, response.Stderr)
+	}
+}
+
+func TestExecuteRequestsPTYOnlyWhenEnabled(t *testing.T) {
+	var requests []string
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			return &stubSSHSession{requestPty: func(term string, h, w int, modes gossh.TerminalModes) error {
+				if modes[gossh.ECHO] != 0 {
+					t.Fatalf("expected echo to be disabled, got %v", modes)
+				}
+				requests = append(requests, term)
+				return nil
+			}}, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	req := ExecuteRequest{Command: "uptime", ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret"}
+	if response := Execute(req, "instance-1"); !response.Success || response.PTY || len(requests) != 0 {
+		t.Fatalf("expected no PTY by default, got %+v (requests=%v)", response, requests)
+	}
+	req.RequestPTY = true
+	if response := Execute(req, "instance-1"); !response.Success || !response.PTY || len(requests) != 1 || requests[0] != "xterm" {
+		t.Fatalf("expected one xterm PTY request, got %+v (requests=%v)", response, requests)
+	}
+}
+
+func TestExecuteReportsPTYRequestFailure(t *testing.T) {
+	ran := false
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			return &stubSSHSession{
+				requestPty: func(string, int, int, gossh.TerminalModes) error { return errors.New("pty request denied") },
+				run: func(string) error {
+					ran = true
+					return nil
+				},
+			}, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(ExecuteRequest{Command: "sudo true", ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret", RequestPTY: true}, "instance-1")
+	if response.Success || ran || response.Code != utils.ErrorCodeDependencyFailure || response.Stage != sshStageSessionCreate || !strings.Contains(response.Error, "pty request denied") {
+		t.Fatalf("expected PTY failure before running the command, got %+v (ran=%v)", response, ran)
+	}
+}
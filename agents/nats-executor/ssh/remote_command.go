@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sourceFailureMarker 由远端 shell 写入 stderr，用于识别 source_files 加载失败的文件
+const sourceFailureMarker = "[source_files] failed to source: "
+
+// sourceFailureExitCode 为 source_files 加载失败时远端 shell 的退出码
+const sourceFailureExitCode = 97
+
+// workDirFailureMarker 由远端 shell 写入 stderr，用于识别无法进入 work_dir
+const workDirFailureMarker = "[work_dir] failed to enter: "
+
+// workDirFailureExitCode 为无法进入 work_dir 时远端 shell 的退出码
+const workDirFailureExitCode = 96
+
+// buildRemoteCommand 构造远端执行的命令，sudo 时整条命令（含环境加载与目录切换）以 root 执行
+func buildRemoteCommand(req ExecuteRequest) string {
+	command := buildSetupCommand(req)
+	if req.Sudo {
+		return wrapWithSudo(command)
+	}
+	return command
+}
+
+// buildSetupCommand 在命令前依次加载 source_files 并切换到 work_dir（POSIX shell）。
+// 文件不可读、加载返回非零或目录无法进入时输出标记并退出，避免在错误环境下继续执行命令。
+func buildSetupCommand(req ExecuteRequest) string {
+	if len(req.SourceFiles) == 0 && req.WorkDir == "" {
+		return req.Command
+	}
+
+	var builder strings.Builder
+	for _, file := range req.SourceFiles {
+		quoted := shellQuote(file)
+		fmt.Fprintf(&builder, "{ [ -r %s ] && . %s; } || { echo %s >&2; exit %d; }; ",
+			quoted, quoted, shellQuote(sourceFailureMarker+file), sourceFailureExitCode)
+	}
+	if req.WorkDir != "" {
+		fmt.Fprintf(&builder, "cd %s || { echo %s >&2; exit %d; }; ",
+			shellQuote(req.WorkDir), shellQuote(workDirFailureMarker+req.WorkDir), workDirFailureExitCode)
+	}
+	builder.WriteString(req.Command)
+	return builder.String()
+}
+
+// wrapWithSudo 用 sudo -S 执行命令并置空密码提示：密码从 stdin 读取，不出现在命令行中
+func wrapWithSudo(command string) string {
+	return "sudo -S -p '' sh -c " + shellQuote(command)
+}
+
+// sudoStdin 返回写入 sudo 的密码输入；未提供 sudo_password 时为 nil，依赖远端 NOPASSWD 配置
+func sudoStdin(req ExecuteRequest) io.Reader {
+	if !req.Sudo || req.SudoPassword == "" {
+		return nil
+	}
+	return strings.NewReader(req.SudoPassword + "\n")
+}
+
+func validateSourceFiles(files []string) string {
+	for _, file := range files {
+		if strings.TrimSpace(file) == "" {
+			return "source_files must not contain empty paths"
+		}
+	}
+	return ""
+}
+
+func validateWorkDir(dir string) string {
+	if dir != "" && strings.TrimSpace(dir) == "" {
+		return "work_dir must not be blank"
+	}
+	return ""
+}
+
+// setupFailureHint 从 stderr 中提取 source_files 加载或 work_dir 切换失败的信息并给出排查提示
+func setupFailureHint(stderr []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if file, ok := strings.CutPrefix(line, sourceFailureMarker); ok {
+			return fmt.Sprintf("Failed to source profile %s: file is missing, unreadable or returned non-zero on the remote host", file)
+		}
+		if dir, ok := strings.CutPrefix(line, workDirFailureMarker); ok {
+			return fmt.Sprintf("Failed to change to work_dir %s: directory is missing or not accessible on the remote host", dir)
+		}
+	}
+	return ""
+}
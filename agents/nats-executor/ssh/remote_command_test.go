@@ -0,0 +1,251 @@
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+	"nats-executor/utils"
+)
+
+// runWithLocalShell 用本地 sh 模拟远端执行，便于验证拼装出的命令语义
+func runWithLocalShell(t *testing.T) func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+	t.Helper()
+	return func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			session := &stubSSHSession{}
+			session.run = func(cmd string) error {
+				command := exec.Command("sh", "-c", cmd)
+				command.Stdout = session.stdout
+				command.Stderr = session.stderr
+				command.Stdin = session.stdin
+				return command.Run()
+			}
+			return session, nil
+		}}, nil
+	}
+}
+
+func TestBuildRemoteCommandWithoutSourceFilesKeepsCommand(t *testing.T) {
+	if got := buildRemoteCommand(ExecuteRequest{Command: "uptime"}); got != "uptime" {
+		t.Fatalf("unexpected command: %q", got)
+	}
+}
+
+func TestBuildRemoteCommandQuotesSourceFiles(t *testing.T) {
+	got := buildRemoteCommand(ExecuteRequest{Command: "run", SourceFiles: []string{"/opt/app/env.sh", "/opt/it's.sh"}})
+	for _, want := range []string{
+		"[ -r '/opt/app/env.sh' ] && . '/opt/app/env.sh'",
+		`. '/opt/it'"'"'s.sh'`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in %q", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "; run") {
+		t.Fatalf("expected command to run after sourcing, got %q", got)
+	}
+}
+
+func TestValidateExecuteRequestRejectsEmptySourceFile(t *testing.T) {
+	got := validateExecuteRequest(ExecuteRequest{
+		Command:        "uptime",
+		Host:           "10.0.0.1",
+		User:           "root",
+		Port:           22,
+		ExecuteTimeout: 5,
+		SourceFiles:    []string{"/opt/env.sh", " "},
+	})
+	if got != "source_files must not contain empty paths" {
+		t.Fatalf("unexpected validation result: %q", got)
+	}
+}
+
+func TestExecuteSourcesProfilesBeforeCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	dir := t.TempDir()
+	profile := filepath.Join(dir, "env profile.sh")
+	if err := os.WriteFile(profile, []byte("export APP_HOME=/opt/app\n"), 0o600); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(ExecuteRequest{
+		Command:        `echo "home=$APP_HOME"`,
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		SourceFiles:    []string{profile},
+	}, "instance-1")
+
+	if !response.Success || !strings.Contains(response.Output, "home=/opt/app") {
+		t.Fatalf("expected sourced environment in output, got %+v", response)
+	}
+}
+
+func TestExecuteReturnsHintWhenSourcingFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	missing := filepath.Join(t.TempDir(), "missing.sh")
+
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(ExecuteRequest{
+		Command:        "echo should-not-run",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		SourceFiles:    []string{missing},
+	}, "instance-1")
+
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure {
+		t.Fatalf("expected execution failure, got %+v", response)
+	}
+	if !strings.Contains(response.Error, "Failed to source profile "+missing) {
+		t.Fatalf("expected source failure hint, got %q", response.Error)
+	}
+	if strings.Contains(response.Output, "should-not-run") {
+		t.Fatalf("expected command to be skipped, got %q", response.Output)
+	}
+}
+
+func TestBuildRemoteCommandChangesToWorkDirAfterSourcing(t *testing.T) {
+	got := buildRemoteCommand(ExecuteRequest{Command: "ls", SourceFiles: []string{"/etc/profile"}, WorkDir: "/opt/my app"})
+	sourceIdx := strings.Index(got, ". '/etc/profile'")
+	cdIdx := strings.Index(got, "cd '/opt/my app' ||")
+	if sourceIdx < 0 || cdIdx < sourceIdx {
+		t.Fatalf("expected cd after sourcing, got %q", got)
+	}
+	if !strings.HasSuffix(got, "; ls") {
+		t.Fatalf("expected command to run last, got %q", got)
+	}
+}
+
+func TestExecuteRunsInWorkDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("resolve temp dir: %v", err)
+	}
+	workDir := filepath.Join(dir, "work dir")
+	if err := os.Mkdir(workDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	request := ExecuteRequest{
+		Command:        "pwd",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		WorkDir:        workDir,
+	}
+	response := Execute(request, "instance-1")
+	if !response.Success || strings.TrimSpace(response.Output) != workDir {
+		t.Fatalf("expected command to run in %s, got %+v", workDir, response)
+	}
+
+	request.WorkDir = filepath.Join(dir, "missing")
+	request.Command = "echo should-not-run"
+	request.ExpectedExitCodes = []int{0, workDirFailureExitCode}
+	response = Execute(request, "instance-1")
+	if response.Success || !strings.Contains(response.Error, "Failed to change to work_dir "+request.WorkDir) {
+		t.Fatalf("expected work_dir failure, got %+v", response)
+	}
+	if strings.Contains(response.Output, "should-not-run") {
+		t.Fatalf("expected command to be skipped, got %q", response.Output)
+	}
+}
+
+func TestBuildRemoteCommandWrapsSetupWithSudo(t *testing.T) {
+	got := buildRemoteCommand(ExecuteRequest{Command: "id -u", WorkDir: "/opt/app", Sudo: true})
+	want := "sudo -S -p '' sh -c " + shellQuote(buildSetupCommand(ExecuteRequest{Command: "id -u", WorkDir: "/opt/app"}))
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+// installFakeSudo 在 PATH 前部放置模拟 sudo：校验 -S 与空提示参数并从 stdin 读取密码
+func installFakeSudo(t *testing.T, password string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = -S ] && [ \"$2\" = -p ] && [ -z \"$3\" ] || exit 2\n" +
+		"shift 3\n" +
+		"IFS= read -r pw\n" +
+		"[ \"$pw\" = " + shellQuote(password) + " ] || { echo 'sudo: incorrect password' >&2; exit 1; }\n" +
+		"SUDO_USER_OK=root exec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake sudo: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestExecuteSudoFeedsPasswordThroughStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	installFakeSudo(t, "s3cret")
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	req := ExecuteRequest{
+		Command:        "echo main-$SUDO_USER_OK",
+		CleanupCommand: "echo cleanup-$SUDO_USER_OK",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "ops",
+		Password:       "secret",
+		Sudo:           true,
+		SudoPassword:   "s3cret",
+	}
+	response := Execute(req, "instance-1")
+	if !response.Success || strings.TrimSpace(response.Stdout) != "main-root" {
+		t.Fatalf("expected command to run through sudo, got %+v", response)
+	}
+	if response.Cleanup == nil || strings.TrimSpace(response.Cleanup.Stdout) != "cleanup-root" {
+		t.Fatalf("expected cleanup command to run through sudo, got %+v", response.Cleanup)
+	}
+	if strings.Contains(response.Output, "s3cret") {
+		t.Fatalf("sudo password leaked into output: %q", response.Output)
+	}
+
+	req.SudoPassword = "wrong"
+	req.CleanupCommand = ""
+	response = Execute(req, "instance-1")
+	if response.Success || response.ExitCode != 1 || !strings.Contains(response.Stderr, "incorrect password") {
+		t.Fatalf("expected sudo authentication failure, got %+v", response)
+	}
+}
+
+func TestValidateExecuteRequestRejectsSudoPasswordWithoutSudo(t *testing.T) {
+	got := validateExecuteRequest(ExecuteRequest{Command: "uptime", Host: "10.0.0.1", User: "root", Port: 22, SudoPassword: "secret"})
+	if got != "sudo_password requires sudo" {
+		t.Fatalf("unexpected validation result: %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"nats-executor/utils"
+)
+
+// remoteEnvCaptureTimeout 为采集远端环境变量的最长等待时间，超时后不影响命令执行
+const remoteEnvCaptureTimeout = 10 * time.Second
+
+const redactedEnvValue = "***"
+
+// sensitiveEnvNamePattern 匹配可能包含凭据的变量名，采集结果中以 *** 代替其值
+var sensitiveEnvNamePattern = regexp.MustCompile(`(?i)(PASS|SECRET|TOKEN|KEY|CREDENTIAL|AUTH|COOKIE|SESSION|PRIVATE)`)
+
+func redactRemoteEnv(env map[string]string) map[string]string {
+	for name := range env {
+		if sensitiveEnvNamePattern.MatchString(name) {
+			env[name] = redactedEnvValue
+		}
+	}
+	return env
+}
+
+// parseEnvOutput 解析 env / set 输出的 NAME=VALUE 行，无法解析的行（多行值的续行等）忽略
+func parseEnvOutput(output []byte) map[string]string {
+	env := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), utils.CommandOutputLimitBytes)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(strings.TrimRight(scanner.Text(), "\r"), "=")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		env[name] = value
+	}
+	return env
+}
+
+func runEnvCommand(client sshClient, command string, stdin io.Reader) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	capture := utils.NewSharedOutputCapture(utils.CommandOutputLimitBytes)
+	session.SetStdout(capture.StdoutWriter())
+	session.SetStderr(capture.StderrWriter())
+	session.SetStdin(stdin)
+	err = session.Run(command)
+	return capture.Snapshot().Stdout, err
+}
+
+// captureRemoteEnv 在独立会话中按与命令相同的 source_files / work_dir 执行 env 采集远端环境变量；
+// POSIX env 不可用时（Windows OpenSSH 默认 cmd.exe）回退为 set。
+func captureRemoteEnv(client sshClient, req ExecuteRequest, deadline time.Time) (map[string]string, error) {
+	timeout := minDuration(remoteEnvCaptureTimeout, remainingBudget(deadline))
+	type result struct {
+		output []byte
+		err    error
+	}
+	captured, timedOut := utils.RunWithDeadline(timeout, func(context.Context) result {
+		envReq := req
+		envReq.Command = "env"
+		// sudo 时采集会话同样需要密码输入，否则 sudo -S 读不到密码而失败
+		output, err := runEnvCommand(client, buildRemoteCommand(envReq), sudoStdin(req))
+		if err != nil {
+			output, err = runEnvCommand(client, "set", nil)
+		}
+		return result{output: output, err: err}
+	}, func() result { return result{} })
+	if timedOut {
+		return nil, fmt.Errorf("capture remote environment timed out after %s", timeout)
+	}
+	if captured.err != nil {
+		return nil, fmt.Errorf("capture remote environment: %w", captured.err)
+	}
+	return redactRemoteEnv(parseEnvOutput(captured.output)), nil
+}
@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEnvOutputRedactsSensitiveNames(t *testing.T) {
+	env := redactRemoteEnv(parseEnvOutput([]byte("PATH=/usr/bin:/bin\r\nDB_PASSWORD=hunter2\nAWS_SECRET_ACCESS_KEY=abc\nGREETING=a=b\ncontinued line\nAPI_TOKEN=t\n")))
+	if env["PATH"] != "/usr/bin:/bin" || env["GREETING"] != "a=b" {
+		t.Fatalf("unexpected parsed env: %+v", env)
+	}
+	for _, name := range []string{"DB_PASSWORD", "AWS_SECRET_ACCESS_KEY", "API_TOKEN"} {
+		if env[name] != redactedEnvValue {
+			t.Fatalf("expected %s to be redacted, got %q", name, env[name])
+		}
+	}
+	if _, ok := env["continued line"]; ok || len(env) != 5 {
+		t.Fatalf("unexpected entries: %+v", env)
+	}
+}
+
+func TestExecuteCapturesRemoteEnvWhenRequested(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	t.Setenv("BKLITE_DEBUG_MARKER", "visible")
+	t.Setenv("BKLITE_DEBUG_TOKEN", "should-not-leak")
+
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	request := ExecuteRequest{
+		Command:        "echo done",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+	}
+	if response := Execute(request, "instance-1"); response.RemoteEnv != nil {
+		t.Fatalf("expected no environment without capture_env, got %d entries", len(response.RemoteEnv))
+	}
+
+	request.CaptureEnv = true
+	response := Execute(request, "instance-1")
+	if !response.Success || strings.TrimSpace(response.Output) != "done" {
+		t.Fatalf("expected command output to be unaffected, got %+v", response)
+	}
+	if response.RemoteEnv["BKLITE_DEBUG_MARKER"] != "visible" || response.RemoteEnv["BKLITE_DEBUG_TOKEN"] != redactedEnvValue {
+		t.Fatalf("unexpected remote env: marker=%q token=%q", response.RemoteEnv["BKLITE_DEBUG_MARKER"], response.RemoteEnv["BKLITE_DEBUG_TOKEN"])
+	}
+}
+
+func TestCaptureRemoteEnvFallsBackToSet(t *testing.T) {
+	var commands []string
+	client := stubSSHClient{newSession: func() (sshSession, error) {
+		session := &stubSSHSession{}
+		session.run = func(cmd string) error {
+			commands = append(commands, cmd)
+			if cmd != "set" {
+				return errors.New("'env' is not recognized as an internal or external command")
+			}
+			_, _ = session.stdout.Write([]byte("COMPUTERNAME=WIN01\r\nPath=C:\\Windows\r\n"))
+			return nil
+		}
+		return session, nil
+	}}
+
+	env, err := captureRemoteEnv(client, ExecuteRequest{}, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("capture env: %v", err)
+	}
+	if env["COMPUTERNAME"] != "WIN01" || env["Path"] != `C:\Windows` || len(commands) != 2 {
+		t.Fatalf("unexpected fallback result: env=%+v commands=%v", env, commands)
+	}
+}
+
+func TestCaptureRemoteEnvFeedsSudoPassword(t *testing.T) {
+	var stdin []byte
+	var command string
+	client := stubSSHClient{newSession: func() (sshSession, error) {
+		session := &stubSSHSession{}
+		session.run = func(cmd string) error {
+			command = cmd
+			if session.stdin != nil {
+				stdin, _ = io.ReadAll(session.stdin)
+			}
+			_, _ = session.stdout.Write([]byte("USER=root\n"))
+			return nil
+		}
+		return session, nil
+	}}
+
+	req := ExecuteRequest{Sudo: true, SudoPassword: "s3cret"}
+	env, err := captureRemoteEnv(client, req, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("capture env: %v", err)
+	}
+	if command != "sudo -S -p '' sh -c 'env'" || string(stdin) != "s3cret\n" || env["USER"] != "root" {
+		t.Fatalf("unexpected sudo env capture: command=%q stdin=%q env=%+v", command, stdin, env)
+	}
+}
@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"nats-executor/logger"
+)
+
+const (
+	sshDialRetriesEnv          = "SSH_DIAL_RETRIES"
+	sshDialRetryBackoffEnv     = "SSH_DIAL_RETRY_BACKOFF_MS"
+	defaultSSHDialRetries      = 2
+	defaultSSHDialRetryBackoff = 500 * time.Millisecond
+)
+
+var retrySleepFn = time.Sleep
+
+type dialRetryPolicy struct {
+	retries        int
+	backoff        time.Duration
+	connectTimeout time.Duration // 每次重新拨号的建连超时上限
+}
+
+// configuredDialRetryPolicy 读取连接重置重试配置，非法值回退默认值
+func configuredDialRetryPolicy() dialRetryPolicy {
+	policy := dialRetryPolicy{retries: defaultSSHDialRetries, backoff: defaultSSHDialRetryBackoff, connectTimeout: sshConnectTimeout}
+	if value := strings.TrimSpace(os.Getenv(sshDialRetriesEnv)); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			policy.retries = retries
+		} else {
+			logger.Warnf("[SSH Execute] invalid %s=%q, using default %d", sshDialRetriesEnv, value, defaultSSHDialRetries)
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(sshDialRetryBackoffEnv)); value != "" {
+		if backoffMs, err := strconv.Atoi(value); err == nil && backoffMs >= 0 {
+			policy.backoff = time.Duration(backoffMs) * time.Millisecond
+		} else {
+			logger.Warnf("[SSH Execute] invalid %s=%q, using default %s", sshDialRetryBackoffEnv, value, defaultSSHDialRetryBackoff)
+		}
+	}
+	return policy
+}
+
+// isConnectionResetError 仅识别建连阶段的连接重置（含握手阶段被对端直接断开），
+// 认证失败、超时等其他错误不重试。
+func isConnectionResetError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	lower := strings.ToLower(err.Error())
+	return strings.Contains(lower, "connection reset by peer") || strings.HasSuffix(lower, "handshake failed: eof")
+}
+
+// canRetry 判断剩余预算是否足够完成一次退避后的重试
+func (p dialRetryPolicy) canRetry(attempt int, err error, deadline time.Time) bool {
+	return attempt < p.retries && isConnectionResetError(err) && remainingBudget(deadline) > p.backoff
+}
+
+// dialSSHWithRetry 在连接重置时按策略重试拨号，此时远端命令尚未开始执行，重试是安全的
+func dialSSHWithRetry(instanceId string, dial sshDialer, addr string, config *ssh.ClientConfig, deadline time.Time, policy dialRetryPolicy) (sshClient, error) {
+	client, err := dial("tcp", addr, config)
+	for attempt := 0; err != nil && policy.canRetry(attempt, err, deadline); attempt++ {
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] SSH dial to %s reset, retrying (%d/%d) in %s - Error: %v", addr, attempt+1, policy.retries, policy.backoff, err)
+		retrySleepFn(policy.backoff)
+		config.Timeout = minDuration(policy.connectTimeout, remainingBudget(deadline))
+		client, err = dial("tcp", addr, config)
+	}
+	return client, err
+}
+
+// openSessionWithRetry 在创建会话时遇到连接重置则重新拨号后重试。
+// 返回的 client 始终非 nil，调用方负责关闭。
+func openSessionWithRetry(instanceId string, dial sshDialer, addr string, client sshClient, config *ssh.ClientConfig, deadline time.Time, policy dialRetryPolicy) (sshClient, sshSession, error) {
+	session, err := client.NewSession()
+	for attempt := 0; err != nil && policy.canRetry(attempt, err, deadline); attempt++ {
+		logger.WithInstance(instanceId).Warnf("[SSH Execute] SSH session to %s reset, reconnecting (%d/%d) in %s - Error: %v", addr, attempt+1, policy.retries, policy.backoff, err)
+		discardPooledClient(client)
+		client.Close()
+		retrySleepFn(policy.backoff)
+		config.Timeout = minDuration(policy.connectTimeout, remainingBudget(deadline))
+		newClient, dialErr := dial("tcp", addr, config)
+		if dialErr != nil {
+			return client, nil, dialErr
+		}
+		client = newClient
+		session, err = client.NewSession()
+	}
+	return client, session, err
+}
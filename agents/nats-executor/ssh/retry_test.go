@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"nats-executor/utils"
+)
+
+func withNoRetrySleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var sleeps []time.Duration
+	original := retrySleepFn
+	retrySleepFn = func(d time.Duration) { sleeps = append(sleeps, d) }
+	t.Cleanup(func() { retrySleepFn = original })
+	return &sleeps
+}
+
+func retryTestRequest() ExecuteRequest {
+	return ExecuteRequest{
+		Command:        "uptime",
+		ExecuteTimeout: 5,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+	}
+}
+
+func TestConfiguredDialRetryPolicy(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "")
+	t.Setenv(sshDialRetryBackoffEnv, "")
+	if policy := configuredDialRetryPolicy(); policy.retries != defaultSSHDialRetries || policy.backoff != defaultSSHDialRetryBackoff {
+		t.Fatalf("unexpected default policy: %+v", policy)
+	}
+
+	t.Setenv(sshDialRetriesEnv, "4")
+	t.Setenv(sshDialRetryBackoffEnv, "50")
+	if policy := configuredDialRetryPolicy(); policy.retries != 4 || policy.backoff != 50*time.Millisecond {
+		t.Fatalf("unexpected configured policy: %+v", policy)
+	}
+
+	t.Setenv(sshDialRetriesEnv, "-1")
+	t.Setenv(sshDialRetryBackoffEnv, "abc")
+	if policy := configuredDialRetryPolicy(); policy.retries != defaultSSHDialRetries || policy.backoff != defaultSSHDialRetryBackoff {
+		t.Fatalf("expected invalid values to fall back to defaults: %+v", policy)
+	}
+}
+
+func TestIsConnectionResetError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: fmt.Errorf("dial: %w", syscall.ECONNRESET), want: true},
+		{err: errors.New("read tcp 10.0.0.2:5100->10.0.0.1:22: read: connection reset by peer"), want: true},
+		{err: errors.New("ssh: handshake failed: EOF"), want: true},
+		{err: errors.New("ssh: handshake failed: ssh: unable to authenticate"), want: false},
+		{err: errors.New("dial tcp 10.0.0.1:22: i/o timeout"), want: false},
+		{err: errors.New("connection refused"), want: false},
+	}
+	for _, tt := range testCases {
+		if got := isConnectionResetError(tt.err); got != tt.want {
+			t.Fatalf("isConnectionResetError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteRetriesDialOnConnectionReset(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "2")
+	t.Setenv(sshDialRetryBackoffEnv, "10")
+	sleeps := withNoRetrySleep(t)
+
+	attempts := 0
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("read: connection reset by peer")
+		}
+		return stubSSHClient{newSession: func() (sshSession, error) { return &stubSSHSession{}, nil }}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(retryTestRequest(), "instance-1")
+	if !response.Success {
+		t.Fatalf("expected success after retries, got %+v", response)
+	}
+	if attempts != 3 || len(*sleeps) != 2 || (*sleeps)[0] != 10*time.Millisecond {
+		t.Fatalf("unexpected retry behavior: attempts=%d sleeps=%v", attempts, *sleeps)
+	}
+}
+
+func TestExecuteStopsDialRetryWhenAttemptsExhausted(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "1")
+	t.Setenv(sshDialRetryBackoffEnv, "10")
+	withNoRetrySleep(t)
+
+	attempts := 0
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		attempts++
+		return nil, errors.New("read: connection reset by peer")
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(retryTestRequest(), "instance-1")
+	if response.Success || response.Code != utils.ErrorCodeDependencyFailure || response.Category != sshCategoryNetwork {
+		t.Fatalf("expected network dependency failure, got %+v", response)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected one retry, got %d attempts", attempts)
+	}
+}
+
+func TestExecuteDoesNotRetryNonResetDialErrors(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "3")
+	withNoRetrySleep(t)
+
+	attempts := 0
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		attempts++
+		return nil, errors.New("ssh: handshake failed: ssh: unable to authenticate")
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(retryTestRequest(), "instance-1")
+	if response.Category != sshCategoryAuth {
+		t.Fatalf("expected auth failure, got %+v", response)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected no retry for auth failure, got %d attempts", attempts)
+	}
+}
+
+func TestExecuteRedialsWhenSessionCreationIsReset(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "2")
+	t.Setenv(sshDialRetryBackoffEnv, "10")
+	withNoRetrySleep(t)
+
+	dials, closes, runs := 0, 0, 0
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		dials++
+		current := dials
+		return stubSSHClient{
+			newSession: func() (sshSession, error) {
+				if current == 1 {
+					return nil, errors.New("ssh: unexpected packet: connection reset by peer")
+				}
+				return &stubSSHSession{run: func(cmd string) error { runs++; return nil }}, nil
+			},
+			close: func() error { closes++; return nil },
+		}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(retryTestRequest(), "instance-1")
+	if !response.Success {
+		t.Fatalf("expected success after session retry, got %+v", response)
+	}
+	if dials != 2 || closes != 2 || runs != 1 {
+		t.Fatalf("unexpected session retry behavior: dials=%d closes=%d runs=%d", dials, closes, runs)
+	}
+}
+
+func TestExecuteDoesNotRetryCommandFailures(t *testing.T) {
+	t.Setenv(sshDialRetriesEnv, "2")
+	withNoRetrySleep(t)
+
+	runs := 0
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			return &stubSSHSession{run: func(cmd string) error {
+				runs++
+				return errors.New("read: connection reset by peer")
+			}}, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = originalDial }()
+
+	response := Execute(retryTestRequest(), "instance-1")
+	if response.Success || response.Code != utils.ErrorCodeExecutionFailure {
+		t.Fatalf("expected execution failure, got %+v", response)
+	}
+	if runs != 1 {
+		t.Fatalf("expected command to run once, got %d", runs)
+	}
+}
@@ -0,0 +1,402 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nats-executor/local"
+	"nats-executor/logger"
+	"nats-executor/utils"
+	"nats-executor/utils/downloaderr"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	transferModeSCP  = "scp"
+	transferModeSFTP = "sftp"
+)
+
+// remoteFileClient 为 SFTP 流式写入所需的远端文件操作
+type remoteFileClient interface {
+	Stat(p string) (os.FileInfo, error)
+	Create(p string) (io.WriteCloser, error)
+	Mkdir(p string) error
+	Chmod(p string, mode os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(p string) error
+	Close() error
+}
+
+type realSFTPClient struct {
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func (c realSFTPClient) Stat(p string) (os.FileInfo, error)      { return c.client.Stat(p) }
+func (c realSFTPClient) Create(p string) (io.WriteCloser, error) { return c.client.Create(p) }
+func (c realSFTPClient) Remove(p string) error                   { return c.client.Remove(p) }
+func (c realSFTPClient) Mkdir(p string) error                    { return c.client.Mkdir(p) }
+func (c realSFTPClient) Chmod(p string, mode os.FileMode) error  { return c.client.Chmod(p, mode) }
+
+// Rename 优先使用 posix-rename 覆盖已存在的目标；服务端不支持时先删除目标再重命名
+func (c realSFTPClient) Rename(oldpath, newpath string) error {
+	if err := c.client.PosixRename(oldpath, newpath); err == nil {
+		return nil
+	}
+	_ = c.client.Remove(newpath)
+	return c.client.Rename(oldpath, newpath)
+}
+
+func (c realSFTPClient) Close() error {
+	err := c.client.Close()
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+var (
+	openObjectStream = func(req utils.DownloadFileRequest, nc sshConn) (io.ReadCloser, int64, error) {
+		natsConn, _ := nc.(*nats.Conn)
+		return utils.OpenObjectStream(req, natsConn)
+	}
+	openSFTPClientFn = func(addr string, config *ssh.ClientConfig) (remoteFileClient, error) {
+		conn, err := ssh.Dial("tcp", addr, config)
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return realSFTPClient{conn: conn, client: client}, nil
+	}
+)
+
+func validateTransferMode(mode string) string {
+	switch mode {
+	case "", transferModeSCP, transferModeSFTP:
+		return ""
+	default:
+		return fmt.Sprintf("unsupported transfer_mode: %s", mode)
+	}
+}
+
+func sftpClientConfig(user, password, privateKey, passphrase string, timeout time.Duration) (*ssh.ClientConfig, error) {
+	var authMethods []ssh.AuthMethod
+	if privateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if passphrase != "" {
+			signer, err = parsePrivateKeyWithPassphraseFn([]byte(privateKey), []byte(passphrase))
+		} else {
+			signer, err = parsePrivateKeyFn([]byte(privateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		authMethods = append(authMethods, buildPublicKeyAuthMethod(signer, profileModern))
+	}
+	if password != "" {
+		authMethods = append(authMethods, ssh.Password(password))
+	}
+	if len(authMethods) == 0 {
+		return nil, errors.New("no authentication method provided (password or private key required)")
+	}
+
+	hostKeyCallback, err := buildHostKeyCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure SSH host key verification: %w", err)
+	}
+	return &ssh.ClientConfig{
+		User:              user,
+		Auth:              authMethods,
+		Timeout:           minDuration(sshConnectTimeout, timeout),
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithmsForProfile(profileModern),
+	}, nil
+}
+
+// remoteTargetFile 与 scp 语义一致：target_path 为已存在目录时写入 target_path/file_name，否则视为目标文件路径
+func remoteTargetFile(client remoteFileClient, targetPath, fileName string) string {
+	if info, err := client.Stat(targetPath); err == nil && info.IsDir() {
+		return path.Join(targetPath, fileName)
+	}
+	return targetPath
+}
+
+func objectStreamErrorCode(err error) string {
+	switch {
+	case downloaderr.KindOf(err) == downloaderr.KindTimeout || errors.Is(err, context.DeadlineExceeded):
+		return utils.ErrorCodeTimeout
+	case downloaderr.KindOf(err) == downloaderr.KindIO:
+		return utils.ErrorCodeExecutionFailure
+	default:
+		return utils.ErrorCodeDependencyFailure
+	}
+}
+
+func failedStreamResponse(instanceId, code, message string) local.ExecuteResponse {
+	return local.ExecuteResponse{InstanceId: instanceId, Success: false, Output: message, Code: code, Error: message}
+}
+
+// streamDownloadToRemote 将对象存储中的对象经 SFTP 直接写入远端主机，不在本机落盘；
+// 先写入远端临时文件，字节数与对象大小一致后再重命名为目标文件
+func streamDownloadToRemote(req DownloadFileRequest, instanceId string, nc sshConn, deadline time.Time) local.ExecuteResponse {
+	if strings.TrimSpace(req.FileName) == "" || strings.ContainsAny(req.FileName, `/\`) || req.FileName == "." || req.FileName == ".." {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, "file_name must be a plain file name")
+	}
+	if strings.TrimSpace(req.TargetPath) == "" {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, "target_path is required")
+	}
+
+	config, err := sftpClientConfig(req.User, req.Password, req.PrivateKey, req.Passphrase, remainingBudget(deadline))
+	if err != nil {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, err.Error())
+	}
+
+	reader, size, err := openObjectStream(utils.DownloadFileRequest{
+		BucketName:     req.BucketName,
+		FileKey:        req.FileKey,
+		FileName:       req.FileName,
+		ExecuteTimeout: remainingBudgetSeconds(deadline),
+	}, nc)
+	if err != nil {
+		return failedStreamResponse(instanceId, objectStreamErrorCode(err), fmt.Sprintf("Failed to open object: %v", err))
+	}
+	defer reader.Close()
+
+	addr := fmt.Sprintf("%s:%d", req.Host, req.Port)
+	client, err := openSFTPClientFn(addr, config)
+	if err != nil {
+		code := utils.ErrorCodeDependencyFailure
+		if remainingBudget(deadline) <= 0 || isLikelyTimeoutError(err) {
+			code = utils.ErrorCodeTimeout
+		}
+		return failedStreamResponse(instanceId, code, fmt.Sprintf("Failed to open SFTP session: %v", err))
+	}
+	// 远端写入阻塞时到期关闭连接，使 io.Copy 及时返回
+	timer := time.AfterFunc(remainingBudget(deadline), func() { client.Close() })
+	defer func() {
+		timer.Stop()
+		client.Close()
+	}()
+
+	targetFile := remoteTargetFile(client, req.TargetPath, req.FileName)
+	tempFile := remoteTempPath(targetFile)
+	logger.WithInstance(instanceId).Debugf("[SFTP Transfer] streaming %s/%s (%s) -> %s@%s:%s", req.BucketName, req.FileKey, humanReadableSize(size), req.User, addr, targetFile)
+
+	writer, err := client.Create(tempFile)
+	if err != nil {
+		return failedStreamResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to create remote file %s: %v", tempFile, err))
+	}
+	hasher := sha256.New()
+	written, copyErr := io.Copy(io.MultiWriter(writer, hasher), reader)
+	closeErr := writer.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil && written != size {
+		copyErr = fmt.Errorf("size mismatch: wrote %d bytes, object has %d bytes", written, size)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); copyErr == nil && req.ExpectedSHA256 != "" && !strings.EqualFold(actual, req.ExpectedSHA256) {
+		copyErr = fmt.Errorf("sha256 mismatch: expected %s, got %s", req.ExpectedSHA256, actual)
+	}
+	if copyErr != nil {
+		_ = client.Remove(tempFile)
+		code := objectStreamErrorCode(copyErr)
+		if remainingBudget(deadline) <= 0 {
+			code = utils.ErrorCodeTimeout
+		}
+		return failedStreamResponse(instanceId, code, fmt.Sprintf("Failed to stream file to %s: %v", targetFile, copyErr))
+	}
+	if err := client.Rename(tempFile, targetFile); err != nil {
+		_ = client.Remove(tempFile)
+		return failedStreamResponse(instanceId, utils.ErrorCodeExecutionFailure, fmt.Sprintf("Failed to finalize remote file %s: %v", targetFile, err))
+	}
+
+	logger.WithInstance(instanceId).Infof("[SFTP Transfer] streamed %d bytes to %s@%s:%s", written, req.User, addr, targetFile)
+	return local.ExecuteResponse{
+		InstanceId:       instanceId,
+		Success:          true,
+		Output:           fmt.Sprintf("Streamed %d bytes (%s) to %s:%s via SFTP", written, humanReadableSize(written), req.Host, targetFile),
+		BytesTransferred: written,
+	}
+}
+
+// uploadToRemoteOverSFTP 经 SFTP 将本机文件或目录（需 recursive）上传到远端，不依赖 scp / sshpass；
+// 目标语义与 scp -r 一致，每个文件先写入远端临时文件再重命名。
+// source_path 本身为软链接时解引用，目录内的软链接与特殊文件一律跳过，避免越出源目录或形成循环
+func uploadToRemoteOverSFTP(req UploadFileRequest, instanceId string, deadline time.Time) local.ExecuteResponse {
+	if strings.TrimSpace(req.SourcePath) == "" || strings.TrimSpace(req.TargetPath) == "" {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, "source_path and target_path are required")
+	}
+	sourceInfo, err := os.Stat(req.SourcePath)
+	if err != nil {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, fmt.Sprintf("Failed to read source_path: %v", err))
+	}
+	if sourceInfo.IsDir() && !req.Recursive {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, fmt.Sprintf("source_path %s is a directory, set recursive to upload directories", req.SourcePath))
+	}
+
+	config, err := sftpClientConfig(req.User, req.Password, req.PrivateKey, req.Passphrase, remainingBudget(deadline))
+	if err != nil {
+		return failedStreamResponse(instanceId, utils.ErrorCodeInvalidRequest, err.Error())
+	}
+	addr := fmt.Sprintf("%s:%d", req.Host, req.Port)
+	client, err := openSFTPClientFn(addr, config)
+	if err != nil {
+		code := utils.ErrorCodeDependencyFailure
+		if remainingBudget(deadline) <= 0 || isLikelyTimeoutError(err) {
+			code = utils.ErrorCodeTimeout
+		}
+		return failedStreamResponse(instanceId, code, fmt.Sprintf("Failed to open SFTP session: %v", err))
+	}
+	timer := time.AfterFunc(remainingBudget(deadline), func() { client.Close() })
+	defer func() {
+		timer.Stop()
+		client.Close()
+	}()
+
+	target := remoteTargetFile(client, req.TargetPath, filepath.Base(req.SourcePath))
+	logger.WithInstance(instanceId).Debugf("[SFTP Transfer] uploading %s -> %s@%s:%s", req.SourcePath, req.User, addr, target)
+	var written int64
+	var skipped []string
+	if sourceInfo.IsDir() {
+		written, skipped, err = uploadDirOverSFTP(client, req.SourcePath, target, req.PreserveMode)
+	} else {
+		written, err = uploadFileOverSFTP(client, req.SourcePath, target, req.PreserveMode)
+	}
+	for _, link := range skipped {
+		logger.WithInstance(instanceId).Warnf("[SFTP Transfer] skipped symlink or special file %s", link)
+	}
+	if err != nil {
+		code := utils.ErrorCodeExecutionFailure
+		if remainingBudget(deadline) <= 0 {
+			code = utils.ErrorCodeTimeout
+		}
+		return failedStreamResponse(instanceId, code, fmt.Sprintf("Failed to upload %s to %s: %v", req.SourcePath, target, err))
+	}
+
+	logger.WithInstance(instanceId).Infof("[SFTP Transfer] uploaded %d bytes to %s@%s:%s", written, req.User, addr, target)
+	output := fmt.Sprintf("Uploaded %d bytes (%s) to %s:%s via SFTP", written, humanReadableSize(written), req.Host, target)
+	if len(skipped) > 0 {
+		output += fmt.Sprintf(", skipped %d symlink or special file(s): %s", len(skipped), strings.Join(skipped, ", "))
+	}
+	return local.ExecuteResponse{
+		InstanceId:       instanceId,
+		Success:          true,
+		Output:           output,
+		BytesTransferred: written,
+	}
+}
+
+// uploadDirOverSFTP 递归重建目录结构并上传其中的普通文件，返回跳过的软链接与特殊文件（相对源目录）；
+// 保留权限时目录权限在其内容写完后再设置，避免只读目录阻止写入子文件
+func uploadDirOverSFTP(client remoteFileClient, sourceDir, targetDir string, preserveMode bool) (int64, []string, error) {
+	var written int64
+	var skipped []string
+	type dirMode struct {
+		path string
+		mode os.FileMode
+	}
+	var dirModes []dirMode
+	err := filepath.WalkDir(sourceDir, func(localPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, localPath)
+		if err != nil {
+			return err
+		}
+		remotePath := path.Join(targetDir, filepath.ToSlash(rel))
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			skipped = append(skipped, filepath.ToSlash(rel))
+			return nil
+		case entry.IsDir():
+			if preserveMode {
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				dirModes = append(dirModes, dirMode{path: remotePath, mode: info.Mode().Perm()})
+			}
+			if info, statErr := client.Stat(remotePath); statErr == nil && info.IsDir() {
+				return nil
+			}
+			return client.Mkdir(remotePath)
+		case !entry.Type().IsRegular():
+			skipped = append(skipped, filepath.ToSlash(rel))
+			return nil
+		}
+		n, err := uploadFileOverSFTP(client, localPath, remotePath, preserveMode)
+		written += n
+		return err
+	})
+	if err != nil {
+		return written, skipped, err
+	}
+	for i := len(dirModes) - 1; i >= 0; i-- {
+		if err := client.Chmod(dirModes[i].path, dirModes[i].mode); err != nil {
+			return written, skipped, fmt.Errorf("failed to set mode of %s: %w", dirModes[i].path, err)
+		}
+	}
+	return written, skipped, nil
+}
+
+// uploadFileOverSFTP 上传单个文件；保留权限时在重命名前按源文件权限位设置临时文件
+func uploadFileOverSFTP(client remoteFileClient, localPath, remotePath string, preserveMode bool) (int64, error) {
+	source, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tempFile := remoteTempPath(remotePath)
+	writer, err := client.Create(tempFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create remote file %s: %w", tempFile, err)
+	}
+	written, copyErr := io.Copy(writer, source)
+	if closeErr := writer.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil && preserveMode {
+		copyErr = client.Chmod(tempFile, info.Mode().Perm())
+	}
+	if copyErr == nil {
+		copyErr = client.Rename(tempFile, remotePath)
+	}
+	if copyErr != nil {
+		_ = client.Remove(tempFile)
+		return written, copyErr
+	}
+	return written, nil
+}
+
+// remoteTempPath 返回写入 target 时使用的同目录临时文件名，随机后缀避免并发写入同一目标时相互覆盖
+func remoteTempPath(target string) string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return target + ".tmp-" + hex.EncodeToString(suffix)
+}
@@ -5,11 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 	"nats-executor/local"
@@ -65,6 +67,10 @@ func (s *subscriberStubSSHSession) Close() error {
 
 func (s *subscriberStubSSHSession) SetStdout(w io.Writer) { s.stdout = w }
 func (s *subscriberStubSSHSession) SetStderr(w io.Writer) { s.stderr = w }
+func (s *subscriberStubSSHSession) SetStdin(r io.Reader)  {}
+func (s *subscriberStubSSHSession) RequestPty(term string, h, w int, modes gossh.TerminalModes) error {
+	return nil
+}
 
 func (s stubResponseMsg) Respond(payload []byte) error {
 	if s.respond == nil {
@@ -136,6 +142,175 @@ func TestHandleSSHExecuteMessageReturnsExecutionResponse(t *testing.T) {
 	}
 }
 
+func TestHandleSSHExecuteMessageFiltersOutputLines(t *testing.T) {
+	original := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			session := &subscriberStubSSHSession{}
+			session.run = func(cmd string) error {
+				_, _ = session.stdout.Write([]byte("warn: retry\nok: done\nwarn: slow\n"))
+				return nil
+			}
+			return session, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = original }()
+
+	for _, tt := range []struct {
+		invert bool
+		want   string
+	}{
+		{invert: false, want: "warn: retry\nwarn: slow\n"},
+		{invert: true, want: "ok: done\n"},
+	} {
+		payload := fmt.Sprintf(`{"args":[{"command":"deploy","execute_timeout":5,"host":"10.0.0.1","port":22,"user":"root","password":"x","output_grep":"^warn","output_grep_invert":%t}],"kwargs":{}}`, tt.invert)
+		response, _ := handleSSHExecuteMessage([]byte(payload), "instance-1", nil)
+		var result ExecuteResponse
+		if err := json.Unmarshal(response, &result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if !result.Success || result.Output != tt.want {
+			t.Fatalf("invert=%v: unexpected response: %+v", tt.invert, result)
+		}
+	}
+}
+
+func TestHandleSSHExecuteMessageCapsResponseSize(t *testing.T) {
+	utils.SetResponseSizeConfig(utils.ResponseSizeConfig{MaxBytes: 512})
+	defer utils.SetResponseSizeConfig(utils.ResponseSizeConfig{})
+	original := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			session := &subscriberStubSSHSession{}
+			session.run = func(cmd string) error {
+				_, _ = session.stdout.Write([]byte(strings.Repeat("x", 4096)))
+				return nil
+			}
+			return session, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = original }()
+
+	payload := []byte(`{"args":[{"command":"cat big.log","execute_timeout":5,"host":"10.0.0.1","port":22,"user":"root","password":"x"}],"kwargs":{}}`)
+	response, _ := handleSSHExecuteMessage(payload, "instance-1", nil)
+	if len(response) > 512 {
+		t.Fatalf("expected response within 512 bytes, got %d", len(response))
+	}
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Success || !result.Truncated {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
+func TestHandleSSHExecuteMessageRespondsWhenHandlerDeadlineExceeded(t *testing.T) {
+	// 拨号一直卡住，直到测试结束才放行；处理函数须在截止时自行放弃拨号并返回
+	release := make(chan struct{})
+	defer close(release)
+	returned := make(chan struct{})
+	originalDial := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		<-release
+		return nil, errors.New("released")
+	}
+	originalDeadline := handlerDeadline
+	handlerDeadline = func(int) time.Duration { return 20 * time.Millisecond }
+	originalReturned := handlerReturned
+	handlerReturned = func() { close(returned) }
+	defer func() {
+		sshDialFn = originalDial
+		handlerDeadline = originalDeadline
+		handlerReturned = originalReturned
+	}()
+
+	payload := []byte(`{"args":[{"command":"uptime","execute_timeout":5,"host":"10.0.0.1","port":22,"user":"root","password":"x"}],"kwargs":{}}`)
+	start := time.Now()
+	response, ok := handleSSHExecuteMessage(payload, "instance-1", nil)
+	if !ok {
+		t.Fatal("expected execute response")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected handler to respond at its deadline, took %s", elapsed)
+	}
+	if running := utils.DefaultLimits.Snapshot().RunningJobs; running != 0 {
+		t.Fatalf("expected the concurrency slot to be released at the deadline, %d still running", running)
+	}
+	if jobs := utils.DefaultJobs.List(""); len(jobs) != 0 {
+		t.Fatalf("expected the job entry to be removed at the deadline, got %+v", jobs)
+	}
+	// 等后台处理函数返回后再恢复包级变量，避免与后续测试竞争
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler to abandon the stuck dial once its deadline passed")
+	}
+
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Success || result.Code != utils.ErrorCodeTimeout || !strings.Contains(result.Error, "Handler deadline exceeded") {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
+func TestHandleSSHExecuteMessageRejectsInstanceMismatch(t *testing.T) {
+	original := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		t.Fatal("must not dial on instance mismatch")
+		return nil, nil
+	}
+	defer func() { sshDialFn = original }()
+
+	payload := []byte(`{"args":[{"command":"uptime","execute_timeout":5,"host":"10.0.0.1","port":22,"user":"root","password":"x"}],"kwargs":{"instance_id":"node-b"}}`)
+	response, _ := handleSSHExecuteMessage(payload, "node-a", nil)
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Success || result.Code != utils.ErrorCodeInstanceMismatch {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+}
+
+func TestHandleSSHExecuteMessageSeparatesStdoutAndStderr(t *testing.T) {
+	original := sshDialFn
+	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			session := &subscriberStubSSHSession{}
+			session.run = func(cmd string) error {
+				_, err := io.WriteString(session.stderr, "warning: disk almost full\n")
+				return err
+			}
+			return session, nil
+		}}, nil
+	}
+	defer func() { sshDialFn = original }()
+
+	payload := []byte(`{"args":[{"command":"check-disk","execute_timeout":5,"host":"10.0.0.1","port":22,"user":"root","password":"x"}],"kwargs":{}}`)
+	response, _ := handleSSHExecuteMessage(payload, "instance-1", nil)
+
+	var fields map[string]any
+	if err := json.Unmarshal(response, &fields); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if _, ok := fields["stdout"]; !ok {
+		t.Fatalf("expected stdout field in serialized response: %s", response)
+	}
+	var result ExecuteResponse
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !result.Success || result.Stdout != "" || result.Stderr != "warning: disk almost full\n" {
+		t.Fatalf("unexpected stdout/stderr split: %+v", result)
+	}
+	if result.Output != result.Stderr {
+		t.Fatalf("expected merged output to keep stderr, got %q", result.Output)
+	}
+}
+
 func TestRespondSSHExecuteMessageSendsExecutionResponse(t *testing.T) {
 	original := sshDialFn
 	sshDialFn = func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
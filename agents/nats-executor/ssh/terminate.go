@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"nats-executor/logger"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	terminationExited        = "exited"
+	terminationTimeoutKilled = "timeout_killed"
+	terminationCanceled      = "canceled"
+)
+
+// remoteKillTimeout 为超时或取消后结束远端进程组的等待上限，避免卡住的连接拖慢响应
+const remoteKillTimeout = 5 * time.Second
+
+// newRemotePIDFile 生成远端 PID 文件路径，仅含十六进制字符，拼接进命令时无需转义
+var newRemotePIDFile = func() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return "/tmp/.nats-executor-" + hex.EncodeToString(buf) + ".pid"
+}
+
+// wrapWithPIDFile 在命令前记录远端 shell 的 PID，正常退出时删除；sshd 无 pty 时会 setsid，该 PID 即进程组号
+func wrapWithPIDFile(command, pidFile string) string {
+	return fmt.Sprintf("echo $$ > %s; trap 'rm -f %s' EXIT; %s", pidFile, pidFile, command)
+}
+
+// killRemoteProcessGroup 另开会话读取 PID 文件并 SIGKILL 整个进程组，进程组不存在时退回只结束该进程；
+// sudo 时组内有 root 进程，普通用户无权结束，因此同样经 sudo 执行
+func killRemoteProcessGroup(client sshClient, req ExecuteRequest, pidFile string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	script := fmt.Sprintf(`pid=$(cat %s 2>/dev/null); rm -f %s; [ -n "$pid" ] || exit 0; kill -KILL "-$pid" 2>/dev/null || kill -KILL "$pid" 2>/dev/null; exit 0`, pidFile, pidFile)
+	if req.Sudo {
+		script = wrapWithSudo(script)
+		session.SetStdin(sudoStdin(req))
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- session.Run(script)
+	}()
+
+	timer := time.NewTimer(remoteKillTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-errChan:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s", remoteKillTimeout)
+	}
+}
+
+// terminateRemoteCommand 终止超时或被取消的远端命令：很多 sshd 不转发信号，发送 SIGKILL 后关闭会话，
+// 需要时按 PID 结束进程组；无 cleanup_command 时立即断开连接，否则在清理命令结束后断开
+func terminateRemoteCommand(client sshClient, session sshSession, req ExecuteRequest, pidFile, instanceId string) {
+	// 远端命令可能仍在运行，连接不再放回连接池
+	discardPooledClient(client)
+	if err := session.Signal(ssh.SIGKILL); err != nil {
+		logger.WithInstance(instanceId).Debugf("[SSH Execute] SIGKILL not delivered: %v", err)
+	}
+	if pidFile != "" {
+		if err := killRemoteProcessGroup(client, req, pidFile); err != nil {
+			logger.WithInstance(instanceId).Warnf("[SSH Execute] Failed to kill remote process group: %v", err)
+		}
+	}
+	session.Close()
+	if !hasCleanupCommand(req) {
+		client.Close()
+	}
+}
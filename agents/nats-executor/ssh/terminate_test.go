@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExecuteReportsNaturalTermination(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires POSIX sh")
+	}
+	originalDial := sshDialFn
+	sshDialFn = runWithLocalShell(t)
+	defer func() { sshDialFn = originalDial }()
+
+	base := ExecuteRequest{ExecuteTimeout: 5, Host: "10.0.0.1", Port: 22, User: "root", Password: "secret", KillOnTimeout: true}
+	for command, success := range map[string]bool{"echo ok": true, "exit 3": false} {
+		req := base
+		req.Command = command
+		response := Execute(req, "instance-1")
+		if response.Success != success || response.Termination != terminationExited {
+			t.Fatalf("command %q: unexpected response %+v", command, response)
+		}
+	}
+}
+
+func TestWrapWithPIDFileRecordsAndRemovesPID(t *testing.T) {
+	got := wrapWithPIDFile("uptime", "/tmp/.nats-executor-ab.pid")
+	want := "echo $$ > /tmp/.nats-executor-ab.pid; trap 'rm -f /tmp/.nats-executor-ab.pid' EXIT; uptime"
+	if got != want {
+		t.Fatalf("unexpected command: %q", got)
+	}
+	if path := newRemotePIDFile(); !strings.HasPrefix(path, "/tmp/.nats-executor-") || strings.ContainsAny(path, " '\"") {
+		t.Fatalf("unexpected pid file path: %q", path)
+	}
+}
+
+func TestKillRemoteProcessGroupRunsThroughSudo(t *testing.T) {
+	var command string
+	var stdin []byte
+	client := stubSSHClient{newSession: func() (sshSession, error) {
+		session := &stubSSHSession{}
+		session.run = func(cmd string) error {
+			command = cmd
+			if session.stdin != nil {
+				stdin, _ = io.ReadAll(session.stdin)
+			}
+			return nil
+		}
+		return session, nil
+	}}
+
+	req := ExecuteRequest{Sudo: true, SudoPassword: "s3cret"}
+	if err := killRemoteProcessGroup(client, req, "/tmp/.nats-executor-ab.pid"); err != nil {
+		t.Fatalf("kill: %v", err)
+	}
+	if !strings.HasPrefix(command, "sudo -S -p '' sh -c ") || !strings.Contains(command, "kill -KILL") || string(stdin) != "s3cret\n" {
+		t.Fatalf("kill did not run through sudo: command=%q stdin=%q", command, stdin)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"nats-executor/utils"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// runInProcessGroup 与 runWithLocalShell 相同，但像 sshd 一样让远端 shell 自成进程组，并记录命令何时退出
+func runInProcessGroup(t *testing.T, exited chan<- string) func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+	t.Helper()
+	return func(network, addr string, config *gossh.ClientConfig) (sshClient, error) {
+		return stubSSHClient{newSession: func() (sshSession, error) {
+			session := &stubSSHSession{}
+			session.run = func(cmd string) error {
+				command := exec.Command("sh", "-c", cmd)
+				command.Stdout = session.stdout
+				command.Stderr = session.stderr
+				command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+				err := command.Run()
+				exited <- cmd
+				return err
+			}
+			return session, nil
+		}}, nil
+	}
+}
+
+func TestExecuteKillOnTimeoutKillsRemoteProcessGroup(t *testing.T) {
+	exited := make(chan string, 4)
+	originalDial := sshDialFn
+	sshDialFn = runInProcessGroup(t, exited)
+	pidFile := filepath.Join(t.TempDir(), "remote.pid")
+	originalPIDFile := newRemotePIDFile
+	newRemotePIDFile = func() string { return pidFile }
+	defer func() {
+		sshDialFn = originalDial
+		newRemotePIDFile = originalPIDFile
+	}()
+
+	response := Execute(ExecuteRequest{
+		Command:        "sleep 30; echo finished",
+		ExecuteTimeout: 1,
+		Host:           "10.0.0.1",
+		Port:           22,
+		User:           "root",
+		Password:       "secret",
+		KillOnTimeout:  true,
+	}, "instance-1")
+
+	if response.Code != utils.ErrorCodeTimeout || response.Termination != terminationTimeoutKilled {
+		t.Fatalf("expected timeout_killed response, got %+v", response)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case cmd := <-exited:
+			if strings.Contains(cmd, "sleep 30") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("expected remote command to be killed after timeout")
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nats-executor/local"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/sftp"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// e2eSSHServer 为仅支持 sftp 子系统的进程内 SSH 服务端，OpenSSH 9+ 的 scp 默认也走 sftp 协议
+type e2eSSHServer struct {
+	port       uint
+	privateKey string
+}
+
+func startE2ESSHServer(t *testing.T) e2eSSHServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate host key: %v", err)
+	}
+	hostSigner, err := gossh.NewSignerFromSigner(hostKey)
+	if err != nil {
+		t.Fatalf("host signer: %v", err)
+	}
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+	clientSSHPublic, err := gossh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatalf("client public key: %v", err)
+	}
+	block, err := gossh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatalf("marshal client key: %v", err)
+	}
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if string(key.Marshal()) == string(clientSSHPublic.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown public key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveE2ESSHConn(conn, config)
+		}
+	}()
+
+	// 写入服务端主机公钥，使 Go 客户端与 scp 都开启严格主机校验，避免改动用户的 known_hosts
+	addr := listener.Addr().String()
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("write known_hosts: %v", err)
+	}
+	t.Setenv("SSH_KNOWN_HOSTS_FILE", knownHostsFile)
+
+	return e2eSSHServer{
+		port:       uint(listener.Addr().(*net.TCPAddr).Port),
+		privateKey: string(pem.EncodeToMemory(block)),
+	}
+}
+
+func serveE2ESSHConn(conn net.Conn, config *gossh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go gossh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range channelRequests {
+				var subsystem struct{ Name string }
+				if req.Type != "subsystem" || gossh.Unmarshal(req.Payload, &subsystem) != nil || subsystem.Name != "sftp" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				sftpServer, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				status := uint32(0)
+				if err := sftpServer.Serve(); err != nil && !errors.Is(err, io.EOF) {
+					status = 1
+				}
+				// sftp.Server.Close 会关闭 channel，须先回报退出码，否则 scp 视为异常退出
+				channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{status}))
+				sftpServer.Close()
+				return
+			}
+		}()
+	}
+}
+
+func startE2ENATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func e2eRequest(t *testing.T, nc *nats.Conn, subject string, request any) local.ExecuteResponse {
+	t.Helper()
+	args, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	payload, _ := json.Marshal(map[string]any{"args": []json.RawMessage{args}, "kwargs": map[string]any{}})
+	msg, err := nc.Request(subject, payload, 30*time.Second)
+	if err != nil {
+		t.Fatalf("request %s: %v", subject, err)
+	}
+	var response local.ExecuteResponse
+	if err := json.Unmarshal(msg.Data, &response); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	return response
+}
+
+func assertFileContent(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read transferred file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("transferred file %s does not match: got %d bytes, want %d bytes", path, len(got), len(want))
+	}
+}
+
+func TestTransferEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end-to-end transfer test")
+	}
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp not available")
+	}
+
+	sshServer := startE2ESSHServer(t)
+	nc := startE2ENATS(t)
+	instanceID := "e2e-instance"
+	SubscribeDownloadToRemote(nc, &instanceID)
+	SubscribeUploadToRemote(nc, &instanceID)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush subscriptions: %v", err)
+	}
+
+	content := make([]byte, 256*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("random content: %v", err)
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	store, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: "e2e"})
+	if err != nil {
+		t.Fatalf("create object store: %v", err)
+	}
+	if _, err := store.PutBytes("packages/agent.bin", content); err != nil {
+		t.Fatalf("put object: %v", err)
+	}
+
+	remoteDir := t.TempDir()
+	for _, mode := range []string{transferModeSCP, transferModeSFTP} {
+		t.Run("download.remote "+mode, func(t *testing.T) {
+			targetDir := filepath.Join(remoteDir, mode)
+			if err := os.MkdirAll(targetDir, 0o755); err != nil {
+				t.Fatalf("mkdir target: %v", err)
+			}
+			response := e2eRequest(t, nc, "download.remote."+instanceID, DownloadFileRequest{
+				BucketName:     "e2e",
+				FileKey:        "packages/agent.bin",
+				FileName:       "agent.bin",
+				TargetPath:     targetDir,
+				Host:           "127.0.0.1",
+				Port:           sshServer.port,
+				User:           "deploy",
+				PrivateKey:     sshServer.privateKey,
+				ExecuteTimeout: 30,
+				TransferMode:   mode,
+			})
+			if !response.Success {
+				t.Fatalf("download.remote failed: %+v", response)
+			}
+			assertFileContent(t, filepath.Join(targetDir, "agent.bin"), content)
+		})
+	}
+
+	for _, mode := range []string{transferModeSCP, transferModeSFTP} {
+		t.Run("upload.remote "+mode, func(t *testing.T) {
+			sourcePath := filepath.Join(t.TempDir(), "report.log")
+			if err := os.WriteFile(sourcePath, content, 0o600); err != nil {
+				t.Fatalf("write source: %v", err)
+			}
+			targetPath := filepath.Join(remoteDir, "uploaded-"+mode+".log")
+			response := e2eRequest(t, nc, "upload.remote."+instanceID, UploadFileRequest{
+				SourcePath:     sourcePath,
+				TargetPath:     targetPath,
+				Host:           "127.0.0.1",
+				Port:           sshServer.port,
+				User:           "deploy",
+				PrivateKey:     sshServer.privateKey,
+				ExecuteTimeout: 30,
+				TransferMode:   mode,
+			})
+			if !response.Success {
+				t.Fatalf("upload.remote failed: %+v", response)
+			}
+			assertFileContent(t, targetPath, content)
+		})
+	}
+
+	t.Run("upload.remote sftp directory", func(t *testing.T) {
+		sourceDir := writeNestedSourceTree(t)
+		targetDir := filepath.Join(remoteDir, "tree")
+		response := e2eRequest(t, nc, "upload.remote."+instanceID, UploadFileRequest{
+			SourcePath:     sourceDir,
+			TargetPath:     targetDir,
+			Host:           "127.0.0.1",
+			Port:           sshServer.port,
+			User:           "deploy",
+			PrivateKey:     sshServer.privateKey,
+			ExecuteTimeout: 30,
+			TransferMode:   transferModeSFTP,
+			Recursive:      true,
+			PreserveMode:   true,
+		})
+		if !response.Success {
+			t.Fatalf("upload.remote failed: %+v", response)
+		}
+		assertFileContent(t, filepath.Join(targetDir, "sub", "deep", "b.yaml"), []byte("bb"))
+		if _, err := os.Lstat(filepath.Join(targetDir, "outside")); !os.IsNotExist(err) {
+			t.Fatalf("expected symlink to be skipped, got %v", err)
+		}
+		for name, want := range map[string]os.FileMode{"bin/run.sh": 0o755, "a.yaml": 0o640, "sub": 0o750} {
+			info, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(name)))
+			if err != nil || info.Mode().Perm() != want {
+				t.Fatalf("expected %s mode %o, got %v (%v)", name, want, info, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"nats-executor/logger"
+)
+
+// CancelRegistry 按 task_id 记录执行中任务的 cancel 函数，供 *.cancel 主题中途取消
+type CancelRegistry struct {
+	mu      sync.Mutex
+	cancels map[string]*cancelEntry
+}
+
+type cancelEntry struct {
+	cancel context.CancelFunc
+}
+
+func NewCancelRegistry() *CancelRegistry {
+	return &CancelRegistry{cancels: make(map[string]*cancelEntry)}
+}
+
+// Register 登记任务的 cancel 函数，返回的函数在任务结束时调用；taskID 为空时不登记
+func (r *CancelRegistry) Register(taskID string, cancel context.CancelFunc) func() {
+	if taskID == "" {
+		return func() {}
+	}
+	entry := &cancelEntry{cancel: cancel}
+	r.mu.Lock()
+	r.cancels[taskID] = entry
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			// 同 task_id 的新任务已替换登记时保留新任务
+			if r.cancels[taskID] == entry {
+				delete(r.cancels, taskID)
+			}
+			r.mu.Unlock()
+		})
+	}
+}
+
+// Cancel 取消执行中的任务，任务不存在或已结束时返回 false
+func (r *CancelRegistry) Cancel(taskID string) bool {
+	r.mu.Lock()
+	entry, ok := r.cancels[taskID]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	entry.cancel()
+	return true
+}
+
+// CancelRequest 为 *.cancel 主题的请求参数
+type CancelRequest struct {
+	TaskID string `json:"task_id"`
+}
+
+type CancelResponse struct {
+	InstanceId string `json:"instance_id"`
+	Success    bool   `json:"success"`
+	TaskID     string `json:"task_id"`
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HandleCancelMessage 解析 {"args":[{"task_id":"..."}]} 并取消对应任务；取消只是触发，任务的结果仍由原请求返回
+func (r *CancelRegistry) HandleCancelMessage(operation string, data []byte, instanceId string) []byte {
+	var incoming struct {
+		Args []CancelRequest `json:"args"`
+	}
+	if err := json.Unmarshal(data, &incoming); err != nil || len(incoming.Args) == 0 {
+		return marshalCancelResponse(CancelResponse{InstanceId: instanceId, Code: ErrorCodeInvalidRequest, Error: "invalid request payload"})
+	}
+	taskID := strings.TrimSpace(incoming.Args[0].TaskID)
+	if taskID == "" {
+		return marshalCancelResponse(CancelResponse{InstanceId: instanceId, Code: ErrorCodeInvalidRequest, Error: "task_id is required"})
+	}
+	if !r.Cancel(taskID) {
+		return marshalCancelResponse(CancelResponse{InstanceId: instanceId, TaskID: taskID, Code: ErrorCodeTaskNotFound, Error: fmt.Sprintf("task %s is not running on this instance", taskID)})
+	}
+	logger.Infof("[%s] Instance: %s, Canceled task %s", operation, instanceId, taskID)
+	return marshalCancelResponse(CancelResponse{InstanceId: instanceId, Success: true, TaskID: taskID})
+}
+
+func marshalCancelResponse(response CancelResponse) []byte {
+	data, _ := json.Marshal(response)
+	return data
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCancelRegistryCancelsRegisteredTask(t *testing.T) {
+	registry := NewCancelRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	unregister := registry.Register("task-1", cancel)
+
+	if registry.Cancel("task-2") {
+		t.Fatal("expected unknown task to report false")
+	}
+	if !registry.Cancel("task-1") || ctx.Err() != context.Canceled {
+		t.Fatalf("expected task-1 to be canceled, err=%v", ctx.Err())
+	}
+
+	unregister()
+	if registry.Cancel("task-1") {
+		t.Fatal("expected finished task to be removed")
+	}
+}
+
+func TestCancelRegistryKeepsNewerTaskWithSameID(t *testing.T) {
+	registry := NewCancelRegistry()
+	_, firstCancel := context.WithCancel(context.Background())
+	defer firstCancel()
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+
+	unregisterFirst := registry.Register("task-1", firstCancel)
+	registry.Register("task-1", secondCancel)
+	unregisterFirst()
+
+	if !registry.Cancel("task-1") || secondCtx.Err() != context.Canceled {
+		t.Fatal("expected newer task to stay registered")
+	}
+	if unregister := registry.Register("", secondCancel); registry.Cancel("") {
+		unregister()
+		t.Fatal("expected empty task_id not to be registered")
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// HandlerDeadlineGrace 为处理器在请求超时之外额外等待的时间，
+// 执行器自身的超时应在此之前以结构化结果返回。
+const HandlerDeadlineGrace = 5 * time.Second
+
+// HandlerDeadline 返回处理器持有一条请求的最长时间（请求超时 + 宽限时间）
+func HandlerDeadline(executeTimeout int) time.Duration {
+	if executeTimeout < 0 {
+		executeTimeout = 0
+	}
+	return time.Duration(executeTimeout)*time.Second + HandlerDeadlineGrace
+}
+
+// DefaultExecuteTimeoutSeconds 为未指定 execute_timeout（或 <= 0）时 local / ssh 命令执行的超时（秒）
+const DefaultExecuteTimeoutSeconds = 300
+
+// ExecuteTimeout 返回命令执行的超时秒数，<= 0 视为未设置并使用默认值
+func ExecuteTimeout(timeout int) int {
+	if timeout <= 0 {
+		return DefaultExecuteTimeoutSeconds
+	}
+	return timeout
+}
+
+// DefaultCleanupTimeoutSeconds 为 cleanup_command 未指定 cleanup_timeout 时的超时（秒）
+const DefaultCleanupTimeoutSeconds = 30
+
+// CleanupTimeout 返回 cleanup_command 的超时秒数，未设置 cleanup_command 时为 0
+func CleanupTimeout(cleanupCommand string, timeout int) int {
+	if strings.TrimSpace(cleanupCommand) == "" {
+		return 0
+	}
+	if timeout <= 0 {
+		return DefaultCleanupTimeoutSeconds
+	}
+	return timeout
+}
+
+// RunWithDeadline 在 deadline 内等待 fn 返回；超时后取消传给 fn 的 ctx 并立即返回 onTimeout 的结果（第二个返回值为 true），
+// 避免执行卡死时调用方的 nc.Request 一直等不到应答。fn 应在 ctx 取消后终止进程、断开连接并尽快返回，其结果被丢弃。
+func RunWithDeadline[T any](deadline time.Duration, fn func(ctx context.Context) T, onTimeout func() T) (T, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan T, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result, false
+	case <-timer.C:
+		return onTimeout(), true
+	}
+}
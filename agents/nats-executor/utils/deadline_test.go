@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandlerDeadlineAddsGrace(t *testing.T) {
+	if got := HandlerDeadline(30); got != 30*time.Second+HandlerDeadlineGrace {
+		t.Fatalf("unexpected deadline: %s", got)
+	}
+	if got := HandlerDeadline(-1); got != HandlerDeadlineGrace {
+		t.Fatalf("unexpected deadline for invalid timeout: %s", got)
+	}
+}
+
+func TestCleanupTimeout(t *testing.T) {
+	if got := CleanupTimeout("", 10); got != 0 {
+		t.Fatalf("expected 0 without cleanup command, got %d", got)
+	}
+	if got := CleanupTimeout("rm -rf /tmp/job", 0); got != DefaultCleanupTimeoutSeconds {
+		t.Fatalf("expected default cleanup timeout, got %d", got)
+	}
+	if got := CleanupTimeout("rm -rf /tmp/job", 5); got != 5 {
+		t.Fatalf("expected explicit cleanup timeout, got %d", got)
+	}
+}
+
+func TestExecuteTimeoutDefaultsNonPositive(t *testing.T) {
+	for _, timeout := range []int{0, -1} {
+		if got := ExecuteTimeout(timeout); got != DefaultExecuteTimeoutSeconds {
+			t.Fatalf("expected default execute timeout for %d, got %d", timeout, got)
+		}
+	}
+	if got := ExecuteTimeout(5); got != 5 {
+		t.Fatalf("expected explicit execute timeout, got %d", got)
+	}
+}
+
+func TestRunWithDeadline(t *testing.T) {
+	result, timedOut := RunWithDeadline(time.Second, func(context.Context) string { return "done" }, func() string { return "timeout" })
+	if result != "done" || timedOut {
+		t.Fatalf("expected fn result, got %q timedOut=%v", result, timedOut)
+	}
+
+	canceled := make(chan struct{})
+	start := time.Now()
+	result, timedOut = RunWithDeadline(20*time.Millisecond, func(ctx context.Context) string {
+		<-ctx.Done()
+		close(canceled)
+		return "late"
+	}, func() string { return "timeout" })
+	if result != "timeout" || !timedOut {
+		t.Fatalf("expected timeout result, got %q timedOut=%v", result, timedOut)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected deadline to return promptly, took %s", elapsed)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected fn context to be canceled at the deadline")
+	}
+}
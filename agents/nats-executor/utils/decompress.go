@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressedName 去掉 .gz/.zst 后缀得到解压后的文件名
+func decompressedName(fileName string) (string, error) {
+	lower := strings.ToLower(fileName)
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		if strings.HasSuffix(lower, ext) && len(fileName) > len(ext) {
+			return fileName[:len(fileName)-len(ext)], nil
+		}
+	}
+	return "", fmt.Errorf("file_name must end with .gz or .zst to be decompressed: %s", fileName)
+}
+
+// detectCompression 按文件头魔数识别压缩格式，扩展名与实际内容不一致时以内容为准
+func detectCompression(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return compressionGzip, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return compressionZstd, nil
+	default:
+		return "", fmt.Errorf("unsupported compression format")
+	}
+}
+
+// DecompressFile 将单文件 .gz/.zst 解压到同目录（文件名去掉压缩后缀），成功后删除压缩文件，返回解压后的路径
+func DecompressFile(path string) (string, error) {
+	name, err := decompressedName(filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(filepath.Dir(path), name)
+
+	source, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open compressed file: %w", err)
+	}
+	defer source.Close()
+
+	buffered := bufio.NewReader(source)
+	header, _ := buffered.Peek(len(zstdMagic))
+	format, err := detectCompression(header)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, path)
+	}
+
+	var reader io.Reader
+	switch format {
+	case compressionGzip:
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return "", fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case compressionZstd:
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return "", fmt.Errorf("failed to read zstd stream: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	// 先写临时文件再重命名，解压失败时不会留下半截目标文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create decompressed file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	if _, err := io.Copy(tmp, reader); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to decompress %s file: %w", format, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write decompressed file: %w", err)
+	}
+	if info, err := source.Stat(); err == nil {
+		_ = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to finalize decompressed file: %w", err)
+	}
+
+	source.Close()
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove compressed file: %w", err)
+	}
+	return target, nil
+}
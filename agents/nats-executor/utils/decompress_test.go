@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"nats-executor/utils/downloaderr"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+)
+
+func gzipBytes(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(payload); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd writer: %v", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(payload, nil)
+}
+
+func TestDecompressFileHandlesGzipAndZstd(t *testing.T) {
+	payload := []byte(strings.Repeat("artifact-line\n", 1024))
+	testCases := []struct {
+		name     string
+		fileName string
+		content  []byte
+		want     string
+	}{
+		{name: "gzip", fileName: "agent.bin.gz", content: gzipBytes(t, payload), want: "agent.bin"},
+		{name: "zstd", fileName: "agent.bin.zst", content: zstdBytes(t, payload), want: "agent.bin"},
+		{name: "content wins over extension", fileName: "agent.bin.gz", content: zstdBytes(t, payload), want: "agent.bin"},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, tt.fileName)
+			if err := os.WriteFile(source, tt.content, 0o640); err != nil {
+				t.Fatalf("write source: %v", err)
+			}
+
+			target, err := DecompressFile(source)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if target != filepath.Join(dir, tt.want) {
+				t.Fatalf("unexpected target: %s", target)
+			}
+			got, err := os.ReadFile(target)
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Fatalf("unexpected decompressed content: len=%d err=%v", len(got), err)
+			}
+			if _, err := os.Stat(source); !os.IsNotExist(err) {
+				t.Fatalf("expected compressed file to be removed, stat err=%v", err)
+			}
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Fatalf("expected only the decompressed file to remain, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestDecompressFileRejectsUnknownInput(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "notes.gz")
+	if err := os.WriteFile(plain, []byte("plain text"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := DecompressFile(plain); err == nil || !strings.Contains(err.Error(), "unsupported compression format") {
+		t.Fatalf("expected unsupported format error, got %v", err)
+	}
+	if _, err := os.Stat(plain); err != nil {
+		t.Fatalf("expected source to be kept on failure: %v", err)
+	}
+
+	if _, err := DecompressFile(filepath.Join(dir, "archive.tar")); err == nil || !strings.Contains(err.Error(), "must end with .gz or .zst") {
+		t.Fatalf("expected extension error, got %v", err)
+	}
+}
+
+func TestDownloadFileDecompressesAfterDownload(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte("binary-content")
+	withStubDownloader(t, func(nc *nats.Conn, bucketName string) (fileDownloader, error) {
+		return stubDownloader{download: func(ctx context.Context, fileKey, targetPath, fileName string) error {
+			return os.WriteFile(filepath.Join(targetPath, fileName), gzipBytes(t, payload), 0o600)
+		}}, nil
+	})
+
+	request := DownloadFileRequest{BucketName: "bucket", FileKey: "key", FileName: "tool.gz", TargetPath: dir, ExecuteTimeout: 1, Decompress: true}
+	if err := DownloadFile(request, nil); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "tool")); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("unexpected decompressed file: %q err=%v", got, err)
+	}
+
+	request.FileName = "tool.bin"
+	if err := DownloadFile(request, nil); err == nil || !strings.Contains(err.Error(), "must end with .gz or .zst") {
+		t.Fatalf("expected validation error before download, got %v", err)
+	}
+
+	withStubDownloader(t, func(nc *nats.Conn, bucketName string) (fileDownloader, error) {
+		return stubDownloader{download: func(ctx context.Context, fileKey, targetPath, fileName string) error {
+			return os.WriteFile(filepath.Join(targetPath, fileName), []byte("corrupt"), 0o600)
+		}}, nil
+	})
+	request.FileName = "broken.zst"
+	if err := DownloadFile(request, nil); downloaderr.KindOf(err) != downloaderr.KindIO {
+		t.Fatalf("expected io error for corrupt archive, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const downloadAllowedBaseDirEnv = "DOWNLOAD_ALLOWED_BASE_DIR"
+
+// CheckDownloadTarget 在配置了 DOWNLOAD_ALLOWED_BASE_DIR 时校验下载目标路径清理后仍位于该目录内，
+// 返回的错误信息可直接作为 path_forbidden 响应的 error；未配置时不做限制
+func CheckDownloadTarget(field, targetPath string) string {
+	base := strings.TrimSpace(os.Getenv(downloadAllowedBaseDirEnv))
+	if base == "" {
+		return ""
+	}
+	base = filepath.Clean(base)
+	// 相对路径取决于执行器或远端的工作目录，无法判断是否越界，一律拒绝
+	if !filepath.IsAbs(targetPath) {
+		return fmt.Sprintf("%s %s must be an absolute path under %s", field, targetPath, base)
+	}
+	rel, err := filepath.Rel(base, filepath.Clean(targetPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Sprintf("%s %s is outside the allowed base directory %s", field, targetPath, base)
+	}
+	return ""
+}
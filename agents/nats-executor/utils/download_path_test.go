@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckDownloadTarget(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX paths")
+	}
+	t.Setenv(downloadAllowedBaseDirEnv, "")
+	if message := CheckDownloadTarget("target_path", "/etc"); message != "" {
+		t.Fatalf("expected no restriction without base dir, got %q", message)
+	}
+
+	t.Setenv(downloadAllowedBaseDirEnv, "/srv/files/")
+	tests := []struct {
+		target string
+		allow  bool
+	}{
+		{target: "/srv/files", allow: true},
+		{target: "/srv/files/app/releases", allow: true},
+		{target: "/srv/files/app/../conf", allow: true},
+		{target: "/srv/files/../../etc", allow: false},
+		{target: "/srv/files/..", allow: false},
+		{target: "/srv/files-other", allow: false},
+		{target: "/etc", allow: false},
+		{target: "srv/files/app", allow: false},
+		{target: "../files", allow: false},
+	}
+	for _, tt := range tests {
+		message := CheckDownloadTarget("target_path", tt.target)
+		if (message == "") != tt.allow {
+			t.Fatalf("target %q: expected allow=%v, got %q", tt.target, tt.allow, message)
+		}
+		if !tt.allow && !strings.Contains(message, tt.target) {
+			t.Fatalf("target %q: expected message to name the path, got %q", tt.target, message)
+		}
+	}
+}
+
+func TestCheckDownloadTargetUsesCleanedBaseDir(t *testing.T) {
+	base := t.TempDir()
+	t.Setenv(downloadAllowedBaseDirEnv, base+string(filepath.Separator)+".")
+	if message := CheckDownloadTarget("target_path", filepath.Join(base, "nested")); message != "" {
+		t.Fatalf("expected nested path to be allowed, got %q", message)
+	}
+	if message := CheckDownloadTarget("target_path", filepath.Join(base, "..", "escape")); message == "" {
+		t.Fatal("expected traversal out of base dir to be rejected")
+	}
+}
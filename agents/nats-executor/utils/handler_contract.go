@@ -7,6 +7,15 @@ const (
 	ErrorCodeDependencyFailure = "dependency_failure"
 	ErrorCodeExecutionFailure  = "execution_failure"
 	ErrorCodeTimeout           = "timeout"
+	ErrorCodeCommandNotFound   = "command_not_found"
+	ErrorCodeTooManyRequests   = "too_many_requests"
+	ErrorCodeInstanceMismatch  = "instance_mismatch"
+	ErrorCodeCanceled          = "canceled"
+	ErrorCodeTaskNotFound      = "task_not_found"
+	ErrorCodeStderrPresent     = "stderr_present"
+	ErrorCodePathForbidden     = "path_forbidden"
+	ErrorCodeObjectNotFound    = "object_not_found"
+	ErrorCodePermissionDenied  = "permission_denied"
 )
 
 type HandlerResponse interface {
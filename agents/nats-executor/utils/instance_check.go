@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"nats-executor/logger"
+)
+
+// RequestInstanceID 取请求中声明的目标实例 ID：优先 args[0].instance_id，其次 kwargs.instance_id
+func RequestInstanceID(data []byte) string {
+	var incoming struct {
+		Args   []json.RawMessage `json:"args"`
+		Kwargs struct {
+			InstanceID string `json:"instance_id"`
+		} `json:"kwargs"`
+	}
+	if err := json.Unmarshal(data, &incoming); err != nil {
+		return ""
+	}
+	if len(incoming.Args) > 0 {
+		var arg struct {
+			InstanceID string `json:"instance_id"`
+		}
+		if json.Unmarshal(incoming.Args[0], &arg) == nil && strings.TrimSpace(arg.InstanceID) != "" {
+			return strings.TrimSpace(arg.InstanceID)
+		}
+	}
+	return strings.TrimSpace(incoming.Kwargs.InstanceID)
+}
+
+// InstanceMismatchResponse 在请求声明的实例 ID 与本实例不一致时返回 instance_mismatch 错误响应，
+// 一致或未声明时返回 nil。用于发现路由或配置错误，避免请求在错误的节点上执行。
+func InstanceMismatchResponse(operation string, data []byte, instanceId string) []byte {
+	requested := RequestInstanceID(data)
+	if requested == "" || requested == instanceId {
+		return nil
+	}
+	message := fmt.Sprintf("request targets instance %s but was received by instance %s", requested, instanceId)
+	logger.Warnf("[%s] Instance: %s, Rejecting request: %s", operation, instanceId, message)
+	return NewErrorExecuteResponse(instanceId, ErrorCodeInstanceMismatch, message)
+}
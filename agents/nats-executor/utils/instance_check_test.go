@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRequestInstanceID(t *testing.T) {
+	cases := map[string]string{
+		`{"args":[{"command":"uptime","instance_id":"node-a"}],"kwargs":{}}`:      "node-a",
+		`{"args":[{"command":"uptime"}],"kwargs":{"instance_id":"node-b"}}`:       "node-b",
+		`{"args":[{"instance_id":" node-a "}],"kwargs":{"instance_id":"node-b"}}`: "node-a",
+		`{"args":[{"command":"uptime"}],"kwargs":{}}`:                             "",
+		`{"args":["not-an-object"],"kwargs":{}}`:                                  "",
+		`not json`:                                                                "",
+	}
+	for payload, want := range cases {
+		if got := RequestInstanceID([]byte(payload)); got != want {
+			t.Fatalf("payload %s: expected %q, got %q", payload, want, got)
+		}
+	}
+}
+
+func TestInstanceMismatchResponse(t *testing.T) {
+	if response := InstanceMismatchResponse("Local Execute", []byte(`{"args":[{"instance_id":"node-a"}]}`), "node-a"); response != nil {
+		t.Fatalf("expected matching instance to pass, got %s", response)
+	}
+	if response := InstanceMismatchResponse("Local Execute", []byte(`{"args":[{"command":"uptime"}]}`), "node-a"); response != nil {
+		t.Fatalf("expected request without instance_id to pass, got %s", response)
+	}
+
+	response := InstanceMismatchResponse("Local Execute", []byte(`{"args":[{"instance_id":"node-b"}]}`), "node-a")
+	var decoded executeHandlerResponse
+	if err := json.Unmarshal(response, &decoded); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if decoded.Success || decoded.Code != ErrorCodeInstanceMismatch || decoded.InstanceId != "node-a" {
+		t.Fatalf("unexpected response: %+v", decoded)
+	}
+	if !strings.Contains(decoded.Error, "targets instance node-b but was received by instance node-a") {
+		t.Fatalf("unexpected error message: %q", decoded.Error)
+	}
+}
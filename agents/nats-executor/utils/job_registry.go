@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobStatusRunning 为执行中的任务状态；执行器目前只有即时执行，没有排队或定时任务
+const JobStatusRunning = "running"
+
+// JobInfo 为 jobs.list 返回的任务摘要
+type JobInfo struct {
+	ID        string    `json:"id,omitempty"` // 请求中的 job_id，缺省时取 execution_id
+	Operation string    `json:"operation"`    // 触发该任务的主题类型，如 local.execute
+	Command   string    `json:"command"`
+	Host      string    `json:"host,omitempty"` // SSH 任务的目标主机
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// JobRegistry 记录本实例当前正在处理的任务，供运维查看执行器即将/正在做什么
+type JobRegistry struct {
+	mu   sync.Mutex
+	seq  uint64
+	now  func() time.Time
+	jobs map[uint64]JobInfo
+}
+
+// DefaultJobs 为进程级任务登记表，由各执行处理器登记
+var DefaultJobs = NewJobRegistry()
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{
+		now:  func() time.Time { return time.Now().UTC() },
+		jobs: make(map[uint64]JobInfo),
+	}
+}
+
+// Track 登记一个执行中的任务，返回的函数在任务结束时调用（重复调用无副作用）
+func (r *JobRegistry) Track(info JobInfo) func() {
+	r.mu.Lock()
+	r.seq++
+	key := r.seq
+	info.Status = JobStatusRunning
+	info.StartedAt = r.now()
+	r.jobs[key] = info
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.jobs, key)
+			r.mu.Unlock()
+		})
+	}
+}
+
+// List 按开始时间返回任务列表，status 非空时只返回该状态的任务
+func (r *JobRegistry) List(status string) []JobInfo {
+	r.mu.Lock()
+	keys := make([]uint64, 0, len(r.jobs))
+	for key, info := range r.jobs {
+		if status == "" || info.Status == status {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	jobs := make([]JobInfo, 0, len(keys))
+	for _, key := range keys {
+		jobs = append(jobs, r.jobs[key])
+	}
+	r.mu.Unlock()
+	return jobs
+}
+
+// IsKnownJobStatus 校验 jobs.list 的状态过滤条件
+func IsKnownJobStatus(status string) bool {
+	return status == JobStatusRunning
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobRegistryTracksRunningJobs(t *testing.T) {
+	registry := NewJobRegistry()
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	registry.now = func() time.Time { return started }
+
+	doneFirst := registry.Track(JobInfo{ID: "job-1", Operation: "local.execute", Command: "uptime"})
+	doneSecond := registry.Track(JobInfo{ID: "job-2", Operation: "ssh.execute", Command: "df -h", Host: "10.0.0.1"})
+
+	jobs := registry.List("")
+	if len(jobs) != 2 || jobs[0].ID != "job-1" || jobs[1].ID != "job-2" {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+	if jobs[0].Status != JobStatusRunning || !jobs[0].StartedAt.Equal(started) {
+		t.Fatalf("unexpected job state: %+v", jobs[0])
+	}
+	if filtered := registry.List(JobStatusRunning); len(filtered) != 2 {
+		t.Fatalf("expected running filter to match all jobs, got %+v", filtered)
+	}
+	if filtered := registry.List("scheduled"); len(filtered) != 0 {
+		t.Fatalf("expected no scheduled jobs, got %+v", filtered)
+	}
+
+	doneFirst()
+	doneFirst()
+	jobs = registry.List("")
+	if len(jobs) != 1 || jobs[0].ID != "job-2" {
+		t.Fatalf("expected finished job to be removed, got %+v", jobs)
+	}
+	doneSecond()
+	if jobs := registry.List(""); len(jobs) != 0 {
+		t.Fatalf("expected empty registry, got %+v", jobs)
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Limits 为可在运行期调整的执行限制
+type Limits struct {
+	// 同时执行的 local.execute / ssh.execute 数量，0 表示不限制
+	MaxConcurrentJobs int `json:"max_concurrent_jobs"`
+}
+
+// LimitsSnapshot 为当前生效的限制、允许调整的上限与正在执行的任务数
+type LimitsSnapshot struct {
+	Limits
+	// 运行期调整 max_concurrent_jobs 的上限，0 表示不设上限
+	MaxConcurrentJobsCeiling int `json:"max_concurrent_jobs_ceiling"`
+	RunningJobs              int `json:"running_jobs"`
+}
+
+// LimitController 控制执行类请求的并发；调整只影响新请求，进行中的任务不受影响
+type LimitController struct {
+	mu      sync.Mutex
+	limits  Limits
+	ceiling int
+	running int
+	// 上报并发限制、执行中任务数与拒绝次数，为 nil 时不上报
+	metrics *OperationMetrics
+}
+
+// DefaultLimits 为进程级执行限制，由启动配置初始化，可通过 limits.set 主题调整
+var DefaultLimits = &LimitController{metrics: DefaultMetrics}
+
+func NewLimitController() *LimitController {
+	return &LimitController{}
+}
+
+func validateLimits(limits Limits, ceiling int) error {
+	if limits.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("max_concurrent_jobs must not be negative")
+	}
+	if ceiling > 0 && (limits.MaxConcurrentJobs == 0 || limits.MaxConcurrentJobs > ceiling) {
+		return fmt.Errorf("max_concurrent_jobs must be between 1 and %d", ceiling)
+	}
+	return nil
+}
+
+// Configure 设置启动时的限制与运行期调整上限
+func (c *LimitController) Configure(limits Limits, ceiling int) error {
+	if ceiling < 0 {
+		return fmt.Errorf("max_concurrent_jobs_ceiling must not be negative")
+	}
+	if ceiling > 0 && limits.MaxConcurrentJobs == 0 {
+		limits.MaxConcurrentJobs = ceiling
+	}
+	if err := validateLimits(limits, ceiling); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.limits = limits
+	c.ceiling = ceiling
+	c.reportLocked()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *LimitController) Snapshot() LimitsSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return LimitsSnapshot{Limits: c.limits, MaxConcurrentJobsCeiling: c.ceiling, RunningJobs: c.running}
+}
+
+// Set 在上限范围内调整限制，返回调整后的生效值
+func (c *LimitController) Set(limits Limits) (LimitsSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := validateLimits(limits, c.ceiling); err != nil {
+		return LimitsSnapshot{Limits: c.limits, MaxConcurrentJobsCeiling: c.ceiling, RunningJobs: c.running}, err
+	}
+	c.limits = limits
+	c.reportLocked()
+	return LimitsSnapshot{Limits: c.limits, MaxConcurrentJobsCeiling: c.ceiling, RunningJobs: c.running}, nil
+}
+
+// Acquire 为 operation 的一个执行任务占用并发名额，超过限制时记录一次拒绝并返回 false；
+// 返回的函数在任务结束时调用（重复调用无副作用）
+func (c *LimitController) Acquire(operation string) (func(), bool) {
+	c.mu.Lock()
+	if c.limits.MaxConcurrentJobs > 0 && c.running >= c.limits.MaxConcurrentJobs {
+		c.mu.Unlock()
+		if c.metrics != nil {
+			c.metrics.Reject(operation)
+		}
+		return func() {}, false
+	}
+	c.running++
+	c.reportLocked()
+	c.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			c.running--
+			c.reportLocked()
+			c.mu.Unlock()
+		})
+	}, true
+}
+
+func (c *LimitController) reportLocked() {
+	if c.metrics != nil {
+		c.metrics.SetJobs(c.limits.MaxConcurrentJobs, c.running)
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitControllerEnforcesConcurrency(t *testing.T) {
+	controller := NewLimitController()
+	if err := controller.Configure(Limits{MaxConcurrentJobs: 1}, 4); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+
+	release, ok := controller.Acquire("local.execute")
+	if !ok {
+		t.Fatal("expected first job to be admitted")
+	}
+	if _, ok := controller.Acquire("local.execute"); ok {
+		t.Fatal("expected second job to be rejected at limit")
+	}
+
+	// 调低/调高限制只影响新请求，进行中的任务继续占用名额
+	snapshot, err := controller.Set(Limits{MaxConcurrentJobs: 2})
+	if err != nil || snapshot.MaxConcurrentJobs != 2 || snapshot.RunningJobs != 1 || snapshot.MaxConcurrentJobsCeiling != 4 {
+		t.Fatalf("unexpected snapshot: %+v err=%v", snapshot, err)
+	}
+	second, ok := controller.Acquire("local.execute")
+	if !ok {
+		t.Fatal("expected raised limit to admit a new job")
+	}
+	release()
+	release()
+	second()
+	if running := controller.Snapshot().RunningJobs; running != 0 {
+		t.Fatalf("expected released jobs to free slots, got %d running", running)
+	}
+}
+
+func TestLimitControllerRejectsValuesOutsideCeiling(t *testing.T) {
+	controller := NewLimitController()
+	if err := controller.Configure(Limits{}, 8); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	if got := controller.Snapshot().MaxConcurrentJobs; got != 8 {
+		t.Fatalf("expected unset limit to default to ceiling, got %d", got)
+	}
+
+	for _, value := range []int{0, 9, -1} {
+		snapshot, err := controller.Set(Limits{MaxConcurrentJobs: value})
+		if err == nil || snapshot.MaxConcurrentJobs != 8 {
+			t.Fatalf("expected %d to be rejected, got %+v err=%v", value, snapshot, err)
+		}
+	}
+	if err := controller.Configure(Limits{MaxConcurrentJobs: 10}, 8); err == nil || !strings.Contains(err.Error(), "between 1 and 8") {
+		t.Fatalf("expected initial limit above ceiling to be rejected, got %v", err)
+	}
+
+	unlimited := NewLimitController()
+	if _, err := unlimited.Set(Limits{MaxConcurrentJobs: 0}); err != nil {
+		t.Fatalf("expected zero to disable the limit without a ceiling, got %v", err)
+	}
+}
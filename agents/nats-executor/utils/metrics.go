@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// throughputWindowSeconds 为 CompletedRecently 统计的时间窗口（秒）
+const throughputWindowSeconds = 60
+
+var metricsNow = time.Now
+
+// OperationMetrics 按操作类型（NATS 主题前缀，如 local.execute）统计进行中与累计处理的请求数，
+// 以 Prometheus 文本格式输出，便于观察执行器饱和度。
+type OperationMetrics struct {
+	mu       sync.Mutex
+	inflight map[string]int64
+	total    map[string]int64
+	// 按 unix 秒分桶的完成数，覆盖最近 throughputWindowSeconds 秒
+	completed       [throughputWindowSeconds]int64
+	completedSecond [throughputWindowSeconds]int64
+	// 进行中的操作总数；归零时关闭 idle，优雅关闭时据此等待。Drain 期间仍可能有新回调开始，
+	// 因此不用 sync.WaitGroup（计数为零时 Add 与 Wait 并发属于误用）
+	pending int64
+	idle    chan struct{}
+
+	// 因并发限制被拒绝的请求数
+	rejected map[string]int64
+	// 各操作订阅中已收到、尚未开始处理的消息数，抓取时读取
+	queues map[string][]func() int
+	// 并发限制器上报的 max_concurrent_jobs（0 为不限制）与执行中的任务数
+	jobLimit    int64
+	runningJobs int64
+	// 连接池上报的连接数
+	pools map[poolState]int64
+}
+
+type poolState struct {
+	pool, state string
+}
+
+// DefaultMetrics 为进程级指标，由各订阅处理器上报
+var DefaultMetrics = NewOperationMetrics()
+
+func NewOperationMetrics() *OperationMetrics {
+	return &OperationMetrics{
+		inflight: make(map[string]int64),
+		total:    make(map[string]int64),
+		rejected: make(map[string]int64),
+		queues:   make(map[string][]func() int),
+		pools:    make(map[poolState]int64),
+	}
+}
+
+// Begin 记录一次操作开始，返回的函数在操作结束时调用（重复调用无副作用）
+func (m *OperationMetrics) Begin(operation string) func() {
+	m.mu.Lock()
+	m.inflight[operation]++
+	m.total[operation]++
+	if m.pending == 0 {
+		m.idle = make(chan struct{})
+	}
+	m.pending++
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			m.inflight[operation]--
+			m.recordCompletedLocked()
+			m.pending--
+			if m.pending == 0 {
+				close(m.idle)
+			}
+			m.mu.Unlock()
+		})
+	}
+}
+
+// Wait 等待所有进行中的操作结束，超过 timeout 仍未结束时返回 false
+func (m *OperationMetrics) Wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		if m.pending == 0 {
+			m.mu.Unlock()
+			return true
+		}
+		idle := m.idle
+		m.mu.Unlock()
+		select {
+		case <-idle:
+			// 归零后可能又有新操作开始，重新检查计数
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// InFlight 返回指定操作当前进行中的数量
+func (m *OperationMetrics) InFlight(operation string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inflight[operation]
+}
+
+// TotalInFlight 返回所有操作当前进行中的数量之和
+func (m *OperationMetrics) TotalInFlight() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, count := range m.inflight {
+		total += count
+	}
+	return total
+}
+
+// CompletedRecently 返回最近 throughputWindowSeconds 秒内完成的操作数
+func (m *OperationMetrics) CompletedRecently() int64 {
+	now := metricsNow().Unix()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for i, second := range m.completedSecond {
+		if now-second < throughputWindowSeconds {
+			total += m.completed[i]
+		}
+	}
+	return total
+}
+
+// ThroughputWindow 返回 CompletedRecently 的统计窗口
+func (m *OperationMetrics) ThroughputWindow() time.Duration {
+	return throughputWindowSeconds * time.Second
+}
+
+func (m *OperationMetrics) recordCompletedLocked() {
+	now := metricsNow().Unix()
+	slot := int(now % throughputWindowSeconds)
+	if m.completedSecond[slot] != now {
+		m.completedSecond[slot] = now
+		m.completed[slot] = 0
+	}
+	m.completed[slot]++
+}
+
+// Reject 记录一次因并发限制被拒绝的请求
+func (m *OperationMetrics) Reject(operation string) {
+	m.mu.Lock()
+	m.rejected[operation]++
+	m.mu.Unlock()
+}
+
+// TrackQueue 登记操作订阅的待处理消息数来源，同一操作的多个订阅累加
+func (m *OperationMetrics) TrackQueue(operation string, pending func() int) {
+	m.mu.Lock()
+	m.queues[operation] = append(m.queues[operation], pending)
+	m.mu.Unlock()
+}
+
+// SetJobs 更新并发限制与执行中的任务数，由并发限制器在变化时调用
+func (m *OperationMetrics) SetJobs(limit, running int) {
+	m.mu.Lock()
+	m.jobLimit = int64(limit)
+	m.runningJobs = int64(running)
+	m.mu.Unlock()
+}
+
+// SetPoolSize 更新连接池中空闲与借出的连接数，由连接池在变化时调用
+func (m *OperationMetrics) SetPoolSize(pool string, idle, inUse int) {
+	m.mu.Lock()
+	m.pools[poolState{pool, "idle"}] = int64(idle)
+	m.pools[poolState{pool, "in_use"}] = int64(inUse)
+	m.mu.Unlock()
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出指标，标签按操作名排序保证输出稳定
+func (m *OperationMetrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	inflight := copyCounters(m.inflight)
+	total := copyCounters(m.total)
+	rejected := copyCounters(m.rejected)
+	queueSources := make(map[string][]func() int, len(m.queues))
+	for operation, sources := range m.queues {
+		queueSources[operation] = append([]func() int(nil), sources...)
+	}
+	jobLimit, runningJobs := m.jobLimit, m.runningJobs
+	pools := make(map[poolState]int64, len(m.pools))
+	for key, value := range m.pools {
+		pools[key] = value
+	}
+	m.mu.Unlock()
+
+	// 待处理消息数由订阅在锁外读取
+	queued := make(map[string]int64, len(queueSources))
+	for operation, sources := range queueSources {
+		var count int64
+		for _, pending := range sources {
+			if n := pending(); n > 0 {
+				count += int64(n)
+			}
+		}
+		queued[operation] = count
+	}
+
+	if err := writeOperationSeries(w, "nats_executor_inflight_operations", "Operations currently being handled.", "gauge", inflight); err != nil {
+		return err
+	}
+	if err := writeOperationSeries(w, "nats_executor_operations_total", "Operations received since start.", "counter", total); err != nil {
+		return err
+	}
+	if err := writeOperationSeries(w, "nats_executor_queued_operations", "Requests received but not yet being handled.", "gauge", queued); err != nil {
+		return err
+	}
+	if err := writeOperationSeries(w, "nats_executor_rejected_operations_total", "Requests rejected by max_concurrent_jobs since start.", "counter", rejected); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# HELP nats_executor_max_concurrent_jobs Configured max_concurrent_jobs, 0 means unlimited.\n# TYPE nats_executor_max_concurrent_jobs gauge\nnats_executor_max_concurrent_jobs %d\n", jobLimit); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# HELP nats_executor_running_jobs Jobs currently holding a max_concurrent_jobs slot.\n# TYPE nats_executor_running_jobs gauge\nnats_executor_running_jobs %d\n", runningJobs); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "# HELP nats_executor_pool_connections Connections held by a connection pool.\n# TYPE nats_executor_pool_connections gauge\n"); err != nil {
+		return err
+	}
+	keys := make([]poolState, 0, len(pools))
+	for key := range pools {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pool != keys[j].pool {
+			return keys[i].pool < keys[j].pool
+		}
+		return keys[i].state < keys[j].state
+	})
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "nats_executor_pool_connections{pool=%q,state=%q} %d\n", key.pool, key.state, pools[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOperationSeries 输出以 operation 为标签的一组指标
+func writeOperationSeries(w io.Writer, name, help, kind string, values map[string]int64) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind); err != nil {
+		return err
+	}
+	for _, operation := range sortedKeys(values) {
+		if _, err := fmt.Fprintf(w, "%s{operation=%q} %d\n", name, operation, values[operation]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler 返回 /metrics 处理器
+func (m *OperationMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WritePrometheus(w)
+	})
+}
+
+func copyCounters(source map[string]int64) map[string]int64 {
+	copied := make(map[string]int64, len(source))
+	for key, value := range source {
+		copied[key] = value
+	}
+	return copied
+}
+
+func sortedKeys(values map[string]int64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrapeMetrics(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestOperationMetricsEndpointReportsInFlightAndTotals(t *testing.T) {
+	metrics := NewOperationMetrics()
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	doneExecute := metrics.Begin("local.execute")
+	doneSSH := metrics.Begin("ssh.execute")
+
+	body := scrapeMetrics(t, server.URL)
+	for _, want := range []string{
+		"# TYPE nats_executor_inflight_operations gauge",
+		`nats_executor_inflight_operations{operation="local.execute"} 1`,
+		`nats_executor_inflight_operations{operation="ssh.execute"} 1`,
+		`nats_executor_operations_total{operation="local.execute"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+
+	doneExecute()
+	doneExecute()
+	doneSSH()
+	metrics.Begin("local.execute")()
+
+	body = scrapeMetrics(t, server.URL)
+	for _, want := range []string{
+		`nats_executor_inflight_operations{operation="local.execute"} 0`,
+		`nats_executor_inflight_operations{operation="ssh.execute"} 0`,
+		`nats_executor_operations_total{operation="local.execute"} 2`,
+		`nats_executor_operations_total{operation="ssh.execute"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestOperationMetricsEndpointReportsSaturation(t *testing.T) {
+	metrics := NewOperationMetrics()
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+	limits := &LimitController{metrics: metrics}
+	if err := limits.Configure(Limits{MaxConcurrentJobs: 1}, 0); err != nil {
+		t.Fatalf("configure limits: %v", err)
+	}
+	queued := 0
+	metrics.TrackQueue("local.execute", func() int { return queued })
+
+	release, ok := limits.Acquire("local.execute")
+	if !ok {
+		t.Fatal("expected the first job to acquire a slot")
+	}
+	if _, ok := limits.Acquire("ssh.execute"); ok {
+		t.Fatal("expected the second job to be rejected")
+	}
+	queued = 2
+	metrics.SetPoolSize("ssh", 1, 3)
+
+	body := scrapeMetrics(t, server.URL)
+	for _, want := range []string{
+		"# TYPE nats_executor_queued_operations gauge",
+		`nats_executor_queued_operations{operation="local.execute"} 2`,
+		"# TYPE nats_executor_rejected_operations_total counter",
+		`nats_executor_rejected_operations_total{operation="ssh.execute"} 1`,
+		"nats_executor_max_concurrent_jobs 1",
+		"nats_executor_running_jobs 1",
+		`nats_executor_pool_connections{pool="ssh",state="idle"} 1`,
+		`nats_executor_pool_connections{pool="ssh",state="in_use"} 3`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+
+	release()
+	if _, err := limits.Set(Limits{MaxConcurrentJobs: 4}); err != nil {
+		t.Fatalf("set limits: %v", err)
+	}
+	if _, ok := limits.Acquire("ssh.execute"); !ok {
+		t.Fatal("expected a slot after raising the limit")
+	}
+	if _, ok := limits.Acquire("ssh.execute"); !ok {
+		t.Fatal("expected a second slot after raising the limit")
+	}
+	queued = 0
+	metrics.SetPoolSize("ssh", 4, 0)
+
+	body = scrapeMetrics(t, server.URL)
+	for _, want := range []string{
+		`nats_executor_queued_operations{operation="local.execute"} 0`,
+		`nats_executor_rejected_operations_total{operation="ssh.execute"} 1`,
+		"nats_executor_max_concurrent_jobs 4",
+		"nats_executor_running_jobs 2",
+		`nats_executor_pool_connections{pool="ssh",state="idle"} 4`,
+		`nats_executor_pool_connections{pool="ssh",state="in_use"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestOperationMetricsCountsRecentCompletions(t *testing.T) {
+	original := metricsNow
+	defer func() { metricsNow = original }()
+	now := time.Unix(1_700_000_000, 0)
+	metricsNow = func() time.Time { return now }
+
+	metrics := NewOperationMetrics()
+	running := metrics.Begin("ssh.execute")
+	metrics.Begin("local.execute")()
+	metrics.Begin("local.execute")()
+	if metrics.TotalInFlight() != 1 || metrics.CompletedRecently() != 2 {
+		t.Fatalf("unexpected counts: inflight=%d completed=%d", metrics.TotalInFlight(), metrics.CompletedRecently())
+	}
+
+	now = now.Add(30 * time.Second)
+	running()
+	if got := metrics.CompletedRecently(); got != 3 {
+		t.Fatalf("expected 3 completions within the window, got %d", got)
+	}
+	now = now.Add(45 * time.Second)
+	if got := metrics.CompletedRecently(); got != 1 {
+		t.Fatalf("expected completions older than the window to expire, got %d", got)
+	}
+}
+
+func TestOperationMetricsWaitForInFlight(t *testing.T) {
+	metrics := NewOperationMetrics()
+	if !metrics.Wait(time.Millisecond) {
+		t.Fatal("expected wait to return immediately without in-flight operations")
+	}
+
+	done := metrics.Begin("local.execute")
+	if metrics.Wait(20 * time.Millisecond) {
+		t.Fatal("expected wait to time out while an operation is in flight")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done()
+	}()
+	if !metrics.Wait(time.Second) {
+		t.Fatal("expected wait to return once the operation finished")
+	}
+	done()
+}
+
+func TestOperationMetricsWaitSeesOperationsStartedWhileWaiting(t *testing.T) {
+	metrics := NewOperationMetrics()
+	first := metrics.Begin("local.execute")
+	result := make(chan bool, 1)
+	go func() { result <- metrics.Wait(time.Second) }()
+
+	second := metrics.Begin("ssh.execute")
+	first()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-result:
+		t.Fatal("expected wait to keep waiting for the operation started later")
+	default:
+	}
+	second()
+	if !<-result {
+		t.Fatal("expected wait to return once all operations finished")
+	}
+}
@@ -2,8 +2,11 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"nats-executor/jetstream"
 	"nats-executor/logger"
 	"nats-executor/utils/downloaderr"
@@ -15,11 +18,42 @@ import (
 )
 
 type fileDownloader interface {
-	DownloadToFile(ctx context.Context, fileKey, targetPath, fileName string) error
+	DownloadToFile(ctx context.Context, fileKey, targetPath, fileName, expectedSHA256 string) error
+}
+
+type fileUploader interface {
+	UploadFromFile(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error)
+}
+
+type bytesUploader interface {
+	UploadBytes(ctx context.Context, fileKey string, data []byte) (*nats.ObjectInfo, error)
+}
+
+type objectOpener interface {
+	OpenObject(ctx context.Context, fileKey string) (io.ReadCloser, int64, error)
 }
 
 var newJetStreamClient = func(nc *nats.Conn, bucketName string) (fileDownloader, error) {
-	return jetstream.NewJetStreamClient(nc, bucketName)
+	return jetstream.SharedJetStreamClient(nc, bucketName)
+}
+
+var newJetStreamUploader = func(nc *nats.Conn, bucketName string) (fileUploader, error) {
+	return jetstream.SharedJetStreamClient(nc, bucketName)
+}
+
+var newJetStreamBytesUploader = func(nc *nats.Conn, bucketName string) (bytesUploader, error) {
+	return jetstream.SharedJetStreamClient(nc, bucketName)
+}
+
+var newJetStreamOpener = func(nc *nats.Conn, bucketName string) (objectOpener, error) {
+	return jetstream.SharedJetStreamClient(nc, bucketName)
+}
+
+type UploadFileRequest struct {
+	BucketName     string `json:"bucket_name"`
+	FileKey        string `json:"file_key"`
+	SourcePath     string `json:"source_path"`
+	ExecuteTimeout int    `json:"execute_timeout"`
 }
 
 type DownloadFileRequest struct {
@@ -28,6 +62,9 @@ type DownloadFileRequest struct {
 	FileName       string `json:"file_name"`
 	TargetPath     string `json:"target_path"`
 	ExecuteTimeout int    `json:"execute_timeout"`
+	Decompress     bool   `json:"decompress,omitempty"` // 下载后将单文件 .gz/.zst 解压到 target_path 并删除压缩文件
+	// 期望的内容 SHA256（十六进制，可选），在对象自带摘要之外再做一次校验
+	ExpectedSHA256 string `json:"expected_sha256,omitempty"`
 }
 
 func DownloadFile(req DownloadFileRequest, nc *nats.Conn) error {
@@ -37,10 +74,18 @@ func DownloadFile(req DownloadFileRequest, nc *nats.Conn) error {
 	if err := validateDownloadFileName(req.FileName); err != nil {
 		return err
 	}
+	if req.Decompress {
+		if _, err := decompressedName(req.FileName); err != nil {
+			return err
+		}
+	}
 
 	if req.ExecuteTimeout <= 0 {
 		return fmt.Errorf("execute timeout must be greater than 0")
 	}
+	if err := ValidateSHA256(req.ExpectedSHA256); err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.ExecuteTimeout)*time.Second)
 	defer cancel()
@@ -52,7 +97,7 @@ func DownloadFile(req DownloadFileRequest, nc *nats.Conn) error {
 		return fmt.Errorf("failed to create JetStream client: %w", err)
 	}
 
-	if err := client.DownloadToFile(ctx, req.FileKey, req.TargetPath, req.FileName); err != nil {
+	if err := client.DownloadToFile(ctx, req.FileKey, req.TargetPath, req.FileName, req.ExpectedSHA256); err != nil {
 		switch downloaderr.KindOf(err) {
 		case downloaderr.KindTimeout:
 			return downloaderr.New(downloaderr.KindTimeout, fmt.Errorf("download operation timed out: %w", err))
@@ -74,6 +119,127 @@ func DownloadFile(req DownloadFileRequest, nc *nats.Conn) error {
 	}
 
 	logger.Debugf("[DownloadFile] Download completed successfully!")
+
+	if req.Decompress {
+		target, err := DecompressFile(filepath.Join(req.TargetPath, req.FileName))
+		if err != nil {
+			return downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to decompress downloaded file: %w", err))
+		}
+		logger.Debugf("[DownloadFile] Decompressed downloaded file to %s", target)
+	}
+	return nil
+}
+
+// UploadFile 将本地文件上传到对象存储，错误沿用 downloaderr 分类以便调用方映射错误码
+func UploadFile(req UploadFileRequest, nc *nats.Conn) (*nats.ObjectInfo, error) {
+	if strings.TrimSpace(req.BucketName) == "" || strings.TrimSpace(req.FileKey) == "" || strings.TrimSpace(req.SourcePath) == "" {
+		return nil, fmt.Errorf("bucket_name, file_key, and source_path are required")
+	}
+	if req.ExecuteTimeout <= 0 {
+		return nil, fmt.Errorf("execute timeout must be greater than 0")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.ExecuteTimeout)*time.Second)
+	defer cancel()
+
+	logger.Debugf("[UploadFile] Starting upload with source_path: %s, file_key: %s, timeout: %d seconds", req.SourcePath, req.FileKey, req.ExecuteTimeout)
+
+	client, err := newJetStreamUploader(nc, req.BucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream client: %w", err)
+	}
+
+	info, err := client.UploadFromFile(ctx, req.SourcePath, req.FileKey)
+	if err != nil {
+		switch downloaderr.KindOf(err) {
+		case downloaderr.KindTimeout:
+			return nil, downloaderr.New(downloaderr.KindTimeout, fmt.Errorf("upload operation timed out: %w", err))
+		case downloaderr.KindCanceled:
+			return nil, downloaderr.New(downloaderr.KindCanceled, fmt.Errorf("upload operation canceled: %w", err))
+		case downloaderr.KindIO:
+			return nil, downloaderr.New(downloaderr.KindIO, fmt.Errorf("failed to read source file: %w", err))
+		default:
+			return nil, downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to upload file: %w", err))
+		}
+	}
+
+	logger.Debugf("[UploadFile] Upload completed successfully!")
+	return info, nil
+}
+
+// UploadBytes 将内存数据写入对象存储，用于超大响应输出等无本地文件的场景
+func UploadBytes(nc *nats.Conn, bucketName, fileKey string, data []byte, timeout time.Duration) error {
+	if strings.TrimSpace(bucketName) == "" || strings.TrimSpace(fileKey) == "" {
+		return fmt.Errorf("bucket_name and file_key are required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client, err := newJetStreamBytesUploader(nc, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream client: %w", err)
+	}
+	_, err = client.UploadBytes(ctx, fileKey, data)
+	return err
+}
+
+// DownloadBytes 将对象存储中的对象完整读入内存，仅用于转存结果等小对象
+func DownloadBytes(nc *nats.Conn, bucketName, fileKey string, timeout time.Duration) ([]byte, error) {
+	reader, _, err := OpenObjectStream(DownloadFileRequest{BucketName: bucketName, FileKey: fileKey, ExecuteTimeout: int(timeout / time.Second)}, nc)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// objectStream 在关闭时同时释放读取对象所用的超时上下文
+type objectStream struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (s objectStream) Close() error {
+	defer s.cancel()
+	return s.ReadCloser.Close()
+}
+
+// OpenObjectStream 打开对象存储中的对象用于流式读取，不落本地磁盘；
+// 读取受 execute_timeout 约束，返回的对象大小用于校验写入字节数
+func OpenObjectStream(req DownloadFileRequest, nc *nats.Conn) (io.ReadCloser, int64, error) {
+	if strings.TrimSpace(req.BucketName) == "" || strings.TrimSpace(req.FileKey) == "" {
+		return nil, 0, fmt.Errorf("bucket_name and file_key are required")
+	}
+	if req.ExecuteTimeout <= 0 {
+		return nil, 0, fmt.Errorf("execute timeout must be greater than 0")
+	}
+
+	client, err := newJetStreamOpener(nc, req.BucketName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create JetStream client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.ExecuteTimeout)*time.Second)
+	reader, size, err := client.OpenObject(ctx, req.FileKey)
+	if err != nil {
+		cancel()
+		if downloaderr.KindOf(err) == downloaderr.KindUnknown {
+			return nil, 0, downloaderr.New(downloaderr.KindDependency, fmt.Errorf("failed to open object: %w", err))
+		}
+		return nil, 0, err
+	}
+	return objectStream{ReadCloser: reader, cancel: cancel}, size, nil
+}
+
+// ValidateSHA256 校验 expected_sha256 为空或 64 位十六进制字符串
+func ValidateSHA256(value string) error {
+	if value == "" {
+		return nil
+	}
+	if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("expected_sha256 must be a 64-character hex string")
+	}
 	return nil
 }
 
@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"errors"
+	"io"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,10 +16,14 @@ import (
 )
 
 type stubDownloader struct {
-	download func(ctx context.Context, fileKey, targetPath, fileName string) error
+	download       func(ctx context.Context, fileKey, targetPath, fileName string) error
+	expectedSHA256 *string
 }
 
-func (s stubDownloader) DownloadToFile(ctx context.Context, fileKey, targetPath, fileName string) error {
+func (s stubDownloader) DownloadToFile(ctx context.Context, fileKey, targetPath, fileName, expectedSHA256 string) error {
+	if s.expectedSHA256 != nil {
+		*s.expectedSHA256 = expectedSHA256
+	}
 	if s.download == nil {
 		return nil
 	}
@@ -456,3 +461,159 @@ func BenchmarkDownloadFile(b *testing.B) {
 		}
 	}
 }
+
+type stubUploader struct {
+	upload func(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error)
+}
+
+func (s stubUploader) UploadFromFile(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error) {
+	if s.upload == nil {
+		return &nats.ObjectInfo{ObjectMeta: nats.ObjectMeta{Name: fileKey}}, nil
+	}
+	return s.upload(ctx, sourcePath, fileKey)
+}
+
+func withStubUploader(tb testing.TB, factory func(nc *nats.Conn, bucketName string) (fileUploader, error)) {
+	tb.Helper()
+	original := newJetStreamUploader
+	newJetStreamUploader = factory
+	tb.Cleanup(func() {
+		newJetStreamUploader = original
+	})
+}
+
+func TestUploadFileValidatesRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		req  UploadFileRequest
+		want string
+	}{
+		{name: "missing bucket", req: UploadFileRequest{FileKey: "key", SourcePath: "/tmp/a", ExecuteTimeout: 1}, want: "required"},
+		{name: "missing key", req: UploadFileRequest{BucketName: "bucket", SourcePath: "/tmp/a", ExecuteTimeout: 1}, want: "required"},
+		{name: "missing source", req: UploadFileRequest{BucketName: "bucket", FileKey: "key", ExecuteTimeout: 1}, want: "required"},
+		{name: "invalid timeout", req: UploadFileRequest{BucketName: "bucket", FileKey: "key", SourcePath: "/tmp/a"}, want: "execute timeout must be greater than 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UploadFile(tt.req, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadFileDelegatesAndClassifiesErrors(t *testing.T) {
+	req := UploadFileRequest{BucketName: "bucket", FileKey: "key", SourcePath: "/tmp/a", ExecuteTimeout: 1}
+
+	withStubUploader(t, func(nc *nats.Conn, bucketName string) (fileUploader, error) {
+		if bucketName != "bucket" {
+			t.Fatalf("unexpected bucket: %s", bucketName)
+		}
+		return stubUploader{upload: func(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Fatal("expected upload context deadline")
+			}
+			return &nats.ObjectInfo{ObjectMeta: nats.ObjectMeta{Name: fileKey}, Size: 3}, nil
+		}}, nil
+	})
+	info, err := UploadFile(req, nil)
+	if err != nil || info.Name != "key" || info.Size != 3 {
+		t.Fatalf("unexpected upload result: info=%+v err=%v", info, err)
+	}
+
+	withStubUploader(t, func(nc *nats.Conn, bucketName string) (fileUploader, error) {
+		return stubUploader{upload: func(ctx context.Context, sourcePath, fileKey string) (*nats.ObjectInfo, error) {
+			return nil, downloaderr.New(downloaderr.KindTimeout, context.DeadlineExceeded)
+		}}, nil
+	})
+	if _, err := UploadFile(req, nil); downloaderr.KindOf(err) != downloaderr.KindTimeout {
+		t.Fatalf("expected timeout kind, got %v", err)
+	}
+
+	withStubUploader(t, func(nc *nats.Conn, bucketName string) (fileUploader, error) {
+		return nil, errors.New("jetstream unavailable")
+	})
+	if _, err := UploadFile(req, nil); err == nil || !strings.Contains(err.Error(), "failed to create JetStream client") {
+		t.Fatalf("unexpected factory error: %v", err)
+	}
+}
+
+type stubOpener struct {
+	open func(ctx context.Context, fileKey string) (io.ReadCloser, int64, error)
+}
+
+func (s stubOpener) OpenObject(ctx context.Context, fileKey string) (io.ReadCloser, int64, error) {
+	return s.open(ctx, fileKey)
+}
+
+func withStubOpener(tb testing.TB, factory func(nc *nats.Conn, bucketName string) (objectOpener, error)) {
+	tb.Helper()
+	original := newJetStreamOpener
+	newJetStreamOpener = factory
+	tb.Cleanup(func() {
+		newJetStreamOpener = original
+	})
+}
+
+func TestOpenObjectStreamKeepsContextUntilClose(t *testing.T) {
+	var openedCtx context.Context
+	withStubOpener(t, func(nc *nats.Conn, bucketName string) (objectOpener, error) {
+		return stubOpener{open: func(ctx context.Context, fileKey string) (io.ReadCloser, int64, error) {
+			openedCtx = ctx
+			return io.NopCloser(strings.NewReader("abc")), 3, nil
+		}}, nil
+	})
+
+	reader, size, err := OpenObjectStream(DownloadFileRequest{BucketName: "bucket", FileKey: "key", ExecuteTimeout: 5}, nil)
+	if err != nil || size != 3 {
+		t.Fatalf("unexpected open result: size=%d err=%v", size, err)
+	}
+	if openedCtx.Err() != nil {
+		t.Fatal("expected context to stay alive while streaming")
+	}
+	_ = reader.Close()
+	if openedCtx.Err() == nil {
+		t.Fatal("expected close to release the context")
+	}
+}
+
+func TestOpenObjectStreamValidatesAndClassifiesErrors(t *testing.T) {
+	if _, _, err := OpenObjectStream(DownloadFileRequest{BucketName: "bucket", ExecuteTimeout: 5}, nil); err == nil || !strings.Contains(err.Error(), "required") {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if _, _, err := OpenObjectStream(DownloadFileRequest{BucketName: "bucket", FileKey: "key"}, nil); err == nil || !strings.Contains(err.Error(), "execute timeout") {
+		t.Fatalf("unexpected timeout validation error: %v", err)
+	}
+
+	withStubOpener(t, func(nc *nats.Conn, bucketName string) (objectOpener, error) {
+		return stubOpener{open: func(ctx context.Context, fileKey string) (io.ReadCloser, int64, error) {
+			return nil, 0, errors.New("boom")
+		}}, nil
+	})
+	if _, _, err := OpenObjectStream(DownloadFileRequest{BucketName: "bucket", FileKey: "key", ExecuteTimeout: 5}, nil); downloaderr.KindOf(err) != downloaderr.KindDependency {
+		t.Fatalf("expected dependency kind, got %v", err)
+	}
+}
+
+func TestDownloadFilePassesExpectedSHA256(t *testing.T) {
+	expected := strings.Repeat("ab", 32)
+	var received string
+	withStubDownloader(t, func(nc *nats.Conn, bucketName string) (fileDownloader, error) {
+		return stubDownloader{expectedSHA256: &received}, nil
+	})
+
+	request := DownloadFileRequest{BucketName: "bucket", FileKey: "key", FileName: "file.txt", TargetPath: t.TempDir(), ExecuteTimeout: 1, ExpectedSHA256: expected}
+	if err := DownloadFile(request, nil); err != nil {
+		t.Fatalf("expected download to succeed, got %v", err)
+	}
+	if received != expected {
+		t.Fatalf("expected sha256 %q to reach the downloader, got %q", expected, received)
+	}
+
+	request.ExpectedSHA256 = "not-a-digest"
+	if err := DownloadFile(request, nil); err == nil || !strings.Contains(err.Error(), "expected_sha256 must be a 64-character hex string") {
+		t.Fatalf("expected invalid sha256 error, got %v", err)
+	}
+}
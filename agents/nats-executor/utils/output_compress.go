@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// OutputEncodingGzipBase64 标记响应中的输出字段为 gzip 压缩后再 base64 编码的文本
+const OutputEncodingGzipBase64 = "gzip+base64"
+
+// ShouldCompressOutput 判断输出是否超过 compress_output_threshold，阈值为 0 时不压缩
+func ShouldCompressOutput(output string) bool {
+	threshold := currentResponseSizeConfig().CompressThreshold
+	return threshold > 0 && len(output) > threshold
+}
+
+// EncodeOutput 将输出 gzip 压缩并 base64 编码，空字符串保持为空
+func EncodeOutput(output string) string {
+	if output == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, _ = writer.Write([]byte(output))
+	_ = writer.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// DecodeOutput 按 output_encoding 还原输出字段，encoding 为空时原样返回
+func DecodeOutput(value, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return value, nil
+	case OutputEncodingGzipBase64:
+		if value == "" {
+			return "", nil
+		}
+		compressed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", fmt.Errorf("decode base64 output: %w", err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return "", fmt.Errorf("open gzip output: %w", err)
+		}
+		defer reader.Close()
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("read gzip output: %w", err)
+		}
+		return string(decoded), nil
+	default:
+		return "", fmt.Errorf("unsupported output_encoding: %s", encoding)
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShouldCompressOutputUsesThreshold(t *testing.T) {
+	SetResponseSizeConfig(ResponseSizeConfig{CompressThreshold: 100})
+	defer SetResponseSizeConfig(ResponseSizeConfig{})
+
+	cases := map[int]bool{99: false, 100: false, 101: true}
+	for size, want := range cases {
+		if got := ShouldCompressOutput(strings.Repeat("x", size)); got != want {
+			t.Fatalf("size %d: expected %v, got %v", size, want, got)
+		}
+	}
+
+	SetResponseSizeConfig(ResponseSizeConfig{})
+	if ShouldCompressOutput(strings.Repeat("x", 1<<20)) {
+		t.Fatal("expected compression to be disabled without a threshold")
+	}
+}
+
+func TestEncodeOutputRoundTrip(t *testing.T) {
+	output := strings.Repeat("line 中文\n", 200)
+	encoded := EncodeOutput(output)
+	if len(encoded) >= len(output) {
+		t.Fatalf("expected repetitive output to shrink, got %d >= %d", len(encoded), len(output))
+	}
+	decoded, err := DecodeOutput(encoded, OutputEncodingGzipBase64)
+	if err != nil || decoded != output {
+		t.Fatalf("round trip failed: err=%v equal=%v", err, decoded == output)
+	}
+	if EncodeOutput("") != "" {
+		t.Fatal("expected empty output to stay empty")
+	}
+	if plain, err := DecodeOutput("plain", ""); err != nil || plain != "plain" {
+		t.Fatalf("expected plain output unchanged, got %q err=%v", plain, err)
+	}
+	if _, err := DecodeOutput("plain", "zstd"); err == nil {
+		t.Fatal("expected unsupported encoding to fail")
+	}
+	if _, err := DecodeOutput("not base64!", OutputEncodingGzipBase64); err == nil {
+		t.Fatal("expected invalid base64 to fail")
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// OutputFilter 按正则筛选输出行，用于只关心部分行（如错误）的冗长命令
+type OutputFilter struct {
+	pattern *regexp.Regexp
+	invert  bool
+}
+
+// NewOutputFilter 编译 output_grep，pattern 为空时返回 nil 表示不过滤
+func NewOutputFilter(pattern string, invert bool) (*OutputFilter, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output_grep: %v", err)
+	}
+	return &OutputFilter{pattern: compiled, invert: invert}, nil
+}
+
+// Apply 返回匹配的行（invert 时返回不匹配的行），保留原有换行
+func (f *OutputFilter) Apply(output string) string {
+	if f == nil || output == "" {
+		return output
+	}
+	var kept strings.Builder
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if f.pattern.MatchString(strings.TrimRight(line, "\r\n")) != f.invert {
+			kept.WriteString(line)
+		}
+	}
+	return kept.String()
+}
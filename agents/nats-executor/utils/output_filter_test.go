@@ -0,0 +1,36 @@
+package utils
+
+import "testing"
+
+func TestOutputFilterApply(t *testing.T) {
+	output := "INFO starting\nERROR disk full\nINFO retry\nERROR quota exceeded"
+
+	match, err := NewOutputFilter("^ERROR", false)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if got := match.Apply(output); got != "ERROR disk full\nERROR quota exceeded" {
+		t.Fatalf("unexpected match output: %q", got)
+	}
+
+	invert, err := NewOutputFilter("^ERROR", true)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if got := invert.Apply(output); got != "INFO starting\nINFO retry\n" {
+		t.Fatalf("unexpected inverted output: %q", got)
+	}
+}
+
+func TestOutputFilterHandlesEmptyAndInvalidPatterns(t *testing.T) {
+	filter, err := NewOutputFilter("", false)
+	if err != nil || filter != nil {
+		t.Fatalf("expected no filter for empty pattern, got %v err=%v", filter, err)
+	}
+	if got := filter.Apply("kept\n"); got != "kept\n" {
+		t.Fatalf("expected nil filter to keep output, got %q", got)
+	}
+	if _, err := NewOutputFilter("([", false); err == nil {
+		t.Fatal("expected invalid pattern to fail")
+	}
+}
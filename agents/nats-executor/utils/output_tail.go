@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultTailLines 为每个执行中任务默认保留的最近输出行数
+	DefaultTailLines = 200
+	// maxTailLineBytes 为单行保留的最大字节数，超长行（如进度条）只保留开头部分
+	maxTailLineBytes = 4096
+)
+
+// OutputTail 为单个任务最近输出行的环形缓冲区，stdout 与 stderr 各自通过 Writer 写入
+type OutputTail struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newOutputTail(capacity int) *OutputTail {
+	return &OutputTail{lines: make([]string, capacity)}
+}
+
+func (t *OutputTail) append(line string) {
+	t.mu.Lock()
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+	t.mu.Unlock()
+}
+
+// Last 返回最近的 n 行（按时间顺序），n <= 0 或超过缓冲区时返回全部
+func (t *OutputTail) Last(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	count := t.next
+	if t.full {
+		count = len(t.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+	result := make([]string, 0, n)
+	for i := count - n; i < count; i++ {
+		index := i
+		if t.full {
+			index = (t.next + i) % len(t.lines)
+		}
+		result = append(result, t.lines[index])
+	}
+	return result
+}
+
+// Writer 返回按行写入缓冲区的 writer，每个输出流使用独立的 writer 以免半行交错
+func (t *OutputTail) Writer() io.Writer {
+	return &tailLineWriter{tail: t}
+}
+
+type tailLineWriter struct {
+	tail    *OutputTail
+	partial []byte
+}
+
+func (w *tailLineWriter) Write(p []byte) (int, error) {
+	data := p
+	for {
+		index := bytes.IndexByte(data, '\n')
+		if index < 0 {
+			break
+		}
+		w.partial = appendTailBytes(w.partial, data[:index])
+		w.tail.append(strings.TrimRight(string(w.partial), "\r"))
+		w.partial = w.partial[:0]
+		data = data[index+1:]
+	}
+	w.partial = appendTailBytes(w.partial, data)
+	return len(p), nil
+}
+
+func appendTailBytes(line, data []byte) []byte {
+	if room := maxTailLineBytes - len(line); room < len(data) {
+		data = data[:max(room, 0)]
+	}
+	return append(line, data...)
+}
+
+// TailRegistry 按任务 ID 保存执行中任务的输出缓冲区，任务结束后移除
+type TailRegistry struct {
+	mu       sync.Mutex
+	capacity int
+	tails    map[string]*OutputTail
+}
+
+// DefaultTails 为进程级输出缓冲区登记表，供 tail.<job_id> 查询
+var DefaultTails = NewTailRegistry(DefaultTailLines)
+
+func NewTailRegistry(capacity int) *TailRegistry {
+	r := &TailRegistry{tails: make(map[string]*OutputTail)}
+	r.SetCapacity(capacity)
+	return r
+}
+
+// SetCapacity 设置之后新任务保留的行数，<=0 时使用 DefaultTailLines
+func (r *TailRegistry) SetCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultTailLines
+	}
+	r.mu.Lock()
+	r.capacity = capacity
+	r.mu.Unlock()
+}
+
+// Open 为任务创建输出缓冲区，返回的函数在任务结束时调用；jobID 为空时返回 nil
+func (r *TailRegistry) Open(jobID string) (*OutputTail, func()) {
+	if jobID == "" {
+		return nil, func() {}
+	}
+	r.mu.Lock()
+	tail := newOutputTail(r.capacity)
+	r.tails[jobID] = tail
+	r.mu.Unlock()
+
+	var once sync.Once
+	return tail, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			// 同 ID 的新任务已替换缓冲区时保留新任务的缓冲区
+			if r.tails[jobID] == tail {
+				delete(r.tails, jobID)
+			}
+			r.mu.Unlock()
+		})
+	}
+}
+
+// Last 返回执行中任务最近的 n 行输出，任务不在本实例执行时返回 false
+func (r *TailRegistry) Last(jobID string, n int) ([]string, bool) {
+	r.mu.Lock()
+	tail, ok := r.tails[jobID]
+	r.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return tail.Last(n), true
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputTailKeepsLastLines(t *testing.T) {
+	registry := NewTailRegistry(3)
+	tail, closeTail := registry.Open("job-1")
+	stdout := tail.Writer()
+	stderr := tail.Writer()
+
+	_, _ = io.WriteString(stdout, "one\ntw")
+	_, _ = io.WriteString(stderr, "err-1\r\n")
+	_, _ = io.WriteString(stdout, "o\nthree\nfour\npartial")
+
+	if got := tail.Last(0); !reflect.DeepEqual(got, []string{"two", "three", "four"}) {
+		t.Fatalf("unexpected buffered lines: %q", got)
+	}
+	if got, ok := registry.Last("job-1", 2); !ok || !reflect.DeepEqual(got, []string{"three", "four"}) {
+		t.Fatalf("unexpected last 2 lines: %q ok=%v", got, ok)
+	}
+
+	closeTail()
+	if _, ok := registry.Last("job-1", 1); ok {
+		t.Fatal("expected finished job to be removed")
+	}
+}
+
+func TestOutputTailBeforeWrapAndLongLines(t *testing.T) {
+	registry := NewTailRegistry(0)
+	tail, closeTail := registry.Open("job-2")
+	defer closeTail()
+	if len(tail.lines) != DefaultTailLines {
+		t.Fatalf("expected default capacity, got %d", len(tail.lines))
+	}
+
+	_, _ = io.WriteString(tail.Writer(), "a\n"+strings.Repeat("x", maxTailLineBytes+100)+"\n")
+	got := tail.Last(10)
+	if len(got) != 2 || got[0] != "a" || len(got[1]) != maxTailLineBytes {
+		t.Fatalf("unexpected lines: %d %v", len(got), len(got) > 0 && got[0] == "a")
+	}
+}
+
+func TestTailRegistryKeepsReplacementBuffer(t *testing.T) {
+	registry := NewTailRegistry(2)
+	if tail, _ := registry.Open(""); tail != nil {
+		t.Fatal("expected no buffer without a job id")
+	}
+	_, closeFirst := registry.Open("job-3")
+	second, closeSecond := registry.Open("job-3")
+	_, _ = io.WriteString(second.Writer(), "retry\n")
+
+	closeFirst()
+	if got, ok := registry.Last("job-3", 0); !ok || !reflect.DeepEqual(got, []string{"retry"}) {
+		t.Fatalf("expected replacement buffer to survive, got %q ok=%v", got, ok)
+	}
+	closeSecond()
+	if _, ok := registry.Last("job-3", 0); ok {
+		t.Fatal("expected buffer to be removed")
+	}
+}
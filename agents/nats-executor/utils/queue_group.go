@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	queueGroupMu sync.RWMutex
+	queueGroup   string
+)
+
+// SetQueueGroup 设置执行与传输类主题使用的 queue group，为空时使用普通订阅
+func SetQueueGroup(group string) {
+	queueGroupMu.Lock()
+	queueGroup = group
+	queueGroupMu.Unlock()
+}
+
+// QueueGroup 返回当前配置的 queue group
+func QueueGroup() string {
+	queueGroupMu.RLock()
+	defer queueGroupMu.RUnlock()
+	return queueGroup
+}
+
+// QueueSubscriber 在配置了 queue group 时以 QueueSubscribe 订阅，同组实例中每条消息只由一个实例处理；
+// 未配置时与 nc.Subscribe 相同。查询、取消等需要每个实例都收到的主题不应使用
+type QueueSubscriber struct {
+	Conn *nats.Conn
+}
+
+func (s QueueSubscriber) Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	// 回调串行执行，处理中时新消息在订阅内排队；Pending 含正在处理的消息，需要扣除
+	var handling atomic.Int32
+	handler := func(msg *nats.Msg) {
+		handling.Add(1)
+		defer handling.Add(-1)
+		cb(msg)
+	}
+	var sub *nats.Subscription
+	var err error
+	if group := QueueGroup(); group != "" {
+		sub, err = s.Conn.QueueSubscribe(subject, group, handler)
+	} else {
+		sub, err = s.Conn.Subscribe(subject, handler)
+	}
+	if err != nil {
+		return sub, err
+	}
+	DefaultMetrics.TrackQueue(subjectOperation(subject), func() int {
+		pending, _, _ := sub.Pending()
+		return pending - int(handling.Load())
+	})
+	return sub, nil
+}
+
+// subjectOperation 去掉主题末尾的实例 ID，得到 local.execute 等操作名
+func subjectOperation(subject string) string {
+	if i := strings.LastIndex(subject, "."); i > 0 {
+		return subject[:i]
+	}
+	return subject
+}
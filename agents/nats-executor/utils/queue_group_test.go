@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func startQueueGroupTestNATS(t *testing.T) string {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("start nats server: %v", err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	return ns.ClientURL()
+}
+
+// countDeliveries 让两个实例以 QueueSubscriber 订阅同一主题，返回发布 messages 条消息后两者共收到的条数
+func countDeliveries(t *testing.T, url string, messages int) int32 {
+	t.Helper()
+	var delivered int32
+	for i := 0; i < 2; i++ {
+		nc, err := nats.Connect(url)
+		if err != nil {
+			t.Fatalf("connect nats: %v", err)
+		}
+		t.Cleanup(nc.Close)
+		if _, err := (QueueSubscriber{Conn: nc}).Subscribe("local.execute.shared", func(msg *nats.Msg) {
+			atomic.AddInt32(&delivered, 1)
+		}); err != nil {
+			t.Fatalf("subscribe: %v", err)
+		}
+		if err := nc.Flush(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+
+	publisher, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("connect publisher: %v", err)
+	}
+	defer publisher.Close()
+	for i := 0; i < messages; i++ {
+		if err := publisher.Publish("local.execute.shared", []byte("{}")); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	if err := publisher.Flush(); err != nil {
+		t.Fatalf("flush publisher: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	return atomic.LoadInt32(&delivered)
+}
+
+func TestQueueSubscriberDeliversEachMessageToOneGroupMember(t *testing.T) {
+	SetQueueGroup("executors")
+	defer SetQueueGroup("")
+
+	if delivered := countDeliveries(t, startQueueGroupTestNATS(t), 20); delivered != 20 {
+		t.Fatalf("expected each message to be handled once within the group, got %d deliveries", delivered)
+	}
+}
+
+func TestQueueSubscriberWithoutGroupDeliversToEveryInstance(t *testing.T) {
+	SetQueueGroup("")
+
+	if delivered := countDeliveries(t, startQueueGroupTestNATS(t), 20); delivered != 40 {
+		t.Fatalf("expected plain subscriptions to receive every message, got %d deliveries", delivered)
+	}
+}
+
+func TestQueueSubscriberReportsQueuedMessages(t *testing.T) {
+	nc, err := nats.Connect(startQueueGroupTestNATS(t))
+	if err != nil {
+		t.Fatalf("connect nats: %v", err)
+	}
+	defer nc.Close()
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	if _, err := (QueueSubscriber{Conn: nc}).Subscribe("unzip.local.queued", func(msg *nats.Msg) {
+		started <- struct{}{}
+		<-release
+	}); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := nc.Publish("unzip.local.queued", []byte("{}")); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	<-started
+
+	waitQueued := func(want string) {
+		t.Helper()
+		var out strings.Builder
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			out.Reset()
+			if err := DefaultMetrics.WritePrometheus(&out); err != nil {
+				t.Fatalf("write metrics: %v", err)
+			}
+			if strings.Contains(out.String(), want) {
+				return
+			}
+		}
+		t.Fatalf("expected %q in metrics output:\n%s", want, out.String())
+	}
+	// 一条在处理中，其余两条在订阅内排队
+	waitQueued(`nats_executor_queued_operations{operation="unzip.local"} 2`)
+	close(release)
+	waitQueued(`nats_executor_queued_operations{operation="unzip.local"} 0`)
+}
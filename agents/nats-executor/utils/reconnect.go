@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	reconnectMu        sync.Mutex
+	reconnectCallbacks []func(*nats.Conn)
+)
+
+// OnReconnect 注册 NATS 重连成功后的回调，用于重建依赖连接状态的缓存或补发数据
+func OnReconnect(fn func(*nats.Conn)) {
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+	reconnectCallbacks = append(reconnectCallbacks, fn)
+}
+
+// NotifyReconnect 按注册顺序执行重连回调，由连接的 ReconnectHandler 调用
+func NotifyReconnect(nc *nats.Conn) {
+	reconnectMu.Lock()
+	callbacks := append([]func(*nats.Conn){}, reconnectCallbacks...)
+	reconnectMu.Unlock()
+	for _, fn := range callbacks {
+		fn(nc)
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNotifyReconnectRunsCallbacksInOrder(t *testing.T) {
+	original := reconnectCallbacks
+	reconnectCallbacks = nil
+	defer func() { reconnectCallbacks = original }()
+
+	var calls []string
+	OnReconnect(func(nc *nats.Conn) { calls = append(calls, "first") })
+	OnReconnect(func(nc *nats.Conn) { calls = append(calls, "second") })
+	NotifyReconnect(nil)
+
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Fatalf("unexpected callback order: %v", calls)
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"nats-executor/logger"
+)
+
+const (
+	// ResponseSizePolicyTruncate 截断输出使响应不超过上限（默认）
+	ResponseSizePolicyTruncate = "truncate"
+	// ResponseSizePolicyOffload 将完整输出写入对象存储，响应只携带对象 key
+	ResponseSizePolicyOffload = "offload"
+
+	responseTruncatedMarker = "\n...[output truncated: response exceeded max_response_bytes]"
+	maxTruncateAttempts     = 8
+)
+
+// ResponseSizeConfig 为执行器单条响应的大小上限及超限处理方式
+type ResponseSizeConfig struct {
+	MaxBytes      int    // <=0 表示不限制
+	Policy        string // truncate / offload
+	OffloadBucket string
+	// Offload 写入对象存储，由启动流程基于 NATS 连接注入
+	Offload func(bucket, key string, data []byte) error
+	// CompressThreshold 输出超过该字节数时以 gzip+base64 返回，<=0 表示不压缩
+	CompressThreshold int
+}
+
+var (
+	responseSizeMu     sync.RWMutex
+	responseSizeConfig ResponseSizeConfig
+	responseOffloadNow = time.Now
+)
+
+// IsKnownResponseSizePolicy 校验配置中的超限处理方式，空值按 truncate 处理
+func IsKnownResponseSizePolicy(policy string) bool {
+	switch policy {
+	case "", ResponseSizePolicyTruncate, ResponseSizePolicyOffload:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetResponseSizeConfig 设置进程级响应大小上限
+func SetResponseSizeConfig(cfg ResponseSizeConfig) {
+	responseSizeMu.Lock()
+	responseSizeConfig = cfg
+	responseSizeMu.Unlock()
+}
+
+func currentResponseSizeConfig() ResponseSizeConfig {
+	responseSizeMu.RLock()
+	defer responseSizeMu.RUnlock()
+	return responseSizeConfig
+}
+
+// ExceedsResponseLimit 判断序列化后的响应是否超过 max_response_bytes
+func ExceedsResponseLimit(size int) bool {
+	cfg := currentResponseSizeConfig()
+	return cfg.MaxBytes > 0 && size > cfg.MaxBytes
+}
+
+// FitResponse 在序列化后的响应超过上限时按策略改写输出，避免 Respond 因超过 max_payload 静默失败；
+// rebuild 以新的输出、对象 key 与截断标记重新序列化响应。offload 失败时回退为截断。
+func FitResponse(operation, instanceId string, payload []byte, output string, rebuild func(output, objectKey string, truncated bool) ([]byte, error)) []byte {
+	cfg := currentResponseSizeConfig()
+	if cfg.MaxBytes <= 0 || len(payload) <= cfg.MaxBytes {
+		return payload
+	}
+	logger.Warnf("[%s] Instance: %s, Response size %dB exceeds max_response_bytes=%dB, applying %s policy", operation, instanceId, len(payload), cfg.MaxBytes, policyOrDefault(cfg.Policy))
+
+	if cfg.Policy == ResponseSizePolicyOffload {
+		if fitted, ok := offloadResponse(cfg, operation, instanceId, output, rebuild); ok {
+			return fitted
+		}
+	}
+	return truncateResponse(cfg, operation, instanceId, payload, output, rebuild)
+}
+
+func policyOrDefault(policy string) string {
+	if policy == "" {
+		return ResponseSizePolicyTruncate
+	}
+	return policy
+}
+
+func offloadResponse(cfg ResponseSizeConfig, operation, instanceId, output string, rebuild func(string, string, bool) ([]byte, error)) ([]byte, bool) {
+	if cfg.Offload == nil || cfg.OffloadBucket == "" {
+		logger.Warnf("[%s] Instance: %s, Response offload is not configured, falling back to truncate", operation, instanceId)
+		return nil, false
+	}
+	key := fmt.Sprintf("responses/%s/%d", instanceId, responseOffloadNow().UnixNano())
+	if err := cfg.Offload(cfg.OffloadBucket, key, []byte(output)); err != nil {
+		logger.Warnf("[%s] Instance: %s, Failed to offload response output to %s/%s, falling back to truncate: %v", operation, instanceId, cfg.OffloadBucket, key, err)
+		return nil, false
+	}
+	fitted, err := rebuild(fmt.Sprintf("output (%dB) offloaded to object store %s/%s", len(output), cfg.OffloadBucket, key), key, false)
+	if err != nil || len(fitted) > cfg.MaxBytes {
+		logger.Warnf("[%s] Instance: %s, Offloaded response still exceeds limit, falling back to truncate", operation, instanceId)
+		return nil, false
+	}
+	logger.Infof("[%s] Instance: %s, Response output offloaded to %s/%s", operation, instanceId, cfg.OffloadBucket, key)
+	return fitted, true
+}
+
+func truncateResponse(cfg ResponseSizeConfig, operation, instanceId string, payload []byte, output string, rebuild func(string, string, bool) ([]byte, error)) []byte {
+	kept := output
+	for attempt := 0; attempt < maxTruncateAttempts && len(payload) > cfg.MaxBytes; attempt++ {
+		// JSON 转义会放大输出，按响应实际大小与上限的比例反复收缩
+		keep := (len(kept)+len(responseTruncatedMarker))*cfg.MaxBytes/len(payload) - len(responseTruncatedMarker)
+		if keep < 0 {
+			keep = 0
+		}
+		for keep > 0 && !utf8.RuneStart(kept[keep]) {
+			keep--
+		}
+		kept = kept[:keep]
+		fitted, err := rebuild(kept+responseTruncatedMarker, "", true)
+		if err != nil {
+			break
+		}
+		payload = fitted
+	}
+	if len(payload) > cfg.MaxBytes {
+		logger.Errorf("[%s] Instance: %s, Response still %dB after truncating output, exceeds max_response_bytes=%dB", operation, instanceId, len(payload), cfg.MaxBytes)
+	}
+	return payload
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sizedResponse struct {
+	Output          string `json:"result"`
+	Truncated       bool   `json:"truncated,omitempty"`
+	OutputObjectKey string `json:"output_object_key,omitempty"`
+}
+
+func withResponseSizeConfig(t *testing.T, cfg ResponseSizeConfig) {
+	t.Helper()
+	original := currentResponseSizeConfig()
+	SetResponseSizeConfig(cfg)
+	t.Cleanup(func() { SetResponseSizeConfig(original) })
+}
+
+func fitSizedResponse(t *testing.T, output string) (sizedResponse, []byte) {
+	t.Helper()
+	response := sizedResponse{Output: output}
+	payload, _ := json.Marshal(response)
+	fitted := FitResponse("Test", "instance-1", payload, output, func(output, objectKey string, truncated bool) ([]byte, error) {
+		response.Output, response.OutputObjectKey, response.Truncated = output, objectKey, truncated
+		return json.Marshal(response)
+	})
+	var decoded sizedResponse
+	if err := json.Unmarshal(fitted, &decoded); err != nil {
+		t.Fatalf("unmarshal fitted response: %v", err)
+	}
+	return decoded, fitted
+}
+
+func TestFitResponseKeepsSmallResponses(t *testing.T) {
+	withResponseSizeConfig(t, ResponseSizeConfig{MaxBytes: 1024})
+
+	decoded, _ := fitSizedResponse(t, "ok")
+	if decoded.Output != "ok" || decoded.Truncated {
+		t.Fatalf("unexpected response: %+v", decoded)
+	}
+}
+
+func TestFitResponseTruncatesToLimit(t *testing.T) {
+	withResponseSizeConfig(t, ResponseSizeConfig{MaxBytes: 256})
+
+	// 引号与中文在 JSON 中会被放大或占多字节，需保证截断后仍合法且不超过上限
+	decoded, fitted := fitSizedResponse(t, strings.Repeat(`"输出"`, 200))
+	if len(fitted) > 256 {
+		t.Fatalf("expected response within limit, got %d bytes", len(fitted))
+	}
+	if !decoded.Truncated || !strings.HasSuffix(decoded.Output, responseTruncatedMarker) || !strings.HasPrefix(decoded.Output, `"输出"`) {
+		t.Fatalf("unexpected truncated response: %+v", decoded)
+	}
+}
+
+func TestFitResponseOffloadsOutput(t *testing.T) {
+	var stored []byte
+	var storedKey string
+	withResponseSizeConfig(t, ResponseSizeConfig{
+		MaxBytes:      256,
+		Policy:        ResponseSizePolicyOffload,
+		OffloadBucket: "responses",
+		Offload: func(bucket, key string, data []byte) error {
+			storedKey, stored = key, data
+			return nil
+		},
+	})
+	original := responseOffloadNow
+	responseOffloadNow = func() time.Time { return time.Unix(0, 42) }
+	defer func() { responseOffloadNow = original }()
+
+	output := strings.Repeat("x", 1024)
+	decoded, _ := fitSizedResponse(t, output)
+	if string(stored) != output || storedKey != "responses/instance-1/42" {
+		t.Fatalf("unexpected offload: key=%s size=%d", storedKey, len(stored))
+	}
+	if decoded.OutputObjectKey != storedKey || decoded.Truncated || !strings.Contains(decoded.Output, "responses/responses/instance-1/42") {
+		t.Fatalf("unexpected offloaded response: %+v", decoded)
+	}
+}
+
+func TestFitResponseFallsBackToTruncateWhenOffloadFails(t *testing.T) {
+	withResponseSizeConfig(t, ResponseSizeConfig{
+		MaxBytes:      256,
+		Policy:        ResponseSizePolicyOffload,
+		OffloadBucket: "responses",
+		Offload:       func(string, string, []byte) error { return errors.New("bucket not found") },
+	})
+
+	decoded, fitted := fitSizedResponse(t, strings.Repeat("x", 1024))
+	if len(fitted) > 256 || !decoded.Truncated || decoded.OutputObjectKey != "" {
+		t.Fatalf("expected truncate fallback, got %+v", decoded)
+	}
+}
+
+func TestIsKnownResponseSizePolicy(t *testing.T) {
+	for _, policy := range []string{"", ResponseSizePolicyTruncate, ResponseSizePolicyOffload} {
+		if !IsKnownResponseSizePolicy(policy) {
+			t.Fatalf("expected %q to be accepted", policy)
+		}
+	}
+	if IsKnownResponseSizePolicy("drop") {
+		t.Fatal("expected unknown policy to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultResultCacheTTL 结果缓存保留时长，覆盖 NATS 瞬断重连及调用方补取的时间窗口
+	DefaultResultCacheTTL = 10 * time.Minute
+	// DefaultResultCacheMaxEntries 缓存条目上限，超出时淘汰最早过期的结果，避免内存无限增长
+	DefaultResultCacheMaxEntries = 256
+)
+
+// PendingResult 表示 Respond 失败、待重连后补发的结果
+type PendingResult struct {
+	JobID        string
+	ReplySubject string
+	Payload      []byte
+}
+
+type cachedResult struct {
+	payload      []byte
+	expiresAt    time.Time
+	replySubject string
+	pending      bool
+}
+
+// ResultCache 按 job id 缓存已完成任务的完整响应。
+// 用于 respond 阶段遇到 NATS 瞬断时，在重连后补发或通过 result.fetch 主动拉取。
+type ResultCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+	entries    map[string]*cachedResult
+}
+
+func NewResultCache(ttl time.Duration, maxEntries int) *ResultCache {
+	if ttl <= 0 {
+		ttl = DefaultResultCacheTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultResultCacheMaxEntries
+	}
+	return &ResultCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*cachedResult),
+	}
+}
+
+// Store 保存 job 的完整响应，重复写入同一 job id 会覆盖旧结果
+func (c *ResultCache) Store(jobID string, payload []byte) {
+	if jobID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.evictExpiredLocked(now)
+	if _, exists := c.entries[jobID]; !exists && len(c.entries) >= c.maxEntries {
+		c.evictOldestLocked()
+	}
+	c.entries[jobID] = &cachedResult{
+		payload:   append([]byte(nil), payload...),
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// Get 返回未过期的缓存结果
+func (c *ResultCache) Get(jobID string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[jobID]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expiresAt) {
+		delete(c.entries, jobID)
+		return nil, false
+	}
+	return append([]byte(nil), entry.payload...), true
+}
+
+// MarkPending 记录结果未能送达及原始回复主题，等待重连后补发
+func (c *ResultCache) MarkPending(jobID, replySubject string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[jobID]; ok {
+		entry.replySubject = replySubject
+		entry.pending = replySubject != ""
+	}
+}
+
+// MarkDelivered 清除补发标记，结果仍保留到 TTL 到期以便重复拉取
+func (c *ResultCache) MarkDelivered(jobID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[jobID]; ok {
+		entry.pending = false
+	}
+}
+
+// Pending 返回所有待补发且未过期的结果，按 job id 排序保证补发顺序稳定
+func (c *ResultCache) Pending() []PendingResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpiredLocked(c.now())
+	pending := make([]PendingResult, 0)
+	for jobID, entry := range c.entries {
+		if !entry.pending {
+			continue
+		}
+		pending = append(pending, PendingResult{
+			JobID:        jobID,
+			ReplySubject: entry.replySubject,
+			Payload:      append([]byte(nil), entry.payload...),
+		})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].JobID < pending[j].JobID })
+	return pending
+}
+
+func (c *ResultCache) evictExpiredLocked(now time.Time) {
+	for jobID, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, jobID)
+		}
+	}
+}
+
+func (c *ResultCache) evictOldestLocked() {
+	var oldestID string
+	var oldest time.Time
+	for jobID, entry := range c.entries {
+		if oldestID == "" || entry.expiresAt.Before(oldest) {
+			oldestID = jobID
+			oldest = entry.expiresAt
+		}
+	}
+	if oldestID != "" {
+		delete(c.entries, oldestID)
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestResultCache(ttl time.Duration, maxEntries int, now *time.Time) *ResultCache {
+	cache := NewResultCache(ttl, maxEntries)
+	cache.now = func() time.Time { return *now }
+	return cache
+}
+
+func TestResultCacheStoreAndGetUntilTTL(t *testing.T) {
+	now := time.Date(2026, 5, 9, 8, 0, 0, 0, time.UTC)
+	cache := newTestResultCache(time.Minute, 10, &now)
+
+	cache.Store("job-1", []byte(`{"success":true}`))
+	payload, ok := cache.Get("job-1")
+	if !ok || string(payload) != `{"success":true}` {
+		t.Fatalf("unexpected cached payload: ok=%v payload=%s", ok, payload)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("job-1"); ok {
+		t.Fatal("expected cached result to expire after ttl")
+	}
+}
+
+func TestResultCacheIgnoresEmptyJobID(t *testing.T) {
+	now := time.Now()
+	cache := newTestResultCache(time.Minute, 10, &now)
+
+	cache.Store("", []byte("payload"))
+	if len(cache.entries) != 0 {
+		t.Fatalf("expected empty job id to be ignored, got %d entries", len(cache.entries))
+	}
+}
+
+func TestResultCacheEvictsOldestWhenFull(t *testing.T) {
+	now := time.Date(2026, 5, 9, 8, 0, 0, 0, time.UTC)
+	cache := newTestResultCache(time.Minute, 2, &now)
+
+	cache.Store("job-1", []byte("1"))
+	now = now.Add(time.Second)
+	cache.Store("job-2", []byte("2"))
+	now = now.Add(time.Second)
+	cache.Store("job-3", []byte("3"))
+
+	if _, ok := cache.Get("job-1"); ok {
+		t.Fatal("expected oldest result to be evicted")
+	}
+	for _, jobID := range []string{"job-2", "job-3"} {
+		if _, ok := cache.Get(jobID); !ok {
+			t.Fatalf("expected %s to remain cached", jobID)
+		}
+	}
+}
+
+func TestResultCachePendingLifecycle(t *testing.T) {
+	now := time.Date(2026, 5, 9, 8, 0, 0, 0, time.UTC)
+	cache := newTestResultCache(time.Minute, 10, &now)
+
+	cache.Store("job-b", []byte("b"))
+	cache.Store("job-a", []byte("a"))
+	cache.MarkPending("job-b", "_INBOX.b")
+	cache.MarkPending("job-a", "_INBOX.a")
+	cache.MarkPending("job-missing", "_INBOX.missing")
+
+	pending := cache.Pending()
+	if len(pending) != 2 || pending[0].JobID != "job-a" || pending[1].ReplySubject != "_INBOX.b" {
+		t.Fatalf("unexpected pending results: %+v", pending)
+	}
+
+	cache.MarkDelivered("job-a")
+	pending = cache.Pending()
+	if len(pending) != 1 || pending[0].JobID != "job-b" || string(pending[0].Payload) != "b" {
+		t.Fatalf("unexpected pending results after delivery: %+v", pending)
+	}
+	if _, ok := cache.Get("job-a"); !ok {
+		t.Fatal("expected delivered result to remain fetchable until ttl")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if pending := cache.Pending(); len(pending) != 0 {
+		t.Fatalf("expected expired pending results to be dropped, got %+v", pending)
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"nats-executor/logger"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ResultStoreConfig 为 respond 时连接已关闭的结果转存位置，Save/Load 由启动流程基于 NATS 连接注入
+type ResultStoreConfig struct {
+	Bucket string
+	Save   func(bucket, key string, data []byte) error
+	Load   func(bucket, key string) ([]byte, error)
+}
+
+var (
+	resultStoreMu     sync.RWMutex
+	resultStoreConfig ResultStoreConfig
+)
+
+// SetResultStoreConfig 设置进程级结果转存配置，bucket 为空表示不转存
+func SetResultStoreConfig(cfg ResultStoreConfig) {
+	resultStoreMu.Lock()
+	resultStoreConfig = cfg
+	resultStoreMu.Unlock()
+}
+
+func currentResultStoreConfig() ResultStoreConfig {
+	resultStoreMu.RLock()
+	defer resultStoreMu.RUnlock()
+	return resultStoreConfig
+}
+
+// ResultObjectKey 返回结果在对象存储中的固定 key，调用方可据此直接读取
+func ResultObjectKey(instanceId, resultID string) string {
+	return fmt.Sprintf("results/%s/%s", instanceId, resultID)
+}
+
+// ResultIDFromRequest 从执行请求中取结果 ID：优先 job_id，其次 execution_id
+func ResultIDFromRequest(data []byte) string {
+	var incoming struct {
+		Args []struct {
+			JobID       string `json:"job_id"`
+			ExecutionID string `json:"execution_id"`
+		} `json:"args"`
+	}
+	if err := json.Unmarshal(data, &incoming); err != nil || len(incoming.Args) == 0 {
+		return ""
+	}
+	if incoming.Args[0].JobID != "" {
+		return incoming.Args[0].JobID
+	}
+	return incoming.Args[0].ExecutionID
+}
+
+// IsConnectionClosedError 判断 respond 失败是否因 NATS 连接已关闭，此时结果无法再经原连接送达
+func IsConnectionClosedError(err error) bool {
+	return errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrConnectionDraining)
+}
+
+// PersistUndeliveredResult 在连接关闭导致 respond 失败时把结果写入对象存储，返回对象 key 与是否已转存
+func PersistUndeliveredResult(operation, instanceId, resultID string, payload []byte, respondErr error) (string, bool) {
+	if resultID == "" || !IsConnectionClosedError(respondErr) {
+		return "", false
+	}
+	cfg := currentResultStoreConfig()
+	if cfg.Bucket == "" || cfg.Save == nil {
+		logger.Warnf("[%s] Instance: %s, Connection closed before result %s was delivered and result_bucket is not configured, result is lost", operation, instanceId, resultID)
+		return "", false
+	}
+	key := ResultObjectKey(instanceId, resultID)
+	if err := cfg.Save(cfg.Bucket, key, payload); err != nil {
+		logger.Errorf("[%s] Instance: %s, Failed to persist undelivered result %s to %s/%s: %v", operation, instanceId, resultID, cfg.Bucket, key, err)
+		return "", false
+	}
+	logger.Warnf("[%s] Instance: %s, Connection closed before result %s was delivered; result persisted to %s/%s, fetch it with result.fetch.%s", operation, instanceId, resultID, cfg.Bucket, key, resultID)
+	return key, true
+}
+
+// LoadPersistedResult 读取本实例转存的结果，未配置或不存在时返回 false
+func LoadPersistedResult(instanceId, resultID string) ([]byte, bool) {
+	cfg := currentResultStoreConfig()
+	if resultID == "" || cfg.Bucket == "" || cfg.Load == nil {
+		return nil, false
+	}
+	payload, err := cfg.Load(cfg.Bucket, ResultObjectKey(instanceId, resultID))
+	if err != nil {
+		logger.Debugf("[Result Fetch] Instance: %s, Persisted result %s not available: %v", instanceId, resultID, err)
+		return nil, false
+	}
+	return payload, true
+}
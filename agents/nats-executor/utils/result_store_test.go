@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestPersistUndeliveredResult(t *testing.T) {
+	saved := map[string][]byte{}
+	SetResultStoreConfig(ResultStoreConfig{
+		Bucket: "results",
+		Save:   func(bucket, key string, data []byte) error { saved[bucket+"/"+key] = data; return nil },
+	})
+	defer SetResultStoreConfig(ResultStoreConfig{})
+
+	if _, ok := PersistUndeliveredResult("Test", "instance-1", "job-1", []byte("payload"), errors.New("slow consumer")); ok {
+		t.Fatal("expected non-closed respond errors not to persist")
+	}
+	if _, ok := PersistUndeliveredResult("Test", "instance-1", "", []byte("payload"), nats.ErrConnectionClosed); ok {
+		t.Fatal("expected results without an id not to persist")
+	}
+	key, ok := PersistUndeliveredResult("Test", "instance-1", "job-1", []byte("payload"), nats.ErrConnectionClosed)
+	if !ok || key != "results/instance-1/job-1" || string(saved["results/results/instance-1/job-1"]) != "payload" {
+		t.Fatalf("unexpected persist result key=%q ok=%v saved=%v", key, ok, saved)
+	}
+
+	SetResultStoreConfig(ResultStoreConfig{
+		Bucket: "results",
+		Save:   func(bucket, key string, data []byte) error { return errors.New("object store unavailable") },
+	})
+	if _, ok := PersistUndeliveredResult("Test", "instance-1", "job-1", nil, nats.ErrConnectionDraining); ok {
+		t.Fatal("expected save failure to be reported")
+	}
+}
+
+func TestPersistUndeliveredResultWithoutBucket(t *testing.T) {
+	SetResultStoreConfig(ResultStoreConfig{})
+	if _, ok := PersistUndeliveredResult("Test", "instance-1", "job-1", nil, nats.ErrConnectionClosed); ok {
+		t.Fatal("expected no persistence without a bucket")
+	}
+	if _, ok := LoadPersistedResult("instance-1", "job-1"); ok {
+		t.Fatal("expected no persisted result without a bucket")
+	}
+}
+
+func TestResultIDFromRequest(t *testing.T) {
+	cases := map[string]string{
+		`{"args":[{"job_id":"job-1","execution_id":"exec-1"}]}`: "job-1",
+		`{"args":[{"execution_id":"exec-1"}]}`:                  "exec-1",
+		`{"args":[]}`:                                           "",
+		`not-json`:                                              "",
+	}
+	for data, want := range cases {
+		if got := ResultIDFromRequest([]byte(data)); got != want {
+			t.Fatalf("%s: expected %q, got %q", data, want, got)
+		}
+	}
+}
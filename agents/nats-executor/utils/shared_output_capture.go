@@ -9,6 +9,21 @@ import (
 
 const CommandOutputLimitBytes = 1024 * 1024
 
+// CommandOutputHardLimitBytes 为单个请求可申请的输出上限，请求的 max_output_bytes 超出时按此值截断
+const CommandOutputHardLimitBytes = 16 * 1024 * 1024
+
+// ResolveOutputLimit 返回请求实际生效的输出上限：未指定时使用默认值，超过硬上限时收敛到硬上限
+func ResolveOutputLimit(requested int) int {
+	switch {
+	case requested <= 0:
+		return CommandOutputLimitBytes
+	case requested > CommandOutputHardLimitBytes:
+		return CommandOutputHardLimitBytes
+	default:
+		return requested
+	}
+}
+
 type OutputSnapshot struct {
 	Stdout        []byte
 	Stderr        []byte
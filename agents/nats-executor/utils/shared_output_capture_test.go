@@ -65,3 +65,23 @@ func TestSharedOutputCaptureExactLimitHasNoMarker(t *testing.T) {
 		t.Fatalf("unexpected output: %q", output)
 	}
 }
+
+func TestResolveOutputLimit(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requested int
+		want      int
+	}{
+		{name: "unset uses default", requested: 0, want: CommandOutputLimitBytes},
+		{name: "negative uses default", requested: -1, want: CommandOutputLimitBytes},
+		{name: "below ceiling is honored", requested: 4 * 1024 * 1024, want: 4 * 1024 * 1024},
+		{name: "above ceiling is capped", requested: CommandOutputHardLimitBytes + 1, want: CommandOutputHardLimitBytes},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveOutputLimit(tt.requested); got != tt.want {
+				t.Fatalf("ResolveOutputLimit(%d) = %d, want %d", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
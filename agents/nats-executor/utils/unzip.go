@@ -24,74 +24,113 @@ var (
 type UnzipRequest struct {
 	ZipPath string `json:"zip_path"`
 	DestDir string `json:"dest_dir"`
+	// 为 true 时跳过解压失败的条目（权限不足、路径越界等）继续解压其余条目，默认任一条目失败即整体失败
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+}
+
+// UnzipEntryError 为 continue_on_error 时未能解压的条目及原因
+type UnzipEntryError struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// UnzipResult 为解压结果，Failures 仅在 continue_on_error 时可能非空
+type UnzipResult struct {
+	ParentDir string
+	Failures  []UnzipEntryError
 }
 
 // UnzipToDir 解压 .zip 文件到指定目录，返回父目录名称
 func UnzipToDir(req UnzipRequest) (string, error) {
+	result, err := UnzipToDirWithResult(req)
+	return result.ParentDir, err
+}
+
+// UnzipToDirWithResult 解压 .zip 文件到指定目录；continue_on_error 时逐条收集失败条目，压缩包本身无法读取时仍直接返回错误
+func UnzipToDirWithResult(req UnzipRequest) (UnzipResult, error) {
 	if strings.TrimSpace(req.DestDir) == "" {
-		return "", fmt.Errorf("destination directory is required")
+		return UnzipResult{}, fmt.Errorf("destination directory is required")
 	}
 
 	reader, err := openZipArchive(req.ZipPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open zip file: %w", err)
+		return UnzipResult{}, fmt.Errorf("failed to open zip file: %w", err)
 	}
 	defer reader.Close()
 
 	if len(reader.File) == 0 {
-		return "", fmt.Errorf("zip file is empty")
+		return UnzipResult{}, fmt.Errorf("zip file is empty")
 	}
 
 	// 获取父目录名称
 	firstFile := reader.File[0]
 	parts := strings.SplitN(firstFile.Name, string(os.PathSeparator), 2)
 	if len(parts) == 0 {
-		return "", fmt.Errorf("failed to determine parent directory")
+		return UnzipResult{}, fmt.Errorf("failed to determine parent directory")
 	}
-	parentDir := parts[0]
+	result := UnzipResult{ParentDir: parts[0]}
 
 	for _, f := range reader.File {
-		if filepath.IsAbs(f.Name) {
-			return "", fmt.Errorf("illegal file path: %s", f.Name)
+		if err := unzipEntry(f, req.DestDir); err != nil {
+			if !req.ContinueOnError {
+				return UnzipResult{}, err
+			}
+			result.Failures = append(result.Failures, UnzipEntryError{Name: f.Name, Error: err.Error()})
 		}
+	}
 
-		fpath := filepath.Join(req.DestDir, f.Name)
+	return result, nil
+}
 
-		// 防止 ZipSlip 漏洞
-		if !strings.HasPrefix(fpath, filepath.Clean(req.DestDir)+string(os.PathSeparator)) {
-			return "", fmt.Errorf("illegal file path: %s", fpath)
-		}
+// zipEntryPath 返回条目在 destDir 下的目标路径；条目为绝对路径，或 filepath.Clean 后落在 destDir 之外时返回错误（防止 ZipSlip）
+func zipEntryPath(destDir, name string) (string, error) {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("illegal file path: %s", name)
+	}
+	dest := filepath.Clean(destDir)
+	fpath := filepath.Join(dest, name)
+	rel, err := filepath.Rel(dest, fpath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal file path: %s", name)
+	}
+	return fpath, nil
+}
 
-		if f.Mode()&os.ModeType != 0 && !f.FileInfo().IsDir() {
-			return "", fmt.Errorf("unsupported file type in zip: %s", f.Name)
-		}
+func unzipEntry(f *zip.File, destDir string) error {
+	fpath, err := zipEntryPath(destDir, f.Name)
+	if err != nil {
+		return err
+	}
+	// 文件条目不能指向目标目录本身，否则会删除已存在的目标目录
+	if fpath == filepath.Clean(destDir) && !f.FileInfo().IsDir() {
+		return fmt.Errorf("illegal file path: %s", f.Name)
+	}
 
-		if f.FileInfo().IsDir() {
-			// 创建目录
-			if err := makeDirAll(fpath, 0755); err != nil {
-				return "", fmt.Errorf("failed to create directory: %w", err)
-			}
-			continue
-		}
+	if f.Mode()&os.ModeType != 0 && !f.FileInfo().IsDir() {
+		return fmt.Errorf("unsupported file type in zip: %s", f.Name)
+	}
 
-		// 创建父目录
-		if err := makeDirAll(filepath.Dir(fpath), 0755); err != nil {
-			return "", fmt.Errorf("failed to create parent directory: %w", err)
+	if f.FileInfo().IsDir() {
+		// 创建目录
+		if err := makeDirAll(fpath, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
 		}
+		return nil
+	}
 
-		// 检查目标路径是否已存在目录，如果是则删除
-		if info, err := statPath(fpath); err == nil && info.IsDir() {
-			if err := removePath(fpath); err != nil {
-				return "", fmt.Errorf("failed to remove existing directory: %w", err)
-			}
-		}
+	// 创建父目录
+	if err := makeDirAll(filepath.Dir(fpath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
 
-		if err := extractZipFile(f, fpath); err != nil {
-			return "", err
+	// 检查目标路径是否已存在目录，如果是则删除
+	if info, err := statPath(fpath); err == nil && info.IsDir() {
+		if err := removePath(fpath); err != nil {
+			return fmt.Errorf("failed to remove existing directory: %w", err)
 		}
 	}
 
-	return parentDir, nil
+	return extractZipFile(f, fpath)
 }
 
 func extractZipFile(f *zip.File, fpath string) error {
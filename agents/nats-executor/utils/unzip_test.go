@@ -73,6 +73,57 @@ func TestUnzipToDirRejectsZipSlip(t *testing.T) {
 	}
 }
 
+func TestUnzipToDirRejectsNestedTraversalEntries(t *testing.T) {
+	base := t.TempDir()
+	destDir := filepath.Join(base, "dest")
+	zipFilePath := filepath.Join(t.TempDir(), "malicious.zip")
+	createZipFile(t, zipFilePath, map[string]string{
+		"pkg/readme.txt":              "ok",
+		"pkg/../../evil.txt":          "pwned",
+		"pkg/a/b/../../../../etc.txt": "pwned",
+		"./../sibling/evil.txt":       "pwned",
+	})
+
+	result, err := UnzipToDirWithResult(UnzipRequest{ZipPath: zipFilePath, DestDir: destDir, ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("expected per-entry failures, got %v", err)
+	}
+	if len(result.Failures) != 3 {
+		t.Fatalf("expected every traversal entry to be rejected, got %+v", result.Failures)
+	}
+	for _, failure := range result.Failures {
+		if !strings.Contains(failure.Error, "illegal file path") {
+			t.Fatalf("unexpected failure for %s: %s", failure.Name, failure.Error)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "pkg", "readme.txt")); err != nil {
+		t.Fatalf("expected safe entry to be extracted: %v", err)
+	}
+	for _, escaped := range []string{"evil.txt", "etc.txt", filepath.Join("sibling", "evil.txt")} {
+		if _, err := os.Stat(filepath.Join(base, escaped)); !os.IsNotExist(err) {
+			t.Fatalf("traversal entry escaped to %s (stat err=%v)", escaped, err)
+		}
+	}
+}
+
+func TestZipEntryPathKeepsTargetsInsideDestination(t *testing.T) {
+	for _, tc := range []struct {
+		dest, name string
+		ok         bool
+	}{
+		{"/srv/out", "a/b.txt", true},
+		{"/srv/out/", "a/../b.txt", true},
+		{"/", "etc/app.conf", true},
+		{"/srv/out", "../out2/b.txt", false},
+		{"/srv/out", "a/../../b.txt", false},
+		{"/srv/out", "/etc/passwd", false},
+	} {
+		if _, err := zipEntryPath(tc.dest, tc.name); (err == nil) != tc.ok {
+			t.Fatalf("zipEntryPath(%q, %q) err=%v, want ok=%v", tc.dest, tc.name, err, tc.ok)
+		}
+	}
+}
+
 func TestUnzipToDirRejectsAbsolutePathEntries(t *testing.T) {
 	zipFilePath := filepath.Join(t.TempDir(), "absolute.zip")
 	createZipFile(t, zipFilePath, map[string]string{
@@ -359,3 +410,38 @@ func createZipArchiveForBenchmark(b testing.TB, zipFilePath string, fileCount, f
 		b.Fatalf("failed to close zip writer: %v", err)
 	}
 }
+
+func TestUnzipToDirWithResultContinuesPastFailedEntries(t *testing.T) {
+	zipFilePath := filepath.Join(t.TempDir(), "partial.zip")
+	createZipFile(t, zipFilePath, map[string]string{
+		"pkg/ok.txt":     "ok",
+		"../evil.txt":    "pwned",
+		"pkg/sub/b.txt":  "b",
+		"/tmp/abs.txt":   "abs",
+		"pkg/readme.txt": "readme",
+	})
+	destDir := filepath.Join(t.TempDir(), "dest")
+
+	if _, err := UnzipToDirWithResult(UnzipRequest{ZipPath: zipFilePath, DestDir: destDir}); err == nil {
+		t.Fatal("expected strict mode to fail on the first rejected entry")
+	}
+
+	result, err := UnzipToDirWithResult(UnzipRequest{ZipPath: zipFilePath, DestDir: destDir, ContinueOnError: true})
+	if err != nil {
+		t.Fatalf("expected partial extraction to succeed, got %v", err)
+	}
+	if len(result.Failures) != 2 {
+		t.Fatalf("expected two failed entries, got %+v", result.Failures)
+	}
+	for _, failure := range result.Failures {
+		if (failure.Name != "../evil.txt" && failure.Name != "/tmp/abs.txt") || !strings.Contains(failure.Error, "illegal file path") {
+			t.Fatalf("unexpected failure: %+v", failure)
+		}
+	}
+	for name, want := range map[string]string{"pkg/ok.txt": "ok", "pkg/sub/b.txt": "b", "pkg/readme.txt": "readme"} {
+		content, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil || string(content) != want {
+			t.Fatalf("expected %s to be extracted, content=%q err=%v", name, content, err)
+		}
+	}
+}
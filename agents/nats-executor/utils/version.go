@@ -0,0 +1,4 @@
+package utils
+
+// Version 为执行器版本，发布构建时通过 -ldflags "-X nats-executor/utils.Version=<版本>" 注入
+var Version = "3.0.0"
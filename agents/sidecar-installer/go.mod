@@ -4,7 +4,10 @@ go 1.23.0
 
 toolchain go1.23.7
 
-require github.com/nats-io/nats.go v1.41.0
+require (
+	github.com/nats-io/nats.go v1.41.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/klauspost/compress v1.18.0 // indirect
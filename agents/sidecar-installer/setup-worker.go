@@ -1,10 +1,16 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,10 +20,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
 )
 
 // objectStoreMaxWait 是 JetStream Object Store 每个 chunk 投递的最大等待时间。
@@ -35,6 +43,8 @@ type Config struct {
 	InstallDir string        `json:"install_dir"`
 	Package    PackageConfig `json:"package"`
 	Storage    StorageConfig `json:"storage"`
+	// PackageSHA256 为安装包的十六进制 SHA-256，非空时下载完成后校验
+	PackageSHA256 string `json:"package_sha256,omitempty"`
 }
 
 type PackageConfig struct {
@@ -91,20 +101,51 @@ type EventOptions struct {
 }
 
 var (
-	configURL  = flag.String("url", "", "Configuration URL")
-	installDir = flag.String("install-dir", "", "Installation directory")
-	skipTLS    = flag.Bool("skip-tls", true, "Skip TLS certificate verification")
-	fetchOnly  = flag.Bool("fetch-only", false, "Only fetch and display config")
+	configURL         = flag.String("url", "", "Configuration URL")
+	installDir        = flag.String("install-dir", "", "Installation directory")
+	skipTLS           = flag.Bool("skip-tls", false, "Skip TLS certificate verification (insecure, prints a warning)")
+	fetchOnly         = flag.Bool("fetch-only", false, "Only fetch and display config")
+	keepPackage       = flag.Bool("keep-package", false, "Keep the downloaded package after successful extraction")
+	caFile            = flag.String("ca-file", "", "PEM CA bundle to trust for HTTPS endpoints (enables certificate verification)")
+	pinSHA256         = flag.String("pin-sha256", "", "Comma-separated base64 SHA-256 hashes of the server certificate public key to pin")
+	downloadRetries   = flag.Int("download-retries", 3, "Retries for Object Store package downloads, with exponential backoff")
+	maxExtractFileMB  = flag.Int64("max-extract-file-mb", 2048, "Maximum size in MB of a single file extracted from the package")
+	maxExtractTotalMB = flag.Int64("max-extract-total-mb", 8192, "Maximum total size in MB extracted from the package")
+	maxExtractFiles   = flag.Int("max-extract-files", 10000, "Maximum number of files extracted from the package")
+	healthURL         = flag.String("health-url", "", "Local collector health endpoint that must return 2xx after install (enables the health check)")
+	healthLogPattern  = flag.String("health-log-pattern", "", "Regexp that must appear in the collector logs after install (enables the health check)")
+	healthTimeout     = flag.Duration("health-timeout", 60*time.Second, "How long to wait for the health check to pass")
+	checkOnly         = flag.Bool("check", false, "Only run pre-flight checks (config, package download, install dir) without installing")
+	uninstallOnly     = flag.Bool("uninstall", false, "Stop and remove the sidecar service and delete the install directory")
+	keepLogs          = flag.Bool("keep-logs", false, "Keep the logs directory when used with -uninstall")
 )
 
+func init() {
+	flag.StringVar(caFile, "ca-cert", "", "Alias of -ca-file")
+}
+
 func main() {
 	flag.Parse()
 
+	if *uninstallOnly {
+		dir, err := resolveInstallDir("", *installDir)
+		if err != nil {
+			fatal("Failed to resolve absolute path for install dir: %v", err)
+		}
+		if err := uninstall(runtime.GOOS, dir, *keepLogs); err != nil {
+			fatal("Uninstall failed: %v", err)
+		}
+		return
+	}
+
 	if *configURL == "" {
 		fatal("--url is required")
 	}
 
-	client := newHTTPClient(*skipTLS)
+	client, err := newHTTPClient(*skipTLS, *caFile, *pinSHA256)
+	if err != nil {
+		fatal("Invalid TLS options: %v", err)
+	}
 
 	if *fetchOnly {
 		cfg, err := fetchConfig(client, *configURL)
@@ -115,6 +156,13 @@ func main() {
 		return
 	}
 
+	if *checkOnly {
+		if !runChecks(client, *configURL, *installDir) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	run(client)
 }
 
@@ -131,34 +179,42 @@ func run(client *http.Client) {
 	emitEvent("fetch_session", "success", "Installer session fetched", intPtr(100), 0, 0, "")
 	log("      Node: %s", cfg.NodeID)
 
-	if *installDir != "" {
-		cfg.InstallDir = *installDir
-	}
-	if cfg.InstallDir == "" {
-		cfg.InstallDir = `C:\fusion-collectors`
-	}
-	cfg.InstallDir = filepath.Clean(cfg.InstallDir)
-
-	// Ensure install directory is absolute path (required by collector-sidecar)
-	if !filepath.IsAbs(cfg.InstallDir) {
-		absPath, err := filepath.Abs(cfg.InstallDir)
-		if err != nil {
-			fatal("Failed to resolve absolute path for install dir: %v", err)
-		}
-		cfg.InstallDir = absPath
+	cfg.InstallDir, err = resolveInstallDir(cfg.InstallDir, *installDir)
+	if err != nil {
+		fatal("Failed to resolve absolute path for install dir: %v", err)
 	}
 
 	log("[2/6] Preparing directories...")
 	emitEvent("prepare_directories", "running", "Preparing directories", nil, 0, 0, "")
-	if err := prepareDirs(cfg.InstallDir); err != nil {
+	createdDirs, err := prepareDirs(cfg.InstallDir)
+	// 只删除本次新建的目录，已有安装目录中的内容不动
+	installRollback.push("remove created directories", func() error {
+		return removePaths(createdDirs)
+	})
+	if err != nil {
 		fatalStep("prepare_directories", "Failed: %v", err)
 	}
+	// 下载前确认安装目录可写，避免只读挂载/配额问题在长时间下载后的解压阶段才暴露
+	if err := checkWritable(cfg.InstallDir); err != nil {
+		fatalStep("prepare_directories", "Install directory is not writable: %v", err)
+	}
 	emitEvent("prepare_directories", "success", "Directories prepared", intPtr(100), 0, 0, "")
 
 	if cfg.Storage.FileKey != "" {
 		log("[3/6] Downloading package...")
 		emitEventWithOptions("download_package", "running", "Downloading controller package", intPtr(0), 0, 0, "", downloadEventOptions(cfg))
-		zipPath, err := downloadFromStorage(&cfg.Storage)
+		// Object Store 不支持按偏移读取，重试时从头下载
+		var zipPath string
+		err := withDownloadRetry(*downloadRetries, func() error {
+			var err error
+			zipPath, err = downloadFromStorage(&cfg.Storage, cfg.PackageSHA256)
+			return err
+		})
+		if err == nil && !*keepPackage {
+			installRollback.push("remove downloaded package", func() error {
+				return removePaths([]string{zipPath})
+			})
+		}
 		if err != nil {
 			downloadOptions := downloadEventOptions(cfg)
 			if downloadOptions != nil {
@@ -170,7 +226,7 @@ func run(client *http.Client) {
 
 		log("[4/6] Extracting files...")
 		emitEventWithOptions("extract_package", "running", "Extracting controller package", intPtr(0), 0, 0, "", &EventOptions{InstallDir: cfg.InstallDir, PackageName: firstNonEmpty(cfg.Package.Name, cfg.Storage.FileName), CPUArchitecture: cfg.Package.CPUArchitecture})
-		n, err := extract(zipPath, cfg.InstallDir)
+		n, err := extract(zipPath, cfg.InstallDir, extractLimitsFromFlags())
 		if err != nil {
 			targetPath := extractTargetPath(err)
 			fatalStepWithOptions("extract_package", "Extract failed: %v", err, &EventOptions{
@@ -181,7 +237,7 @@ func run(client *http.Client) {
 				CPUArchitecture: cfg.Package.CPUArchitecture,
 			})
 		}
-		os.Remove(zipPath)
+		cleanupPackage(zipPath, *keepPackage)
 		log("      Extracted %d files", n)
 		emitEventWithOptions("extract_package", "success", fmt.Sprintf("Extracted %d files", n), intPtr(100), 0, 0, "", &EventOptions{InstallDir: cfg.InstallDir, PackageName: firstNonEmpty(cfg.Package.Name, cfg.Storage.FileName), CPUArchitecture: cfg.Package.CPUArchitecture})
 	} else {
@@ -194,12 +250,27 @@ func run(client *http.Client) {
 	if isLinux(cfg.OS) {
 		log("      Linux package mode, skipping generated sidecar.yml")
 	} else {
+		configPath := filepath.Join(cfg.InstallDir, "sidecar.yml")
+		if _, err := os.Stat(configPath); os.IsNotExist(err) {
+			installRollback.push("remove sidecar.yml", func() error {
+				return removePaths([]string{configPath})
+			})
+		}
 		if err := writeConfig(cfg); err != nil {
 			fatalStep("configure_runtime", "Config write failed: %v", err)
 		}
 	}
 	emitEvent("configure_runtime", "success", "Installer runtime configured", intPtr(100), 0, 0, "")
 
+	check, err := healthCheckFromFlags(cfg.InstallDir)
+	if err != nil {
+		fatalStep("run_package_installer", "Invalid health check options: %v", err)
+	}
+	if check != nil {
+		// 启动服务前记录日志位置，只在新写入的内容中查找成功标志
+		check.markLogOffsets()
+	}
+
 	log("[6/6] Registering service...")
 	emitEventWithOptions("run_package_installer", "running", "Running package installer", nil, 0, 0, "", &EventOptions{InstallDir: cfg.InstallDir, CPUArchitecture: cfg.Package.CPUArchitecture})
 	if isLinux(cfg.OS) {
@@ -207,17 +278,152 @@ func run(client *http.Client) {
 			fatalStepWithOptions("run_package_installer", "Linux install failed: %v", err, eventOptionsForExecError(err, &EventOptions{InstallDir: cfg.InstallDir, CPUArchitecture: cfg.Package.CPUArchitecture}))
 		}
 	} else {
-		if err := registerService(cfg.InstallDir); err != nil {
+		if err := registerService(cfg.InstallDir, installRollback); err != nil {
 			fatalStepWithOptions("run_package_installer", "Service registration failed: %v", err, eventOptionsForExecError(err, &EventOptions{InstallDir: cfg.InstallDir, CPUArchitecture: cfg.Package.CPUArchitecture}))
 		}
 	}
+	if check != nil {
+		log("      Waiting up to %s for collector health check...", check.Timeout)
+		if err := check.wait(); err != nil {
+			fatalStepWithOptions("run_package_installer", "Health check failed: %v", err, &EventOptions{InstallDir: cfg.InstallDir, CPUArchitecture: cfg.Package.CPUArchitecture})
+		}
+		log("      Collector is healthy")
+	}
 	emitEventWithOptions("run_package_installer", "success", "Package installer finished", intPtr(100), 0, 0, "", &EventOptions{InstallDir: cfg.InstallDir, CPUArchitecture: cfg.Package.CPUArchitecture})
 
+	installRollback.clear()
 	log("")
 	log("Installation complete!")
 	emitEvent("complete", "success", "Installation complete", intPtr(100), 0, 0, "")
 }
 
+// checkResult 为 -check 模式下单项预检的结果，Skipped 表示配置中没有对应内容
+type checkResult struct {
+	Name    string
+	Detail  string
+	Err     error
+	Skipped bool
+}
+
+// probePackageFn 仅查询安装包元数据而不下载，测试可替换
+var probePackageFn = probePackage
+
+func probePackage(storage *StorageConfig) (uint64, error) {
+	nc, store, err := openObjectStore(storage)
+	if err != nil {
+		return 0, err
+	}
+	defer nc.Close()
+
+	info, err := store.GetInfo(storage.FileKey)
+	if err != nil {
+		return 0, fmt.Errorf("get object info failed: %w", err)
+	}
+	return info.Size, nil
+}
+
+// checkInstallDir 检查安装目录是否可写；目录尚不存在时检查最近的已存在上级目录，不创建任何目录
+func checkInstallDir(dir string) error {
+	target := dir
+	for {
+		info, err := os.Stat(target)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", target)
+			}
+			return checkWritable(target)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(target)
+		if parent == target {
+			return fmt.Errorf("no existing parent directory for %s", dir)
+		}
+		target = parent
+	}
+}
+
+// preflightChecks 依次检查配置获取、安装包可下载与安装目录可写，配置获取失败时后续检查无法进行
+func preflightChecks(client *http.Client, url, installDirOverride string) []checkResult {
+	cfg, err := fetchConfig(client, url)
+	if err == nil && strings.TrimSpace(cfg.NodeID) == "" {
+		err = fmt.Errorf("config is missing node_id")
+	}
+	if err != nil {
+		return []checkResult{
+			{Name: "config", Err: err},
+			{Name: "package", Detail: "config unavailable", Skipped: true},
+			{Name: "install_dir", Detail: "config unavailable", Skipped: true},
+		}
+	}
+	results := []checkResult{{Name: "config", Detail: fmt.Sprintf("node %s, os %s", cfg.NodeID, cfg.OS)}}
+
+	if cfg.Storage.FileKey == "" {
+		results = append(results, checkResult{Name: "package", Detail: "no storage package in config", Skipped: true})
+	} else if size, err := probePackageFn(&cfg.Storage); err != nil {
+		results = append(results, checkResult{Name: "package", Err: err})
+	} else {
+		results = append(results, checkResult{Name: "package", Detail: fmt.Sprintf("%s/%s (%d bytes)", cfg.Storage.Bucket, cfg.Storage.FileKey, size)})
+	}
+
+	dir, err := resolveInstallDir(cfg.InstallDir, installDirOverride)
+	if err == nil {
+		err = checkInstallDir(dir)
+	}
+	results = append(results, checkResult{Name: "install_dir", Detail: dir, Err: err})
+	return results
+}
+
+// runChecks 执行预检并打印汇总，全部通过时返回 true
+func runChecks(client *http.Client, url, installDirOverride string) bool {
+	log("Collector Sidecar Pre-flight Check")
+	log("==================================")
+	failed := 0
+	for _, result := range preflightChecks(client, url, installDirOverride) {
+		switch {
+		case result.Err != nil:
+			failed++
+			log("  [FAIL] %-12s %v", result.Name, result.Err)
+		case result.Skipped:
+			log("  [SKIP] %-12s %s", result.Name, result.Detail)
+		default:
+			log("  [PASS] %-12s %s", result.Name, result.Detail)
+		}
+	}
+	log("")
+	if failed > 0 {
+		log("Pre-flight check failed: %d check(s) failed", failed)
+		return false
+	}
+	log("Pre-flight check passed")
+	return true
+}
+
+// resolveInstallDir 按 -install-dir、配置值、默认目录的顺序确定安装目录并转为绝对路径（collector-sidecar 要求）
+func resolveInstallDir(configured, override string) (string, error) {
+	dir := configured
+	if override != "" {
+		dir = override
+	}
+	if dir == "" {
+		dir = defaultInstallDir(runtime.GOOS)
+	}
+	dir = filepath.Clean(dir)
+	if filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	return filepath.Abs(dir)
+}
+
+// defaultInstallDir 为未指定安装目录时的默认值
+func defaultInstallDir(goos string) string {
+	if goos == "windows" {
+		return `C:\fusion-collectors`
+	}
+	return "/opt/fusion-collectors"
+}
+
 func log(format string, args ...interface{}) {
 	fmt.Printf(format+"\n", args...)
 	os.Stdout.Sync()
@@ -262,8 +468,12 @@ func intPtr(v int) *int {
 	return &v
 }
 
+// installRollback 记录 run 中已完成步骤的撤销动作，fatal 退出前逆序执行
+var installRollback = &rollbackStack{}
+
 func fatal(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", args...)
+	installRollback.run()
 	os.Exit(1)
 }
 
@@ -277,6 +487,55 @@ func fatalStepWithOptions(step, format string, err error, options *EventOptions)
 	fatal("%s", msg)
 }
 
+type rollbackAction struct {
+	name string
+	undo func() error
+}
+
+// rollbackStack 为安装步骤的撤销栈，每个步骤成功（或开始产生副作用）后注册撤销动作
+type rollbackStack struct {
+	actions []rollbackAction
+}
+
+func (r *rollbackStack) push(name string, undo func() error) {
+	r.actions = append(r.actions, rollbackAction{name: name, undo: undo})
+}
+
+func (r *rollbackStack) clear() {
+	r.actions = nil
+}
+
+// run 逆序执行撤销动作。单个动作失败只记录，不中断后续回滚，也不替代原始错误
+func (r *rollbackStack) run() []error {
+	if len(r.actions) == 0 {
+		return nil
+	}
+	log("Rolling back installation...")
+	var errs []error
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		action := r.actions[i]
+		if err := action.undo(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: rollback %s failed: %v\n", action.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", action.name, err))
+			continue
+		}
+		log("      Rolled back: %s", action.name)
+	}
+	r.actions = nil
+	return errs
+}
+
+// removePaths 逆序删除路径，已不存在的路径视为成功
+func removePaths(paths []string) error {
+	var errs []error
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := os.RemoveAll(paths[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func intValuePtr(v int) *int {
 	return &v
 }
@@ -310,6 +569,8 @@ func classifyExtractError(err error) string {
 	}
 	message := strings.ToLower(err.Error())
 	switch {
+	case errors.Is(err, errExtractLimit):
+		return "package_invalid"
 	case strings.Contains(message, "text file busy"):
 		return "file_busy"
 	case strings.Contains(message, "permission denied") || strings.Contains(message, "operation not permitted"):
@@ -387,12 +648,112 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
-func newHTTPClient(skipTLS bool) *http.Client {
+const insecureTLSWarning = `WARNING: ************************************************************
+WARNING: -skip-tls is set: server certificates are NOT verified and
+WARNING: the connection can be intercepted. Use -ca-cert <ca.pem> to
+WARNING: verify a private CA instead.
+WARNING: ************************************************************`
+
+// newHTTPClient 构造访问配置/下载服务的 HTTP 客户端，证书校验规则见 newTLSConfig。
+// 指定 pins 时额外校验服务端证书公钥（SPKI）的 SHA-256，固定公钥在 skip-tls 下同样生效，可用于自签名证书场景。
+func newHTTPClient(skipTLS bool, caFile, pins string) (*http.Client, error) {
+	tlsConfig, configured, err := newTLSConfig(skipTLS, caFile, "")
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, err := parsePins(pins)
+	if err != nil {
+		return nil, err
+	}
+	if len(pinned) > 0 {
+		tlsConfig.VerifyPeerCertificate = verifyPinnedPublicKey(pinned)
+		configured = true
+	}
+
 	tr := &http.Transport{}
-	if skipTLS {
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	if configured {
+		tr.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: tr, Timeout: 120 * time.Second}, nil
+}
+
+// newTLSConfig 构造 HTTP 与 NATS 共用的证书校验配置，默认按系统 CA 校验证书。
+// 指定 caFile 或 nats_tls_ca 时只信任这些 CA 并强制校验证书；仅显式 skipTLS 且未指定 CA 时跳过校验并打印告警。
+// configured 为 false 表示与默认配置相同
+func newTLSConfig(skipTLS bool, caFile, inlineCA string) (*tls.Config, bool, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipTLS}
+	configured := skipTLS
+
+	var pool *x509.CertPool
+	if strings.TrimSpace(caFile) != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("read ca file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, false, fmt.Errorf("no certificates found in ca file %s", caFile)
+		}
+	}
+	if strings.TrimSpace(inlineCA) != "" {
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(inlineCA)) {
+			return nil, false, fmt.Errorf("invalid nats_tls_ca PEM content")
+		}
+	}
+	if pool != nil {
+		tlsConfig.RootCAs = pool
+		tlsConfig.InsecureSkipVerify = false
+		configured = true
+	}
+
+	if tlsConfig.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, insecureTLSWarning)
+	}
+	return tlsConfig, configured, nil
+}
+
+var errPinMismatch = errors.New("server certificate public key does not match -pin-sha256")
+
+func parsePins(value string) (map[string]bool, error) {
+	pinned := map[string]bool{}
+	for _, pin := range strings.Split(value, ",") {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		if pin == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid -pin-sha256 value %q: expected base64 encoded SHA-256", pin)
+		}
+		pinned[pin] = true
+	}
+	return pinned, nil
+}
+
+func spkiSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPinnedPublicKey 校验服务端叶子证书的公钥哈希，在握手阶段拒绝不匹配的连接
+func verifyPinnedPublicKey(pinned map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errPinMismatch
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("parse server certificate: %w", err)
+		}
+		if got := spkiSHA256(leaf); !pinned[got] {
+			return fmt.Errorf("%w (got sha256/%s)", errPinMismatch, got)
+		}
+		return nil
 	}
-	return &http.Client{Transport: tr, Timeout: 120 * time.Second}
 }
 
 func fetchConfig(client *http.Client, url string) (*Config, error) {
@@ -424,9 +785,31 @@ func fetchConfig(client *http.Client, url string) (*Config, error) {
 	if cfg.OS == "" {
 		cfg.OS = "windows"
 	}
+	cfg.PackageSHA256 = strings.ToLower(strings.TrimSpace(cfg.PackageSHA256))
+	if cfg.PackageSHA256 != "" && !isSHA256Hex(cfg.PackageSHA256) {
+		return nil, fmt.Errorf("invalid package_sha256 %q: expected 64 hex characters", cfg.PackageSHA256)
+	}
 	return &cfg, nil
 }
 
+func isSHA256Hex(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+var errPackageChecksumMismatch = errors.New("package checksum mismatch")
+
+// verifyPackageSHA256 比对下载时计算出的哈希，expected 为空时不校验
+func verifyPackageSHA256(sum []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	if actual := hex.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("%w: expected sha256 %s, got %s", errPackageChecksumMismatch, expected, actual)
+	}
+	return nil
+}
+
 func isLinux(osName string) bool {
 	return strings.EqualFold(strings.TrimSpace(osName), "linux")
 }
@@ -519,15 +902,16 @@ func printConfig(cfg *Config) {
 	}
 }
 
-func downloadFromStorage(storage *StorageConfig) (string, error) {
+// openObjectStore 连接 storage 指定的 NATS 并打开对象存储桶，调用方负责关闭返回的连接
+func openObjectStore(storage *StorageConfig) (*nats.Conn, nats.ObjectStore, error) {
 	if strings.TrimSpace(storage.NATSServers) == "" {
-		return "", fmt.Errorf("missing nats_servers")
+		return nil, nil, fmt.Errorf("missing nats_servers")
 	}
 	if strings.TrimSpace(storage.Bucket) == "" {
-		return "", fmt.Errorf("missing bucket")
+		return nil, nil, fmt.Errorf("missing bucket")
 	}
 	if strings.TrimSpace(storage.FileKey) == "" {
-		return "", fmt.Errorf("missing file_key")
+		return nil, nil, fmt.Errorf("missing file_key")
 	}
 
 	serverURL := normalizeNATSURL(storage.NATSProtocol, storage.NATSServers)
@@ -536,34 +920,39 @@ func downloadFromStorage(storage *StorageConfig) (string, error) {
 		options = append(options, nats.UserInfo(storage.NATSUsername, storage.NATSPassword))
 	}
 	if strings.EqualFold(strings.TrimSpace(storage.NATSProtocol), "tls") {
-		tlsConfig := &tls.Config{}
-		if *skipTLS {
-			tlsConfig.InsecureSkipVerify = true
-		} else if strings.TrimSpace(storage.NATSTLSCA) != "" {
-			pool := x509.NewCertPool()
-			if !pool.AppendCertsFromPEM([]byte(storage.NATSTLSCA)) {
-				return "", fmt.Errorf("invalid nats_tls_ca PEM content")
-			}
-			tlsConfig.RootCAs = pool
+		tlsConfig, _, err := newTLSConfig(*skipTLS, *caFile, storage.NATSTLSCA)
+		if err != nil {
+			return nil, nil, err
 		}
 		options = append(options, nats.Secure(tlsConfig))
 	}
 
 	nc, err := nats.Connect(serverURL, options...)
 	if err != nil {
-		return "", fmt.Errorf("connect nats failed: %w", err)
+		return nil, nil, fmt.Errorf("connect nats failed: %w", err)
 	}
-	defer nc.Close()
 
 	js, err := nc.JetStream(nats.MaxWait(objectStoreMaxWait))
 	if err != nil {
-		return "", fmt.Errorf("create jetstream context failed: %w", err)
+		nc.Close()
+		return nil, nil, fmt.Errorf("create jetstream context failed: %w", err)
 	}
 
 	store, err := js.ObjectStore(storage.Bucket)
 	if err != nil {
-		return "", fmt.Errorf("open object store failed: %w", err)
+		nc.Close()
+		return nil, nil, fmt.Errorf("open object store failed: %w", err)
+	}
+	return nc, store, nil
+}
+
+// downloadFromStorage 从 Object Store 下载安装包，边下载边计算 SHA-256，与 expectedSHA256 不一致时删除文件并返回错误
+func downloadFromStorage(storage *StorageConfig, expectedSHA256 string) (string, error) {
+	nc, store, err := openObjectStore(storage)
+	if err != nil {
+		return "", err
 	}
+	defer nc.Close()
 
 	obj, err := store.Get(storage.FileKey)
 	if err != nil {
@@ -584,14 +973,20 @@ func downloadFromStorage(storage *StorageConfig) (string, error) {
 	}
 	defer f.Close()
 
+	hasher := sha256.New()
+	src := io.TeeReader(obj, hasher)
 	if totalSize > 0 {
 		pw := &progressWriter{total: totalSize, desc: "Downloading", step: "download_package"}
-		_, err = io.Copy(f, io.TeeReader(obj, pw))
+		_, err = io.Copy(f, io.TeeReader(src, pw))
 		if err == nil && pw.lastPct < 100 {
 			emitEvent("download_package", "running", "Downloading", intPtr(100), totalSize, totalSize, "")
 		}
 	} else {
-		_, err = io.Copy(f, obj)
+		_, err = io.Copy(f, src)
+	}
+	f.Close()
+	if err == nil {
+		err = verifyPackageSHA256(hasher.Sum(nil), expectedSHA256)
 	}
 	if err != nil {
 		os.Remove(tmp)
@@ -613,16 +1008,54 @@ func normalizeNATSURL(protocol, servers string) string {
 	return fmt.Sprintf("%s://%s", proto, trimmed)
 }
 
-func prepareDirs(base string) error {
+// cleanupPackage 默认在解压成功后删除安装包；keep 为 true 时保留以便排查或重装
+func cleanupPackage(zipPath string, keep bool) {
+	if keep {
+		log("      Package kept at %s", zipPath)
+		return
+	}
+	os.Remove(zipPath)
+}
+
+// prepareDirs 创建安装目录结构，返回本次新建的目录供回滚删除
+func prepareDirs(base string) ([]string, error) {
+	var created []string
 	dirs := []string{"", "bin", "cache", "logs", "generated"}
 	for _, d := range dirs {
-		if err := os.MkdirAll(filepath.Join(base, d), 0755); err != nil {
-			return err
+		dir := filepath.Join(base, d)
+		if _, err := os.Stat(dir); err == nil {
+			continue
 		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return created, err
+		}
+		created = append(created, dir)
+	}
+	return created, nil
+}
+
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".write-probe-*")
+	if err != nil {
+		return fmt.Errorf("create probe file in %s: %w", dir, err)
+	}
+	probePath := probe.Name()
+	_, writeErr := probe.Write([]byte("ok"))
+	closeErr := probe.Close()
+	removeErr := os.Remove(probePath)
+	if writeErr != nil {
+		return fmt.Errorf("write probe file %s: %w", probePath, writeErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("close probe file %s: %w", probePath, closeErr)
+	}
+	if removeErr != nil {
+		return fmt.Errorf("remove probe file %s: %w", probePath, removeErr)
 	}
 	return nil
 }
 
+// progressWriter 统计下载进度。Object Store 不支持按偏移读取，每次重试都从头下载，进度也从 0 开始
 type progressWriter struct {
 	total      int64
 	downloaded int64
@@ -645,136 +1078,383 @@ func (pw *progressWriter) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-func download(client *http.Client, url string) (string, error) {
-	resp, err := client.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+// downloadSleep 为重试退避等待，测试可替换
+var downloadSleep = time.Sleep
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+const maxDownloadBackoff = 30 * time.Second
+
+// downloadBackoff 返回第 attempt 次重试前的等待时间：1s、2s、4s……，上限 30s
+func downloadBackoff(attempt int) time.Duration {
+	if attempt > 5 {
+		return maxDownloadBackoff
 	}
+	return min(time.Second<<attempt, maxDownloadBackoff)
+}
 
-	tmp := filepath.Join(os.TempDir(), fmt.Sprintf("sidecar-%d.zip", time.Now().UnixNano()))
-	f, err := os.Create(tmp)
-	if err != nil {
-		return "", err
+// retriableDownloadError 判断下载错误是否值得重试。对象或 bucket 不存在、认证失败及哈希不一致视为永久失败
+func retriableDownloadError(err error) bool {
+	if errors.Is(err, errPackageChecksumMismatch) {
+		return false
 	}
+	switch classifyDownloadError(err) {
+	case "object_missing", "bucket_missing", "auth":
+		return false
+	}
+	return true
+}
 
-	if resp.ContentLength > 0 {
-		log("      Downloading... 0%%")
-		pw := &progressWriter{total: resp.ContentLength, desc: "Downloading", step: "download_package"}
-		_, err = io.Copy(f, io.TeeReader(resp.Body, pw))
-		if pw.lastPct < 100 {
-			log("      Downloading... 100%%")
-			emitEvent("download_package", "running", "Downloading", intPtr(100), resp.ContentLength, resp.ContentLength, "")
+// withDownloadRetry 执行 fn，可重试的失败按指数退避最多重试 retries 次
+func withDownloadRetry(retries int, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !retriableDownloadError(err) {
+			return err
 		}
-	} else {
-		_, err = io.Copy(f, resp.Body)
+		wait := downloadBackoff(attempt)
+		log("      Download failed (%v), retrying in %s (%d/%d)", err, wait, attempt+1, retries)
+		downloadSleep(wait)
 	}
-	f.Close()
+}
+
+// extractLimits 限制解压出的单文件大小、总大小和文件数，防止 zip 炸弹撑满磁盘
+type extractLimits struct {
+	MaxFileBytes  int64
+	MaxTotalBytes int64
+	MaxFiles      int
+}
+
+func extractLimitsFromFlags() extractLimits {
+	return extractLimits{
+		MaxFileBytes:  *maxExtractFileMB << 20,
+		MaxTotalBytes: *maxExtractTotalMB << 20,
+		MaxFiles:      *maxExtractFiles,
+	}
+}
+
+var errExtractLimit = errors.New("package exceeds extract limit")
+
+// archiveEntry 为安装包中的一个条目，zip 与 tar.gz 统一为该结构后走同一套解压逻辑
+type archiveEntry struct {
+	Name     string
+	Size     int64
+	Mode     os.FileMode
+	IsDir    bool
+	Linkname string // 非空表示符号链接（仅 tar.gz）
+}
 
+// extract 按文件魔数选择 zip 或 tar.gz 解压器解压安装包。超过 limits 时中止，并删除本次已写入的文件
+func extract(archivePath, dest string, limits extractLimits) (int, error) {
+	format, err := detectArchiveFormat(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	if format == "tar.gz" {
+		return extractTarGz(archivePath, dest, limits)
+	}
+	return extractZip(archivePath, dest, limits)
+}
+
+// detectArchiveFormat 根据文件头魔数判断格式：1f 8b 为 gzip，PK\x03\x04 为 zip
+func detectArchiveFormat(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		os.Remove(tmp)
 		return "", err
 	}
-	return tmp, nil
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	switch {
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return "tar.gz", nil
+	case n == 4 && string(magic) == "PK\x03\x04":
+		return "zip", nil
+	}
+	return "", fmt.Errorf("invalid package format: expected zip or tar.gz")
 }
 
-func extract(zipPath, dest string) (int, error) {
+func extractZip(zipPath, dest string, limits extractLimits) (int, error) {
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
 		return 0, err
 	}
 	defer r.Close()
 
-	stripPrefix := detectCommonPrefix(r.File)
-
-	totalFiles := 0
+	entries := make([]archiveEntry, 0, len(r.File))
 	for _, f := range r.File {
-		if !f.FileInfo().IsDir() {
-			totalFiles++
+		entries = append(entries, archiveEntry{Name: f.Name, Size: int64(f.UncompressedSize64), Mode: f.Mode(), IsDir: f.FileInfo().IsDir()})
+	}
+	x, err := newArchiveExtractor(dest, limits, entries)
+	if err != nil {
+		return 0, err
+	}
+	for i, f := range r.File {
+		err := x.extractEntry(entries[i], func() (io.ReadCloser, error) { return f.Open() })
+		if err != nil {
+			return x.abort(err)
 		}
 	}
+	return x.finish(), nil
+}
+
+// extractTarGz 流式解压 tar.gz：第一遍只读条目头做上限检查和前缀识别，第二遍写入文件
+func extractTarGz(path, dest string, limits extractLimits) (int, error) {
+	var entries []archiveEntry
+	err := walkTarGz(path, func(entry archiveEntry, _ io.Reader) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	x, err := newArchiveExtractor(dest, limits, entries)
+	if err != nil {
+		return 0, err
+	}
+	err = walkTarGz(path, func(entry archiveEntry, r io.Reader) error {
+		return x.extractEntry(entry, func() (io.ReadCloser, error) { return io.NopCloser(r), nil })
+	})
+	if err != nil {
+		return x.abort(err)
+	}
+	return x.finish(), nil
+}
 
-	count := 0
-	lastPct := 0
-	if totalFiles > 0 {
-		log("      Extracting... 0%%")
-		emitEvent("extract_package", "running", "Extracting", intPtr(0), 0, int64(totalFiles), "")
+func walkTarGz(path string, fn func(entry archiveEntry, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	destClean := filepath.Clean(dest) + string(os.PathSeparator)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
 
-	for _, f := range r.File {
-		name := f.Name
-		if stripPrefix != "" {
-			name = strings.TrimPrefix(name, stripPrefix)
-			if name == "" {
-				continue
-			}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
 		}
-
-		target := filepath.Join(dest, name)
-		if !strings.HasPrefix(filepath.Clean(target)+string(os.PathSeparator), destClean) {
-			if filepath.Clean(target) != filepath.Clean(dest) {
-				continue
-			}
+		if err != nil {
+			return err
 		}
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(target, f.Mode())
+		entry := archiveEntry{Name: header.Name, Size: header.Size, Mode: header.FileInfo().Mode().Perm()}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.IsDir = true
+		case tar.TypeReg:
+		case tar.TypeSymlink:
+			entry.Linkname = header.Linkname
+		case tar.TypeXGlobalHeader:
 			continue
+		default:
+			return fmt.Errorf("invalid package entry %s: unsupported tar entry type %q", header.Name, header.Typeflag)
 		}
-
-		os.MkdirAll(filepath.Dir(target), 0755)
-
-		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return count, err
+		if err := fn(entry, tr); err != nil {
+			return err
 		}
+	}
+}
 
-		in, err := f.Open()
-		if err != nil {
-			out.Close()
-			return count, err
+// archiveExtractor 实现与包格式无关的解压逻辑：公共前缀剥离、路径穿越防护、大小与数量上限、进度上报
+type archiveExtractor struct {
+	dest         string
+	limits       extractLimits
+	stripPrefix  string
+	totalFiles   int
+	count        int
+	lastPct      int
+	totalWritten int64
+	written      []string
+}
+
+// newArchiveExtractor 先按条目中声明的大小和数量快速拒绝，实际写入时再用 LimitReader 兜底
+func newArchiveExtractor(dest string, limits extractLimits, entries []archiveEntry) (*archiveExtractor, error) {
+	names := make([]string, 0, len(entries))
+	var declaredTotal int64
+	totalFiles := 0
+	for _, entry := range entries {
+		// tar 中常见的 "./" 根目录条目不参与公共前缀判断
+		if name := strings.TrimPrefix(entry.Name, "./"); name != "" {
+			names = append(names, name)
+		}
+		if entry.IsDir {
+			continue
+		}
+		totalFiles++
+		if entry.Size > limits.MaxFileBytes {
+			return nil, fmt.Errorf("%w: %s declares %d bytes, limit is %d", errExtractLimit, entry.Name, entry.Size, limits.MaxFileBytes)
 		}
+		declaredTotal += entry.Size
+	}
+	if totalFiles > limits.MaxFiles {
+		return nil, fmt.Errorf("%w: %d files, limit is %d", errExtractLimit, totalFiles, limits.MaxFiles)
+	}
+	if declaredTotal > limits.MaxTotalBytes {
+		return nil, fmt.Errorf("%w: %d bytes in total, limit is %d", errExtractLimit, declaredTotal, limits.MaxTotalBytes)
+	}
+
+	x := &archiveExtractor{dest: dest, limits: limits, stripPrefix: detectCommonPrefix(names), totalFiles: totalFiles}
+	if totalFiles > 0 {
+		log("      Extracting... 0%%")
+		emitEvent("extract_package", "running", "Extracting", intPtr(0), 0, int64(totalFiles), "")
+	}
+	return x, nil
+}
 
-		_, err = io.Copy(out, in)
-		in.Close()
+func (x *archiveExtractor) extractEntry(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+	name := strings.TrimPrefix(entry.Name, "./")
+	if x.stripPrefix != "" {
+		name = strings.TrimPrefix(name, x.stripPrefix)
+	}
+	if name == "" {
+		return nil
+	}
+
+	target, err := extractTarget(x.dest, name)
+	if err != nil {
+		return err
+	}
+	if err := rejectSymlinkParents(x.dest, name); err != nil {
+		return err
+	}
+
+	if entry.IsDir {
+		os.MkdirAll(target, entry.Mode)
+		return nil
+	}
+
+	os.MkdirAll(filepath.Dir(target), 0755)
+
+	if entry.Linkname != "" {
+		return x.extractSymlink(name, target, entry.Linkname)
+	}
+
+	// 同名的已有链接先删除，O_TRUNC 打开会跟随链接写到其指向的文件
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(target)
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode)
+	if err != nil {
+		return err
+	}
+	x.written = append(x.written, target)
+
+	in, err := open()
+	if err != nil {
 		out.Close()
-		if err != nil {
-			return count, err
-		}
-		count++
+		return err
+	}
 
-		if totalFiles > 0 {
-			pct := count * 100 / totalFiles
-			if pct/5 > lastPct/5 {
-				log("      Extracting... %d%%", pct)
-				emitEvent("extract_package", "running", "Extracting", intPtr(pct), int64(count), int64(totalFiles), "")
-				lastPct = pct
-			}
+	allowed := min(x.limits.MaxFileBytes, x.limits.MaxTotalBytes-x.totalWritten)
+	n, err := io.Copy(out, io.LimitReader(in, allowed+1))
+	in.Close()
+	out.Close()
+	if err != nil {
+		return err
+	}
+	if n > allowed {
+		return fmt.Errorf("%w: %s expands beyond %d bytes", errExtractLimit, entry.Name, allowed)
+	}
+	x.totalWritten += n
+	x.fileDone()
+	return nil
+}
+
+// extractSymlink 只允许指向 dest 内部的相对链接，防止借符号链接写到安装目录之外
+func (x *archiveExtractor) extractSymlink(name, target, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("illegal file path in package: %s -> %s", name, linkname)
+	}
+	if _, err := extractTarget(x.dest, filepath.Join(filepath.Dir(name), linkname)); err != nil {
+		return fmt.Errorf("illegal file path in package: %s -> %s", name, linkname)
+	}
+	os.Remove(target)
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.written = append(x.written, target)
+	x.fileDone()
+	return nil
+}
+
+func (x *archiveExtractor) fileDone() {
+	x.count++
+	if x.totalFiles > 0 {
+		pct := x.count * 100 / x.totalFiles
+		if pct/5 > x.lastPct/5 {
+			log("      Extracting... %d%%", pct)
+			emitEvent("extract_package", "running", "Extracting", intPtr(pct), int64(x.count), int64(x.totalFiles), "")
+			x.lastPct = pct
 		}
 	}
+}
 
-	if totalFiles > 0 && lastPct < 100 {
+// abort 在超出上限时删除本次已写入的文件
+func (x *archiveExtractor) abort(err error) (int, error) {
+	if errors.Is(err, errExtractLimit) {
+		removePaths(x.written)
+	}
+	return x.count, err
+}
+
+func (x *archiveExtractor) finish() int {
+	if x.totalFiles > 0 && x.lastPct < 100 {
 		log("      Extracting... 100%%")
-		emitEvent("extract_package", "running", "Extracting", intPtr(100), int64(totalFiles), int64(totalFiles), "")
+		emitEvent("extract_package", "running", "Extracting", intPtr(100), int64(x.totalFiles), int64(x.totalFiles), "")
+	}
+	return x.count
+}
+
+// rejectSymlinkParents 拒绝经 dest 内已解出的符号链接写入：路径检查只是字面的，
+// 链接串联（如 x -> .、x/y/l -> ../..）可让后续条目落到 dest 之外
+func rejectSymlinkParents(dest, name string) error {
+	current := filepath.Clean(dest)
+	for _, part := range strings.Split(filepath.Dir(filepath.Clean(filepath.FromSlash(name))), string(os.PathSeparator)) {
+		if part == "." || part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("illegal file path in package: %s (through symlink %s)", name, current)
+		}
 	}
+	return nil
+}
 
-	return count, nil
+// extractTarget resolves a zip entry under dest and rejects absolute names and
+// entries that escape dest after cleaning (zip slip).
+func extractTarget(dest, name string) (string, error) {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("illegal file path in package: %s", name)
+	}
+	destClean := filepath.Clean(dest)
+	target := filepath.Join(destClean, name)
+	rel, err := filepath.Rel(destClean, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal file path in package: %s", name)
+	}
+	return target, nil
 }
 
 // detectCommonPrefix finds a common top-level directory prefix if all files share one
-func detectCommonPrefix(files []*zip.File) string {
-	if len(files) == 0 {
+func detectCommonPrefix(names []string) string {
+	if len(names) == 0 {
 		return ""
 	}
 
 	var prefix string
-	for _, f := range files {
-		name := f.Name
+	for _, name := range names {
 		// Get the first path component
 		idx := strings.Index(name, "/")
 		if idx == -1 {
@@ -793,86 +1473,190 @@ func detectCommonPrefix(files []*zip.File) string {
 	return prefix
 }
 
+// sidecarConfig 为 collector-sidecar 读取的 sidecar.yml，由 yaml 库序列化以正确转义各字段
+type sidecarConfig struct {
+	ServerURL                       string   `yaml:"server_url"`
+	ServerAPIToken                  string   `yaml:"server_api_token"`
+	NodeID                          string   `yaml:"node_id"`
+	NodeName                        string   `yaml:"node_name"`
+	UpdateInterval                  int      `yaml:"update_interval"`
+	TLSSkipVerify                   bool     `yaml:"tls_skip_verify"`
+	SendStatus                      bool     `yaml:"send_status"`
+	CachePath                       string   `yaml:"cache_path"`
+	LogPath                         string   `yaml:"log_path"`
+	CollectorConfigurationDirectory string   `yaml:"collector_configuration_directory"`
+	Tags                            []string `yaml:"tags,flow"`
+	CollectorBinariesAccesslist     []string `yaml:"collector_binaries_accesslist"`
+}
+
 func writeConfig(cfg *Config) error {
-	escapePath := func(p string) string {
-		return strings.ReplaceAll(p, `\`, `\\`)
-	}
-	installDir := escapePath(cfg.InstallDir)
-
-	content := fmt.Sprintf(`server_url: "%s"
-server_api_token: "%s"
-node_id: "%s"
-node_name: "%s"
-update_interval: 10
-tls_skip_verify: true
-send_status: true
-cache_path: "%s\\cache"
-log_path: "%s\\logs"
-collector_configuration_directory: "%s\\generated"
-tags: ["zone:%s", "group:%s", "cpu_architecture:%s"]
-collector_binaries_accesslist:
-  - "%s\\bin\\*"
-  - "%s\\bin\\*\\*"
-`,
-		cfg.ServerURL,
-		cfg.APIToken,
-		cfg.NodeID,
-		cfg.NodeName,
-		installDir, installDir, installDir,
-		cfg.ZoneID, cfg.GroupID, cfg.Package.CPUArchitecture,
-		installDir, installDir,
-	)
+	content, err := yaml.Marshal(sidecarConfig{
+		ServerURL:                       cfg.ServerURL,
+		ServerAPIToken:                  cfg.APIToken,
+		NodeID:                          cfg.NodeID,
+		NodeName:                        cfg.NodeName,
+		UpdateInterval:                  10,
+		TLSSkipVerify:                   *skipTLS,
+		SendStatus:                      true,
+		CachePath:                       filepath.Join(cfg.InstallDir, "cache"),
+		LogPath:                         filepath.Join(cfg.InstallDir, "logs"),
+		CollectorConfigurationDirectory: filepath.Join(cfg.InstallDir, "generated"),
+		Tags: []string{
+			"zone:" + cfg.ZoneID,
+			"group:" + cfg.GroupID,
+			"cpu_architecture:" + cfg.Package.CPUArchitecture,
+		},
+		CollectorBinariesAccesslist: []string{
+			filepath.Join(cfg.InstallDir, "bin", "*"),
+			filepath.Join(cfg.InstallDir, "bin", "*", "*"),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encode sidecar.yml: %w", err)
+	}
 
-	return os.WriteFile(filepath.Join(cfg.InstallDir, "sidecar.yml"), []byte(content), 0644)
+	return os.WriteFile(filepath.Join(cfg.InstallDir, "sidecar.yml"), content, 0644)
 }
 
-func registerService(installDir string) error {
-	exePath := filepath.Join(installDir, "collector-sidecar.exe")
-	cfgPath := filepath.Join(installDir, "sidecar.yml")
-	logPath := filepath.Join(installDir, "logs")
+// sidecarServiceName 为各平台注册的服务名，launchd 下作为 label 后缀
+const sidecarServiceName = "sidecar"
+
+// ServiceSpec 描述要注册的 collector-sidecar 服务
+type ServiceSpec struct {
+	Name        string
+	DisplayName string
+	Description string
+	ExePath     string
+	ConfigPath  string
+	LogPath     string
+}
 
-	if _, err := os.Stat(exePath); os.IsNotExist(err) {
-		return fmt.Errorf("collector-sidecar.exe not found at %s", exePath)
+// ServiceManager 将 collector-sidecar 注册为开机自启的系统服务并启动，按平台分别实现
+type ServiceManager interface {
+	Install(spec ServiceSpec) error
+	Uninstall(spec ServiceSpec) error
+	// Exists 判断服务是否已注册，用于区分本次新建与覆盖已有服务
+	Exists(spec ServiceSpec) bool
+}
+
+var (
+	// runServiceCommand 执行服务管理命令并返回合并输出，测试可替换
+	runServiceCommand = func(name string, args ...string) ([]byte, error) {
+		return exec.Command(name, args...).CombinedOutput()
 	}
+	serviceSleep    = time.Sleep
+	systemdUnitDir  = "/etc/systemd/system"
+	launchdPlistDir = "/Library/LaunchDaemons"
+)
 
-	if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
-		return fmt.Errorf("sidecar.yml not found at %s", cfgPath)
+// serviceManagerFor 按 GOOS 选择服务管理实现：Windows 用 sc.exe，Linux 用 systemd，macOS 用 launchd
+func serviceManagerFor(goos string) (ServiceManager, error) {
+	switch goos {
+	case "windows":
+		return windowsServiceManager{}, nil
+	case "linux":
+		return systemdServiceManager{unitDir: systemdUnitDir}, nil
+	case "darwin":
+		return launchdServiceManager{plistDir: launchdPlistDir}, nil
+	}
+	return nil, fmt.Errorf("service registration is not supported on %s", goos)
+}
+
+// sidecarBinaryName 返回安装包中 collector-sidecar 可执行文件名
+func sidecarBinaryName(goos string) string {
+	if goos == "windows" {
+		return "collector-sidecar.exe"
 	}
+	return "collector-sidecar"
+}
 
-	binPath := fmt.Sprintf(`"%s" -c "%s"`, exePath, cfgPath)
+func sidecarServiceSpec(goos, installDir string) ServiceSpec {
+	return ServiceSpec{
+		Name:        sidecarServiceName,
+		DisplayName: "Collector Sidecar",
+		Description: "Collector Sidecar - Log and metric collector agent",
+		ExePath:     filepath.Join(installDir, sidecarBinaryName(goos)),
+		ConfigPath:  filepath.Join(installDir, "sidecar.yml"),
+		LogPath:     filepath.Join(installDir, "logs"),
+	}
+}
 
-	exec.Command("sc.exe", "stop", "sidecar").Run()
-	time.Sleep(time.Second)
-	exec.Command("sc.exe", "delete", "sidecar").Run()
-	time.Sleep(time.Second)
+func registerService(installDir string, rollback *rollbackStack) error {
+	return registerServiceFor(runtime.GOOS, installDir, rollback)
+}
+
+// registerServiceFor 在安装服务前注册卸载动作，服务已创建但启动失败时同样会被回滚；服务此前已存在时不回滚
+func registerServiceFor(goos, installDir string, rollback *rollbackStack) error {
+	manager, err := serviceManagerFor(goos)
+	if err != nil {
+		return err
+	}
+
+	spec := sidecarServiceSpec(goos, installDir)
+	if _, err := os.Stat(spec.ExePath); os.IsNotExist(err) {
+		return fmt.Errorf("%s not found at %s", filepath.Base(spec.ExePath), spec.ExePath)
+	}
 
-	out, err := exec.Command("sc.exe", "create", "sidecar",
+	if _, err := os.Stat(spec.ConfigPath); os.IsNotExist(err) {
+		return fmt.Errorf("sidecar.yml not found at %s", spec.ConfigPath)
+	}
+
+	// 覆盖已有服务时失败不卸载，避免回滚把此前正常运行的服务一并删除
+	if !manager.Exists(spec) {
+		rollback.push("service "+spec.Name, func() error {
+			return manager.Uninstall(spec)
+		})
+	}
+	return manager.Install(spec)
+}
+
+// waitServiceRunning 每秒检查一次服务状态，最多等待 10 秒
+func waitServiceRunning(running func() bool) bool {
+	for i := 0; i < 10; i++ {
+		serviceSleep(time.Second)
+		if running() {
+			log("      Service is running")
+			return true
+		}
+	}
+	return false
+}
+
+type windowsServiceManager struct{}
+
+func (windowsServiceManager) Install(spec ServiceSpec) error {
+	binPath := fmt.Sprintf(`"%s" -c "%s"`, spec.ExePath, spec.ConfigPath)
+
+	runServiceCommand("sc.exe", "stop", spec.Name)
+	serviceSleep(time.Second)
+	runServiceCommand("sc.exe", "delete", spec.Name)
+	serviceSleep(time.Second)
+
+	out, err := runServiceCommand("sc.exe", "create", spec.Name,
 		"binPath=", binPath,
 		"start=", "auto",
-		"DisplayName=", "Collector Sidecar",
-	).CombinedOutput()
+		"DisplayName=", spec.DisplayName,
+	)
 	if err != nil {
 		return fmt.Errorf("sc create failed: %s\n\nTroubleshooting:\n  1. Run as Administrator\n  2. Check: sc.exe query sidecar\n  3. Manual delete: sc.exe delete sidecar", strings.TrimSpace(string(out)))
 	}
 
-	exec.Command("sc.exe", "description", "sidecar", "Collector Sidecar - Log and metric collector agent").Run()
+	runServiceCommand("sc.exe", "description", spec.Name, spec.Description)
 
-	out, err = exec.Command("sc.exe", "start", "sidecar").CombinedOutput()
+	out, err = runServiceCommand("sc.exe", "start", spec.Name)
 	if err != nil {
-		return serviceStartError(string(out), exePath, cfgPath, logPath)
+		return serviceStartError(string(out), spec.ExePath, spec.ConfigPath, spec.LogPath)
 	}
 
-	for i := 0; i < 10; i++ {
-		time.Sleep(time.Second)
-		out, _ := exec.Command("sc.exe", "query", "sidecar").Output()
-		if strings.Contains(string(out), "RUNNING") {
-			log("      Service is running")
-			return nil
-		}
+	if waitServiceRunning(func() bool {
+		out, _ := runServiceCommand("sc.exe", "query", spec.Name)
+		return strings.Contains(string(out), "RUNNING")
+	}) {
+		return nil
 	}
 
-	out, _ = exec.Command("sc.exe", "query", "sidecar").Output()
-	return serviceStartError(string(out), exePath, cfgPath, logPath)
+	out, _ = runServiceCommand("sc.exe", "query", spec.Name)
+	return serviceStartError(string(out), spec.ExePath, spec.ConfigPath, spec.LogPath)
 }
 
 func serviceStartError(scOutput, exePath, cfgPath, logPath string) error {
@@ -904,3 +1688,394 @@ Troubleshooting steps:
      sc.exe create sidecar binPath= "..." start= auto`,
 		strings.TrimSpace(scOutput), exePath, cfgPath, logPath, cfgPath)
 }
+
+func (windowsServiceManager) Exists(spec ServiceSpec) bool {
+	_, err := runServiceCommand("sc.exe", "query", spec.Name)
+	return err == nil
+}
+
+func (windowsServiceManager) Uninstall(spec ServiceSpec) error {
+	runServiceCommand("sc.exe", "stop", spec.Name)
+	serviceSleep(time.Second)
+	out, err := runServiceCommand("sc.exe", "delete", spec.Name)
+	// 1060: 服务不存在
+	if err != nil && !strings.Contains(string(out), "1060") {
+		return fmt.Errorf("sc delete failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+type systemdServiceManager struct {
+	unitDir string
+}
+
+// Install 写入 systemd unit 并 systemctl enable --now，已有服务先停止以加载新版本
+func (m systemdServiceManager) Install(spec ServiceSpec) error {
+	unitPath := filepath.Join(m.unitDir, spec.Name+".service")
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart="%s" -c "%s"
+WorkingDirectory=%s
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=multi-user.target
+`, spec.Description, spec.ExePath, spec.ConfigPath, filepath.Dir(spec.ExePath))
+
+	runServiceCommand("systemctl", "stop", spec.Name)
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("write systemd unit %s: %w\n\nTroubleshooting:\n  1. Run as root", unitPath, err)
+	}
+	if out, err := runServiceCommand("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %s", strings.TrimSpace(string(out)))
+	}
+	if out, err := runServiceCommand("systemctl", "enable", "--now", spec.Name); err != nil {
+		return systemdStartError(string(out), spec)
+	}
+
+	if waitServiceRunning(func() bool {
+		out, err := runServiceCommand("systemctl", "is-active", spec.Name)
+		return err == nil && strings.TrimSpace(string(out)) == "active"
+	}) {
+		return nil
+	}
+
+	out, _ := runServiceCommand("systemctl", "status", "--no-pager", spec.Name)
+	return systemdStartError(string(out), spec)
+}
+
+func (m systemdServiceManager) Exists(spec ServiceSpec) bool {
+	_, err := os.Stat(filepath.Join(m.unitDir, spec.Name+".service"))
+	return err == nil
+}
+
+func (m systemdServiceManager) Uninstall(spec ServiceSpec) error {
+	runServiceCommand("systemctl", "disable", "--now", spec.Name)
+	if err := removePaths([]string{filepath.Join(m.unitDir, spec.Name+".service")}); err != nil {
+		return fmt.Errorf("remove systemd unit: %w", err)
+	}
+	if out, err := runServiceCommand("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %s", strings.TrimSpace(string(out)))
+	}
+	// 清除 failed 状态残留，服务从未失败时命令报错可忽略
+	runServiceCommand("systemctl", "reset-failed", spec.Name)
+	return nil
+}
+
+func systemdStartError(output string, spec ServiceSpec) error {
+	return fmt.Errorf(`service failed to start
+
+systemctl output:
+%s
+
+Troubleshooting steps:
+  1. Check service status:
+     systemctl status %s
+     journalctl -u %s -n 100
+
+  2. Test executable directly:
+     "%s" -c "%s"
+
+  3. Check logs:
+     ls -l "%s"`,
+		strings.TrimSpace(output), spec.Name, spec.Name, spec.ExePath, spec.ConfigPath, spec.LogPath)
+}
+
+type launchdServiceManager struct {
+	plistDir string
+}
+
+func launchdLabel(name string) string {
+	return "com.bklite.collector-" + name
+}
+
+// Install 写入 LaunchDaemon plist 并 launchctl load，已加载的任务先卸载
+func (m launchdServiceManager) Install(spec ServiceSpec) error {
+	label := launchdLabel(spec.Name)
+	plistPath := filepath.Join(m.plistDir, label+".plist")
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>Label</key>
+  <string>%s</string>
+  <key>ProgramArguments</key>
+  <array>
+    <string>%s</string>
+    <string>-c</string>
+    <string>%s</string>
+  </array>
+  <key>WorkingDirectory</key>
+  <string>%s</string>
+  <key>RunAtLoad</key>
+  <true/>
+  <key>KeepAlive</key>
+  <true/>
+  <key>StandardOutPath</key>
+  <string>%s</string>
+  <key>StandardErrorPath</key>
+  <string>%s</string>
+</dict>
+</plist>
+`, xmlText(label), xmlText(spec.ExePath), xmlText(spec.ConfigPath), xmlText(filepath.Dir(spec.ExePath)),
+		xmlText(filepath.Join(spec.LogPath, "launchd.out.log")), xmlText(filepath.Join(spec.LogPath, "launchd.err.log")))
+
+	runServiceCommand("launchctl", "unload", plistPath)
+	if err := os.WriteFile(plistPath, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("write launchd plist %s: %w\n\nTroubleshooting:\n  1. Run with sudo", plistPath, err)
+	}
+	if out, err := runServiceCommand("launchctl", "load", "-w", plistPath); err != nil {
+		return launchdStartError(string(out), label, plistPath, spec)
+	}
+
+	if waitServiceRunning(func() bool {
+		out, err := runServiceCommand("launchctl", "list", label)
+		return err == nil && strings.Contains(string(out), `"PID"`)
+	}) {
+		return nil
+	}
+
+	out, _ := runServiceCommand("launchctl", "list", label)
+	return launchdStartError(string(out), label, plistPath, spec)
+}
+
+func (m launchdServiceManager) Exists(spec ServiceSpec) bool {
+	_, err := os.Stat(filepath.Join(m.plistDir, launchdLabel(spec.Name)+".plist"))
+	return err == nil
+}
+
+func (m launchdServiceManager) Uninstall(spec ServiceSpec) error {
+	plistPath := filepath.Join(m.plistDir, launchdLabel(spec.Name)+".plist")
+	runServiceCommand("launchctl", "unload", plistPath)
+	if err := removePaths([]string{plistPath}); err != nil {
+		return fmt.Errorf("remove launchd plist: %w", err)
+	}
+	return nil
+}
+
+func launchdStartError(output, label, plistPath string, spec ServiceSpec) error {
+	return fmt.Errorf(`service failed to start
+
+launchctl output:
+%s
+
+Troubleshooting steps:
+  1. Check service status:
+     sudo launchctl list %s
+
+  2. Test executable directly:
+     "%s" -c "%s"
+
+  3. Check logs:
+     ls -l "%s"
+
+  4. Manual service control:
+     sudo launchctl unload %s
+     sudo launchctl load -w %s`,
+		strings.TrimSpace(output), label, spec.ExePath, spec.ConfigPath, spec.LogPath, plistPath, plistPath)
+}
+
+func xmlText(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// uninstall 停止并删除服务后删除安装目录。服务或目录不存在时视为已卸载，可重复执行
+func uninstall(goos, installDir string, keepLogs bool) error {
+	manager, err := serviceManagerFor(goos)
+	if err != nil {
+		return err
+	}
+
+	log("[1/2] Removing service...")
+	if err := manager.Uninstall(sidecarServiceSpec(goos, installDir)); err != nil {
+		return fmt.Errorf("remove service: %w", err)
+	}
+
+	log("[2/2] Removing %s...", installDir)
+	if err := removeInstallDir(goos, installDir, keepLogs); err != nil {
+		return fmt.Errorf("remove install dir: %w", err)
+	}
+
+	log("Uninstall complete!")
+	return nil
+}
+
+// removeInstallDir 删除安装目录，keepLogs 时保留 logs 子目录。目录中既无 sidecar.yml 也无
+// collector-sidecar 可执行文件时拒绝删除，避免 -install-dir 写错时误删无关目录
+func removeInstallDir(goos, installDir string, keepLogs bool) error {
+	if filepath.Dir(installDir) == installDir {
+		return fmt.Errorf("refusing to remove root directory %s", installDir)
+	}
+	entries, err := os.ReadDir(installDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !looksLikeSidecarInstall(goos, installDir, entries) {
+		return fmt.Errorf("refusing to remove %s: no sidecar.yml or %s found, not a sidecar install directory", installDir, sidecarBinaryName(goos))
+	}
+	if !keepLogs {
+		return os.RemoveAll(installDir)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.Name() != "logs" {
+			paths = append(paths, filepath.Join(installDir, entry.Name()))
+		}
+	}
+	return removePaths(paths)
+}
+
+// looksLikeSidecarInstall 判断目录是否为 sidecar 安装目录；此前 --keep-logs 卸载后只剩 logs 的目录同样视为安装目录
+func looksLikeSidecarInstall(goos, installDir string, entries []os.DirEntry) bool {
+	if len(entries) == 1 && entries[0].Name() == "logs" && entries[0].IsDir() {
+		return true
+	}
+	for _, name := range []string{"sidecar.yml", sidecarBinaryName(goos)} {
+		if _, err := os.Stat(filepath.Join(installDir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// healthCheck 在服务启动后确认 collector 真正就绪：本地健康端点返回 2xx，或日志中出现成功标志
+type healthCheck struct {
+	URL        string
+	LogPattern *regexp.Regexp
+	LogDir     string
+	Timeout    time.Duration
+	// logOffsets 为服务启动前各日志文件的大小，重装时旧日志中的成功标志不算数
+	logOffsets map[string]int64
+}
+
+var (
+	healthCheckInterval = 2 * time.Second
+	healthLogTailLines  = 20
+)
+
+// healthCheckFromFlags 未指定 -health-url 与 -health-log-pattern 时返回 nil，表示跳过健康检查
+func healthCheckFromFlags(installDir string) (*healthCheck, error) {
+	if *healthURL == "" && *healthLogPattern == "" {
+		return nil, nil
+	}
+	check := &healthCheck{URL: *healthURL, LogDir: filepath.Join(installDir, "logs"), Timeout: *healthTimeout}
+	if *healthLogPattern != "" {
+		pattern, err := regexp.Compile(*healthLogPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -health-log-pattern: %w", err)
+		}
+		check.LogPattern = pattern
+	}
+	return check, nil
+}
+
+// wait 轮询直到探测通过或超时，超时时附上最近的日志片段
+func (c *healthCheck) wait() error {
+	client := &http.Client{Timeout: healthCheckInterval}
+	deadline := time.Now().Add(c.Timeout)
+	var lastErr error
+	for {
+		if lastErr = c.probe(client); lastErr == nil {
+			return nil
+		}
+		if time.Now().Add(healthCheckInterval).After(deadline) {
+			break
+		}
+		time.Sleep(healthCheckInterval)
+	}
+
+	message := fmt.Sprintf("collector not healthy after %s: %v", c.Timeout, lastErr)
+	if path, tail := recentLogTail(c.LogDir, healthLogTailLines); path != "" {
+		message += fmt.Sprintf("\n\nRecent log (%s):\n%s", path, tail)
+	}
+	return errors.New(message)
+}
+
+func (c *healthCheck) probe(client *http.Client) error {
+	if c.URL != "" {
+		resp, err := client.Get(c.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("health endpoint returned HTTP %d", resp.StatusCode)
+		}
+	}
+	if c.LogPattern != nil && !logsMatch(c.LogDir, c.LogPattern, c.logOffsets) {
+		return fmt.Errorf("pattern %q not found in %s", c.LogPattern, c.LogDir)
+	}
+	return nil
+}
+
+// markLogOffsets 记录 LogDir 中现有日志文件的大小
+func (c *healthCheck) markLogOffsets() {
+	c.logOffsets = map[string]int64{}
+	entries, _ := os.ReadDir(c.LogDir)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			c.logOffsets[entry.Name()] = info.Size()
+		}
+	}
+}
+
+// logsMatch 只在 offsets 之后新写入的内容中匹配；文件比记录时短说明已轮转或截断，从头匹配
+func logsMatch(dir string, pattern *regexp.Regexp, offsets map[string]int64) bool {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if offset, ok := offsets[entry.Name()]; ok && offset <= int64(len(content)) {
+			content = content[offset:]
+		}
+		if pattern.Match(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// recentLogTail 返回 dir 中最近修改的日志文件及其末尾 lines 行
+func recentLogTail(dir string, lines int) (string, string) {
+	entries, _ := os.ReadDir(dir)
+	var latest string
+	var latestMod time.Time
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestMod) {
+			latest, latestMod = filepath.Join(dir, entry.Name()), info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", ""
+	}
+	content, err := os.ReadFile(latest)
+	if err != nil {
+		return "", ""
+	}
+	all := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return latest, strings.Join(all, "\n")
+}
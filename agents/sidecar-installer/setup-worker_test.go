@@ -1,14 +1,32 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func captureStdout(t *testing.T, fn func()) string {
@@ -166,6 +184,350 @@ func TestLinuxInstallerAPITokenInputsKeepsEmptyTokenOnArgv(t *testing.T) {
 	}
 }
 
+func TestCleanupPackageHonorsKeepFlag(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.zip")
+	removed := filepath.Join(dir, "removed.zip")
+	for _, path := range []string{kept, removed} {
+		if err := os.WriteFile(path, []byte("zip"), 0o600); err != nil {
+			t.Fatalf("write package: %v", err)
+		}
+	}
+
+	output := captureStdout(t, func() { cleanupPackage(kept, true) })
+	if !strings.Contains(output, kept) {
+		t.Fatalf("expected kept package path to be logged, got %q", output)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("expected package to be kept: %v", err)
+	}
+
+	cleanupPackage(removed, false)
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Fatalf("expected package to be removed, stat err=%v", err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create zip: %v", err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range entries {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("create entry %s: %v", name, err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatalf("write entry %s: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+}
+
+var testExtractLimits = extractLimits{MaxFileBytes: 1 << 20, MaxTotalBytes: 4 << 20, MaxFiles: 100}
+
+func TestExtractEnforcesLimits(t *testing.T) {
+	entries := map[string]string{
+		"sidecar/bin/collector":  strings.Repeat("a", 600),
+		"sidecar/etc/app.conf":   strings.Repeat("b", 600),
+		"sidecar/etc/extra.conf": "c",
+	}
+	for name, limits := range map[string]extractLimits{
+		"file count":  {MaxFileBytes: 1 << 20, MaxTotalBytes: 1 << 20, MaxFiles: 2},
+		"single file": {MaxFileBytes: 500, MaxTotalBytes: 1 << 20, MaxFiles: 100},
+		"total size":  {MaxFileBytes: 1 << 20, MaxTotalBytes: 1000, MaxFiles: 100},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dest := t.TempDir()
+			zipPath := filepath.Join(t.TempDir(), "bomb.zip")
+			writeTestZip(t, zipPath, entries)
+
+			var err error
+			captureStdout(t, func() { _, err = extract(zipPath, dest, limits) })
+			if !errors.Is(err, errExtractLimit) || classifyExtractError(err) != "package_invalid" {
+				t.Fatalf("expected extract limit error, got %v", err)
+			}
+			if remaining, _ := os.ReadDir(dest); len(remaining) != 0 {
+				t.Fatalf("expected nothing to be extracted, found %v", remaining)
+			}
+		})
+	}
+}
+
+type testTarEntry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []testTarEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create tar.gz: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: entry.typeflag, Linkname: entry.linkname}
+		if entry.typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("write header %s: %v", entry.name, err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("write entry %s: %v", entry.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+}
+
+func TestExtractTarGzStripsCommonPrefix(t *testing.T) {
+	dest := t.TempDir()
+	// 包名刻意使用 .zip 后缀，格式只按魔数判断
+	archivePath := filepath.Join(t.TempDir(), "sidecar-1.zip")
+	writeTestTarGz(t, archivePath, []testTarEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "./sidecar/", typeflag: tar.TypeDir},
+		{name: "./sidecar/bin/collector", content: "binary"},
+		{name: "./sidecar/etc/app.conf", content: "conf"},
+		{name: "./sidecar/bin/current", typeflag: tar.TypeSymlink, linkname: "collector"},
+	})
+
+	var count int
+	var err error
+	output := captureStdout(t, func() { count, err = extract(archivePath, dest, testExtractLimits) })
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 extracted entries, got %d (err=%v)", count, err)
+	}
+	if got := readTestFile(t, filepath.Join(dest, "bin", "collector")); got != "binary" {
+		t.Fatalf("unexpected collector content %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(dest, "etc", "app.conf")); got != "conf" {
+		t.Fatalf("unexpected app.conf content %q", got)
+	}
+	if runtime.GOOS != "windows" {
+		if link, err := os.Readlink(filepath.Join(dest, "bin", "current")); err != nil || link != "collector" {
+			t.Fatalf("expected symlink to collector, got %q (err=%v)", link, err)
+		}
+	}
+	if !strings.Contains(output, "Extracting... 100%") {
+		t.Fatalf("expected extract progress output, got:\n%s", output)
+	}
+}
+
+func TestExtractTarGzRejectsTraversalAndLimits(t *testing.T) {
+	base := t.TempDir()
+	dest := filepath.Join(base, "install")
+	for name, tc := range map[string]struct {
+		entries []testTarEntry
+		limits  extractLimits
+		want    string
+	}{
+		"path traversal":  {entries: []testTarEntry{{name: "sidecar/ok", content: "ok"}, {name: "sidecar/../../evil.txt", content: "pwned"}}, limits: testExtractLimits, want: "illegal file path in package"},
+		"symlink escape":  {entries: []testTarEntry{{name: "sidecar/ok", content: "ok"}, {name: "sidecar/evil", typeflag: tar.TypeSymlink, linkname: "../../../etc/passwd"}}, limits: testExtractLimits, want: "illegal file path in package"},
+		"absolute link":   {entries: []testTarEntry{{name: "sidecar/ok", content: "ok"}, {name: "sidecar/evil", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}, limits: testExtractLimits, want: "illegal file path in package"},
+		"hard link":       {entries: []testTarEntry{{name: "sidecar/evil", typeflag: tar.TypeLink, linkname: "/etc/passwd"}}, limits: testExtractLimits, want: "unsupported tar entry type"},
+		"single file cap": {entries: []testTarEntry{{name: "sidecar/big", content: strings.Repeat("x", 600)}}, limits: extractLimits{MaxFileBytes: 500, MaxTotalBytes: 1 << 20, MaxFiles: 100}, want: errExtractLimit.Error()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "malicious.tar.gz")
+			writeTestTarGz(t, archivePath, tc.entries)
+
+			var err error
+			captureStdout(t, func() { _, err = extract(archivePath, dest, tc.limits) })
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q error, got %v", tc.want, err)
+			}
+			if _, statErr := os.Lstat(filepath.Join(base, "evil.txt")); !os.IsNotExist(statErr) {
+				t.Fatalf("traversal entry escaped the install dir (stat err=%v)", statErr)
+			}
+		})
+	}
+}
+
+func TestExtractTarGzRejectsWritesThroughSymlinkChain(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires symlinks")
+	}
+	base := t.TempDir()
+	dest := filepath.Join(base, "install")
+	archivePath := filepath.Join(t.TempDir(), "chain.tar.gz")
+	writeTestTarGz(t, archivePath, []testTarEntry{
+		{name: "x", typeflag: tar.TypeSymlink, linkname: "."},
+		{name: "x/y/l", typeflag: tar.TypeSymlink, linkname: "../.."},
+		{name: "x/y/l/evil", content: "pwned"},
+	})
+
+	var err error
+	captureStdout(t, func() { _, err = extract(archivePath, dest, testExtractLimits) })
+	if err == nil || !strings.Contains(err.Error(), "illegal file path in package") {
+		t.Fatalf("expected symlink chain to be rejected, got %v", err)
+	}
+	if _, statErr := os.Lstat(filepath.Join(base, "evil")); !os.IsNotExist(statErr) {
+		t.Fatalf("symlink chain escaped the install dir (stat err=%v)", statErr)
+	}
+}
+
+func TestExtractRejectsUnknownFormat(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "package.bin")
+	if err := os.WriteFile(archivePath, []byte("not an archive"), 0644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	_, err := extract(archivePath, t.TempDir(), testExtractLimits)
+	if err == nil || classifyExtractError(err) != "package_invalid" {
+		t.Fatalf("expected package_invalid error, got %v", err)
+	}
+}
+
+func TestExtractRejectsZipSlipEntries(t *testing.T) {
+	base := t.TempDir()
+	dest := filepath.Join(base, "install")
+	for name, entry := range map[string]string{
+		"relative traversal": "sidecar/../../evil.txt",
+		"nested traversal":   "sidecar/bin/../../../evil.txt",
+	} {
+		t.Run(name, func(t *testing.T) {
+			zipPath := filepath.Join(t.TempDir(), "malicious.zip")
+			writeTestZip(t, zipPath, map[string]string{"sidecar/bin/collector": "ok", entry: "pwned"})
+
+			var err error
+			captureStdout(t, func() { _, err = extract(zipPath, dest, testExtractLimits) })
+			if err == nil || !strings.Contains(err.Error(), "illegal file path in package") {
+				t.Fatalf("expected zip slip entry to be rejected, got %v", err)
+			}
+			if _, statErr := os.Stat(filepath.Join(base, "evil.txt")); !os.IsNotExist(statErr) {
+				t.Fatalf("traversal entry escaped the install dir (stat err=%v)", statErr)
+			}
+		})
+	}
+}
+
+func TestExtractStripsCommonPrefix(t *testing.T) {
+	dest := t.TempDir()
+	zipPath := filepath.Join(t.TempDir(), "sidecar.zip")
+	writeTestZip(t, zipPath, map[string]string{"sidecar/bin/collector": "ok", "sidecar/etc/app.conf": "conf"})
+
+	var count int
+	var err error
+	captureStdout(t, func() { count, err = extract(zipPath, dest, testExtractLimits) })
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 extracted files, got %d (err=%v)", count, err)
+	}
+	if data, readErr := os.ReadFile(filepath.Join(dest, "bin", "collector")); readErr != nil || string(data) != "ok" {
+		t.Fatalf("unexpected extracted content %q (err=%v)", data, readErr)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkWritable(dir); err != nil {
+		t.Fatalf("expected writable dir, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected probe file to be removed, found %d entries", len(entries))
+	}
+
+	if err := checkWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected missing dir to fail writability check")
+	}
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		return
+	}
+	readOnly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := checkWritable(readOnly); err == nil {
+		t.Fatal("expected read-only dir to fail writability check")
+	}
+}
+
+func TestNewHTTPClientVerifiesCAFileAndPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caPath, caPEM, 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+	pin := spkiSHA256(server.Certificate())
+
+	get := func(client *http.Client) error {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	client, err := newHTTPClient(false, caPath, "")
+	if err != nil {
+		t.Fatalf("build client: %v", err)
+	}
+	if err := get(client); err != nil {
+		t.Fatalf("expected CA file to be trusted, got %v", err)
+	}
+
+	client, _ = newHTTPClient(false, "", "")
+	if err := get(client); err == nil {
+		t.Fatal("expected unknown CA to be rejected without -ca-file")
+	}
+
+	client, _ = newHTTPClient(true, "", "sha256/"+pin)
+	if err := get(client); err != nil {
+		t.Fatalf("expected matching pin to pass, got %v", err)
+	}
+
+	wrongPin := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	client, _ = newHTTPClient(false, caPath, wrongPin)
+	if err := get(client); !errors.Is(err, errPinMismatch) {
+		t.Fatalf("expected pin mismatch error, got %v", err)
+	}
+}
+
+func TestNewHTTPClientRejectsInvalidTLSOptions(t *testing.T) {
+	if _, err := newHTTPClient(true, "", "not-a-hash"); err == nil {
+		t.Fatal("expected invalid pin to be rejected")
+	}
+	if _, err := newHTTPClient(true, filepath.Join(t.TempDir(), "missing.pem"), ""); err == nil {
+		t.Fatal("expected missing ca file to be rejected")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not pem"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := newHTTPClient(true, empty, ""); err == nil {
+		t.Fatal("expected ca file without certificates to be rejected")
+	}
+}
+
 func readTestFile(t *testing.T, path string) string {
 	t.Helper()
 	content, err := os.ReadFile(path)
@@ -186,3 +548,692 @@ func equalStringSlices(a, b []string) bool {
 	}
 	return true
 }
+
+func TestRunChecksReportsEachCheck(t *testing.T) {
+	originalProbe := probePackageFn
+	t.Cleanup(func() { probePackageFn = originalProbe })
+	probePackageFn = func(storage *StorageConfig) (uint64, error) {
+		if storage.FileKey == "missing.zip" {
+			return 0, errors.New("get object info failed: nats: object not found")
+		}
+		return 42, nil
+	}
+
+	base := t.TempDir()
+	notDir := filepath.Join(base, "file")
+	if err := os.WriteFile(notDir, []byte("x"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		status     int
+		body       string
+		installDir string
+		pass       bool
+		want       []string
+	}{
+		{
+			name:       "all pass with missing install dir",
+			status:     http.StatusOK,
+			body:       `{"node_id":"n1","os":"linux","storage":{"bucket":"b","file_key":"pkg.zip","nats_servers":"127.0.0.1:4222"}}`,
+			installDir: filepath.Join(base, "new", "fusion-collectors"),
+			pass:       true,
+			want:       []string{"[PASS] config", "[PASS] package      b/pkg.zip (42 bytes)", "[PASS] install_dir", "Pre-flight check passed"},
+		},
+		{
+			name:       "no package is skipped",
+			status:     http.StatusOK,
+			body:       `{"node_id":"n1"}`,
+			installDir: base,
+			pass:       true,
+			want:       []string{"[SKIP] package", "[PASS] install_dir"},
+		},
+		{
+			name:       "package missing and install dir not a directory",
+			status:     http.StatusOK,
+			body:       `{"node_id":"n1","storage":{"bucket":"b","file_key":"missing.zip","nats_servers":"127.0.0.1:4222"}}`,
+			installDir: filepath.Join(notDir, "sub"),
+			want:       []string{"[FAIL] package      get object info failed", "[FAIL] install_dir", "not a directory", "2 check(s) failed"},
+		},
+		{
+			name:   "config unreachable",
+			status: http.StatusNotFound,
+			body:   "session expired",
+			want:   []string{"[FAIL] config       HTTP 404: session expired", "[SKIP] package", "1 check(s) failed"},
+		},
+		{
+			name:   "config missing node id",
+			status: http.StatusOK,
+			body:   `{"server_url":"http://example"}`,
+			want:   []string{"[FAIL] config       config is missing node_id"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				_, _ = io.WriteString(w, tc.body)
+			}))
+			defer server.Close()
+
+			var pass bool
+			output := captureStdout(t, func() {
+				pass = runChecks(server.Client(), server.URL, tc.installDir)
+			})
+			if pass != tc.pass {
+				t.Fatalf("expected pass=%v, output:\n%s", tc.pass, output)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(output, want) {
+					t.Fatalf("expected output to contain %q, got:\n%s", want, output)
+				}
+			}
+			if tc.installDir != "" {
+				if _, err := os.Stat(filepath.Join(base, "new")); !os.IsNotExist(err) {
+					t.Fatalf("check mode must not create directories, stat err=%v", err)
+				}
+			}
+		})
+	}
+}
+
+func withServiceCommandStub(t *testing.T, respond func(name string, args ...string) ([]byte, error)) *[]string {
+	t.Helper()
+	originalRun, originalSleep := runServiceCommand, serviceSleep
+	var calls []string
+	runServiceCommand = func(name string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(append([]string{name}, args...), " "))
+		return respond(name, args...)
+	}
+	serviceSleep = func(time.Duration) {}
+	t.Cleanup(func() { runServiceCommand, serviceSleep = originalRun, originalSleep })
+	return &calls
+}
+
+func writeSidecarFiles(t *testing.T, goos string) string {
+	t.Helper()
+	installDir := t.TempDir()
+	for _, name := range []string{sidecarBinaryName(goos), "sidecar.yml"} {
+		if err := os.WriteFile(filepath.Join(installDir, name), nil, 0755); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	return installDir
+}
+
+func TestServiceManagerForDispatchesByGOOS(t *testing.T) {
+	for goos, want := range map[string]ServiceManager{
+		"windows": windowsServiceManager{},
+		"linux":   systemdServiceManager{unitDir: systemdUnitDir},
+		"darwin":  launchdServiceManager{plistDir: launchdPlistDir},
+	} {
+		got, err := serviceManagerFor(goos)
+		if err != nil || got != want {
+			t.Fatalf("serviceManagerFor(%q) = %#v, %v", goos, got, err)
+		}
+	}
+	if _, err := serviceManagerFor("plan9"); err == nil {
+		t.Fatal("expected unsupported platform error")
+	}
+}
+
+func TestRegisterServiceWritesSystemdUnitAndEnables(t *testing.T) {
+	unitDir := t.TempDir()
+	originalUnitDir := systemdUnitDir
+	systemdUnitDir = unitDir
+	defer func() { systemdUnitDir = originalUnitDir }()
+	calls := withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "is-active" {
+			return []byte("active\n"), nil
+		}
+		return nil, nil
+	})
+
+	installDir := writeSidecarFiles(t, "linux")
+	if err := registerServiceFor("linux", installDir, &rollbackStack{}); err != nil {
+		t.Fatalf("registerServiceFor: %v", err)
+	}
+
+	unit := readTestFile(t, filepath.Join(unitDir, "sidecar.service"))
+	wantExec := `ExecStart="` + filepath.Join(installDir, "collector-sidecar") + `" -c "` + filepath.Join(installDir, "sidecar.yml") + `"`
+	if !strings.Contains(unit, wantExec) || !strings.Contains(unit, "WantedBy=multi-user.target") {
+		t.Fatalf("unexpected unit:\n%s", unit)
+	}
+	want := []string{"systemctl stop sidecar", "systemctl daemon-reload", "systemctl enable --now sidecar", "systemctl is-active sidecar"}
+	if !equalStringSlices(*calls, want) {
+		t.Fatalf("unexpected commands\nwant: %#v\n got: %#v", want, *calls)
+	}
+}
+
+func TestRegisterServiceRollsBackSystemdUnitAfterStartFailure(t *testing.T) {
+	unitDir := t.TempDir()
+	originalUnitDir := systemdUnitDir
+	systemdUnitDir = unitDir
+	defer func() { systemdUnitDir = originalUnitDir }()
+	calls := withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "is-active" {
+			return []byte("failed\n"), errors.New("exit status 3")
+		}
+		if args[0] == "status" {
+			return []byte("Active: failed (Result: exit-code)"), nil
+		}
+		return nil, nil
+	})
+
+	rollback := &rollbackStack{}
+	err := registerServiceFor("linux", writeSidecarFiles(t, "linux"), rollback)
+	if err == nil || !strings.Contains(err.Error(), "Active: failed") || !strings.Contains(err.Error(), "journalctl -u sidecar") {
+		t.Fatalf("expected systemd troubleshooting error, got %v", err)
+	}
+
+	*calls = nil
+	captureStdout(t, func() {
+		if errs := rollback.run(); len(errs) != 0 {
+			t.Fatalf("unexpected rollback errors: %v", errs)
+		}
+	})
+	if _, err := os.Stat(filepath.Join(unitDir, "sidecar.service")); !os.IsNotExist(err) {
+		t.Fatalf("expected unit file to be removed, stat error: %v", err)
+	}
+	want := []string{"systemctl disable --now sidecar", "systemctl daemon-reload", "systemctl reset-failed sidecar"}
+	if !equalStringSlices(*calls, want) {
+		t.Fatalf("unexpected rollback commands\nwant: %#v\n got: %#v", want, *calls)
+	}
+}
+
+func TestRegisterServiceKeepsPreexistingServiceOnFailure(t *testing.T) {
+	unitDir := t.TempDir()
+	originalUnitDir := systemdUnitDir
+	systemdUnitDir = unitDir
+	defer func() { systemdUnitDir = originalUnitDir }()
+	unitPath := filepath.Join(unitDir, "sidecar.service")
+	if err := os.WriteFile(unitPath, []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatalf("write unit: %v", err)
+	}
+	withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "is-active" {
+			return []byte("failed\n"), errors.New("exit status 3")
+		}
+		return nil, nil
+	})
+
+	rollback := &rollbackStack{}
+	if err := registerServiceFor("linux", writeSidecarFiles(t, "linux"), rollback); err == nil {
+		t.Fatal("expected start failure")
+	}
+	captureStdout(t, func() { rollback.run() })
+	if _, err := os.Stat(unitPath); err != nil {
+		t.Fatalf("expected pre-existing unit to survive rollback, stat error: %v", err)
+	}
+}
+
+func TestRegisterServiceWritesLaunchdPlist(t *testing.T) {
+	plistDir := t.TempDir()
+	originalPlistDir := launchdPlistDir
+	launchdPlistDir = plistDir
+	defer func() { launchdPlistDir = originalPlistDir }()
+	calls := withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "list" {
+			return []byte(`{ "PID" = 4242; "Label" = "com.bklite.collector-sidecar"; };`), nil
+		}
+		return nil, nil
+	})
+
+	installDir := writeSidecarFiles(t, "darwin")
+	if err := registerServiceFor("darwin", installDir, &rollbackStack{}); err != nil {
+		t.Fatalf("registerServiceFor: %v", err)
+	}
+
+	plistPath := filepath.Join(plistDir, "com.bklite.collector-sidecar.plist")
+	plist := readTestFile(t, plistPath)
+	if !strings.Contains(plist, "<string>"+filepath.Join(installDir, "collector-sidecar")+"</string>") || !strings.Contains(plist, "<key>RunAtLoad</key>") {
+		t.Fatalf("unexpected plist:\n%s", plist)
+	}
+	want := []string{"launchctl unload " + plistPath, "launchctl load -w " + plistPath, "launchctl list com.bklite.collector-sidecar"}
+	if !equalStringSlices(*calls, want) {
+		t.Fatalf("unexpected commands\nwant: %#v\n got: %#v", want, *calls)
+	}
+}
+
+func TestRegisterServiceUsesScOnWindows(t *testing.T) {
+	calls := withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "query" {
+			return []byte("STATE              : 4  RUNNING"), nil
+		}
+		return nil, nil
+	})
+
+	if err := registerServiceFor("windows", writeSidecarFiles(t, "windows"), &rollbackStack{}); err != nil {
+		t.Fatalf("registerServiceFor: %v", err)
+	}
+	if len(*calls) != 7 || (*calls)[0] != "sc.exe query sidecar" || !strings.HasPrefix((*calls)[3], "sc.exe create sidecar binPath= ") || (*calls)[5] != "sc.exe start sidecar" {
+		t.Fatalf("unexpected commands: %#v", *calls)
+	}
+}
+
+func TestRegisterServiceRequiresPlatformBinary(t *testing.T) {
+	installDir := writeSidecarFiles(t, "windows")
+	err := registerServiceFor("linux", installDir, &rollbackStack{})
+	if err == nil || !strings.Contains(err.Error(), "collector-sidecar not found at") {
+		t.Fatalf("expected missing binary error, got %v", err)
+	}
+}
+
+func readSidecarConfig(t *testing.T, installDir string) (sidecarConfig, map[string]any) {
+	t.Helper()
+	content := readTestFile(t, filepath.Join(installDir, "sidecar.yml"))
+	var parsed sidecarConfig
+	var fields map[string]any
+	if err := yaml.Unmarshal([]byte(content), &parsed); err != nil {
+		t.Fatalf("sidecar.yml is not valid YAML: %v\n%s", err, content)
+	}
+	if err := yaml.Unmarshal([]byte(content), &fields); err != nil {
+		t.Fatalf("sidecar.yml is not a YAML mapping: %v", err)
+	}
+	return parsed, fields
+}
+
+func TestWriteConfigUsesPlatformPaths(t *testing.T) {
+	installDir := t.TempDir()
+	if err := writeConfig(&Config{ServerURL: "https://bk.example", InstallDir: installDir}); err != nil {
+		t.Fatalf("writeConfig: %v", err)
+	}
+	parsed, _ := readSidecarConfig(t, installDir)
+	if parsed.LogPath != filepath.Join(installDir, "logs") || parsed.CollectorBinariesAccesslist[1] != filepath.Join(installDir, "bin", "*", "*") {
+		t.Fatalf("unexpected paths: %+v", parsed)
+	}
+}
+
+func TestWriteConfigEscapesSpecialCharacters(t *testing.T) {
+	installDir := filepath.Join(t.TempDir(), `dir "with": #chars`)
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	cfg := &Config{
+		ServerURL:  "https://bk.example",
+		APIToken:   `to"ken`,
+		NodeID:     "node-1",
+		NodeName:   "web: \"prod\"\nserver_api_token: injected # comment",
+		ZoneID:     "zone, a",
+		GroupID:    "[group]",
+		InstallDir: installDir,
+	}
+	if err := writeConfig(cfg); err != nil {
+		t.Fatalf("writeConfig: %v", err)
+	}
+
+	parsed, fields := readSidecarConfig(t, installDir)
+	if parsed.NodeName != cfg.NodeName || parsed.ServerAPIToken != cfg.APIToken {
+		t.Fatalf("fields did not round-trip: node_name=%q token=%q", parsed.NodeName, parsed.ServerAPIToken)
+	}
+	if parsed.CachePath != filepath.Join(installDir, "cache") {
+		t.Fatalf("unexpected cache_path %q", parsed.CachePath)
+	}
+	if !equalStringSlices(parsed.Tags, []string{"zone:zone, a", "group:[group]", "cpu_architecture:"}) {
+		t.Fatalf("unexpected tags %#v", parsed.Tags)
+	}
+	if len(fields) != 12 {
+		t.Fatalf("expected exactly 12 top-level fields, got %d: %v", len(fields), fields)
+	}
+}
+
+func TestVerifyPackageSHA256(t *testing.T) {
+	sum := sha256.Sum256([]byte("controller package"))
+	if err := verifyPackageSHA256(sum[:], hex.EncodeToString(sum[:])); err != nil {
+		t.Fatalf("expected matching sha256 to pass, got %v", err)
+	}
+	if err := verifyPackageSHA256(sum[:], ""); err != nil {
+		t.Fatalf("expected empty sha256 to skip verification, got %v", err)
+	}
+	if err := verifyPackageSHA256(sum[:], strings.Repeat("0", 64)); !errors.Is(err, errPackageChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestFetchConfigRejectsInvalidPackageSHA256(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"node_id":"node-1","package_sha256":"not-a-digest"}`))
+	}))
+	defer server.Close()
+
+	if _, err := fetchConfig(server.Client(), server.URL); err == nil || !strings.Contains(err.Error(), "invalid package_sha256") {
+		t.Fatalf("expected invalid package_sha256 error, got %v", err)
+	}
+}
+
+func TestRollbackStackRunsInReverseAndKeepsGoingOnError(t *testing.T) {
+	var order []string
+	rollback := &rollbackStack{}
+	for _, name := range []string{"dirs", "config", "service"} {
+		name := name
+		rollback.push(name, func() error {
+			order = append(order, name)
+			if name == "config" {
+				return errors.New("permission denied")
+			}
+			return nil
+		})
+	}
+
+	var errs []error
+	captureStdout(t, func() { errs = rollback.run() })
+	if !equalStringSlices(order, []string{"service", "config", "dirs"}) {
+		t.Fatalf("unexpected rollback order: %v", order)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "config: permission denied") {
+		t.Fatalf("unexpected rollback errors: %v", errs)
+	}
+	if errs := rollback.run(); errs != nil {
+		t.Fatalf("expected rollback to run only once, got %v", errs)
+	}
+}
+
+func TestPrepareDirsReportsOnlyCreatedDirectories(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "fusion-collectors")
+	if err := os.MkdirAll(filepath.Join(base, "logs"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	created, err := prepareDirs(base)
+	if err != nil {
+		t.Fatalf("prepareDirs: %v", err)
+	}
+	want := []string{filepath.Join(base, "bin"), filepath.Join(base, "cache"), filepath.Join(base, "generated")}
+	if !equalStringSlices(created, want) {
+		t.Fatalf("unexpected created dirs\nwant: %#v\n got: %#v", want, created)
+	}
+
+	if err := removePaths(created); err != nil {
+		t.Fatalf("removePaths: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "logs")); err != nil {
+		t.Fatalf("pre-existing directory should survive rollback: %v", err)
+	}
+}
+
+func TestUninstallKeepsLogsAndIsIdempotent(t *testing.T) {
+	unitDir := t.TempDir()
+	originalUnitDir := systemdUnitDir
+	systemdUnitDir = unitDir
+	defer func() { systemdUnitDir = originalUnitDir }()
+	withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		if args[0] == "disable" || args[0] == "reset-failed" {
+			return []byte("Unit sidecar.service not loaded."), errors.New("exit status 5")
+		}
+		return nil, nil
+	})
+
+	installDir := writeSidecarFiles(t, "linux")
+	if _, err := prepareDirs(installDir); err != nil {
+		t.Fatalf("prepareDirs: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(installDir, "logs", "sidecar.log"), []byte("log"), 0644); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(unitDir, "sidecar.service"), nil, 0644); err != nil {
+		t.Fatalf("write unit: %v", err)
+	}
+
+	captureStdout(t, func() {
+		for i := 0; i < 2; i++ {
+			if err := uninstall("linux", installDir, true); err != nil {
+				t.Fatalf("uninstall run %d: %v", i+1, err)
+			}
+		}
+	})
+
+	entries, _ := os.ReadDir(installDir)
+	if len(entries) != 1 || entries[0].Name() != "logs" {
+		t.Fatalf("expected only logs to remain, got %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(unitDir, "sidecar.service")); !os.IsNotExist(err) {
+		t.Fatalf("expected unit file to be removed, stat error: %v", err)
+	}
+
+	captureStdout(t, func() {
+		if err := uninstall("linux", installDir, false); err != nil {
+			t.Fatalf("uninstall without keep-logs: %v", err)
+		}
+	})
+	if _, err := os.Stat(installDir); !os.IsNotExist(err) {
+		t.Fatalf("expected install dir to be removed, stat error: %v", err)
+	}
+}
+
+func TestWindowsUninstallIgnoresMissingService(t *testing.T) {
+	withServiceCommandStub(t, func(name string, args ...string) ([]byte, error) {
+		return []byte("[SC] OpenService FAILED 1060:\n\nThe specified service does not exist as an installed service."), errors.New("exit status 1060")
+	})
+	if err := (windowsServiceManager{}).Uninstall(sidecarServiceSpec("windows", `C:\fusion-collectors`)); err != nil {
+		t.Fatalf("expected missing service to be ignored, got %v", err)
+	}
+}
+
+func TestRemoveInstallDirRefusesRoot(t *testing.T) {
+	if err := removeInstallDir("linux", string(filepath.Separator), false); err == nil {
+		t.Fatal("expected root directory to be refused")
+	}
+}
+
+func TestRemoveInstallDirRefusesNonSidecarDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	err := removeInstallDir("linux", dir, false)
+	if err == nil || !strings.Contains(err.Error(), "not a sidecar install directory") {
+		t.Fatalf("expected non-sidecar dir to be refused, got %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "notes.txt")); got != "keep" {
+		t.Fatalf("expected unrelated file to survive, got %q", got)
+	}
+}
+
+func withDownloadSleepStub(t *testing.T) *[]time.Duration {
+	t.Helper()
+	original := downloadSleep
+	var waits []time.Duration
+	downloadSleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { downloadSleep = original })
+	return &waits
+}
+
+func TestWithDownloadRetryBacksOffExponentially(t *testing.T) {
+	waits := withDownloadSleepStub(t)
+	calls := 0
+	captureStdout(t, func() {
+		err := withDownloadRetry(3, func() error {
+			calls++
+			return errors.New("read pipe: i/o timeout")
+		})
+		if err == nil {
+			t.Fatal("expected final error after retries")
+		}
+	})
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if calls != 4 || len(*waits) != 3 || (*waits)[0] != want[0] || (*waits)[1] != want[1] || (*waits)[2] != want[2] {
+		t.Fatalf("unexpected retries: calls=%d waits=%v", calls, *waits)
+	}
+	if got := downloadBackoff(10); got != maxDownloadBackoff {
+		t.Fatalf("expected backoff cap %s, got %s", maxDownloadBackoff, got)
+	}
+}
+
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	originalStderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe stderr: %v", err)
+	}
+	os.Stderr = w
+	defer func() {
+		os.Stderr = originalStderr
+	}()
+
+	fn()
+	_ = w.Close()
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read stderr: %v", err)
+	}
+	return string(output)
+}
+
+func TestTLSVerificationIsDefaultAndSkipWarns(t *testing.T) {
+	if skip := flag.Lookup("skip-tls"); skip == nil || skip.DefValue != "false" {
+		t.Fatalf("expected -skip-tls to default to false, got %+v", skip)
+	}
+	if err := flag.CommandLine.Set("ca-cert", "/etc/ssl/private-ca.pem"); err != nil {
+		t.Fatalf("set -ca-cert: %v", err)
+	}
+	defer flag.CommandLine.Set("ca-file", "")
+	if *caFile != "/etc/ssl/private-ca.pem" {
+		t.Fatalf("expected -ca-cert to set the CA file, got %q", *caFile)
+	}
+
+	if output := captureStderr(t, func() { newHTTPClient(false, "", "") }); output != "" {
+		t.Fatalf("expected no warning when verifying certificates, got %q", output)
+	}
+	if output := captureStderr(t, func() { newHTTPClient(true, "", "") }); !strings.Contains(output, "server certificates are NOT verified") {
+		t.Fatalf("expected insecure warning for -skip-tls, got %q", output)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if output := captureStderr(t, func() { newHTTPClient(true, caPath, "") }); output != "" {
+		t.Fatalf("expected CA file to take precedence over -skip-tls without warning, got %q", output)
+	}
+}
+
+func TestNATSTLSConfigSharesCAAndSkipRules(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	var config *tls.Config
+	var err error
+	if output := captureStderr(t, func() { config, _, err = newTLSConfig(true, "", caPEM) }); output != "" || err != nil {
+		t.Fatalf("expected nats_tls_ca to take precedence over -skip-tls without warning, got output=%q err=%v", output, err)
+	}
+	if config.InsecureSkipVerify || config.RootCAs == nil {
+		t.Fatalf("expected nats_tls_ca to enable verification, got %+v", config)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, []byte(caPEM), 0o600); err != nil {
+		t.Fatalf("write ca file: %v", err)
+	}
+	config, _, err = newTLSConfig(false, caPath, "")
+	if err != nil || config.RootCAs == nil {
+		t.Fatalf("expected -ca-cert to be trusted for NATS, got config=%+v err=%v", config, err)
+	}
+
+	if output := captureStderr(t, func() { config, _, _ = newTLSConfig(true, "", "") }); !config.InsecureSkipVerify || !strings.Contains(output, "server certificates are NOT verified") {
+		t.Fatalf("expected -skip-tls alone to skip verification with a warning, got %q", output)
+	}
+	if _, _, err := newTLSConfig(false, "", "not pem"); err == nil || !strings.Contains(err.Error(), "nats_tls_ca") {
+		t.Fatalf("expected invalid nats_tls_ca to be rejected, got %v", err)
+	}
+}
+
+func TestWriteConfigSkipsTLSVerifyOnlyWithSkipTLS(t *testing.T) {
+	defer flag.CommandLine.Set("skip-tls", "false")
+	for _, skip := range []bool{false, true} {
+		flag.CommandLine.Set("skip-tls", strconv.FormatBool(skip))
+		installDir := t.TempDir()
+		if err := writeConfig(&Config{ServerURL: "https://bk.example", InstallDir: installDir}); err != nil {
+			t.Fatalf("writeConfig: %v", err)
+		}
+		if parsed, _ := readSidecarConfig(t, installDir); parsed.TLSSkipVerify != skip {
+			t.Fatalf("expected tls_skip_verify=%v with -skip-tls=%v, got %v", skip, skip, parsed.TLSSkipVerify)
+		}
+	}
+}
+
+func withFastHealthCheck(t *testing.T) {
+	t.Helper()
+	original := healthCheckInterval
+	healthCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { healthCheckInterval = original })
+}
+
+func TestHealthCheckWaitsForEndpointAndLogPattern(t *testing.T) {
+	withFastHealthCheck(t)
+	logDir := t.TempDir()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// 端点就绪后才写入成功日志，两个条件都满足才算健康
+		os.WriteFile(filepath.Join(logDir, "sidecar.log"), []byte("starting\ncollector ready\n"), 0644)
+	}))
+	defer server.Close()
+
+	check := &healthCheck{URL: server.URL, LogPattern: regexp.MustCompile(`collector ready`), LogDir: logDir, Timeout: 5 * time.Second}
+	if err := check.wait(); err != nil {
+		t.Fatalf("expected health check to pass, got %v", err)
+	}
+	if got := requests.Load(); got < 3 {
+		t.Fatalf("expected endpoint to be polled until ready, got %d requests", got)
+	}
+}
+
+func TestHealthCheckIgnoresLogsWrittenBeforeStart(t *testing.T) {
+	withFastHealthCheck(t)
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "sidecar.log")
+	os.WriteFile(logPath, []byte("collector ready\nstopping\n"), 0644)
+
+	check := &healthCheck{LogPattern: regexp.MustCompile(`collector ready`), LogDir: logDir, Timeout: 50 * time.Millisecond}
+	check.markLogOffsets()
+	if err := check.wait(); err == nil {
+		t.Fatal("expected a success marker from a previous run to be ignored")
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	f.WriteString("starting\ncollector ready\n")
+	f.Close()
+	if err := check.wait(); err != nil {
+		t.Fatalf("expected the new success marker to pass, got %v", err)
+	}
+}
+
+func TestHealthCheckTimeoutIncludesRecentLogs(t *testing.T) {
+	withFastHealthCheck(t)
+	logDir := t.TempDir()
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	lines = append(lines, "ERROR: failed to parse sidecar.yml")
+	os.WriteFile(filepath.Join(logDir, "sidecar.log"), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	check := &healthCheck{LogPattern: regexp.MustCompile(`collector ready`), LogDir: logDir, Timeout: 50 * time.Millisecond}
+	err := check.wait()
+	if err == nil || !strings.Contains(err.Error(), "failed to parse sidecar.yml") || !strings.Contains(err.Error(), "not healthy after 50ms") {
+		t.Fatalf("expected timeout error with log tail, got %v", err)
+	}
+	if strings.Contains(err.Error(), "line 11\n") {
+		t.Fatalf("expected only the last %d log lines, got %v", healthLogTailLines, err)
+	}
+}
+
+func TestHealthCheckFromFlagsIsOptional(t *testing.T) {
+	if check, err := healthCheckFromFlags(t.TempDir()); check != nil || err != nil {
+		t.Fatalf("expected health check to be disabled by default, got %+v, %v", check, err)
+	}
+	flag.CommandLine.Set("health-log-pattern", "(")
+	defer flag.CommandLine.Set("health-log-pattern", "")
+	if _, err := healthCheckFromFlags(t.TempDir()); err == nil || !strings.Contains(err.Error(), "invalid -health-log-pattern") {
+		t.Fatalf("expected invalid pattern error, got %v", err)
+	}
+}